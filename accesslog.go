@@ -0,0 +1,131 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyAccessLogFormat,
+	)
+}
+
+var (
+	flagPluginsAPIKeyAccessLogFormat = config.Flag{
+		Long:  "plugins.apiKey.access_log_format",
+		Short: "",
+		Value: "",
+		Usage: "Format of the access line written to stdout for each request. Valid formats are common and combined. Access lines are not written if empty.",
+	}
+)
+
+const (
+	accessLogFormatCommon   = "common"
+	accessLogFormatCombined = "combined"
+	// accessLogTimeLayout is the timestamp layout used by the Common Log Format
+	accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+)
+
+var (
+	// accessLogWriter is where access lines are written. Access lines are
+	// kept apart from the structured logs so that neither format is mangled.
+	accessLogWriter io.Writer = os.Stdout
+	accessLogMutex  sync.Mutex
+)
+
+// writeAccessLog will, if access lines are enabled, write an access line
+// describing the given request and the status and size of its response.
+// A negative size indicates that the size of the response is unknown.
+func writeAccessLog(r *http.Request, status int, size int64) {
+	format := strings.ToLower(viper.GetString(flagPluginsAPIKeyAccessLogFormat.GetLong()))
+	if format == "" {
+		return
+	}
+	if format != accessLogFormatCommon && format != accessLogFormatCombined {
+		logrus.Warnf("unknown access log format %s - access line will not be written", format)
+		return
+	}
+
+	currTime, ok := getRequestTime(r)
+	if !ok {
+		currTime = time.Now()
+	}
+
+	accessLogMutex.Lock()
+	defer accessLogMutex.Unlock()
+	if _, err := io.WriteString(accessLogWriter, formatAccessLog(format, r, status, size, currTime)+"\n"); err != nil {
+		logrus.Warnf("could not write access line: %s", err.Error())
+	}
+}
+
+// formatAccessLog formats an access line for the given request in either
+// the Common or Combined Log Format. The name of the APIKey that made the
+// request, masked if configured, is used in place of the authenticated user.
+// Apikeys sent as query parameters are redacted from the request and Referer.
+func formatAccessLog(format string, r *http.Request, status int, size int64, currTime time.Time) string {
+	host := getClientIP(r)
+
+	user := getAPIKeyName(r)
+	if user != "" {
+		user = displayKeyName(user)
+	}
+
+	bytes := "-"
+	if size >= 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		accessLogField(host),
+		accessLogField(user),
+		currTime.Format(accessLogTimeLayout),
+		r.Method,
+		getRedactedRequestURI(r),
+		r.Proto,
+		status,
+		bytes,
+	)
+	if format == accessLogFormatCombined {
+		line += fmt.Sprintf(" %s %s", strconv.Quote(accessLogField(getRedactedReferer(r))), strconv.Quote(accessLogField(r.UserAgent())))
+	}
+	return line
+}
+
+// accessLogField returns the given value, or a hyphen if the value is empty
+func accessLogField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatAccessLog(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), false)
+
+	currTime := time.Date(2017, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+	r := getTestRequest()
+	r.Proto = "HTTP/1.1"
+	r.RemoteAddr = "127.0.0.1:52314"
+	r.URL.RawQuery = "limit=10"
+
+	assert.Equal(`127.0.0.1 - - [10/Oct/2017:13:55:36 -0700] "GET /api/v1/accounts?limit=10 HTTP/1.1" 401 -`, formatAccessLog(accessLogFormatCommon, r, http.StatusUnauthorized, -1, currTime))
+
+	setAPIKey(r, getTestAPIKey())
+	assert.Equal(`127.0.0.1 - apikeyone [10/Oct/2017:13:55:36 -0700] "GET /api/v1/accounts?limit=10 HTTP/1.1" 200 2326`, formatAccessLog(accessLogFormatCommon, r, http.StatusOK, 2326, currTime))
+
+	r.Header.Set("Referer", "http://example.com/start")
+	r.Header.Set("User-Agent", `curl/7.54.0 "quoted"`)
+	assert.Equal(`127.0.0.1 - apikeyone [10/Oct/2017:13:55:36 -0700] "GET /api/v1/accounts?limit=10 HTTP/1.1" 200 0 "http://example.com/start" "curl/7.54.0 \"quoted\""`, formatAccessLog(accessLogFormatCombined, r, http.StatusOK, 0, currTime))
+
+	r.Header.Del("Referer")
+	r.Header.Del("User-Agent")
+	assert.Equal(`127.0.0.1 - apikeyone [10/Oct/2017:13:55:36 -0700] "GET /api/v1/accounts?limit=10 HTTP/1.1" 200 0 "-" "-"`, formatAccessLog(accessLogFormatCombined, r, http.StatusOK, 0, currTime))
+
+	viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), true)
+	assert.Equal(`127.0.0.1 - `+displayKeyName("apikeyone")+` [10/Oct/2017:13:55:36 -0700] "GET /api/v1/accounts?limit=10 HTTP/1.1" 200 0`, formatAccessLog(accessLogFormatCommon, r, http.StatusOK, 0, currTime))
+	assert.NotContains(formatAccessLog(accessLogFormatCommon, r, http.StatusOK, 0, currTime), "apikeyone")
+}
+
+func TestFormatAccessLogRedactsQueryKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "api_key")
+
+	currTime := time.Date(2017, time.October, 10, 13, 55, 36, 0, time.UTC)
+	r := getTestRequest()
+	r.Proto = "HTTP/1.1"
+	r.RemoteAddr = "127.0.0.1:52314"
+	r.URL.RawQuery = "limit=10&api_key=" + testutil.KeyData + "&offset=5"
+	r.Header.Set("Referer", "https://app.example.com/start?token=abc&api_key="+testutil.KeyData+"#top")
+
+	line := formatAccessLog(accessLogFormatCombined, r, http.StatusOK, 0, currTime)
+	assert.Equal(`127.0.0.1 - - [10/Oct/2017:13:55:36 +0000] "GET /api/v1/accounts?limit=10&api_key=REDACTED&offset=5 HTTP/1.1" 200 0 "https://app.example.com/start?REDACTED" "-"`, line)
+	assert.NotContains(line, testutil.KeyData)
+	assert.Equal("limit=10&api_key="+testutil.KeyData+"&offset=5", r.URL.RawQuery, "the request should not be modified")
+}
+
+func TestWriteAccessLog(t *testing.T) {
+	assert := assert.New(t)
+	buf := &bytes.Buffer{}
+	accessLogWriter = buf
+	defer func() {
+		accessLogWriter = os.Stdout
+		viper.Set(flagPluginsAPIKeyAccessLogFormat.GetLong(), "")
+	}()
+
+	r := getTestRequest()
+	setRequestTime(r, time.Date(2017, time.October, 10, 13, 55, 36, 0, time.UTC))
+
+	viper.Set(flagPluginsAPIKeyAccessLogFormat.GetLong(), "")
+	writeAccessLog(r, http.StatusOK, 10)
+	assert.Equal("", buf.String(), "access lines should not be written when disabled")
+
+	viper.Set(flagPluginsAPIKeyAccessLogFormat.GetLong(), "xml")
+	writeAccessLog(r, http.StatusOK, 10)
+	assert.Equal("", buf.String(), "access lines should not be written for unknown formats")
+
+	viper.Set(flagPluginsAPIKeyAccessLogFormat.GetLong(), "Common")
+	writeAccessLog(r, http.StatusOK, 10)
+	assert.Equal("- - - [10/Oct/2017:13:55:36 +0000] \"GET /api/v1/accounts \" 200 10\n", buf.String())
+}
+
+func TestOnRequestAccessLog(t *testing.T) {
+	assert := assert.New(t)
+	buf := &bytes.Buffer{}
+	accessLogWriter = buf
+	defer func() {
+		accessLogWriter = os.Stdout
+		viper.Set(flagPluginsAPIKeyAccessLogFormat.GetLong(), "")
+	}()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyAccessLogFormat.GetLong(), accessLogFormatCommon)
+
+	r := getTestRequest()
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Contains(buf.String(), "\"GET /api/v1/accounts \" 401 -\n", "denied requests should be logged by OnRequest")
+
+	buf.Reset()
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	r = getTestRequest()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.Equal("", buf.String(), "authorized requests should be logged by OnResponse")
+
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, &http.Response{StatusCode: http.StatusOK, ContentLength: 42}, opentracing.StartSpan("test span")))
+	assert.Contains(buf.String(), " - apikeyone [")
+	assert.Contains(buf.String(), "\"GET /api/v1/accounts \" 200 42\n")
+}
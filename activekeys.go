@@ -0,0 +1,234 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyActiveKeysInterval,
+	)
+}
+
+var (
+	flagPluginsAPIKeyActiveKeysInterval = config.Flag{
+		Long:  "plugins.apiKey.active_keys_interval",
+		Short: "",
+		Value: "0s",
+		Usage: "Interval at which the number of distinct keys bound to each binding is refreshed. Disabled if 0.",
+	}
+)
+
+// bindingGetter is satisfied by spec.BindingStore
+type bindingGetter interface {
+	Get(params ...interface{}) (interface{}, error)
+}
+
+// BindingLister enumerates every APIKeyBinding. Binding stores that
+// implement it have their bindings counted by active key counting.
+type BindingLister interface {
+	ListBindings() []spec.APIKeyBinding
+}
+
+// BindingListerFunc allows an ordinary function to be used as a BindingLister
+type BindingListerFunc func() []spec.APIKeyBinding
+
+// ListBindings calls f()
+func (f BindingListerFunc) ListBindings() []spec.APIKeyBinding {
+	return f()
+}
+
+// activeKeysStore is the store active keys are counted from
+var activeKeysStore bindingGetter = spec.BindingStore
+
+var activeKeys = struct {
+	sync.Mutex
+	lister BindingLister
+	warned bool
+	counts map[string]int
+}{counts: map[string]int{}}
+
+// activeKeysRefresh holds the interval active keys are being refreshed
+// at and the channel that stops the refresh
+var activeKeysRefresh = struct {
+	sync.Mutex
+	started  bool
+	interval time.Duration
+	stop     chan struct{}
+}{}
+
+// SetBindingLister configures the BindingLister that active keys are counted
+// from when the binding store cannot enumerate its bindings itself. It can be
+// retrieved via plugin.Lookup and called by Kanali, or another plugin, at
+// startup. Passing nil removes it.
+func SetBindingLister(l BindingLister) {
+	activeKeys.Lock()
+	defer activeKeys.Unlock()
+	activeKeys.lister = l
+	activeKeys.warned = false
+}
+
+// getBindingLister returns the BindingLister of the given store or, if it
+// cannot enumerate its bindings, the configured BindingLister. Nil is
+// returned if neither is available.
+func getBindingLister(store bindingGetter) BindingLister {
+	if lister, ok := store.(BindingLister); ok {
+		return lister
+	}
+	activeKeys.Lock()
+	defer activeKeys.Unlock()
+	return activeKeys.lister
+}
+
+// countActiveKeys returns the number of distinct keys bound to each of the
+// given bindings, keyed by the namespace and name of the binding
+func countActiveKeys(bindings []spec.APIKeyBinding) map[string]int {
+	counts := map[string]int{}
+	for _, binding := range bindings {
+		names := map[string]bool{}
+		for _, keyObj := range binding.Spec.Keys {
+			if keyObj.Name != "" {
+				names[keyObj.Name] = true
+			}
+		}
+		counts[fmt.Sprintf("%s/%s", binding.ObjectMeta.Namespace, binding.ObjectMeta.Name)] = len(names)
+	}
+	return counts
+}
+
+// refreshActiveKeys recounts the keys bound to every binding in the given
+// store and publishes the counts. Nothing is counted if the bindings of the
+// store cannot be listed.
+func refreshActiveKeys(store bindingGetter) {
+	lister := getBindingLister(store)
+	if lister == nil {
+		activeKeys.Lock()
+		defer activeKeys.Unlock()
+		if !activeKeys.warned {
+			activeKeys.warned = true
+			logrus.Warn("the binding store cannot list its bindings and no binding lister has been set - active keys will not be counted")
+		}
+		return
+	}
+	counts := countActiveKeys(lister.ListBindings())
+
+	activeKeys.Lock()
+	activeKeys.counts = counts
+	activeKeys.Unlock()
+	publishActiveKeys(counts)
+
+	for binding, count := range counts {
+		logrus.WithFields(logrus.Fields{
+			"binding":     binding,
+			"active_keys": count,
+		}).Debug("refreshed active key count")
+	}
+}
+
+// runActiveKeysRefresh refreshes the active key counts every interval
+// until the given channel is closed
+func runActiveKeysRefresh(store bindingGetter, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	refreshActiveKeys(store)
+	for {
+		select {
+		case <-ticker.C:
+			refreshActiveKeys(store)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// startActiveKeysRefresh starts refreshing the active key counts
+// the first time it is called, if an interval is configured
+func startActiveKeysRefresh() {
+	activeKeysRefresh.Lock()
+	defer activeKeysRefresh.Unlock()
+	if !activeKeysRefresh.started {
+		restartActiveKeysRefresh(viper.GetDuration(flagPluginsAPIKeyActiveKeysInterval.GetLong()))
+	}
+}
+
+// reloadActiveKeysRefresh restarts a started refresh if its interval has changed
+func reloadActiveKeysRefresh() {
+	activeKeysRefresh.Lock()
+	defer activeKeysRefresh.Unlock()
+
+	if interval := viper.GetDuration(flagPluginsAPIKeyActiveKeysInterval.GetLong()); activeKeysRefresh.started && interval != activeKeysRefresh.interval {
+		restartActiveKeysRefresh(interval)
+	}
+}
+
+// restartActiveKeysRefresh stops any running refresh and starts refreshing
+// at the given interval, if positive. It must be called with the lock held.
+func restartActiveKeysRefresh(interval time.Duration) {
+	if activeKeysRefresh.stop != nil {
+		close(activeKeysRefresh.stop)
+	}
+	activeKeysRefresh.started, activeKeysRefresh.interval, activeKeysRefresh.stop = true, interval, nil
+	if interval > 0 {
+		activeKeysRefresh.stop = make(chan struct{})
+		go runActiveKeysRefresh(activeKeysStore, interval, activeKeysRefresh.stop)
+	}
+}
+
+// publishActiveKeys publishes the given counts as a gauge under the
+// active_keys entry of the configured expvar, replacing earlier counts
+func publishActiveKeys(counts map[string]int) {
+	vars := getDecisionVars()
+	if vars == nil {
+		return
+	}
+	gauge := new(expvar.Map).Init()
+	for binding, count := range counts {
+		v := new(expvar.Int)
+		v.Set(int64(count))
+		gauge.Set(binding, v)
+	}
+	vars.Set(expvarActiveKeys, gauge)
+}
+
+// ActiveKeyCounts returns the number of distinct keys bound to each
+// binding, keyed by the namespace and name of the binding, as of the
+// last refresh. It is empty unless plugins.apiKey.active_keys_interval is set.
+func ActiveKeyCounts() map[string]int {
+	activeKeys.Lock()
+	defer activeKeys.Unlock()
+
+	counts := make(map[string]int, len(activeKeys.counts))
+	for binding, count := range activeKeys.counts {
+		counts[binding] = count
+	}
+	return counts
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetActiveKeys() {
+	viper.Set(flagPluginsAPIKeyActiveKeysInterval.GetLong(), "")
+	activeKeys.Lock()
+	activeKeys.lister = nil
+	activeKeys.warned = false
+	activeKeys.counts = map[string]int{}
+	activeKeys.Unlock()
+}
+
+func getTestActiveKeysBinding(name string, keys ...string) spec.APIKeyBinding {
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Name = name
+	binding.Spec.APIProxyName = name
+	binding.Spec.Keys = []spec.Key{}
+	for _, key := range keys {
+		binding.Spec.Keys = append(binding.Spec.Keys, spec.Key{Name: key})
+	}
+	return binding
+}
+
+func TestCountActiveKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(map[string]int{}, countActiveKeys(nil))
+	assert.Equal(map[string]int{
+		"foo/one":   2,
+		"foo/two":   0,
+		"foo/three": 1,
+	}, countActiveKeys([]spec.APIKeyBinding{
+		getTestActiveKeysBinding("one", "apikeyone", "apikeytwo", "apikeyone"),
+		getTestActiveKeysBinding("two"),
+		getTestActiveKeysBinding("three", "apikeyone", ""),
+	}))
+}
+
+func TestRefreshActiveKeys(t *testing.T) {
+	assert := assert.New(t)
+	defer resetActiveKeys()
+	resetActiveKeys()
+
+	store := &testutil.BindingStore{Bindings: []spec.APIKeyBinding{
+		getTestActiveKeysBinding("one", "apikeyone", "apikeytwo"),
+		getTestActiveKeysBinding("two", "apikeyone"),
+	}}
+	assert.Equal(map[string]int{}, ActiveKeyCounts())
+	refreshActiveKeys(store)
+	assert.Equal(map[string]int{"foo/one": 2, "foo/two": 1}, ActiveKeyCounts())
+
+	store.Bindings = store.Bindings[1:]
+	refreshActiveKeys(store)
+	assert.Equal(map[string]int{"foo/two": 1}, ActiveKeyCounts(), "removed bindings should no longer be counted")
+}
+
+func TestRefreshActiveKeysLister(t *testing.T) {
+	assert := assert.New(t)
+	defer resetActiveKeys()
+	defer spec.BindingStore.Clear()
+	resetActiveKeys()
+
+	spec.BindingStore.Set(getTestActiveKeysBinding("one", "apikeyone", "apikeytwo"))
+	refreshActiveKeys(spec.BindingStore)
+	assert.Equal(map[string]int{}, ActiveKeyCounts(), "bindings should not be counted if they cannot be listed")
+
+	SetBindingLister(BindingListerFunc(func() []spec.APIKeyBinding {
+		return []spec.APIKeyBinding{
+			getTestActiveKeysBinding("one", "apikeyone", "apikeytwo"),
+			getTestActiveKeysBinding("two", "apikeyone"),
+		}
+	}))
+	refreshActiveKeys(spec.BindingStore)
+	assert.Equal(map[string]int{"foo/one": 2, "foo/two": 1}, ActiveKeyCounts(), "every listed binding should be counted")
+}
+
+func TestPublishActiveKeys(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_publish_active_keys")
+
+	publishActiveKeys(map[string]int{"foo/one": 2, "foo/two": 1})
+	publishActiveKeys(map[string]int{"foo/one": 3})
+	gauge, ok := getDecisionVars().Get(expvarActiveKeys).(*expvar.Map)
+	if assert.True(ok) {
+		assert.Equal("3", gauge.Get("foo/one").String())
+		assert.Nil(gauge.Get("foo/two"), "earlier counts should be replaced")
+	}
+}
+
+func TestRunActiveKeysRefresh(t *testing.T) {
+	assert := assert.New(t)
+	defer resetActiveKeys()
+	resetActiveKeys()
+
+	store := &testutil.BindingStore{Bindings: []spec.APIKeyBinding{getTestActiveKeysBinding("one", "apikeyone")}}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runActiveKeysRefresh(store, time.Millisecond, stop)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(ActiveKeyCounts()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+	assert.Equal(map[string]int{"foo/one": 1}, ActiveKeyCounts())
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyAdminPaths,
+	)
+}
+
+var (
+	flagPluginsAPIKeyAdminPaths = config.Flag{
+		Long:  "plugins.apiKey.admin_paths",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of paths, relative to the APIProxy, that may only be requested by admin apikeys in addition to the rules of their binding.",
+	}
+)
+
+// annotationKeyAdmin is the APIKey annotation, or label, that
+// marks a key as permitted to request admin paths
+const annotationKeyAdmin = "apikey.kanali.io/admin"
+
+var errAdminKeyRequired = &utils.StatusError{http.StatusForbidden, errors.New("admin apikey required for this path")}
+
+// getAdminPaths returns the configured admin paths. A trailing
+// wildcard is dropped so that /admin/* matches the paths below /admin.
+func getAdminPaths() []string {
+	paths := []string{}
+	for _, path := range getStringSlice(flagPluginsAPIKeyAdminPaths.GetLong()) {
+		if path = strings.TrimSuffix(path, "*"); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// isAdminPath will return true if the given target path is, or is below,
+// one of the configured admin paths. If admin paths are configured, a
+// path that cannot be cleaned is treated as an admin path.
+func isAdminPath(targetPath string) bool {
+	paths := getAdminPaths()
+	if len(paths) < 1 {
+		return false
+	}
+	targetPath, ok := cleanRequestPath(targetPath)
+	if !ok {
+		return true
+	}
+	for _, path := range paths {
+		if pathMatches(path, targetPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdminKey will return true if the given APIKey has a true
+// apikey.kanali.io/admin annotation or label
+func isAdminKey(key spec.APIKey) bool {
+	for _, values := range []map[string]string{key.ObjectMeta.Annotations, key.ObjectMeta.Labels} {
+		if admin, err := strconv.ParseBool(strings.TrimSpace(values[annotationKeyAdmin])); err == nil && admin {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAdminPath will return an error if the given target
+// path is an admin path and the given APIKey is not an admin key
+func validateAdminPath(key spec.APIKey, targetPath string) error {
+	if !isAdminPath(targetPath) || isAdminKey(key) {
+		return nil
+	}
+	return errAdminKeyRequired
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAdminPaths(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyAdminPaths.GetLong(), "")
+
+	assert.Equal([]string{}, getAdminPaths())
+
+	viper.Set(flagPluginsAPIKeyAdminPaths.GetLong(), "/admin/*, /internal,,*")
+	assert.Equal([]string{"/admin/", "/internal"}, getAdminPaths())
+	assert.True(isAdminPath("/admin/users"))
+	assert.False(isAdminPath("/admin"))
+	assert.False(isAdminPath("/administrators"))
+	assert.True(isAdminPath("/internal"))
+	assert.True(isAdminPath("/internal/health"))
+	assert.False(isAdminPath("/accounts"))
+
+	// dot segments cannot hide an admin path
+	assert.True(isAdminPath("/x/../admin/users"))
+	assert.True(isAdminPath("/accounts/../../internal"))
+	assert.True(isAdminPath("//internal/./health"))
+	assert.True(isAdminPath("/accounts/..internal"), "paths that cannot be cleaned should be treated as admin paths")
+	assert.False(isAdminPath("/x/../accounts"))
+}
+
+func TestIsAdminKey(t *testing.T) {
+	assert := assert.New(t)
+
+	key := testutil.Key()
+	assert.False(isAdminKey(key))
+
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyAdmin: "false"}
+	assert.False(isAdminKey(key))
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyAdmin: "yes"}
+	assert.False(isAdminKey(key), "values that are not booleans should be ignored")
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyAdmin: " true "}
+	assert.True(isAdminKey(key))
+
+	key.ObjectMeta.Annotations = nil
+	key.ObjectMeta.Labels = map[string]string{annotationKeyAdmin: "true"}
+	assert.True(isAdminKey(key))
+}
+
+func TestOnRequestAdminPaths(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyAdminPaths.GetLong(), "/admin/*")
+	defer viper.Set(flagPluginsAPIKeyAdminPaths.GetLong(), "")
+
+	admin := testutil.NamedKey("apikeyadmin", "myadminkey")
+	admin.ObjectMeta.Annotations = map[string]string{annotationKeyAdmin: "true"}
+	binding := testutil.Binding(
+		testutil.GlobalKey(testutil.KeyName),
+		testutil.GranularKey("apikeyadmin", "GET"),
+	)
+	defer testutil.Stores([]spec.APIKey{testutil.Key(), admin}, []spec.APIKeyBinding{binding})()
+
+	tests := []struct {
+		apiKey string
+		method string
+		path   string
+		status int
+	}{
+		{testutil.KeyData, "GET", "/accounts", 0},
+		{testutil.KeyData, "GET", "/admin/users", http.StatusForbidden},
+		{"myadminkey", "GET", "/accounts", 0},
+		{"myadminkey", "GET", "/admin/users", 0},
+		{"myadminkey", "DELETE", "/admin/users", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		m := &metrics.Metrics{}
+		err := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request(test.method, testutil.ProxyPath+test.path, test.apiKey), testutil.Span())
+		if test.status == 0 {
+			assert.Nil(err, "%s %s %s", test.apiKey, test.method, test.path)
+			continue
+		}
+		assert.Equal(test.status, getStatusCode(err), "%s %s %s", test.apiKey, test.method, test.path)
+	}
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath+"/admin/users", testutil.KeyData), testutil.Span())
+	assert.Equal("admin apikey required for this path", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_admin_denied", "true", true})
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+const (
+	// annotationKeyAliasOf is the APIKey annotation naming the canonical
+	// APIKey this key is an alias of, such as during a key rotation
+	annotationKeyAliasOf = "apikey.kanali.io/alias-of"
+	// annotationKeyAliasExpires is the APIKey annotation holding the
+	// RFC 3339 time after which an alias is no longer honored
+	annotationKeyAliasExpires = "apikey.kanali.io/alias-expires"
+)
+
+// resolveAlias returns the APIKey that bindings should be consulted for
+// on behalf of the given APIKey. If the key is an unexpired alias, a copy
+// named after its canonical key is returned so that it shares the
+// canonical key's rules, rate limits, and quota. An alias without a valid
+// expiry is ignored, and an error is returned if the alias has expired.
+func resolveAlias(key spec.APIKey, currTime time.Time) (spec.APIKey, error) {
+	canonical := strings.TrimSpace(key.ObjectMeta.Annotations[annotationKeyAliasOf])
+	if canonical == "" {
+		return key, nil
+	}
+
+	expires, err := time.Parse(time.RFC3339, strings.TrimSpace(key.ObjectMeta.Annotations[annotationKeyAliasExpires]))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"key":       displayKeyName(key.ObjectMeta.Name),
+			"namespace": key.ObjectMeta.Namespace,
+		}).Warnf("alias without a valid %s annotation will be ignored", annotationKeyAliasExpires)
+		return key, nil
+	}
+
+	if !currTime.Before(expires) {
+		return key, &utils.StatusError{http.StatusUnauthorized, errors.New("api key alias has expired")}
+	}
+
+	resolved := key
+	resolved.ObjectMeta.Name = canonical
+	return resolved, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestAliasAPIKey(expires string) spec.APIKey {
+	key := getTestAPIKey()
+	key.ObjectMeta.Name = "apikeyone-rotated"
+	key.ObjectMeta.Annotations = map[string]string{
+		annotationKeyAliasOf:      "apikeyone",
+		annotationKeyAliasExpires: expires,
+	}
+	key.Spec.APIKeyData = "myrotatedapikey"
+	return key
+}
+
+func TestResolveAlias(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2017, time.October, 10, 12, 0, 0, 0, time.UTC)
+
+	resolved, err := resolveAlias(getTestAPIKey(), now)
+	assert.Nil(err)
+	assert.Equal(getTestAPIKey(), resolved, "keys that are not aliases should be unchanged")
+
+	resolved, err = resolveAlias(getTestAliasAPIKey("2017-10-11T00:00:00Z"), now)
+	assert.Nil(err)
+	assert.Equal("apikeyone", resolved.ObjectMeta.Name)
+	assert.Equal("myrotatedapikey", resolved.Spec.APIKeyData)
+
+	_, err = resolveAlias(getTestAliasAPIKey("2017-10-10T12:00:00Z"), now)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("api key alias has expired", err.Error())
+
+	for _, expires := range []string{"", "tomorrow"} {
+		resolved, err = resolveAlias(getTestAliasAPIKey(expires), now)
+		assert.Nil(err)
+		assert.Equal("apikeyone-rotated", resolved.ObjectMeta.Name, "aliases without a valid expiry should be ignored")
+	}
+}
+
+func TestOnRequestAlias(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.KeyStore.Set(getTestAliasAPIKey(time.Now().Add(time.Hour).Format(time.RFC3339)))
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	r.Header.Set("apikey", "myrotatedapikey")
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	m := &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, span), "aliases should be authorized by the canonical key's binding")
+	assert.Contains(*m, metrics.Metric{"api_key_name", "apikeyone-rotated", true})
+	assert.Equal("apikeyone", span.Tag("kanali.api_key_alias_of"))
+	assert.Equal("apikeyone-rotated", getAPIKeyName(r))
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")), "the canonical key should still be authorized")
+
+	spec.KeyStore.Set(getTestAliasAPIKey(time.Now().Add(-time.Hour).Format(time.RFC3339)))
+	r = getTestRequest()
+	r.Header.Set("apikey", "myrotatedapikey")
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal("api key alias has expired", err.Error())
+}
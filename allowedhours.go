@@ -0,0 +1,179 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+// annotationKeyAllowedHours is the APIKey annotation holding the time of
+// day a key may be used, such as 09:00-17:00 America/Chicago. The time
+// zone is optional and defaults to UTC. A window whose end is before its
+// start crosses midnight.
+const annotationKeyAllowedHours = "apikey.kanali.io/allowed-hours"
+
+var errOutsideAllowedHours = &utils.StatusError{http.StatusForbidden, errors.New("api key not permitted at this time of day")}
+
+// timeWindow is a daily window of time in a time zone. Start and end
+// are offsets from midnight, and the window includes its start only.
+type timeWindow struct {
+	start time.Duration
+	end   time.Duration
+	loc   *time.Location
+}
+
+// parsedTimeWindow is the result of parsing an allowed hours annotation
+type parsedTimeWindow struct {
+	value  string
+	window timeWindow
+	err    error
+}
+
+// allowedHours caches the parsed allowed hours of every APIKey by its
+// namespace and name, so that an annotation is only parsed, and its time
+// zone loaded, again once it changes
+var allowedHours = struct {
+	sync.RWMutex
+	windows map[string]parsedTimeWindow
+}{windows: map[string]parsedTimeWindow{}}
+
+// parseTimeOfDay parses a time of day of the form HH:MM
+// into its offset from midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseTimeWindow parses a window of the form HH:MM-HH:MM,
+// optionally followed by an IANA time zone
+func parseTimeWindow(value string) (timeWindow, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 1 || len(fields) > 2 {
+		return timeWindow{}, fmt.Errorf("expected HH:MM-HH:MM followed by an optional time zone, got %q", value)
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return timeWindow{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", fields[0])
+	}
+	start, err := parseTimeOfDay(bounds[0])
+	if err != nil {
+		return timeWindow{}, err
+	}
+	end, err := parseTimeOfDay(bounds[1])
+	if err != nil {
+		return timeWindow{}, err
+	}
+	if start == end {
+		return timeWindow{}, fmt.Errorf("window %q is empty", fields[0])
+	}
+
+	loc := time.UTC
+	if len(fields) == 2 {
+		if loc, err = time.LoadLocation(fields[1]); err != nil {
+			return timeWindow{}, err
+		}
+	}
+	return timeWindow{start, end, loc}, nil
+}
+
+// contains will return true if the given time falls within the window
+func (w timeWindow) contains(currTime time.Time) bool {
+	local := currTime.In(w.loc)
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	// the window crosses midnight
+	return offset >= w.start || offset < w.end
+}
+
+// getAllowedHours returns the window of the given allowed hours annotation
+// value of the given APIKey, parsing it only if it has changed since it was
+// last parsed. Malformed windows are logged once, when they are parsed.
+func getAllowedHours(key spec.APIKey, value string) (timeWindow, error) {
+	id := fmt.Sprintf("%s/%s", key.ObjectMeta.Namespace, key.ObjectMeta.Name)
+
+	allowedHours.RLock()
+	parsed, ok := allowedHours.windows[id]
+	allowedHours.RUnlock()
+	if ok && parsed.value == value {
+		return parsed.window, parsed.err
+	}
+
+	window, err := parseTimeWindow(value)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"key_name":      displayKeyName(key.ObjectMeta.Name),
+			"key_namespace": key.ObjectMeta.Namespace,
+		}).Warnf("malformed %s annotation - the apikey will be denied: %s", annotationKeyAllowedHours, err.Error())
+	}
+
+	allowedHours.Lock()
+	allowedHours.windows[id] = parsedTimeWindow{value, window, err}
+	allowedHours.Unlock()
+	return window, err
+}
+
+// forgetAllowedHours discards the parsed allowed hours of the given APIKey
+func forgetAllowedHours(key spec.APIKey) {
+	id := fmt.Sprintf("%s/%s", key.ObjectMeta.Namespace, key.ObjectMeta.Name)
+
+	allowedHours.RLock()
+	_, ok := allowedHours.windows[id]
+	allowedHours.RUnlock()
+	if ok {
+		allowedHours.Lock()
+		delete(allowedHours.windows, id)
+		allowedHours.Unlock()
+	}
+}
+
+// validateAllowedHours will return an error if the given APIKey restricts
+// the time of day it may be used and the given time is outside of it.
+// Keys with a malformed window are denied rather than left unrestricted.
+func validateAllowedHours(key spec.APIKey, currTime time.Time) error {
+	value, ok := key.ObjectMeta.Annotations[annotationKeyAllowedHours]
+	if !ok {
+		forgetAllowedHours(key)
+		return nil
+	}
+	window, err := getAllowedHours(key, value)
+	if err != nil {
+		return errOutsideAllowedHours
+	}
+	if !window.contains(currTime) {
+		return errOutsideAllowedHours
+	}
+	return nil
+}
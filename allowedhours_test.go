@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestAllowedHoursAPIKey(window string) spec.APIKey {
+	key := testutil.Key()
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyAllowedHours: window}
+	return key
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	window, err := parseTimeWindow("09:00-17:30 America/Chicago")
+	assert.Nil(err)
+	assert.Equal(9*time.Hour, window.start)
+	assert.Equal(17*time.Hour+30*time.Minute, window.end)
+	assert.Equal("America/Chicago", window.loc.String())
+
+	window, err = parseTimeWindow(" 22:00-06:00 ")
+	assert.Nil(err)
+	assert.Equal(time.UTC, window.loc)
+
+	for _, malformed := range []string{"", "09:00", "9-17", "09:00-25:00", "09:00-17:00-18:00", "09:00-09:00", "09:00-17:00 Not/AZone", "09:00-17:00 UTC extra"} {
+		_, err := parseTimeWindow(malformed)
+		assert.NotNil(err, "window %q", malformed)
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	assert := assert.New(t)
+	chicago := mustLoadLocation(t, "America/Chicago")
+
+	business, _ := parseTimeWindow("09:00-17:00 America/Chicago")
+	assert.True(business.contains(time.Date(2017, 6, 1, 9, 0, 0, 0, chicago)))
+	assert.True(business.contains(time.Date(2017, 6, 1, 16, 59, 59, 0, chicago)))
+	assert.False(business.contains(time.Date(2017, 6, 1, 17, 0, 0, 0, chicago)))
+	assert.False(business.contains(time.Date(2017, 6, 1, 8, 59, 0, 0, chicago)))
+	// 15:00 UTC is 10:00 in Chicago during daylight saving time
+	assert.True(business.contains(time.Date(2017, 6, 1, 15, 0, 0, 0, time.UTC)))
+	assert.False(business.contains(time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC)))
+
+	overnight, _ := parseTimeWindow("22:00-06:00")
+	assert.True(overnight.contains(time.Date(2017, 6, 1, 23, 30, 0, 0, time.UTC)))
+	assert.True(overnight.contains(time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(overnight.contains(time.Date(2017, 6, 1, 5, 59, 0, 0, time.UTC)))
+	assert.False(overnight.contains(time.Date(2017, 6, 1, 6, 0, 0, 0, time.UTC)))
+	assert.False(overnight.contains(time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestValidateAllowedHours(t *testing.T) {
+	assert := assert.New(t)
+	noon := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Nil(validateAllowedHours(testutil.Key(), noon))
+	assert.Nil(validateAllowedHours(getTestAllowedHoursAPIKey("09:00-17:00"), noon))
+	assert.Equal(errOutsideAllowedHours, validateAllowedHours(getTestAllowedHoursAPIKey("22:00-06:00"), noon))
+	assert.Equal(errOutsideAllowedHours, validateAllowedHours(getTestAllowedHoursAPIKey("always"), noon), "malformed windows should deny")
+}
+
+func TestGetAllowedHours(t *testing.T) {
+	assert := assert.New(t)
+	key := getTestAllowedHoursAPIKey("09:00-17:00 America/Chicago")
+	id := key.ObjectMeta.Namespace + "/" + key.ObjectMeta.Name
+
+	window, err := getAllowedHours(key, "09:00-17:00 America/Chicago")
+	assert.Nil(err)
+	allowedHours.Lock()
+	allowedHours.windows[id] = parsedTimeWindow{"09:00-17:00 America/Chicago", timeWindow{time.Hour, 2 * time.Hour, window.loc}, nil}
+	allowedHours.Unlock()
+	window, _ = getAllowedHours(key, "09:00-17:00 America/Chicago")
+	assert.Equal(time.Hour, window.start, "an unchanged annotation should not be parsed again")
+
+	window, _ = getAllowedHours(key, "10:00-17:00 America/Chicago")
+	assert.Equal(10*time.Hour, window.start, "a changed annotation should be parsed again")
+
+	assert.Nil(validateAllowedHours(testutil.Key(), time.Now()))
+	allowedHours.RLock()
+	_, ok := allowedHours.windows[id]
+	allowedHours.RUnlock()
+	assert.False(ok, "a removed annotation should be forgotten")
+}
+
+func TestOnRequestAllowedHours(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	now := time.Now().UTC()
+	inside := now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04")
+	outside := now.Add(time.Hour).Format("15:04") + "-" + now.Add(2*time.Hour).Format("15:04")
+
+	cleanup := testutil.Stores([]spec.APIKey{getTestAllowedHoursAPIKey(inside)}, []spec.APIKeyBinding{testutil.Binding()})
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+	cleanup()
+
+	cleanup = testutil.Stores([]spec.APIKey{getTestAllowedHoursAPIKey(outside)}, []spec.APIKeyBinding{testutil.Binding()})
+	defer cleanup()
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("api_key_not_permitted_at_this_time_of_day", getDenyReasonCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_allowed_hours_denied", "true", true})
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyAnonymousPaths,
+		flagPluginsAPIKeyAnonymousRate,
+		flagPluginsAPIKeyAnonymousMaxClients,
+	)
+}
+
+var (
+	flagPluginsAPIKeyAnonymousPaths = config.Flag{
+		Long:  "plugins.apiKey.anonymous_paths",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of request paths, and the paths below them, that are proxied without an apikey.",
+	}
+	flagPluginsAPIKeyAnonymousRate = config.Flag{
+		Long:  "plugins.apiKey.anonymous_rate",
+		Short: "",
+		Value: "",
+		Usage: "Rate limit, of the form amount/unit, applied to each client IP making requests to an anonymous path. Unlimited if empty.",
+	}
+	flagPluginsAPIKeyAnonymousMaxClients = config.Flag{
+		Long:  "plugins.apiKey.anonymous_max_clients",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of client IPs whose anonymous traffic is tracked at once. The least recently seen client is evicted when full.",
+	}
+)
+
+// anonymousTraffic holds the traffic of every client IP
+// making requests to an anonymous path
+var anonymousTraffic = newTrafficCounter()
+
+// isAnonymousPath will return true if the given request may be
+// proxied without an apikey. Paths that cannot be cleaned always
+// require an apikey.
+func isAnonymousPath(r *http.Request) bool {
+	if r.URL == nil {
+		return false
+	}
+	requestPath, ok := cleanRequestPath(r.URL.Path)
+	if !ok {
+		return false
+	}
+	for _, path := range getStringSlice(flagPluginsAPIKeyAnonymousPaths.GetLong()) {
+		if pathMatches(path, requestPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAnonymousRate returns the rate limit applied to each client IP.
+// Nil is returned if anonymous traffic is unlimited.
+func getAnonymousRate() *spec.Rate {
+	value := viper.GetString(flagPluginsAPIKeyAnonymousRate.GetLong())
+	if value == "" {
+		return nil
+	}
+	rate, err := parseRate(value)
+	if err != nil {
+		logrus.Warnf("invalid %s will be ignored: %s", flagPluginsAPIKeyAnonymousRate.GetLong(), err.Error())
+		return nil
+	}
+	return rate
+}
+
+// getAnonymousTrafficID scopes the traffic of a client IP to an APIProxy so
+// that its traffic against one proxy does not count against another
+func getAnonymousTrafficID(p spec.APIProxy, ip string) string {
+	return fmt.Sprintf("%s/%s/%s", p.ObjectMeta.Namespace, p.ObjectMeta.Name, ip)
+}
+
+// validateAnonymousRequest returns a 429 error if the client IP of the given
+// request has exceeded the anonymous rate limit. Otherwise the request is
+// counted against that limit.
+func validateAnonymousRequest(p spec.APIProxy, r *http.Request, currTime time.Time) error {
+	rate := getAnonymousRate()
+	if rate == nil || isRateLimitExempt(r.Method) {
+		return nil
+	}
+
+	id := getAnonymousTrafficID(p, getClientIP(r))
+	since := currTime.Add(-getRateWindow(rate.Unit))
+	if anonymousTraffic.count(id, since) >= rate.Amount {
+		err := &utils.StatusError{http.StatusTooManyRequests, errors.New("rate limit reached. please retry later")}
+		oldest, ok := anonymousTraffic.oldest(id, since)
+		if !ok {
+			return err
+		}
+		retryAfter := int(math.Ceil(oldest.Sub(since).Seconds()))
+		return withRetryAfter(err, retryAfter)
+	}
+
+	anonymousTraffic.makeRoom(viper.GetInt(flagPluginsAPIKeyAnonymousMaxClients.GetLong()), id, currTime)
+	anonymousTraffic.add(id, currTime)
+	return nil
+}
+
+// checkAnonymousRequest reports whether the given request is for an anonymous
+// path and, if so, whether it is within the anonymous rate limit
+func checkAnonymousRequest(m *metrics.Metrics, p spec.APIProxy, r *http.Request, currTime time.Time) (bool, error) {
+	if !isAnonymousPath(r) {
+		return false, nil
+	}
+	m.Add(metrics.Metric{"api_key_anonymous", "true", true})
+	if err := validateAnonymousRequest(p, r, currTime); err != nil {
+		m.Add(metrics.Metric{"api_key_anonymous_rate_limited", "true", true})
+		return true, err
+	}
+	return true, nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetAnonymous() {
+	viper.Set(flagPluginsAPIKeyAnonymousPaths.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyAnonymousRate.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyAnonymousMaxClients.GetLong(), 10000)
+	viper.Set(flagPluginsAPIKeyRateLimitExemptMethods.GetLong(), "")
+	anonymousTraffic = newTrafficCounter()
+}
+
+func getTestAnonymousRequest(ip string) *http.Request {
+	r := getTestRequest()
+	r.Header.Del("Apikey")
+	r.URL.Path = "/api/v1/accounts/public"
+	r.RemoteAddr = ip + ":1234"
+	return r
+}
+
+func TestIsAnonymousPath(t *testing.T) {
+	assert := assert.New(t)
+	defer resetAnonymous()
+	resetAnonymous()
+
+	assert.False(isAnonymousPath(getTestAnonymousRequest("10.0.0.1")))
+	assert.False(isAnonymousPath(&http.Request{}))
+
+	viper.Set(flagPluginsAPIKeyAnonymousPaths.GetLong(), "/status,/api/v1/accounts/public")
+	assert.True(isAnonymousPath(getTestAnonymousRequest("10.0.0.1")))
+	assert.False(isAnonymousPath(getTestRequest()))
+
+	// dot segments cannot escape an anonymous path
+	for _, raw := range []string{"/api/v1/accounts/public/../orders", "/api/v1/accounts/public/%2e%2e/orders", "/api/v1/accounts/public/%2E%2E/%2E%2E/orders"} {
+		r := getTestAnonymousRequest("10.0.0.1")
+		u, err := url.Parse("http://host.com" + raw)
+		assert.Nil(err)
+		r.URL = u
+		assert.False(isAnonymousPath(r), "path %s", raw)
+	}
+	r := getTestAnonymousRequest("10.0.0.1")
+	r.URL.Path = "/api/v1//accounts/./public/reports"
+	assert.True(isAnonymousPath(r), "equivalent paths should still match")
+}
+
+func TestGetAnonymousRate(t *testing.T) {
+	assert := assert.New(t)
+	defer resetAnonymous()
+	resetAnonymous()
+
+	assert.Nil(getAnonymousRate())
+	viper.Set(flagPluginsAPIKeyAnonymousRate.GetLong(), "10/minute")
+	assert.Equal(10, getAnonymousRate().Amount)
+	assert.Equal("minute", getAnonymousRate().Unit)
+	viper.Set(flagPluginsAPIKeyAnonymousRate.GetLong(), "lots")
+	assert.Nil(getAnonymousRate(), "invalid rates should be ignored")
+}
+
+func TestValidateAnonymousRequest(t *testing.T) {
+	assert := assert.New(t)
+	defer resetAnonymous()
+	resetAnonymous()
+
+	now := time.Now()
+	p := getTestAPIProxy()
+	for i := 0; i < 5; i++ {
+		assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.1"), now), "anonymous traffic should be unlimited by default")
+	}
+
+	resetAnonymous()
+	viper.Set(flagPluginsAPIKeyAnonymousRate.GetLong(), "2/minute")
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.1"), now.Add(-30*time.Second)))
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.1"), now))
+
+	err := validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.1"), now)
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Equal("rate limit reached. please retry later - retry after 30 seconds", err.Error())
+
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.2"), now), "each client ip should be limited separately")
+
+	other := getTestAPIProxy()
+	other.ObjectMeta.Name = "APIProxytwo"
+	assert.Nil(validateAnonymousRequest(other, getTestAnonymousRequest("10.0.0.1"), now), "each proxy should be limited separately")
+
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.1"), now.Add(31*time.Second)), "traffic outside of the window should not count")
+
+	viper.Set(flagPluginsAPIKeyRateLimitExemptMethods.GetLong(), "GET")
+	for i := 0; i < 5; i++ {
+		assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.3"), now))
+	}
+	assert.Equal(0, anonymousTraffic.count(getAnonymousTrafficID(p, "10.0.0.3"), now.Add(-time.Minute)), "exempt methods should not be counted")
+}
+
+func TestValidateAnonymousRequestMaxClients(t *testing.T) {
+	assert := assert.New(t)
+	defer resetAnonymous()
+	resetAnonymous()
+	viper.Set(flagPluginsAPIKeyAnonymousRate.GetLong(), "1/hour")
+	viper.Set(flagPluginsAPIKeyAnonymousMaxClients.GetLong(), 2)
+
+	now := time.Now()
+	p := getTestAPIProxy()
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.1"), now.Add(-2*time.Minute)))
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.2"), now.Add(-time.Minute)))
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.3"), now))
+	assert.Equal(2, len(anonymousTraffic.hits))
+	assert.Nil(validateAnonymousRequest(p, getTestAnonymousRequest("10.0.0.1"), now), "the least recently seen client should have been evicted")
+}
+
+func TestOnRequestAnonymous(t *testing.T) {
+	assert := assert.New(t)
+	defer resetAnonymous()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	resetAnonymous()
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestAnonymousRequest("10.0.0.1"), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err), "paths should require an apikey by default")
+
+	viper.Set(flagPluginsAPIKeyAnonymousPaths.GetLong(), "/api/v1/accounts/public")
+	viper.Set(flagPluginsAPIKeyAnonymousRate.GetLong(), "1/minute")
+
+	m = &metrics.Metrics{}
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestAnonymousRequest("10.0.0.1"), span))
+	assert.Equal(metrics.Metrics{{"api_key_anonymous", "true", true}}, *m)
+	assert.Equal(true, span.Tag("kanali.anonymous"))
+
+	m = &metrics.Metrics{}
+	err = Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestAnonymousRequest("10.0.0.1"), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_anonymous_rate_limited", "true", true})
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestAnonymousRequest("10.0.0.2"), opentracing.StartSpan("test span")))
+}
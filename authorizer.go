@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/northwesternmutual/kanali/spec"
+)
+
+// AuthContext describes a request that has passed the
+// built-in authorization checks of this plugin
+type AuthContext struct {
+	// Proxy is the APIProxy the request was made to
+	Proxy spec.APIProxy
+	// Request is the incoming request
+	Request *http.Request
+	// APIKey is the APIKey resource that made the request
+	APIKey spec.APIKey
+	// Binding is the APIKeyBinding that authorized the APIKey for the proxy
+	Binding spec.APIKeyBinding
+	// Rule is the rule of the binding that applies to the request
+	Rule spec.Rule
+}
+
+// Authorizer makes a custom authorization decision for requests that
+// have passed the built-in checks of this plugin. Authorize returns
+// whether the request is allowed and, if it is not, the reason why.
+type Authorizer interface {
+	Authorize(ctx context.Context, authContext AuthContext) (bool, string)
+}
+
+// AuthorizerFunc allows an ordinary function to be used as an Authorizer
+type AuthorizerFunc func(ctx context.Context, authContext AuthContext) (bool, string)
+
+// Authorize calls f(ctx, authContext)
+func (f AuthorizerFunc) Authorize(ctx context.Context, authContext AuthContext) (bool, string) {
+	return f(ctx, authContext)
+}
+
+// authorizer holds the custom Authorizer, if any
+var authorizer = struct {
+	sync.RWMutex
+	a Authorizer
+}{}
+
+// SetAuthorizer configures a custom Authorizer that runs after the built-in
+// rule check. It can be retrieved via plugin.Lookup and called by Kanali, or
+// another plugin, at startup. Passing nil restores the built-in logic alone.
+func SetAuthorizer(a Authorizer) {
+	authorizer.Lock()
+	defer authorizer.Unlock()
+	authorizer.a = a
+}
+
+// getAuthorizer returns the custom Authorizer, or nil if none has been set
+func getAuthorizer() Authorizer {
+	authorizer.RLock()
+	defer authorizer.RUnlock()
+	return authorizer.a
+}
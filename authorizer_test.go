@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetAuthorizer(t *testing.T) {
+	assert := assert.New(t)
+	defer SetAuthorizer(nil)
+
+	assert.Nil(getAuthorizer())
+	SetAuthorizer(AuthorizerFunc(func(ctx context.Context, authContext AuthContext) (bool, string) {
+		return true, ""
+	}))
+	assert.NotNil(getAuthorizer())
+	SetAuthorizer(nil)
+	assert.Nil(getAuthorizer())
+}
+
+func TestOnRequestAuthorizer(t *testing.T) {
+	assert := assert.New(t)
+	defer SetAuthorizer(nil)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	var received AuthContext
+	calls := 0
+	SetAuthorizer(AuthorizerFunc(func(ctx context.Context, authContext AuthContext) (bool, string) {
+		calls++
+		received = authContext
+		return false, "outside of business hours"
+	}))
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("outside of business hours", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_authorizer_denied", "true", true})
+	assert.Equal(1, calls)
+	assert.Equal("apikeyone", received.APIKey.ObjectMeta.Name)
+	assert.Equal("apikeybindingone", received.Binding.ObjectMeta.Name)
+	assert.Equal("APIProxyone", received.Proxy.ObjectMeta.Name)
+	assert.Equal("GET", received.Request.Method)
+
+	SetAuthorizer(AuthorizerFunc(func(ctx context.Context, authContext AuthContext) (bool, string) {
+		calls++
+		return false, ""
+	}))
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal("request denied by custom authorizer", err.Error())
+
+	SetAuthorizer(AuthorizerFunc(func(ctx context.Context, authContext AuthContext) (bool, string) {
+		calls++
+		return true, ""
+	}))
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+
+	calls = 0
+	r := getTestRequest()
+	r.Header.Set("apikey", "notmyapikey")
+	Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(0, calls, "the authorizer should only run after the built-in checks pass")
+}
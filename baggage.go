@@ -0,0 +1,69 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyBaggageKeyName,
+		flagPluginsAPIKeyBaggageBinding,
+	)
+}
+
+var (
+	flagPluginsAPIKeyBaggageKeyName = config.Flag{
+		Long:  "plugins.apiKey.baggage_key_name",
+		Short: "",
+		Value: "",
+		Usage: "Tracing baggage item holding the name of the APIKey that authorized a request, such as apikey.name. Not propagated if empty.",
+	}
+	flagPluginsAPIKeyBaggageBinding = config.Flag{
+		Long:  "plugins.apiKey.baggage_binding",
+		Short: "",
+		Value: "",
+		Usage: "Tracing baggage item holding the namespace and name of the APIKeyBinding that authorized a request, such as apikey.binding. Not propagated if empty.",
+	}
+)
+
+// setDecisionBaggage propagates the APIKey and APIKeyBinding that
+// authorized the given request to downstream services as baggage
+// on the given span. Since baggage reaches every downstream service,
+// nothing is propagated unless a baggage item has been configured.
+func setDecisionBaggage(span opentracing.Span, r *http.Request) {
+	if item := viper.GetString(flagPluginsAPIKeyBaggageKeyName.GetLong()); item != "" {
+		if name := getAPIKeyName(r); name != "" {
+			span.SetBaggageItem(item, displayKeyName(name))
+		}
+	}
+
+	if item := viper.GetString(flagPluginsAPIKeyBaggageBinding.GetLong()); item != "" {
+		if binding := getBindingID(r); binding != "" {
+			span.SetBaggageItem(item, binding)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestBaggageConfig() func() {
+	viper.Set(flagPluginsAPIKeyBaggageKeyName.GetLong(), "apikey.name")
+	viper.Set(flagPluginsAPIKeyBaggageBinding.GetLong(), "apikey.binding")
+	return func() {
+		viper.Set(flagPluginsAPIKeyBaggageKeyName.GetLong(), "")
+		viper.Set(flagPluginsAPIKeyBaggageBinding.GetLong(), "")
+		viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), false)
+	}
+}
+
+func TestSetDecisionBaggage(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", flagPluginsAPIKeyBaggageKeyName.Value, "baggage should be opt-in")
+	assert.Equal("", flagPluginsAPIKeyBaggageBinding.Value, "baggage should be opt-in")
+
+	r := getTestRequest()
+	setAPIKey(r, getTestAPIKey())
+	setBinding(r, getTestAPIKeyBinding())
+	unconfigured := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	setDecisionBaggage(unconfigured, r)
+	assert.Equal("", unconfigured.BaggageItem("apikey.name"), "baggage should not be set unless configured")
+	assert.Equal("", unconfigured.BaggageItem("apikey.binding"))
+
+	defer setTestBaggageConfig()()
+
+	r = getTestRequest()
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	setDecisionBaggage(span, r)
+	assert.Equal("", span.BaggageItem("apikey.name"), "baggage should not be set before a key is found")
+	assert.Equal("", span.BaggageItem("apikey.binding"))
+
+	setAPIKey(r, getTestAPIKey())
+	setBinding(r, getTestAPIKeyBinding())
+	setDecisionBaggage(span, r)
+	assert.Equal("apikeyone", span.BaggageItem("apikey.name"))
+	assert.Equal("foo/apikeybindingone", span.BaggageItem("apikey.binding"))
+
+	viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), true)
+	viper.Set(flagPluginsAPIKeyBaggageKeyName.GetLong(), "tenant.key")
+	viper.Set(flagPluginsAPIKeyBaggageBinding.GetLong(), "")
+	span = mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	setDecisionBaggage(span, r)
+	assert.Equal(displayKeyName("apikeyone"), span.BaggageItem("tenant.key"), "key names should be masked if configured")
+	assert.Equal("", span.BaggageItem("apikey.binding"), "empty baggage items should not be propagated")
+}
+
+func TestOnRequestDecisionBaggage(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestBaggageConfig()()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), span))
+	assert.Equal("apikeyone", span.BaggageItem("apikey.name"))
+	assert.Equal("foo/apikeybindingone", span.BaggageItem("apikey.binding"))
+
+	spec.BindingStore.Clear()
+	span = mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.NotNil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), span))
+	assert.Equal("", span.BaggageItem("apikey.name"), "baggage should only be set on success")
+}
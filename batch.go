@@ -0,0 +1,163 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+)
+
+// Result is the outcome of validating a single apikey with ValidateKeys
+type Result struct {
+	// Key is the apikey that was validated
+	Key string
+	// Name is the name of the APIKey resource matching the apikey, if any
+	Name string
+	// Allowed is true if the apikey would be authorized
+	Allowed bool
+	// Reason describes why the apikey would be denied
+	Reason string
+}
+
+// Keys of the config map accepted by ValidateKeys
+const (
+	ValidateConfigProxyName      = "proxy_name"
+	ValidateConfigProxyNamespace = "proxy_namespace"
+	ValidateConfigMethod         = "method"
+	ValidateConfigPath           = "path"
+)
+
+// ValidateKeys runs the authorization decision for each of the given apikeys
+// without an HTTP request, so that apikeys and bindings can be verified
+// before configuration is promoted. The config map describes the request
+// being simulated: the proxy_name and proxy_namespace of the APIProxy, and
+// the HTTP method (GET by default) and target path (/ by default). Rate
+// limits and quotas are not checked and no traffic is recorded.
+func ValidateKeys(keys []string, config map[string]string) []Result {
+	p := spec.APIProxy{}
+	p.ObjectMeta.Name = config[ValidateConfigProxyName]
+	p.ObjectMeta.Namespace = config[ValidateConfigProxyNamespace]
+
+	method := strings.ToUpper(config[ValidateConfigMethod])
+	if method == "" {
+		method = "GET"
+	}
+	path := config[ValidateConfigPath]
+	if path == "" {
+		path = "/"
+	}
+
+	results := make([]Result, 0, len(keys))
+	for _, apiKey := range keys {
+		result := Result{Key: apiKey}
+		name, err := validateKey(apiKey, p, method, path)
+		result.Name = name
+		if err != nil {
+			result.Reason = err.Error()
+		} else {
+			result.Allowed = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// validateKey runs the decision made by validateRequest, up to and including
+// the rule decision, for a single apikey. Checks of the request itself, such
+// as its signature, referer, or client certificate, are skipped. The name of
+// the matching APIKey resource is returned if one was found.
+func validateKey(apiKey string, p spec.APIProxy, method, targetPath string) (string, error) {
+	if apiKey == "" {
+		return "", errors.New("apikey not found in request")
+	}
+	if !isWellFormedAPIKey(apiKey) || !hasAllowedPrefix(apiKey) {
+		return "", errors.New("apikey is malformed")
+	}
+
+	storeKey, _ := stripKeyPrefix(apiKey)
+	var secret string
+	if isTwoPartMode() {
+		var err error
+		if storeKey, secret, err = splitKeySecret(storeKey); err != nil {
+			return "", err
+		}
+	}
+	untypedKey, _, err := findAPIKey(context.Background(), storeKey)
+	if err != nil {
+		return "", getStoreUnavailableError()
+	}
+	key, ok := untypedKey.(spec.APIKey)
+	if !ok {
+		return "", errors.New("apikey not found in k8s cluster")
+	}
+
+	name := key.ObjectMeta.Name
+	if isTwoPartMode() {
+		if _, err := verifyKeySecret(key, secret, time.Now()); err != nil {
+			return name, err
+		}
+	}
+	credential := storeKey
+	if isTwoPartMode() {
+		credential = secret
+	}
+	if err := validateKeyStrength(key, credential); err != nil {
+		return name, err
+	}
+	if err := validateNamespace(key, p.ObjectMeta.Namespace); err != nil {
+		return name, err
+	}
+	if err := validateAllowedHours(key, time.Now()); err != nil {
+		return name, err
+	}
+	if key, err = resolveAlias(key, time.Now()); err != nil {
+		return name, err
+	}
+
+	untypedBinding, err := getWithRetry(context.Background(), func() (interface{}, error) {
+		return localBindingStore.Get(getBindingProxyName(p), p.ObjectMeta.Namespace)
+	})
+	if err != nil {
+		return name, getStoreUnavailableError()
+	}
+	binding, ok := untypedBinding.(spec.APIKeyBinding)
+	if !ok {
+		return name, errors.New("no binding found for associated APIProxy")
+	}
+	if err := validateBindingMetadata(binding, getBindingProxyName(p), p.ObjectMeta.Namespace); err != nil {
+		return name, err
+	}
+
+	// the rule decision is made for a request carrying nothing but the method and path
+	r := &http.Request{Method: method, URL: &url.URL{Path: targetPath}, Header: http.Header{}}
+	span := opentracing.NoopTracer{}.StartSpan("validate keys")
+	defer span.Finish()
+	_, _, _, err = decideRule(context.Background(), &metrics.Metrics{}, p, r, span, apiKey, key, binding, targetPath)
+	return name, err
+}
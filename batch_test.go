@@ -0,0 +1,87 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateKeys(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+
+	other := getTestAPIKey()
+	other.ObjectMeta.Name = "apikeytwo"
+	other.Spec.APIKeyData = "otherapikey"
+	readOnly := getTestAPIKey()
+	readOnly.ObjectMeta.Name = "apikeythree"
+	readOnly.Spec.APIKeyData = "readonlyapikey"
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys = append(binding.Spec.Keys, spec.Key{
+		Name: "apikeythree",
+		DefaultRule: spec.Rule{
+			Granular: &spec.GranularProxy{Verbs: []string{"GET"}},
+		},
+	})
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.KeyStore.Set(other)
+	spec.KeyStore.Set(readOnly)
+	spec.BindingStore.Set(binding)
+
+	config := map[string]string{
+		ValidateConfigProxyName:      "APIProxyone",
+		ValidateConfigProxyNamespace: "foo",
+	}
+	assert.Equal([]Result{
+		{"myapikey", "apikeyone", true, ""},
+		{"otherapikey", "apikeytwo", false, "api key not authorized for this proxy"},
+		{"readonlyapikey", "apikeythree", true, ""},
+		{"unknownapikey", "", false, "apikey not found in k8s cluster"},
+		{"", "", false, "apikey not found in request"},
+	}, ValidateKeys([]string{"myapikey", "otherapikey", "readonlyapikey", "unknownapikey", ""}, config))
+
+	config[ValidateConfigMethod] = "post"
+	config[ValidateConfigPath] = "/accounts"
+	assert.Equal([]Result{
+		{"myapikey", "apikeyone", true, ""},
+		{"readonlyapikey", "apikeythree", false, "api key unauthorized"},
+	}, ValidateKeys([]string{"myapikey", "readonlyapikey"}, config))
+
+	SetAuthorizer(AuthorizerFunc(func(ctx context.Context, authContext AuthContext) (bool, string) {
+		return authContext.APIKey.ObjectMeta.Name != "apikeyone", "apikeyone is suspended"
+	}))
+	assert.Equal([]Result{
+		{"myapikey", "apikeyone", false, "apikeyone is suspended"},
+	}, ValidateKeys([]string{"myapikey"}, config))
+	SetAuthorizer(nil)
+
+	config[ValidateConfigProxyName] = "APIProxytwo"
+	assert.Equal([]Result{
+		{"myapikey", "apikeyone", false, "no binding found for associated APIProxy"},
+	}, ValidateKeys([]string{"myapikey"}, config))
+
+	assert.Equal([]Result{}, ValidateKeys(nil, config))
+}
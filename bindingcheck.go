@@ -0,0 +1,51 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+var errBindingMismatch = &utils.StatusError{http.StatusForbidden, errors.New("binding does not match the associated APIProxy")}
+
+// validateBindingMetadata will return an error if the given binding, as
+// returned by a store, is not the binding that was requested for the given
+// APIProxy name and namespace. Such a binding indicates an inconsistent
+// store, so it is logged and never trusted.
+func validateBindingMetadata(binding spec.APIKeyBinding, proxyName, namespace string) error {
+	if binding.Spec.APIProxyName == proxyName && binding.ObjectMeta.Namespace == namespace {
+		return nil
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"binding_name":             binding.ObjectMeta.Name,
+		"binding_namespace":        binding.ObjectMeta.Namespace,
+		"binding_proxy_name":       binding.Spec.APIProxyName,
+		"expected_proxy_name":      proxyName,
+		"expected_proxy_namespace": namespace,
+	}).Error("binding store returned a binding for another APIProxy")
+	return errBindingMismatch
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBindingMetadata(t *testing.T) {
+	assert := assert.New(t)
+	hook := test.NewGlobal()
+
+	binding := testutil.Binding()
+	assert.Nil(validateBindingMetadata(binding, testutil.ProxyName, testutil.Namespace))
+
+	hook.Reset()
+	assert.Equal(errBindingMismatch, validateBindingMetadata(binding, "otherproxy", testutil.Namespace))
+	assert.NotNil(hook.LastEntry())
+	assert.Equal(logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Equal(testutil.ProxyName, hook.LastEntry().Data["binding_proxy_name"])
+	assert.Equal("otherproxy", hook.LastEntry().Data["expected_proxy_name"])
+
+	assert.Equal(errBindingMismatch, validateBindingMetadata(binding, testutil.ProxyName, "bar"))
+
+	binding.ObjectMeta.Namespace = "bar"
+	assert.Equal(errBindingMismatch, validateBindingMetadata(binding, testutil.ProxyName, testutil.Namespace), "bindings from another namespace should be rejected")
+	assert.Nil(validateBindingMetadata(binding, testutil.ProxyName, "bar"))
+}
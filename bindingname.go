@@ -0,0 +1,95 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyBindingNameMap,
+		flagPluginsAPIKeyBindingHeader,
+		flagPluginsAPIKeyBindingHeaderAllowlist,
+	)
+}
+
+var (
+	flagPluginsAPIKeyBindingNameMap = config.Flag{
+		Long:  "plugins.apiKey.binding_name_map",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of logical=actual pairs used to remap the proxy name a binding is looked up by.",
+	}
+	flagPluginsAPIKeyBindingHeader = config.Flag{
+		Long:  "plugins.apiKey.binding_header",
+		Short: "",
+		Value: "",
+		Usage: "Name of the HTTP header that a client may use to select the binding a request is authorized against. Disabled if empty.",
+	}
+	flagPluginsAPIKeyBindingHeaderAllowlist = config.Flag{
+		Long:  "plugins.apiKey.binding_header_allowlist",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of binding names that may be selected using the binding header.",
+	}
+)
+
+// getBindingProxyName returns the proxy name that the binding for the
+// given APIProxy is stored under. This allows the same APIProxy spec to
+// be promoted across environments in which bindings are named differently.
+// If no mapping exists, the name of the APIProxy is returned.
+func getBindingProxyName(p spec.APIProxy) string {
+	if name, ok := getStringMap(flagPluginsAPIKeyBindingNameMap.GetLong())[p.ObjectMeta.Name]; ok && name != "" {
+		logrus.Debugf("binding name %s has been remapped to %s", p.ObjectMeta.Name, name)
+		return name
+	}
+	return p.ObjectMeta.Name
+}
+
+// getRequestedBindingName returns the proxy name that the binding for the
+// given request is stored under. If a binding header is configured and
+// present on the request, its value is used provided that it appears in the
+// allowlist. Otherwise, the result of getBindingProxyName is returned.
+func getRequestedBindingName(p spec.APIProxy, r *http.Request) (string, error) {
+	header := viper.GetString(flagPluginsAPIKeyBindingHeader.GetLong())
+	if header == "" {
+		return getBindingProxyName(p), nil
+	}
+	name := r.Header.Get(header)
+	if name == "" {
+		return getBindingProxyName(p), nil
+	}
+	for _, allowed := range getStringSlice(flagPluginsAPIKeyBindingHeaderAllowlist.GetLong()) {
+		if name == allowed {
+			logrus.Debugf("binding %s has been selected by the %s header", name, header)
+			return name, nil
+		}
+	}
+	return "", &utils.StatusError{http.StatusForbidden, errors.New("requested binding is not allowed")}
+}
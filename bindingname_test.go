@@ -0,0 +1,130 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBindingProxyName(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyBindingNameMap.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyBindingNameMap.GetLong(), "")
+	assert.Equal("APIProxyone", getBindingProxyName(getTestAPIProxy()), "unmapped names should be used literally")
+
+	viper.Set(flagPluginsAPIKeyBindingNameMap.GetLong(), "APIProxyone=APIProxyone-prod,other=other-prod")
+	assert.Equal("APIProxyone-prod", getBindingProxyName(getTestAPIProxy()))
+
+	viper.Set(flagPluginsAPIKeyBindingNameMap.GetLong(), "APIProxyone=")
+	assert.Equal("APIProxyone", getBindingProxyName(getTestAPIProxy()))
+}
+
+func TestOnRequestBindingNameMap(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyBindingNameMap.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.APIProxyName = "APIProxyone-prod"
+
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Clear()
+	spec.BindingStore.Set(binding)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+
+	viper.Set(flagPluginsAPIKeyBindingNameMap.GetLong(), "")
+	assert.Equal("no binding found for associated APIProxy", Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")).Error())
+
+	viper.Set(flagPluginsAPIKeyBindingNameMap.GetLong(), "APIProxyone=APIProxyone-prod")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+}
+
+func TestGetRequestedBindingName(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyBindingHeader.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyBindingHeaderAllowlist.GetLong(), "")
+
+	r := getTestRequest()
+	r.Header.Set("X-Binding", "orders")
+
+	name, err := getRequestedBindingName(getTestAPIProxy(), r)
+	assert.Nil(err)
+	assert.Equal("APIProxyone", name, "the header should be ignored when not configured")
+
+	viper.Set(flagPluginsAPIKeyBindingHeader.GetLong(), "X-Binding")
+	viper.Set(flagPluginsAPIKeyBindingHeaderAllowlist.GetLong(), "orders,invoices")
+	name, err = getRequestedBindingName(getTestAPIProxy(), r)
+	assert.Nil(err)
+	assert.Equal("orders", name)
+
+	r.Header.Set("X-Binding", "payments")
+	_, err = getRequestedBindingName(getTestAPIProxy(), r)
+	assert.Equal(403, getStatusCode(err))
+	assert.Equal("requested binding is not allowed", err.Error())
+
+	r.Header.Del("X-Binding")
+	name, err = getRequestedBindingName(getTestAPIProxy(), r)
+	assert.Nil(err)
+	assert.Equal("APIProxyone", name)
+
+	viper.Set(flagPluginsAPIKeyBindingHeaderAllowlist.GetLong(), "")
+	r.Header.Set("X-Binding", "orders")
+	_, err = getRequestedBindingName(getTestAPIProxy(), r)
+	assert.NotNil(err, "no binding may be selected when the allowlist is empty")
+}
+
+func TestOnRequestBindingHeader(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyBindingHeader.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyBindingHeaderAllowlist.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyBindingHeader.GetLong(), "X-Binding")
+	viper.Set(flagPluginsAPIKeyBindingHeaderAllowlist.GetLong(), "orders")
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.APIProxyName = "orders"
+
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Clear()
+	spec.BindingStore.Set(binding)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+
+	r := getTestRequest()
+	r.Header.Set("X-Binding", "orders")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+
+	r = getTestRequest()
+	r.Header.Set("X-Binding", "payments")
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal("requested binding is not allowed", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_binding_not_allowed", "true", true})
+}
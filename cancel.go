@@ -0,0 +1,46 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+// statusClientClosedRequest is the non-standard status code, popularized
+// by nginx, for a request whose client went away before it was answered
+const statusClientClosedRequest = 499
+
+// errRequestCancelled is returned when the context of a request is
+// cancelled, such as by the client disconnecting, before a decision is made
+var errRequestCancelled = &utils.StatusError{statusClientClosedRequest, errors.New("request cancelled by client")}
+
+// checkCancelled returns errRequestCancelled if the given context has
+// been cancelled or has expired, so that no more work is done for a
+// request nobody is waiting on
+func checkCancelled(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return errRequestCancelled
+	}
+	return nil
+}
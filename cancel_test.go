@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(checkCancelled(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Nil(checkCancelled(ctx))
+	cancel()
+	assert.Equal(errRequestCancelled, checkCancelled(ctx))
+	assert.Equal(statusClientClosedRequest, getStatusCode(checkCancelled(ctx)))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	assert.Equal(errRequestCancelled, checkCancelled(ctx), "expired contexts should be treated as cancelled")
+}
+
+func TestOnRequestCancelled(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_on_request_cancelled")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	// a key of its own keeps traffic emitted by other tests out of the count
+	key := testutil.NamedKey("apikeycancelled", "mycancelledapikey")
+	keyObj := testutil.GlobalKey(key.ObjectMeta.Name)
+	keyObj.Quota = 1
+	defer testutil.Stores([]spec.APIKey{key}, []spec.APIKeyBinding{testutil.Binding(keyObj)})()
+	defer spec.TrafficStore.Clear()
+
+	// the client is gone before the apikey is looked up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := testutil.Request("GET", testutil.ProxyPath, "mycancelledapikey")
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(ctx, m, testutil.Proxy(), r, testutil.Span())
+	assert.Equal(errRequestCancelled, err)
+	assert.Contains(*m, metrics.Metric{"api_key_cancelled", "true", false})
+	assert.NotContains(*m, metrics.Metric{"api_key_denied", "true", true})
+	assert.Nil(expvar.Get("test_on_request_cancelled"), "cancelled requests should not be counted as decisions")
+	_, ok := getPriorDecision(r, testutil.Proxy())
+	assert.False(ok, "cancelled requests should not be remembered")
+
+	// the client goes away while the request is being authorized
+	defer SetAuthorizer(nil)
+	ctx, cancel = context.WithCancel(context.Background())
+	SetAuthorizer(AuthorizerFunc(func(ctx context.Context, authContext AuthContext) (bool, string) {
+		cancel()
+		return true, ""
+	}))
+	err = Plugin.OnRequest(ctx, &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, "mycancelledapikey"), testutil.Span())
+	assert.Equal(errRequestCancelled, err)
+
+	// traffic is reported asynchronously, so give it time to arrive
+	time.Sleep(10 * time.Millisecond)
+	SetAuthorizer(nil)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, "mycancelledapikey"), testutil.Span()), "traffic should not be reported for cancelled requests")
+}
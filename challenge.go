@@ -0,0 +1,122 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyChallengeRealm,
+		flagPluginsAPIKeyChallengeErrorURI,
+	)
+}
+
+var (
+	flagPluginsAPIKeyChallengeRealm = config.Flag{
+		Long:  "plugins.apiKey.challenge_realm",
+		Short: "",
+		Value: "",
+		Usage: "Realm of the challenge included in the message of 401 responses to requests for APIProxies whose binding does not set its own.",
+	}
+	flagPluginsAPIKeyChallengeErrorURI = config.Flag{
+		Long:  "plugins.apiKey.challenge_error_uri",
+		Short: "",
+		Value: "",
+		Usage: "URI of a page describing authentication errors, included in the challenge of 401 responses to requests for APIProxies whose binding does not set its own.",
+	}
+)
+
+const (
+	// annotationBindingChallengeRealm is the APIKeyBinding annotation
+	// holding the realm of the challenge included in 401 responses
+	annotationBindingChallengeRealm = "apikey.kanali.io/challenge-realm"
+	// annotationBindingChallengeErrorURI is the APIKeyBinding annotation
+	// holding the error_uri of the challenge included in 401 responses
+	annotationBindingChallengeErrorURI = "apikey.kanali.io/challenge-error-uri"
+)
+
+// challengeScheme is the authentication scheme named by the challenge
+const challengeScheme = "APIKey"
+
+// getChallengeParam returns the value of a challenge parameter, read from
+// the given annotation of the given binding or, failing that, the given flag
+func getChallengeParam(binding *spec.APIKeyBinding, annotation string, flag config.Flag) string {
+	if binding != nil {
+		if value, ok := binding.ObjectMeta.Annotations[annotation]; ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return viper.GetString(flag.GetLong())
+}
+
+// quoteChallengeParam quotes the given value as an auth-param value
+func quoteChallengeParam(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// getChallenge returns the challenge, in the form of a WWW-Authenticate
+// header value, for the given binding, which may be nil if the APIProxy has none. An empty string
+// is returned if neither the binding nor the flags set a parameter.
+func getChallenge(binding *spec.APIKeyBinding) string {
+	params := []string{}
+	if realm := getChallengeParam(binding, annotationBindingChallengeRealm, flagPluginsAPIKeyChallengeRealm); realm != "" {
+		params = append(params, "realm="+quoteChallengeParam(realm))
+	}
+	if uri := getChallengeParam(binding, annotationBindingChallengeErrorURI, flagPluginsAPIKeyChallengeErrorURI); uri != "" {
+		params = append(params, "error_uri="+quoteChallengeParam(uri))
+	}
+	if len(params) < 1 {
+		return ""
+	}
+	return challengeScheme + " " + strings.Join(params, ", ")
+}
+
+// withChallenge will, if the given error is a 401, append a challenge built
+// from the binding of the given APIProxy to its message. Kanali writes the
+// message of a plugin error to the response but none of its headers, so the
+// challenge cannot be sent as a WWW-Authenticate header. The binding is
+// looked up again as many requests are rejected before it is consulted.
+func withChallenge(p spec.APIProxy, err error) error {
+	if err == nil || getStatusCode(err) != http.StatusUnauthorized {
+		return err
+	}
+
+	var binding *spec.APIKeyBinding
+	if untypedBinding, lookupErr := localBindingStore.Get(getBindingProxyName(p), p.ObjectMeta.Namespace); lookupErr == nil {
+		if b, ok := untypedBinding.(spec.APIKeyBinding); ok {
+			binding = &b
+		}
+	}
+
+	challenge := getChallenge(binding)
+	if challenge == "" {
+		return err
+	}
+	return withErrorMessage(err, fmt.Sprintf("%s (challenge: %s)", err.Error(), challenge))
+}
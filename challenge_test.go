@@ -0,0 +1,103 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetChallenge(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyChallengeRealm.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyChallengeErrorURI.GetLong(), "")
+
+	assert.Equal("", getChallenge(nil))
+
+	viper.Set(flagPluginsAPIKeyChallengeRealm.GetLong(), "api")
+	assert.Equal(`APIKey realm="api"`, getChallenge(nil))
+	viper.Set(flagPluginsAPIKeyChallengeErrorURI.GetLong(), "https://example.com/errors")
+	assert.Equal(`APIKey realm="api", error_uri="https://example.com/errors"`, getChallenge(nil))
+
+	binding := testutil.Binding()
+	assert.Equal(`APIKey realm="api", error_uri="https://example.com/errors"`, getChallenge(&binding), "bindings without annotations should fall back to the flags")
+
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingChallengeRealm: `accounts "v1"`}
+	assert.Equal(`APIKey realm="accounts \"v1\"", error_uri="https://example.com/errors"`, getChallenge(&binding))
+
+	binding.ObjectMeta.Annotations[annotationBindingChallengeErrorURI] = ""
+	assert.Equal(`APIKey realm="accounts \"v1\""`, getChallenge(&binding), "an empty annotation should remove the parameter")
+}
+
+func TestWithChallenge(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyChallengeRealm.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyChallengeRealm.GetLong(), "api")
+
+	binding := testutil.Binding()
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingChallengeRealm: "accounts"}
+	defer testutil.Stores(nil, []spec.APIKeyBinding{binding})()
+
+	unauthorized := &utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request")}
+	assert.Equal(&utils.StatusError{http.StatusUnauthorized, errors.New(`apikey not found in request (challenge: APIKey realm="accounts")`)}, withChallenge(testutil.Proxy(), unauthorized))
+
+	other := testutil.Proxy()
+	other.ObjectMeta.Name = "APIProxytwo"
+	assert.Equal(`apikey not found in request (challenge: APIKey realm="api")`, withChallenge(other, unauthorized).Error(), "proxies without a binding should use the default")
+
+	viper.Set(flagPluginsAPIKeyChallengeRealm.GetLong(), "")
+	assert.Equal(unauthorized, withChallenge(other, unauthorized), "no challenge should be added if no parameter applies")
+
+	forbidden := &utils.StatusError{http.StatusForbidden, errors.New("api key unauthorized")}
+	assert.Equal(forbidden, withChallenge(testutil.Proxy(), forbidden), "only 401 responses should carry a challenge")
+	assert.Nil(withChallenge(testutil.Proxy(), nil))
+}
+
+func TestOnRequestChallenge(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := testutil.Binding()
+	binding.ObjectMeta.Annotations = map[string]string{
+		annotationBindingChallengeRealm:    "accounts",
+		annotationBindingChallengeErrorURI: "https://example.com/accounts/errors",
+	}
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{binding})()
+
+	challenge := `(challenge: APIKey realm="accounts", error_uri="https://example.com/accounts/errors")`
+
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Contains(err.Error(), challenge)
+
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, "notmyapikey"), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Contains(err.Error(), challenge)
+}
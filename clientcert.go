@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+// annotationKeyClientCertSHA256 is the APIKey annotation holding a comma
+// separated list of the SHA-256 fingerprints of the client certificates
+// that may use the key
+const annotationKeyClientCertSHA256 = "apikey.kanali.io/client-cert-sha256"
+
+// normalizeFingerprint lower cases a hex encoded fingerprint and removes
+// any colons or spaces used to separate its bytes
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+}
+
+// getAllowedClientCerts returns the fingerprints of the client certificates
+// that may use the given APIKey. An empty list is returned if the key is
+// not bound to a client certificate.
+func getAllowedClientCerts(key spec.APIKey) []string {
+	fingerprints := []string{}
+	for _, fingerprint := range strings.Split(key.ObjectMeta.Annotations[annotationKeyClientCertSHA256], ",") {
+		if fingerprint = normalizeFingerprint(fingerprint); fingerprint != "" {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	return fingerprints
+}
+
+// getClientCertFingerprint returns the hex encoded SHA-256 fingerprint of
+// the client certificate presented with the given request. An empty string
+// is returned if the request was not made over TLS with a client certificate.
+func getClientCertFingerprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) < 1 || r.TLS.PeerCertificates[0] == nil {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// validateClientCert will return an error if the given APIKey is bound to
+// client certificates and the given request was not made with one of them
+func validateClientCert(r *http.Request, key spec.APIKey) error {
+	allowed := getAllowedClientCerts(key)
+	if len(allowed) < 1 {
+		return nil
+	}
+
+	fingerprint := getClientCertFingerprint(r)
+	if fingerprint == "" {
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("client certificate required for this api key")}
+	}
+
+	for _, a := range allowed {
+		if a == fingerprint {
+			return nil
+		}
+	}
+	return &utils.StatusError{http.StatusForbidden, errors.New("client certificate not allowed for this api key")}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestClientCertAPIKey(fingerprints string) spec.APIKey {
+	key := getTestAPIKey()
+	key.ObjectMeta.Annotations = map[string]string{
+		annotationKeyClientCertSHA256: fingerprints,
+	}
+	return key
+}
+
+func getTestClientCertRequest(raw string) *http.Request {
+	r := getTestRequest()
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Raw: []byte(raw)}},
+	}
+	return r
+}
+
+func getTestFingerprint(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestGetAllowedClientCerts(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{}, getAllowedClientCerts(getTestAPIKey()))
+	assert.Equal([]string{"ab01ff", "cd02"}, getAllowedClientCerts(getTestClientCertAPIKey(" AB:01:FF, ,cd02 ")))
+}
+
+func TestGetClientCertFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", getClientCertFingerprint(getTestRequest()))
+
+	r := getTestRequest()
+	r.TLS = &tls.ConnectionState{}
+	assert.Equal("", getClientCertFingerprint(r), "tls requests without a client certificate have no fingerprint")
+
+	assert.Equal(getTestFingerprint("cert one"), getClientCertFingerprint(getTestClientCertRequest("cert one")))
+}
+
+func TestValidateClientCert(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(validateClientCert(getTestRequest(), getTestAPIKey()), "unbound keys should not require a client certificate")
+
+	key := getTestClientCertAPIKey(getTestFingerprint("cert two") + "," + strings.ToUpper(getTestFingerprint("cert one")))
+	assert.Nil(validateClientCert(getTestClientCertRequest("cert one"), key))
+	assert.Nil(validateClientCert(getTestClientCertRequest("cert two"), key))
+
+	err := validateClientCert(getTestClientCertRequest("cert three"), key)
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("client certificate not allowed for this api key", err.Error())
+
+	err = validateClientCert(getTestRequest(), key)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("client certificate required for this api key", err.Error())
+}
+
+func TestOnRequestClientCert(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestClientCertAPIKey(getTestFingerprint("cert one")))
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestClientCertRequest("cert one"), opentracing.StartSpan("test span")))
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestClientCertRequest("cert two"), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_client_cert_denied", "true", true})
+}
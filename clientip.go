@@ -0,0 +1,167 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyClientIPStrategy,
+		flagPluginsAPIKeyClientPortHeader,
+	)
+}
+
+var (
+	flagPluginsAPIKeyClientIPStrategy = config.Flag{
+		Long:  "plugins.apiKey.client_ip_strategy",
+		Short: "",
+		Value: clientIPRemoteAddr,
+		Usage: "Strategy used to find the IP address of the client that made a request. One of remote-addr, x-forwarded-for-first, x-forwarded-for-last, or x-real-ip. The remote address is used when the chosen header holds no valid IP address.",
+	}
+	flagPluginsAPIKeyClientPortHeader = config.Flag{
+		Long:  "plugins.apiKey.client_port_header",
+		Short: "",
+		Value: "",
+		Usage: "Name of the header, set by a trusted proxy, holding the port of the client that made a request. The port of the remote address is used when the header is missing or malformed. The header is not consulted if empty.",
+	}
+)
+
+// Strategies accepted by plugins.apiKey.client_ip_strategy
+const (
+	clientIPRemoteAddr         = "remote-addr"
+	clientIPXForwardedForFirst = "x-forwarded-for-first"
+	clientIPXForwardedForLast  = "x-forwarded-for-last"
+	clientIPXRealIP            = "x-real-ip"
+)
+
+// getClientIP returns the IP address of the client that made the given
+// request according to the configured strategy. It is used wherever a
+// client is identified by its IP address, such as by lockouts, anonymous
+// rate limits, key sharing detection, and access lines.
+func getClientIP(r *http.Request) string {
+	switch strategy := strings.ToLower(strings.TrimSpace(viper.GetString(flagPluginsAPIKeyClientIPStrategy.GetLong()))); strategy {
+	case "", clientIPRemoteAddr:
+	case clientIPXForwardedForFirst:
+		if ips := getForwardedIPs(r); len(ips) > 0 {
+			return ips[0]
+		}
+	case clientIPXForwardedForLast:
+		if ips := getForwardedIPs(r); len(ips) > 0 {
+			return ips[len(ips)-1]
+		}
+	case clientIPXRealIP:
+		if ip := parseClientIP(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	default:
+		logrus.Warnf("unknown %s %s - the remote address will be used", flagPluginsAPIKeyClientIPStrategy.GetLong(), strategy)
+	}
+	return getRemoteIP(r)
+}
+
+// getClientPort returns the port of the client that made the given request.
+// The last value of the configured client port header is used if it is a
+// valid port, as it was added by the proxy nearest to Kanali. Otherwise the
+// port of the remote address, if any, is used. An empty string is returned
+// if no port is known.
+func getClientPort(r *http.Request) string {
+	if header := viper.GetString(flagPluginsAPIKeyClientPortHeader.GetLong()); header != "" {
+		if values := r.Header[http.CanonicalHeaderKey(header)]; len(values) > 0 {
+			ports := strings.Split(values[len(values)-1], ",")
+			if port := parseClientPort(ports[len(ports)-1]); port != "" {
+				return port
+			}
+		}
+	}
+	_, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return parseClientPort(port)
+}
+
+// getClientAddr returns the IP address and, if known, the port of the
+// client that made the given request in the form of a remote address
+func getClientAddr(r *http.Request) string {
+	ip, port := getClientIP(r), getClientPort(r)
+	if port == "" {
+		return ip
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// parseClientPort returns the port held by the given header value. An
+// empty string is returned if the value is not a port between 1 and 65535.
+func parseClientPort(value string) string {
+	port, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+	if err != nil || port == 0 {
+		return ""
+	}
+	return strconv.FormatUint(port, 10)
+}
+
+// getRemoteIP returns the IP address of the remote address of the given
+// request, or the whole remote address if it does not include a port
+func getRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getForwardedIPs returns the valid IP addresses listed by every
+// X-Forwarded-For header of the given request, in order
+func getForwardedIPs(r *http.Request) []string {
+	ips := []string{}
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, value := range strings.Split(header, ",") {
+			if ip := parseClientIP(value); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// parseClientIP returns the IP address held by the given header value,
+// which may include a port. An empty string is returned if the value is
+// not a valid IP address, so that a crafted header cannot inject text.
+func parseClientIP(value string) string {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	ip := net.ParseIP(strings.Trim(value, "[]"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetClientIP(t *testing.T) {
+	assert := assert.New(t)
+
+	r := getTestRequest()
+	r.RemoteAddr = "10.0.0.1:52314"
+	assert.Equal("10.0.0.1", getClientIP(r))
+	r.RemoteAddr = "[::1]:52314"
+	assert.Equal("::1", getClientIP(r))
+	r.RemoteAddr = "10.0.0.1"
+	assert.Equal("10.0.0.1", getClientIP(r))
+}
+
+func TestGetClientIPStrategies(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), "")
+
+	r := getTestRequest()
+	r.RemoteAddr = "10.0.0.1:52314"
+	r.Header.Add("X-Forwarded-For", "203.0.113.7, 198.51.100.2")
+	r.Header.Add("X-Forwarded-For", "192.0.2.9:8080")
+	r.Header.Set("X-Real-IP", "198.51.100.44")
+
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPRemoteAddr)
+	assert.Equal("10.0.0.1", getClientIP(r))
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXForwardedForFirst)
+	assert.Equal("203.0.113.7", getClientIP(r))
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXForwardedForLast)
+	assert.Equal("192.0.2.9", getClientIP(r))
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXRealIP)
+	assert.Equal("198.51.100.44", getClientIP(r))
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), "bogus")
+	assert.Equal("10.0.0.1", getClientIP(r))
+}
+
+func TestGetClientIPCraftedHeaders(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), "")
+
+	r := getTestRequest()
+	r.RemoteAddr = "10.0.0.1:52314"
+	r.Header.Set("X-Forwarded-For", "not-an-ip, , [2001:db8::1]:443, <script>")
+	r.Header.Set("X-Real-IP", "10.0.0.2; drop table")
+
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXForwardedForFirst)
+	assert.Equal("2001:db8::1", getClientIP(r))
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXForwardedForLast)
+	assert.Equal("2001:db8::1", getClientIP(r))
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXRealIP)
+	assert.Equal("10.0.0.1", getClientIP(r))
+
+	r.Header.Set("X-Forwarded-For", "garbage")
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXForwardedForFirst)
+	assert.Equal("10.0.0.1", getClientIP(r))
+	r.Header.Del("X-Forwarded-For")
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXForwardedForLast)
+	assert.Equal("10.0.0.1", getClientIP(r))
+}
+
+func TestGetClientPort(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyClientPortHeader.GetLong(), "")
+
+	r := getTestRequest()
+	r.RemoteAddr = "10.0.0.1:52314"
+	r.Header.Set("X-Client-Port", "40000")
+	assert.Equal("52314", getClientPort(r), "the header should not be consulted unless configured")
+
+	viper.Set(flagPluginsAPIKeyClientPortHeader.GetLong(), "x-client-port")
+	assert.Equal("40000", getClientPort(r))
+	r.Header.Set("X-Client-Port", " 1234, 40001 ")
+	assert.Equal("40001", getClientPort(r), "the last value should be used")
+	r.Header.Add("X-Client-Port", "40002")
+	assert.Equal("40002", getClientPort(r), "the last header should be used")
+
+	for _, malformed := range []string{"", "port", "0", "65536", "-1", "80; drop", "1.5"} {
+		r.Header.Set("X-Client-Port", malformed)
+		assert.Equal("52314", getClientPort(r), "malformed value %q", malformed)
+	}
+
+	r.Header.Del("X-Client-Port")
+	assert.Equal("52314", getClientPort(r))
+	r.RemoteAddr = "[::1]:8443"
+	assert.Equal("8443", getClientPort(r))
+	r.RemoteAddr = "10.0.0.1"
+	assert.Equal("", getClientPort(r))
+}
+
+func TestGetClientAddr(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyClientPortHeader.GetLong(), "")
+
+	r := getTestRequest()
+	r.RemoteAddr = "10.0.0.1:52314"
+	assert.Equal("10.0.0.1:52314", getClientAddr(r))
+	r.RemoteAddr = "10.0.0.1"
+	assert.Equal("10.0.0.1", getClientAddr(r))
+
+	viper.Set(flagPluginsAPIKeyClientIPStrategy.GetLong(), clientIPXRealIP)
+	viper.Set(flagPluginsAPIKeyClientPortHeader.GetLong(), "X-Real-Port")
+	r.Header.Set("X-Real-IP", "2001:db8::1")
+	r.Header.Set("X-Real-Port", "40000")
+	assert.Equal("[2001:db8::1]:40000", getClientAddr(r))
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyConfig,
+	)
+}
+
+var (
+	flagPluginsAPIKeyConfig = config.Flag{
+		Long:  "plugins.apiKey.config",
+		Short: "",
+		Value: "",
+		Usage: "JSON document holding plugin configuration items keyed by their name without the plugins.apiKey. prefix.",
+	}
+)
+
+// configPrefix is the prefix shared by every configuration item of this plugin
+const configPrefix = "plugins.apiKey."
+
+// configEnvPrefix is the prefix shared by the name of every environment
+// variable that holds a configuration item
+const configEnvPrefix = "KANALI_"
+
+var configEnvOnce sync.Once
+
+// loadConfigDefaults applies the environment variables the first time it
+// is called and the plugins.apiKey.config document whenever it changes.
+// Items in the document take precedence over environment variables.
+func loadConfigDefaults() {
+	configEnvOnce.Do(func() {
+		applyConfigEnv(os.LookupEnv, viper.SetDefault)
+	})
+	reloadConfigDocument(viper.GetString(flagPluginsAPIKeyConfig.GetLong()))
+}
+
+// getConfigEnvName returns the name of the environment variable holding
+// the given configuration item, such as KANALI_PLUGINS_APIKEY_HEADER_KEY
+// for plugins.apiKey.header_key
+func getConfigEnvName(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Replace(name, ".", "_", -1))
+}
+
+// applyConfigEnv passes the value of the environment variable of every
+// configuration item of this plugin that has one to the given setter. As
+// with the configuration document, the setter used at runtime registers
+// each value as a default so that items set individually, such as in the
+// configuration of a proxy, continue to take precedence.
+func applyConfigEnv(lookup func(key string) (string, bool), set func(key string, value interface{})) {
+	for _, f := range config.Flags {
+		if !strings.HasPrefix(f.GetLong(), configPrefix) {
+			continue
+		}
+		if value, ok := lookup(getConfigEnvName(f.GetLong())); ok {
+			set(f.GetLong(), value)
+		}
+	}
+}
+
+// applyConfigDocument parses a JSON document whose keys are configuration
+// item names, without the plugins.apiKey. prefix, and passes each value to
+// the given setter. The setter used at runtime registers each value as the
+// default of the matching configuration item so that items set
+// individually continue to take precedence over the document.
+//
+// Arrays are converted to comma separated lists and objects to comma
+// separated key=value pairs so that nested values, such as a list of
+// stores, can be expressed naturally.
+func applyConfigDocument(document string, set func(key string, value interface{})) error {
+	if strings.TrimSpace(document) == "" {
+		return nil
+	}
+
+	items := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(document), &items); err != nil {
+		return err
+	}
+
+	for name, value := range items {
+		if !isConfigItem(configPrefix+name) || configPrefix+name == flagPluginsAPIKeyConfig.GetLong() {
+			logrus.Warnf("unknown configuration item %s will be ignored", name)
+			continue
+		}
+
+		flat, err := flattenConfigValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err.Error())
+		}
+		set(configPrefix+name, flat)
+	}
+	return nil
+}
+
+// isConfigItem will return true if a configuration item with the given name exists
+func isConfigItem(name string) bool {
+	for _, f := range config.Flags {
+		if f.GetLong() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenConfigValue converts a value decoded from a JSON document
+// into the representation used by flat configuration items
+func flattenConfigValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			s, err := flattenConfigScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = s
+		}
+		return strings.Join(values, ","), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			s, err := flattenConfigScalar(v[key])
+			if err != nil {
+				return nil, err
+			}
+			pairs[i] = key + "=" + s
+		}
+		return strings.Join(pairs, ","), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+		return v, nil
+	case string, bool:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// flattenConfigScalar converts a scalar value decoded from a JSON
+// document into a string
+func flattenConfigScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported nested value %v", value)
+	}
+}
+
+// getStringSlice returns the value of a comma separated configuration
+// item as a slice. Whitespace surrounding each value is removed and
+// empty values are omitted.
+func getStringSlice(key string) []string {
+	return splitStringSlice(viper.GetString(key))
+}
+
+// splitStringSlice splits a comma separated list as getStringSlice does
+func splitStringSlice(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getStringMap returns the value of a configuration item of the form
+// key1=value1,key2=value2 as a map. Malformed entries are omitted.
+func getStringMap(key string) map[string]string {
+	return parseStringMap(viper.GetString(key))
+}
+
+// parseStringMap parses a list of the form key1=value1,key2=value2
+// as getStringMap does
+func parseStringMap(list string) map[string]string {
+	values := map[string]string{}
+	for _, entry := range splitStringSlice(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values
+}
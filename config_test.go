@@ -0,0 +1,175 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStringSlice(t *testing.T) {
+	assert := assert.New(t)
+
+	viper.Set("test.slice", "")
+	assert.Equal([]string{}, getStringSlice("test.slice"))
+
+	viper.Set("test.slice", "foo")
+	assert.Equal([]string{"foo"}, getStringSlice("test.slice"))
+
+	viper.Set("test.slice", " foo, bar ,,baz ")
+	assert.Equal([]string{"foo", "bar", "baz"}, getStringSlice("test.slice"))
+
+	assert.Equal([]string{}, getStringSlice("test.missing"))
+}
+
+func TestGetStringMap(t *testing.T) {
+	assert := assert.New(t)
+
+	viper.Set("test.map", "")
+	assert.Equal(map[string]string{}, getStringMap("test.map"))
+
+	viper.Set("test.map", "foo=bar, baz = qux,malformed,=empty,url=http://host.com?a=b")
+	assert.Equal(map[string]string{
+		"foo": "bar",
+		"baz": "qux",
+		"url": "http://host.com?a=b",
+	}, getStringMap("test.map"))
+}
+
+func TestApplyConfigDocument(t *testing.T) {
+	assert := assert.New(t)
+
+	items := map[string]interface{}{}
+	set := func(key string, value interface{}) {
+		items[key] = value
+	}
+
+	assert.Nil(applyConfigDocument("", set))
+	assert.NotNil(applyConfigDocument("{", set))
+	assert.NotNil(applyConfigDocument(`{"key_store_order": [["local"]]}`, set))
+	assert.NotNil(applyConfigDocument(`{"binding_name_map": {"foo": {"bar": "baz"}}}`, set))
+
+	items = map[string]interface{}{}
+	assert.Nil(applyConfigDocument(`{
+		"header_key": "x-api-key",
+		"key_store_order": ["federated", "local"],
+		"binding_name_map": {"foo": "foo-prod", "bar": "bar-prod"},
+		"store_retries": 5,
+		"soft_deny": true,
+		"config": "{}",
+		"unknown": "ignored"
+	}`, set))
+
+	assert.Equal(map[string]interface{}{
+		flagPluginsAPIKeyHeaderKey.GetLong():      "x-api-key",
+		flagPluginsAPIKeyKeyStoreOrder.GetLong():  "federated,local",
+		flagPluginsAPIKeyBindingNameMap.GetLong(): "bar=bar-prod,foo=foo-prod",
+		flagPluginsAPIKeyStoreRetries.GetLong():   5,
+		flagPluginsAPIKeySoftDeny.GetLong():       true,
+	}, items)
+
+	viper.Set("test.document.slice", items[flagPluginsAPIKeyKeyStoreOrder.GetLong()])
+	viper.Set("test.document.map", items[flagPluginsAPIKeyBindingNameMap.GetLong()])
+	assert.Equal([]string{"federated", "local"}, getStringSlice("test.document.slice"))
+	assert.Equal(map[string]string{"foo": "foo-prod", "bar": "bar-prod"}, getStringMap("test.document.map"))
+}
+
+func TestFlattenConfigValue(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{"foo", "foo"},
+		{true, true},
+		{float64(3), 3},
+		{1.5, 1.5},
+		{[]interface{}{"a", true, float64(2), 2.5}, "a,true,2,2.5"},
+		{map[string]interface{}{"b": "2", "a": float64(1)}, "a=1,b=2"},
+	} {
+		actual, err := flattenConfigValue(test.value)
+		assert.Nil(err)
+		assert.Equal(test.expected, actual)
+	}
+
+	_, err := flattenConfigValue(nil)
+	assert.NotNil(err)
+	_, err = flattenConfigValue([]interface{}{nil})
+	assert.NotNil(err)
+}
+
+func TestGetConfigEnvName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("KANALI_PLUGINS_APIKEY_HEADER_KEY", getConfigEnvName(flagPluginsAPIKeyHeaderKey.GetLong()))
+	assert.Equal("KANALI_PLUGINS_APIKEY_CONFIG", getConfigEnvName(flagPluginsAPIKeyConfig.GetLong()))
+}
+
+func TestApplyConfigEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	env := map[string]string{
+		"KANALI_PLUGINS_APIKEY_HEADER_KEY": "x-api-key",
+		"KANALI_PLUGINS_APIKEY_FAIL_OPEN":  "true",
+		"KANALI_PLUGINS_APIKEY_UNKNOWN":    "ignored",
+		"PLUGINS_APIKEY_SOFT_DENY":         "true",
+	}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	items := map[string]interface{}{}
+	applyConfigEnv(lookup, func(key string, value interface{}) {
+		items[key] = value
+	})
+	assert.Equal(map[string]interface{}{
+		flagPluginsAPIKeyHeaderKey.GetLong(): "x-api-key",
+		flagPluginsAPIKeyFailOpen.GetLong():  "true",
+	}, items)
+}
+
+func TestApplyConfigEnvPrecedence(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.SetDefault(flagPluginsAPIKeyDenyLogLevels.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyDenyLogLevels.GetLong(), "")
+
+	lookup := func(key string) (string, bool) {
+		if key == getConfigEnvName(flagPluginsAPIKeyDenyLogLevels.GetLong()) {
+			return "quota_limit_reached=warn", true
+		}
+		return "", false
+	}
+
+	applyConfigEnv(lookup, viper.SetDefault)
+	assert.Equal("quota_limit_reached=warn", viper.GetString(flagPluginsAPIKeyDenyLogLevels.GetLong()), "environment variables should be used when an item is absent")
+	assert.Equal(logrus.WarnLevel, getDenyLogLevel("quota_limit_reached"))
+
+	assert.Nil(applyConfigDocument(`{"deny_log_levels": {"quota_limit_reached": "error"}}`, viper.SetDefault))
+	assert.Equal(logrus.ErrorLevel, getDenyLogLevel("quota_limit_reached"), "the configuration document should take precedence over environment variables")
+
+	viper.Set(flagPluginsAPIKeyDenyLogLevels.GetLong(), "quota_limit_reached=debug")
+	assert.Equal(logrus.DebugLevel, getDenyLogLevel("quota_limit_reached"), "items set individually should take precedence over environment variables")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+// configDocument records the plugins.apiKey.config document that was last
+// applied and the items it set, so that a changed document can be detected
+// and applied without restarting Kanali
+var configDocument = struct {
+	sync.RWMutex
+	loaded   bool
+	document string
+	items    []string
+}{}
+
+// reloadConfigDocument applies the given plugins.apiKey.config document if
+// it differs from the one last applied. Items set by the previous document
+// that the given document omits are restored to their environment variable
+// or default value, and every cache derived from the configuration is
+// invalidated. True is returned if the document was applied.
+func reloadConfigDocument(document string) bool {
+	configDocument.RLock()
+	unchanged := configDocument.loaded && document == configDocument.document
+	configDocument.RUnlock()
+	if unchanged {
+		return false
+	}
+
+	configDocument.Lock()
+	defer configDocument.Unlock()
+	if configDocument.loaded && document == configDocument.document {
+		return false
+	}
+
+	for _, name := range configDocument.items {
+		resetConfigItem(name)
+	}
+	items := []string{}
+	err := applyConfigDocument(document, func(key string, value interface{}) {
+		items = append(items, key)
+		viper.SetDefault(key, value)
+	})
+	if err != nil {
+		logrus.Errorf("could not apply %s: %s", flagPluginsAPIKeyConfig.GetLong(), err.Error())
+	}
+
+	if configDocument.loaded {
+		invalidateConfigCaches()
+		logrus.Infof("%s has changed and has been reapplied", flagPluginsAPIKeyConfig.GetLong())
+	}
+	configDocument.loaded, configDocument.document, configDocument.items = true, document, items
+	return true
+}
+
+// resetConfigItem restores the default of the given configuration item to
+// the value of its environment variable, if set, or of its flag otherwise
+func resetConfigItem(name string) {
+	if value, ok := os.LookupEnv(getConfigEnvName(name)); ok {
+		viper.SetDefault(name, value)
+		return
+	}
+	for _, f := range config.Flags {
+		if f.GetLong() == name {
+			viper.SetDefault(name, f.Value)
+			return
+		}
+	}
+}
+
+// invalidateConfigCaches discards the values that were cached under the
+// previous configuration so that they are derived again, and restarts the
+// background workers whose settings have changed
+func invalidateConfigCaches() {
+	decisions.Lock()
+	decisions.entries = map[string]cachedDecision{}
+	decisions.Unlock()
+
+	openAPISpecs.Lock()
+	openAPISpecs.entries = map[string]cachedOpenAPISpec{}
+	openAPISpecs.Unlock()
+
+	reloadDenyWebhook()
+	reloadFederatedStore()
+	reloadDecisionSinkQueue()
+	reloadTrafficBatcher()
+	reloadActiveKeysRefresh()
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfigDocument(t *testing.T) {
+	assert := assert.New(t)
+	defer reloadConfigDocument("")
+
+	assert.True(reloadConfigDocument(`{"validate_options": true, "decision_id_header": "X-Request-Decision"}`))
+	assert.True(viper.GetBool(flagPluginsAPIKeyValidateOptions.GetLong()))
+	assert.Equal("X-Request-Decision", viper.GetString(flagPluginsAPIKeyDecisionIDHeader.GetLong()))
+	assert.False(reloadConfigDocument(`{"validate_options": true, "decision_id_header": "X-Request-Decision"}`), "an unchanged document should not be reapplied")
+
+	decisions.Lock()
+	decisions.entries["cached"] = cachedDecision{expires: time.Now().Add(time.Hour)}
+	decisions.Unlock()
+	openAPISpecs.Lock()
+	openAPISpecs.entries["cached"] = cachedOpenAPISpec{}
+	openAPISpecs.Unlock()
+
+	assert.True(reloadConfigDocument(`{"validate_options": true}`))
+	assert.True(viper.GetBool(flagPluginsAPIKeyValidateOptions.GetLong()))
+	assert.Equal("X-Decision-Id", viper.GetString(flagPluginsAPIKeyDecisionIDHeader.GetLong()), "omitted items should be restored to their default")
+
+	decisions.Lock()
+	assert.Equal(0, len(decisions.entries), "cached decisions should be invalidated")
+	decisions.Unlock()
+	openAPISpecs.Lock()
+	assert.Equal(0, len(openAPISpecs.entries), "cached specs should be invalidated")
+	openAPISpecs.Unlock()
+
+	assert.True(reloadConfigDocument(`not json`))
+	assert.False(viper.GetBool(flagPluginsAPIKeyValidateOptions.GetLong()), "items of a previous document should not survive an invalid one")
+	assert.False(reloadConfigDocument(`not json`))
+}
+
+func TestInvalidateConfigCachesWorkers(t *testing.T) {
+	assert := assert.New(t)
+	defer func() {
+		viper.Set(flagPluginsAPIKeyDenyWebhookURL.GetLong(), "")
+		viper.Set(flagPluginsAPIKeyFederatedStoreURL.GetLong(), "")
+		viper.Set(flagPluginsAPIKeyTrafficBatchSize.GetLong(), 0)
+		invalidateConfigCaches()
+	}()
+
+	viper.Set(flagPluginsAPIKeyDenyWebhookURL.GetLong(), "http://localhost:1")
+	viper.Set(flagPluginsAPIKeyFederatedStoreURL.GetLong(), "http://localhost:1")
+	viper.Set(flagPluginsAPIKeyTrafficBatchSize.GetLong(), 10)
+	invalidateConfigCaches()
+	webhook, store, batcher := getDenyWebhook(), getFederatedStore(), getTrafficBatcher()
+
+	invalidateConfigCaches()
+	assert.True(webhook == getDenyWebhook(), "an unchanged webhook should be kept")
+	assert.True(store == getFederatedStore(), "an unchanged store should be kept")
+	assert.True(batcher == getTrafficBatcher(), "an unchanged batcher should be kept")
+
+	viper.Set(flagPluginsAPIKeyDenyWebhookURL.GetLong(), "http://localhost:2")
+	viper.Set(flagPluginsAPIKeyFederatedStoreURL.GetLong(), "http://localhost:2")
+	viper.Set(flagPluginsAPIKeyTrafficBatchSize.GetLong(), 0)
+	invalidateConfigCaches()
+	assert.Equal("http://localhost:2", getDenyWebhook().url)
+	assert.False(webhook.notify(denyEvent{}), "the previous webhook should be closed")
+	assert.Equal("http://localhost:2", getFederatedStore().url)
+	assert.Nil(getTrafficBatcher(), "a disabled batcher should be stopped")
+}
+
+func TestOnRequestConfigReload(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer reloadConfigDocument("")
+	defer viper.Set(flagPluginsAPIKeyConfig.GetLong(), "")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	request := func(header string) error {
+		r := testutil.Request("GET", testutil.ProxyPath, "")
+		r.Header.Set(header, testutil.KeyData)
+		return Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span())
+	}
+
+	viper.Set(flagPluginsAPIKeyConfig.GetLong(), `{"header_key": "x-api-key"}`)
+	assert.Nil(request("x-api-key"))
+	assert.NotNil(request("apikey"))
+
+	viper.Set(flagPluginsAPIKeyConfig.GetLong(), `{"header_key": "x-tenant-key"}`)
+	assert.Nil(request("x-tenant-key"), "a changed document should be applied without a restart")
+	assert.NotNil(request("x-api-key"))
+}
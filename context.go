@@ -0,0 +1,75 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+// contextKey is the type of every key used by this plugin
+// to store values in a request's context
+type contextKey string
+
+func (c contextKey) String() string {
+	return "kanali-plugin-apikey context key " + string(c)
+}
+
+// Context keys are exported so that Kanali, or another plugin, can
+// retrieve them via plugin.Lookup and read the associated values from
+// the context of a request that has been processed by this plugin. They
+// are declared as interface{} so that the *interface{} returned by
+// plugin.Lookup can be dereferenced without access to contextKey.
+var (
+	// ContextKeyUpstreamTimeout holds the time.Duration configured by the
+	// binding that authorized a request, if any
+	ContextKeyUpstreamTimeout interface{} = contextKey("upstream_timeout")
+	// ContextKeyDecisionID holds the string identifying the authorization
+	// decision made for a request
+	ContextKeyDecisionID interface{} = contextKey("decision_id")
+	// ContextKeyRequestTime holds the time.Time at which the plugin
+	// began processing a request
+	ContextKeyRequestTime interface{} = contextKey("request_time")
+	// ContextKeyAPIKeyName holds the string name of the APIKey resource
+	// that made a request, once it has been found
+	ContextKeyAPIKeyName interface{} = contextKey("api_key_name")
+	// ContextKeyAPIKeyNamespace holds the string namespace of the APIKey
+	// resource that made a request, once it has been found
+	ContextKeyAPIKeyNamespace interface{} = contextKey("api_key_namespace")
+	// ContextKeyBindingName holds the string name of the APIKeyBinding
+	// that was consulted for a request, once it has been found
+	ContextKeyBindingName interface{} = contextKey("binding_name")
+	// ContextKeyBindingNamespace holds the string namespace of the
+	// APIKeyBinding that was consulted for a request, once it has been found
+	ContextKeyBindingNamespace interface{} = contextKey("binding_namespace")
+	// ContextKeyRateLimit holds the RateLimit applied to the
+	// APIKey that made a request, if it has one
+	ContextKeyRateLimit interface{} = contextKey("rate_limit")
+	// ContextKeyAPIKeyLocation holds the string location, either header,
+	// query, or form, the apikey of a request was found in
+	ContextKeyAPIKeyLocation interface{} = contextKey("api_key_location")
+	// ContextKeyAPIKeyPrefix holds the string prefix that was stripped from
+	// the apikey of a request before it was looked up, if any
+	ContextKeyAPIKeyPrefix interface{} = contextKey("api_key_prefix")
+)
+
+// contextKeyPriorDecision holds the priorDecision made for a request. It is
+// unexported because its value is only meaningful to this plugin.
+var contextKeyPriorDecision = contextKey("prior_decision")
+
+// contextKeyDecisionToken holds the decision token issued for a request, if
+// any. It is unexported because the token is only returned to the client.
+var contextKeyDecisionToken = contextKey("decision_token")
@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextKeyCollisions(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.WithValue(context.Background(), "api_key_name", "other plugin")
+	ctx = context.WithValue(ctx, ContextKeyAPIKeyName, testutil.KeyName)
+	assert.Equal("other plugin", ctx.Value("api_key_name"), "plain string keys should not collide")
+	assert.Equal(testutil.KeyName, ctx.Value(ContextKeyAPIKeyName))
+	assert.Equal("kanali-plugin-apikey context key api_key_name", ContextKeyAPIKeyName.(contextKey).String())
+	assert.NotEqual(contextKeyPriorDecision, ContextKeyDecisionID)
+
+	// plugin.Lookup returns a pointer to each exported variable
+	var symbol interface{} = &ContextKeyAPIKeyName
+	lookedUp, ok := symbol.(*interface{})
+	assert.True(ok)
+	assert.Equal(testutil.KeyName, ctx.Value(*lookedUp))
+}
+
+func TestContextKeyRetrieval(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	key := testutil.NamedKey("apikeycontext", "mycontextkey")
+	binding := testutil.Binding(spec.Key{
+		Name:        "apikeycontext",
+		Rate:        &spec.Rate{Amount: 100, Unit: "minute"},
+		DefaultRule: spec.Rule{Global: true},
+	})
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingTimeout: "3s"}
+	defer testutil.Stores([]spec.APIKey{key}, []spec.APIKeyBinding{binding})()
+
+	r := testutil.Request("GET", testutil.ProxyPath, "mycontextkey")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+
+	// read every exported key as another plugin would
+	ctx := r.Context()
+	assert.Equal(3*time.Second, ctx.Value(ContextKeyUpstreamTimeout))
+	id, _ := ctx.Value(ContextKeyDecisionID).(string)
+	assert.NotEqual("", id)
+	_, ok := ctx.Value(ContextKeyRequestTime).(time.Time)
+	assert.True(ok)
+	assert.Equal("apikeycontext", ctx.Value(ContextKeyAPIKeyName))
+	assert.Equal(testutil.Namespace, ctx.Value(ContextKeyAPIKeyNamespace))
+	assert.Equal(binding.ObjectMeta.Name, ctx.Value(ContextKeyBindingName))
+	assert.Equal(binding.ObjectMeta.Namespace, ctx.Value(ContextKeyBindingNamespace))
+	limit, ok := ctx.Value(ContextKeyRateLimit).(RateLimit)
+	assert.True(ok)
+	assert.Equal(100, limit.Limit)
+	assert.Equal(keyLocationHeader, ctx.Value(ContextKeyAPIKeyLocation))
+	assert.Equal("", ctx.Value(ContextKeyAPIKeyPrefix))
+}
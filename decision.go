@@ -0,0 +1,124 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDecisionIDHeader,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDecisionIDHeader = config.Flag{
+		Long:  "plugins.apiKey.decision_id_header",
+		Short: "",
+		Value: "X-Decision-Id",
+		Usage: "Name of the HTTP response header holding the decision id. Decision ids are not echoed to clients if empty.",
+	}
+)
+
+// decisionIDLength is the number of random bytes in a decision id
+const decisionIDLength = 8
+
+// newDecisionID generates a short, random identifier for the
+// authorization decision made for a single request
+func newDecisionID() string {
+	b := make([]byte, decisionIDLength)
+	if _, err := rand.Read(b); err != nil {
+		logrus.Warnf("could not generate decision id: %s", err.Error())
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// setDecisionID stores the given decision id in the context of the given request
+func setDecisionID(r *http.Request, id string) {
+	*r = *r.WithContext(context.WithValue(r.Context(), ContextKeyDecisionID, id))
+}
+
+// getDecisionID retrieves the decision id from the context of the given
+// request. An empty string is returned if no decision has been made.
+func getDecisionID(r *http.Request) string {
+	id, _ := r.Context().Value(ContextKeyDecisionID).(string)
+	return id
+}
+
+// logDecision logs the outcome of the decision made for a request.
+// Denials are sampled according to the configured sample rate and
+// logged at the level configured for their reason.
+func logDecision(p spec.APIProxy, r *http.Request, id string, err error) {
+	entry := logrus.WithFields(logrus.Fields{
+		"decision_id":     id,
+		"method":          r.Method,
+		"proxy_name":      p.ObjectMeta.Name,
+		"proxy_namespace": p.ObjectMeta.Namespace,
+	})
+	if prefix := getAPIKeyPrefix(r); prefix != "" {
+		entry = entry.WithField("key_prefix", prefix)
+	}
+
+	if err == nil {
+		entry.Debug("request authorized")
+		return
+	}
+	if shouldLogDenial() {
+		logAtLevel(entry.WithField("reason", err.Error()), getDenyLogLevel(getDenyReasonCode(err)), "request denied")
+	}
+}
+
+// withDecisionID will, if decision ids are echoed to clients, append the
+// given decision id to the message of the given error so that it is
+// included in the body of the error response
+func withDecisionID(err error, id string) error {
+	if err == nil || viper.GetString(flagPluginsAPIKeyDecisionIDHeader.GetLong()) == "" {
+		return err
+	}
+
+	return withErrorMessage(err, fmt.Sprintf("%s (decision id: %s)", err.Error(), id))
+}
+
+// setDecisionIDHeader will, if decision ids are echoed to clients, set the
+// decision id made for the given request on the given response
+func setDecisionIDHeader(r *http.Request, resp *http.Response) {
+	header := viper.GetString(flagPluginsAPIKeyDecisionIDHeader.GetLong())
+	id := getDecisionID(r)
+	if header == "" || id == "" || resp == nil {
+		return
+	}
+
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	resp.Header.Set(header, id)
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDecisionID(t *testing.T) {
+	assert := assert.New(t)
+
+	id := newDecisionID()
+	assert.Equal(2*decisionIDLength, len(id))
+	assert.NotEqual(id, newDecisionID())
+}
+
+func TestGetDecisionID(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &http.Request{}
+	assert.Equal("", getDecisionID(r))
+	setDecisionID(r, "abc123")
+	assert.Equal("abc123", getDecisionID(r))
+}
+
+func TestWithDecisionID(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "")
+
+	err := &utils.StatusError{http.StatusUnauthorized, errors.New("api key unauthorized")}
+
+	viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "")
+	assert.Equal(err, withDecisionID(err, "abc123"))
+
+	viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "X-Decision-Id")
+	assert.Nil(withDecisionID(nil, "abc123"))
+	assert.Equal(&utils.StatusError{http.StatusUnauthorized, errors.New("api key unauthorized (decision id: abc123)")}, withDecisionID(err, "abc123"))
+	assert.Equal(errors.New("foo (decision id: abc123)"), withDecisionID(errors.New("foo"), "abc123"))
+}
+
+func TestSetDecisionIDHeader(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "")
+
+	r := &http.Request{}
+	setDecisionID(r, "abc123")
+
+	viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "")
+	resp := &http.Response{}
+	setDecisionIDHeader(r, resp)
+	assert.Nil(resp.Header)
+
+	viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "X-Decision-Id")
+	setDecisionIDHeader(r, resp)
+	assert.Equal("abc123", resp.Header.Get("X-Decision-Id"))
+
+	resp = &http.Response{}
+	setDecisionIDHeader(&http.Request{}, resp)
+	assert.Equal("", resp.Header.Get("X-Decision-Id"))
+
+	setDecisionIDHeader(r, nil)
+}
+
+func TestDecisionIDConsistency(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "X-Decision-Id")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	hook := test.NewGlobal()
+	tracer := mocktracer.New()
+
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+
+	// authorized request
+	r := getTestRequest()
+	span := tracer.StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, span))
+	resp := &http.Response{}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, resp, span))
+
+	id := resp.Header.Get("X-Decision-Id")
+	assert.NotEmpty(id)
+	assert.Equal(id, span.Tag("kanali.decision_id"))
+
+	// denied request
+	hook.Reset()
+	r = getTestRequest()
+	r.Header.Set("apikey", "unknown")
+	span = tracer.StartSpan("test span").(*mocktracer.MockSpan)
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, span)
+
+	id = span.Tag("kanali.decision_id").(string)
+	assert.NotEmpty(id)
+	assert.Equal("apikey not found in k8s cluster (decision id: "+id+")", err.Error())
+	assert.NotNil(hook.LastEntry())
+	assert.Equal("request denied", hook.LastEntry().Message)
+	assert.Equal(id, hook.LastEntry().Data["decision_id"])
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDecisionCacheTTL,
+		flagPluginsAPIKeyDecisionCacheMaxEntries,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDecisionCacheTTL = config.Flag{
+		Long:  "plugins.apiKey.decision_cache_ttl",
+		Short: "",
+		Value: "0s",
+		Usage: "Duration for which the rule evaluation of an api key, method, and path is cached. Disabled if 0.",
+	}
+	flagPluginsAPIKeyDecisionCacheMaxEntries = config.Flag{
+		Long:  "plugins.apiKey.decision_cache_max_entries",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of rule evaluations cached at once.",
+	}
+)
+
+var (
+	errKeyNotBound   = &utils.StatusError{http.StatusForbidden, errors.New("api key not authorized for this proxy")}
+	errNoRuleForPath = &utils.StatusError{http.StatusForbidden, errors.New("api key has no rule for this path")}
+)
+
+// cachedDecision is the outcome of evaluating the rules of a binding
+type cachedDecision struct {
+	keyObj  *spec.Key
+	rule    spec.Rule
+	err     error
+	expires time.Time
+}
+
+var decisions = struct {
+	sync.Mutex
+	entries map[string]cachedDecision
+}{entries: map[string]cachedDecision{}}
+
+// getDecisionCacheKey identifies a rule evaluation. The resource versions of
+// the binding and key are included so that a binding, or the extra methods
+// of a key, that is updated, rather than replaced, is evaluated again
+// immediately.
+func getDecisionCacheKey(binding spec.APIKeyBinding, key spec.APIKey, method, targetPath string) string {
+	return strings.Join([]string{
+		binding.ObjectMeta.Namespace,
+		binding.ObjectMeta.Name,
+		binding.ObjectMeta.ResourceVersion,
+		key.ObjectMeta.Name,
+		key.ObjectMeta.ResourceVersion,
+		strings.ToUpper(method),
+		targetPath,
+	}, "\x00")
+}
+
+// evaluateRules returns the entry for the given key in the given binding and
+// the rule that applies to the given target path, or an error if the key may
+// not make a request with the given method to that path, along with the
+// number of rules evaluated. If enabled, the outcome is cached so that hot
+// endpoints skip rule evaluation, in which case no rules are evaluated. A
+// revoked api key may therefore continue to be authorized for up to the
+// cache ttl.
+func evaluateRules(binding spec.APIKeyBinding, key spec.APIKey, method, targetPath string, currTime time.Time) (*spec.Key, spec.Rule, int, error) {
+	ttl := viper.GetDuration(flagPluginsAPIKeyDecisionCacheTTL.GetLong())
+	if ttl <= 0 {
+		return evaluateRulesUncached(binding, key, method, targetPath)
+	}
+
+	id := getDecisionCacheKey(binding, key, method, targetPath)
+	decisions.Lock()
+	decision, ok := decisions.entries[id]
+	decisions.Unlock()
+	if ok && currTime.Before(decision.expires) {
+		return decision.keyObj, decision.rule, 0, decision.err
+	}
+
+	keyObj, rule, evaluated, err := evaluateRulesUncached(binding, key, method, targetPath)
+
+	decisions.Lock()
+	defer decisions.Unlock()
+	makeDecisionRoom(currTime)
+	decisions.entries[id] = cachedDecision{keyObj, rule, err, currTime.Add(ttl)}
+	return keyObj, rule, evaluated, err
+}
+
+// evaluateRulesUncached performs the rule evaluation cached by evaluateRules
+func evaluateRulesUncached(binding spec.APIKeyBinding, key spec.APIKey, method, targetPath string) (*spec.Key, spec.Rule, int, error) {
+	keyObj, rule, evaluated, err := lookupRule(binding, key, targetPath)
+	if err != nil {
+		return keyObj, rule, evaluated, err
+	}
+
+	// the extra methods of the key broaden, but are not part of, the rule
+	if granted := withExtraMethods(rule, key); !validateAPIKey(granted, method) {
+		return keyObj, rule, evaluated, getUnauthorizedMethodError(granted)
+	}
+	return keyObj, rule, evaluated, nil
+}
+
+// lookupRule returns the entry for the given key in the given binding, the
+// rule that applies to the given target path, and the number of rules that
+// were evaluated to find it. The binding's default rule applies when the
+// key has no rule for the path, and failing that the unmatched path policy.
+func lookupRule(binding spec.APIKeyBinding, key spec.APIKey, targetPath string) (*spec.Key, spec.Rule, int, error) {
+	keyObj := binding.GetAPIKey(key.ObjectMeta.Name)
+	if keyObj == nil {
+		return nil, spec.Rule{}, 0, errKeyNotBound
+	}
+
+	// a default rule that is neither global nor granular is not a definition
+	rule, matched, evaluated := matchRule(keyObj, targetPath)
+	if matched || keyObj.DefaultRule.Global || keyObj.DefaultRule.Granular != nil {
+		return keyObj, rule, evaluated, nil
+	}
+
+	evaluated++
+	defaultRule, ok := getDefaultRule(binding)
+	if ok {
+		return keyObj, defaultRule, evaluated, nil
+	}
+
+	fields := logrus.Fields{
+		"key":  displayKeyName(keyObj.Name),
+		"path": targetPath,
+	}
+	if isUnmatchedPathAllowed() {
+		logrus.WithFields(fields).Debug("no rule defined for this path - allowed by the unmatched path policy")
+		return keyObj, spec.Rule{Global: true}, evaluated, nil
+	}
+	logrus.WithFields(fields).Debug("no rule defined for this path")
+	return keyObj, spec.Rule{}, evaluated, errNoRuleForPath
+}
+
+// makeDecisionRoom ensures that there is room for another cached decision by
+// removing expired decisions or, if none have expired, an arbitrary one.
+// It must be called with the lock held.
+func makeDecisionRoom(currTime time.Time) {
+	max := viper.GetInt(flagPluginsAPIKeyDecisionCacheMaxEntries.GetLong())
+	if max <= 0 || len(decisions.entries) < max {
+		return
+	}
+
+	for id, decision := range decisions.entries {
+		if !currTime.Before(decision.expires) {
+			delete(decisions.entries, id)
+		}
+	}
+	for id := range decisions.entries {
+		if len(decisions.entries) < max {
+			break
+		}
+		delete(decisions.entries, id)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetDecisions() {
+	decisions.Lock()
+	decisions.entries = map[string]cachedDecision{}
+	decisions.Unlock()
+}
+
+func TestEvaluateRules(t *testing.T) {
+	assert := assert.New(t)
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{}
+	binding.Spec.Keys[0].Subpaths = []*spec.Path{
+		{Path: "/accounts", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}},
+	}
+
+	keyObj, rule, _, err := evaluateRules(binding, getTestAPIKey(), "GET", "/accounts", time.Now())
+	assert.Nil(err)
+	assert.Equal("apikeyone", keyObj.Name)
+	assert.Equal([]string{"GET"}, rule.Granular.Verbs)
+
+	_, _, _, err = evaluateRules(binding, getTestAPIKey(), "POST", "/accounts", time.Now())
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("api key unauthorized", err.Error())
+
+	_, _, _, err = evaluateRules(binding, getTestAPIKey(), "GET", "/reports", time.Now())
+	assert.Equal(errNoRuleForPath, err)
+
+	other := getTestAPIKey()
+	other.ObjectMeta.Name = "apikeytwo"
+	_, _, _, err = evaluateRules(binding, other, "GET", "/accounts", time.Now())
+	assert.Equal(errKeyNotBound, err)
+}
+
+func TestEvaluateRulesCache(t *testing.T) {
+	assert := assert.New(t)
+	defer resetDecisions()
+	defer viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "10s")
+	resetDecisions()
+
+	now := time.Now()
+	binding := getTestAPIKeyBinding()
+	_, _, _, err := evaluateRules(binding, getTestAPIKey(), "GET", "/", now)
+	assert.Nil(err)
+
+	// revoke the key by removing it from the binding
+	revoked := getTestAPIKeyBinding()
+	revoked.Spec.Keys = []spec.Key{}
+
+	_, _, _, err = evaluateRules(revoked, getTestAPIKey(), "GET", "/", now.Add(9*time.Second))
+	assert.Nil(err, "the cached decision should be used within the ttl")
+	_, _, _, err = evaluateRules(revoked, getTestAPIKey(), "GET", "/", now.Add(10*time.Second))
+	assert.Equal(errKeyNotBound, err, "a stale allow should not outlive the ttl")
+
+	_, _, _, err = evaluateRules(binding, getTestAPIKey(), "get", "/", now.Add(11*time.Second))
+	assert.Equal(errKeyNotBound, err, "methods should be cached case insensitively")
+
+	revoked.ObjectMeta.ResourceVersion = "2"
+	_, _, _, err = evaluateRules(binding, getTestAPIKey(), "GET", "/other", now)
+	assert.Nil(err)
+	_, _, _, err = evaluateRules(revoked, getTestAPIKey(), "GET", "/other", now)
+	assert.Equal(errKeyNotBound, err, "updated bindings should be evaluated again immediately")
+}
+
+func TestMakeDecisionRoom(t *testing.T) {
+	assert := assert.New(t)
+	defer resetDecisions()
+	defer viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyDecisionCacheMaxEntries.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "10s")
+	viper.Set(flagPluginsAPIKeyDecisionCacheMaxEntries.GetLong(), 2)
+	resetDecisions()
+
+	now := time.Now()
+	for _, path := range []string{"/a", "/b", "/c"} {
+		evaluateRules(getTestAPIKeyBinding(), getTestAPIKey(), "GET", path, now)
+	}
+	assert.Equal(2, len(decisions.entries))
+
+	evaluateRules(getTestAPIKeyBinding(), getTestAPIKey(), "GET", "/d", now.Add(time.Minute))
+	assert.Equal(1, len(decisions.entries), "expired decisions should be removed when full")
+}
+
+func TestOnRequestDecisionCache(t *testing.T) {
+	assert := assert.New(t)
+	defer resetDecisions()
+	defer viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "1h0m0s")
+	resetDecisions()
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{}
+	binding.Spec.Keys[0].Subpaths = []*spec.Path{{Path: "/details", Rule: spec.Rule{Global: true}}}
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(binding)
+
+	for i := 0; i < 2; i++ {
+		m := &metrics.Metrics{}
+		err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+		assert.Equal("api key has no rule for this path", err.Error())
+		assert.Contains(*m, metrics.Metric{"api_key_no_rule_for_path", "true", true}, "cached denials should still be reported")
+	}
+}
+
+func BenchmarkEvaluateRules(b *testing.B) {
+	defer resetDecisions()
+	defer viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "")
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{}
+	for _, path := range []string{"/accounts", "/accounts/details", "/reports", "/reports/daily", "/admin"} {
+		binding.Spec.Keys[0].Subpaths = append(binding.Spec.Keys[0].Subpaths, &spec.Path{
+			Path: path,
+			Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET", "POST", "PUT"}}},
+		})
+	}
+	key := getTestAPIKey()
+	now := time.Now()
+
+	for _, ttl := range []string{"0s", "1h0m0s"} {
+		b.Run("ttl="+ttl, func(b *testing.B) {
+			viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), ttl)
+			resetDecisions()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				evaluateRules(binding, key, "PUT", "/reports/daily/2017", now)
+			}
+		})
+	}
+}
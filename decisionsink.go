@@ -0,0 +1,240 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDecisionSinkQueueSize,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDecisionSinkQueueSize = config.Flag{
+		Long:  "plugins.apiKey.decision_sink_queue_size",
+		Short: "",
+		Value: 1000,
+		Usage: "Maximum number of decision records waiting to be delivered to the decision sink before new records are dropped.",
+	}
+)
+
+const (
+	decisionOutcomeAuthorized = "authorized"
+	decisionOutcomeDenied     = "denied"
+)
+
+// DecisionRecord describes the authorization decision made for a single request
+type DecisionRecord struct {
+	// DecisionID is the id of the decision, as logged and reported elsewhere
+	DecisionID string
+	// Time is the time at which the decision was made
+	Time time.Time
+	// ProxyName and ProxyNamespace identify the APIProxy the request was made to
+	ProxyName      string
+	ProxyNamespace string
+	// Key is the name of the APIKey, masked if configured, if one was found
+	Key string
+	// Binding is the namespace and name of the APIKeyBinding, if one was found
+	Binding string
+	// Method and Path are the http method and path of the request
+	Method string
+	Path   string
+	// Outcome is either authorized or denied
+	Outcome string
+	// Status is the http status code of a denial, or 0 if authorized
+	Status int
+	// Reason is why the request was denied, or empty if authorized
+	Reason string
+	// Latency is the time taken to make the decision
+	Latency time.Duration
+}
+
+// DecisionSink receives a DecisionRecord for every request this plugin makes
+// a decision for, such as to feed a SIEM, analytics, or billing system.
+// Records are delivered one at a time from a single goroutine so that a
+// sink never blocks the request path.
+type DecisionSink interface {
+	Record(record DecisionRecord)
+}
+
+// DecisionSinkFunc allows an ordinary function to be used as a DecisionSink
+type DecisionSinkFunc func(record DecisionRecord)
+
+// Record calls f(record)
+func (f DecisionSinkFunc) Record(record DecisionRecord) {
+	f(record)
+}
+
+// noopDecisionSink is the DecisionSink used until another is set
+type noopDecisionSink struct{}
+
+func (noopDecisionSink) Record(DecisionRecord) {}
+
+// decisionSink holds the configured DecisionSink
+var decisionSink = struct {
+	sync.RWMutex
+	s DecisionSink
+}{s: noopDecisionSink{}}
+
+// SetDecisionSink configures the DecisionSink decision records are delivered
+// to. It can be retrieved via plugin.Lookup and called by Kanali, or another
+// plugin, at startup. Passing nil restores the default no-op sink.
+func SetDecisionSink(s DecisionSink) {
+	if s == nil {
+		s = noopDecisionSink{}
+	}
+	decisionSink.Lock()
+	defer decisionSink.Unlock()
+	decisionSink.s = s
+}
+
+// getDecisionSink returns the configured DecisionSink
+func getDecisionSink() DecisionSink {
+	decisionSink.RLock()
+	defer decisionSink.RUnlock()
+	return decisionSink.s
+}
+
+// decisionSinkQueue holds the queue of records waiting to be delivered
+// and the size it was created with. Records are queued while holding the
+// read lock so that a queue is never closed while a record is being queued.
+var decisionSinkQueue = struct {
+	sync.RWMutex
+	size    int
+	records chan DecisionRecord
+}{}
+
+func getDecisionSinkQueueSize() int {
+	if size := viper.GetInt(flagPluginsAPIKeyDecisionSinkQueueSize.GetLong()); size > 0 {
+		return size
+	}
+	return 1
+}
+
+// queueDecisionRecord queues a record to be delivered, starting the delivery
+// goroutine on first use. False is returned if the queue is full.
+func queueDecisionRecord(record DecisionRecord) bool {
+	decisionSinkQueue.RLock()
+	if decisionSinkQueue.records == nil {
+		decisionSinkQueue.RUnlock()
+		decisionSinkQueue.Lock()
+		if decisionSinkQueue.records == nil {
+			startDecisionSinkQueue(getDecisionSinkQueueSize())
+		}
+		decisionSinkQueue.Unlock()
+		decisionSinkQueue.RLock()
+	}
+	defer decisionSinkQueue.RUnlock()
+
+	select {
+	case decisionSinkQueue.records <- record:
+		return true
+	default:
+		return false
+	}
+}
+
+// reloadDecisionSinkQueue replaces a started queue if its size has
+// changed. Records in the previous queue are still delivered.
+func reloadDecisionSinkQueue() {
+	decisionSinkQueue.Lock()
+	defer decisionSinkQueue.Unlock()
+
+	if size := getDecisionSinkQueueSize(); decisionSinkQueue.records != nil && size != decisionSinkQueue.size {
+		close(decisionSinkQueue.records)
+		startDecisionSinkQueue(size)
+	}
+}
+
+// startDecisionSinkQueue creates a queue of the given size and starts its
+// delivery goroutine. It must be called with the lock held.
+func startDecisionSinkQueue(size int) {
+	decisionSinkQueue.size = size
+	decisionSinkQueue.records = make(chan DecisionRecord, size)
+	go runDecisionSink(decisionSinkQueue.records)
+}
+
+// runDecisionSink delivers queued records until the queue is closed
+func runDecisionSink(queue chan DecisionRecord) {
+	for record := range queue {
+		deliverDecisionRecord(getDecisionSink(), record)
+	}
+}
+
+// deliverDecisionRecord delivers a record to the given sink,
+// recovering from any panic so that delivery continues
+func deliverDecisionRecord(s DecisionSink, record DecisionRecord) {
+	defer func() {
+		if e := recover(); e != nil {
+			logrus.Warnf("decision sink panicked: %v", e)
+		}
+	}()
+	s.Record(record)
+}
+
+// notifyDecisionSink queues a record to be delivered to the configured
+// sink. It never blocks and will return false if the record was dropped
+// because the queue is full. Nothing is queued for the no-op sink.
+func notifyDecisionSink(record DecisionRecord) bool {
+	if _, ok := getDecisionSink().(noopDecisionSink); ok {
+		return true
+	}
+	return queueDecisionRecord(record)
+}
+
+// newDecisionRecord creates a DecisionRecord describing
+// the decision made for the given request
+func newDecisionRecord(p spec.APIProxy, r *http.Request, id string, err error, currTime time.Time) DecisionRecord {
+	record := DecisionRecord{
+		DecisionID:     id,
+		Time:           currTime,
+		ProxyName:      p.ObjectMeta.Name,
+		ProxyNamespace: p.ObjectMeta.Namespace,
+		Binding:        getBindingID(r),
+		Method:         r.Method,
+		Outcome:        decisionOutcomeAuthorized,
+	}
+	if r.URL != nil {
+		record.Path = r.URL.Path
+	}
+	if name := getAPIKeyName(r); name != "" {
+		record.Key = displayKeyName(name)
+	}
+	if start, ok := getRequestTime(r); ok {
+		record.Latency = currTime.Sub(start)
+	}
+	if err != nil {
+		record.Outcome = decisionOutcomeDenied
+		record.Status = getStatusCode(err)
+		record.Reason = err.Error()
+	}
+	return record
+}
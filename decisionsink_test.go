@@ -0,0 +1,130 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDecisionSink sends every record it receives on a channel
+type recordingDecisionSink chan DecisionRecord
+
+func (s recordingDecisionSink) Record(record DecisionRecord) {
+	s <- record
+}
+
+// next returns the next record received, or false if none arrives in time
+func (s recordingDecisionSink) next() (DecisionRecord, bool) {
+	select {
+	case record := <-s:
+		return record, true
+	case <-time.After(time.Second):
+		return DecisionRecord{}, false
+	}
+}
+
+func TestNewDecisionRecord(t *testing.T) {
+	assert := assert.New(t)
+
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	start := time.Now()
+	setRequestTime(r, start)
+	record := newDecisionRecord(testutil.Proxy(), r, "abc", nil, start.Add(time.Millisecond))
+	assert.Equal("abc", record.DecisionID)
+	assert.Equal(testutil.ProxyName, record.ProxyName)
+	assert.Equal(testutil.Namespace, record.ProxyNamespace)
+	assert.Equal("GET", record.Method)
+	assert.Equal(testutil.ProxyPath, record.Path)
+	assert.Equal(decisionOutcomeAuthorized, record.Outcome)
+	assert.Equal(0, record.Status)
+	assert.Equal("", record.Reason)
+	assert.Equal("", record.Key)
+	assert.Equal(time.Millisecond, record.Latency)
+
+	setAPIKey(r, testutil.Key())
+	setBinding(r, testutil.Binding())
+	record = newDecisionRecord(testutil.Proxy(), r, "abc", &utils.StatusError{http.StatusForbidden, errors.New("api key unauthorized")}, start)
+	assert.Equal(testutil.KeyName, record.Key)
+	assert.Equal(testutil.Namespace+"/"+testutil.BindingName, record.Binding)
+	assert.Equal(decisionOutcomeDenied, record.Outcome)
+	assert.Equal(http.StatusForbidden, record.Status)
+	assert.Equal("api key unauthorized", record.Reason)
+}
+
+func TestSetDecisionSink(t *testing.T) {
+	assert := assert.New(t)
+	defer SetDecisionSink(nil)
+
+	assert.IsType(noopDecisionSink{}, getDecisionSink())
+	assert.True(notifyDecisionSink(DecisionRecord{}), "the no-op sink should never drop records")
+
+	sink := make(recordingDecisionSink, 1)
+	SetDecisionSink(sink)
+	assert.Equal(sink, getDecisionSink())
+	SetDecisionSink(nil)
+	assert.IsType(noopDecisionSink{}, getDecisionSink())
+}
+
+func TestDeliverDecisionRecordPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NotPanics(func() {
+		deliverDecisionRecord(DecisionSinkFunc(func(DecisionRecord) { panic("boom") }), DecisionRecord{})
+	})
+}
+
+func TestOnRequestDecisionSink(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer SetDecisionSink(nil)
+	sink := make(recordingDecisionSink, 10)
+	SetDecisionSink(sink)
+	cleanup := testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))})
+	defer cleanup()
+
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	record, ok := sink.next()
+	assert.True(ok)
+	assert.Equal(getDecisionID(r), record.DecisionID)
+	assert.Equal(decisionOutcomeAuthorized, record.Outcome)
+	assert.Equal(testutil.KeyName, record.Key)
+	assert.Equal("GET", record.Method)
+
+	r = testutil.Request("POST", testutil.ProxyPath, testutil.KeyData)
+	assert.NotNil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	record, ok = sink.next()
+	assert.True(ok)
+	assert.Equal(decisionOutcomeDenied, record.Outcome)
+	assert.Equal(http.StatusForbidden, record.Status)
+	assert.Equal("POST", record.Method)
+}
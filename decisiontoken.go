@@ -0,0 +1,248 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDecisionTokenTTL,
+		flagPluginsAPIKeyDecisionTokenHeader,
+		flagPluginsAPIKeyDecisionTokenSecret,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDecisionTokenTTL = config.Flag{
+		Long:  "plugins.apiKey.decision_token_ttl",
+		Short: "",
+		Value: "0h0m0s",
+		Usage: "Duration for which a decision token lets a client skip the rule evaluation of repeated requests. Disabled if 0.",
+	}
+	flagPluginsAPIKeyDecisionTokenHeader = config.Flag{
+		Long:  "plugins.apiKey.decision_token_header",
+		Short: "",
+		Value: "X-Decision-Token",
+		Usage: "Name of the HTTP header holding decision tokens, on both responses and requests.",
+	}
+	flagPluginsAPIKeyDecisionTokenSecret = config.Flag{
+		Long:  "plugins.apiKey.decision_token_secret",
+		Short: "",
+		Value: "",
+		Usage: "Secret used to sign decision tokens. A random secret, valid only on this Kanali instance, is used if empty.",
+	}
+)
+
+// decisionTokenFields is the number of fields in the payload of a decision
+// token: its expiry and the APIKey and APIKeyBinding that authorized it,
+// along with their resource versions
+const decisionTokenFields = 7
+
+// decisionTokenClaims describes the decision that a decision token records
+type decisionTokenClaims struct {
+	expires                time.Time
+	keyName                string
+	keyNamespace           string
+	keyResourceVersion     string
+	bindingName            string
+	bindingNamespace       string
+	bindingResourceVersion string
+}
+
+// matches will return true if the given claims were issued for the given
+// APIKey and APIKeyBinding, neither of which has changed since
+func (c decisionTokenClaims) matches(key spec.APIKey, binding spec.APIKeyBinding) bool {
+	return c.keyName == key.ObjectMeta.Name &&
+		c.keyNamespace == key.ObjectMeta.Namespace &&
+		c.keyResourceVersion == key.ObjectMeta.ResourceVersion &&
+		c.bindingName == binding.ObjectMeta.Name &&
+		c.bindingNamespace == binding.ObjectMeta.Namespace &&
+		c.bindingResourceVersion == binding.ObjectMeta.ResourceVersion
+}
+
+var (
+	decisionTokenSecretOnce sync.Once
+	decisionTokenSecret     []byte
+)
+
+// getDecisionTokenSecret returns the configured secret or,
+// if none is configured, a random secret for this instance
+func getDecisionTokenSecret() []byte {
+	if secret := viper.GetString(flagPluginsAPIKeyDecisionTokenSecret.GetLong()); secret != "" {
+		return []byte(secret)
+	}
+	decisionTokenSecretOnce.Do(func() {
+		decisionTokenSecret = make([]byte, sha256.Size)
+		if _, err := rand.Read(decisionTokenSecret); err != nil {
+			logrus.Errorf("could not generate decision token secret - decision tokens will not be issued: %s", err.Error())
+			decisionTokenSecret = nil
+		}
+	})
+	return decisionTokenSecret
+}
+
+// signDecisionToken signs the given payload for the given apikey and
+// request, so that a token cannot be replayed with another apikey or
+// for another proxy, method, or path
+func signDecisionToken(secret []byte, payload, apiKey string, p spec.APIProxy, r *http.Request) string {
+	keyHash := sha256.Sum256([]byte(apiKey))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{
+		payload,
+		base64.RawURLEncoding.EncodeToString(keyHash[:]),
+		p.ObjectMeta.Namespace,
+		p.ObjectMeta.Name,
+		strings.ToUpper(r.Method),
+		getTargetPath(p, r),
+	}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newDecisionToken returns a token recording that the given apikey was
+// authorized by the given APIKey and APIKeyBinding to make the given request
+func newDecisionToken(apiKey string, p spec.APIProxy, r *http.Request, key spec.APIKey, binding spec.APIKeyBinding, expires time.Time) (string, bool) {
+	secret := getDecisionTokenSecret()
+	if len(secret) < 1 {
+		return "", false
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strings.Join([]string{
+		strconv.FormatInt(expires.Unix(), 10),
+		key.ObjectMeta.Name,
+		key.ObjectMeta.Namespace,
+		key.ObjectMeta.ResourceVersion,
+		binding.ObjectMeta.Name,
+		binding.ObjectMeta.Namespace,
+		binding.ObjectMeta.ResourceVersion,
+	}, "\n")))
+	return payload + "." + signDecisionToken(secret, payload, apiKey, p, r), true
+}
+
+// verifyDecisionToken returns the claims of the decision token carried by
+// the given request. False is returned if decision tokens are disabled or
+// the request carries no token, or a token that is expired, malformed, or
+// was not issued to the given apikey for the same proxy, method, and path.
+func verifyDecisionToken(apiKey string, p spec.APIProxy, r *http.Request, currTime time.Time) (decisionTokenClaims, bool) {
+	if viper.GetDuration(flagPluginsAPIKeyDecisionTokenTTL.GetLong()) <= 0 {
+		return decisionTokenClaims{}, false
+	}
+	token := r.Header.Get(viper.GetString(flagPluginsAPIKeyDecisionTokenHeader.GetLong()))
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return decisionTokenClaims{}, false
+	}
+
+	secret := getDecisionTokenSecret()
+	if len(secret) < 1 || !hmac.Equal([]byte(parts[1]), []byte(signDecisionToken(secret, parts[0], apiKey, p, r))) {
+		return decisionTokenClaims{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return decisionTokenClaims{}, false
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != decisionTokenFields {
+		return decisionTokenClaims{}, false
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || !currTime.Before(time.Unix(expires, 0)) {
+		return decisionTokenClaims{}, false
+	}
+
+	return decisionTokenClaims{
+		expires:                time.Unix(expires, 0),
+		keyName:                fields[1],
+		keyNamespace:           fields[2],
+		keyResourceVersion:     fields[3],
+		bindingName:            fields[4],
+		bindingNamespace:       fields[5],
+		bindingResourceVersion: fields[6],
+	}, true
+}
+
+// isDecisionTokenEligible will return true if the rule evaluation made for
+// the given request may be reused. Every other check, along with quota and
+// rate limit accounting, is repeated on requests carrying a decision token.
+// Decisions deferred to a custom authorizer are never eligible.
+func isDecisionTokenEligible(keyObj *spec.Key) bool {
+	return keyObj != nil && getAuthorizer() == nil
+}
+
+// issueDecisionToken will, if decision tokens are enabled and the decision
+// made for the given request may be reused, store a new decision token in
+// the context of the given request to be returned to the client
+func issueDecisionToken(apiKey string, p spec.APIProxy, r *http.Request, key spec.APIKey, binding spec.APIKeyBinding, keyObj *spec.Key, currTime time.Time) {
+	ttl := viper.GetDuration(flagPluginsAPIKeyDecisionTokenTTL.GetLong())
+	if ttl <= 0 || !isDecisionTokenEligible(keyObj) {
+		return
+	}
+	if token, ok := newDecisionToken(apiKey, p, r, key, binding, currTime.Add(ttl)); ok {
+		*r = *r.WithContext(context.WithValue(r.Context(), contextKeyDecisionToken, token))
+	}
+}
+
+// reuseDecisionToken returns the Key of the given APIKeyBinding that a
+// decision token carried by the given request authorized. False is returned
+// if the request carries no valid token, or if the APIKey or APIKeyBinding
+// has changed or the key has been unbound since the token was issued.
+func reuseDecisionToken(apiKey string, p spec.APIProxy, r *http.Request, key spec.APIKey, binding spec.APIKeyBinding, currTime time.Time) (*spec.Key, bool) {
+	claims, ok := verifyDecisionToken(apiKey, p, r, currTime)
+	if !ok || !claims.matches(key, binding) {
+		return nil, false
+	}
+	keyObj := binding.GetAPIKey(key.ObjectMeta.Name)
+	if !isDecisionTokenEligible(keyObj) {
+		return nil, false
+	}
+	return keyObj, true
+}
+
+// setDecisionTokenHeader sets the decision token issued
+// for the given request, if any, on the given response
+func setDecisionTokenHeader(r *http.Request, resp *http.Response) {
+	header := viper.GetString(flagPluginsAPIKeyDecisionTokenHeader.GetLong())
+	token, _ := r.Context().Value(contextKeyDecisionToken).(string)
+	if header == "" || token == "" || resp == nil {
+		return
+	}
+
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	resp.Header.Set(header, token)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionToken(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDecisionTokenTTL.GetLong(), "0h0m0s")
+	defer viper.Set(flagPluginsAPIKeyDecisionTokenSecret.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyDecisionTokenTTL.GetLong(), "0h1m0s")
+	viper.SetDefault(flagPluginsAPIKeyDecisionTokenHeader.GetLong(), "X-Decision-Token")
+	viper.Set(flagPluginsAPIKeyDecisionTokenSecret.GetLong(), "mysecret")
+
+	p := testutil.Proxy()
+	binding := testutil.Binding()
+	now := time.Now()
+
+	r := testutil.Request("GET", testutil.ProxyPath+"/accounts", testutil.KeyData)
+	token, ok := newDecisionToken(testutil.KeyData, p, r, testutil.Key(), binding, now.Add(time.Minute))
+	assert.True(ok)
+
+	withToken := func(method, path, token string) *http.Request {
+		r := testutil.Request(method, testutil.ProxyPath+path, testutil.KeyData)
+		r.Header.Set("X-Decision-Token", token)
+		return r
+	}
+
+	claims, ok := verifyDecisionToken(testutil.KeyData, p, withToken("GET", "/accounts", token), now)
+	assert.True(ok)
+	assert.Equal(testutil.KeyName, claims.keyName)
+	assert.Equal(testutil.Namespace, claims.keyNamespace)
+	assert.Equal(binding.ObjectMeta.Name, claims.bindingName)
+	assert.Equal(binding.ObjectMeta.Namespace, claims.bindingNamespace)
+	assert.Equal(now.Add(time.Minute).Unix(), claims.expires.Unix())
+
+	_, ok = verifyDecisionToken(testutil.KeyData, p, withToken("GET", "/accounts", token), now.Add(time.Minute))
+	assert.False(ok, "expired tokens should be rejected")
+	_, ok = verifyDecisionToken("notmyapikey", p, withToken("GET", "/accounts", token), now)
+	assert.False(ok, "tokens should be bound to their apikey")
+	_, ok = verifyDecisionToken(testutil.KeyData, p, withToken("DELETE", "/accounts", token), now)
+	assert.False(ok, "tokens should be bound to their method")
+	_, ok = verifyDecisionToken(testutil.KeyData, p, withToken("GET", "/admin", token), now)
+	assert.False(ok, "tokens should be bound to their path")
+	_, ok = verifyDecisionToken(testutil.KeyData, p, withToken("GET", "/accounts", token+"x"), now)
+	assert.False(ok, "tampered tokens should be rejected")
+	_, ok = verifyDecisionToken(testutil.KeyData, p, withToken("GET", "/accounts", "garbage"), now)
+	assert.False(ok)
+
+	viper.Set(flagPluginsAPIKeyDecisionTokenSecret.GetLong(), "othersecret")
+	_, ok = verifyDecisionToken(testutil.KeyData, p, withToken("GET", "/accounts", token), now)
+	assert.False(ok, "tokens signed with another secret should be rejected")
+
+	viper.Set(flagPluginsAPIKeyDecisionTokenSecret.GetLong(), "mysecret")
+	viper.Set(flagPluginsAPIKeyDecisionTokenTTL.GetLong(), "0h0m0s")
+	_, ok = verifyDecisionToken(testutil.KeyData, p, withToken("GET", "/accounts", token), now)
+	assert.False(ok, "tokens should be ignored when disabled")
+}
+
+func TestIsDecisionTokenEligible(t *testing.T) {
+	assert := assert.New(t)
+	defer SetAuthorizer(nil)
+
+	binding := testutil.Binding()
+	keyObj := binding.GetAPIKey(testutil.KeyName)
+	assert.True(isDecisionTokenEligible(keyObj))
+	assert.False(isDecisionTokenEligible(nil))
+
+	SetAuthorizer(AuthorizerFunc(func(context.Context, AuthContext) (bool, string) { return true, "" }))
+	assert.False(isDecisionTokenEligible(keyObj), "decisions deferred to an authorizer should not be eligible")
+}
+
+func TestReuseDecisionToken(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDecisionTokenTTL.GetLong(), "0h0m0s")
+	viper.Set(flagPluginsAPIKeyDecisionTokenTTL.GetLong(), "0h1m0s")
+	viper.SetDefault(flagPluginsAPIKeyDecisionTokenHeader.GetLong(), "X-Decision-Token")
+
+	p := testutil.Proxy()
+	key, binding := testutil.Key(), testutil.Binding()
+	now := time.Now()
+
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	token, ok := newDecisionToken(testutil.KeyData, p, r, key, binding, now.Add(time.Minute))
+	assert.True(ok)
+	r.Header.Set("X-Decision-Token", token)
+
+	keyObj, ok := reuseDecisionToken(testutil.KeyData, p, r, key, binding, now)
+	assert.True(ok)
+	assert.Equal(testutil.KeyName, keyObj.Name)
+
+	updated := testutil.Key()
+	updated.ObjectMeta.ResourceVersion = "2"
+	_, ok = reuseDecisionToken(testutil.KeyData, p, r, updated, binding, now)
+	assert.False(ok, "tokens should not outlive a change to their apikey")
+
+	rebound := testutil.Binding()
+	rebound.ObjectMeta.ResourceVersion = "2"
+	_, ok = reuseDecisionToken(testutil.KeyData, p, r, key, rebound, now)
+	assert.False(ok, "tokens should not outlive a change to their binding")
+
+	_, ok = reuseDecisionToken(testutil.KeyData, p, r, key, testutil.Binding(testutil.GlobalKey("apikeytwo")), now)
+	assert.False(ok, "tokens should not outlive their key being unbound")
+}
+
+func TestOnRequestDecisionToken(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyDecisionTokenTTL.GetLong(), "0h0m0s")
+	viper.Set(flagPluginsAPIKeyDecisionTokenTTL.GetLong(), "0h1m0s")
+	viper.SetDefault(flagPluginsAPIKeyDecisionTokenHeader.GetLong(), "X-Decision-Token")
+	cleanup := testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})
+	defer cleanup()
+
+	// a full validation issues a token
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	resp := &http.Response{StatusCode: http.StatusOK}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, resp, testutil.Span()))
+	token := resp.Header.Get("X-Decision-Token")
+	assert.NotEqual("", token)
+
+	// the token is reused in place of rule evaluation
+	r = testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	r.Header.Set("X-Decision-Token", token)
+	m := &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), r, testutil.Span()))
+	assert.Contains(*m, metrics.Metric{"api_key_decision_token", "true", true})
+	assert.Equal(testutil.KeyName, getAPIKeyName(r))
+	resp = &http.Response{StatusCode: http.StatusOK}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, resp, testutil.Span()))
+	assert.Equal("", resp.Header.Get("X-Decision-Token"), "reused tokens should not be reissued")
+
+	// a revoked apikey is denied despite its token
+	spec.KeyStore.Clear()
+	r = testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	r.Header.Set("X-Decision-Token", token)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span())))
+	spec.KeyStore.Set(testutil.Key())
+
+	// other requests are fully evaluated
+	r = testutil.Request("POST", testutil.ProxyPath, testutil.KeyData)
+	r.Header.Set("X-Decision-Token", token)
+	m = &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), r, testutil.Span()))
+	assert.NotContains(*m, metrics.Metric{"api_key_decision_token", "true", true})
+}
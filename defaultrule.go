@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDefaultRule,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDefaultRule = config.Flag{
+		Long:  "plugins.apiKey.default_rule",
+		Short: "",
+		Value: "",
+		Usage: "Rule applied when a bound key has no rule for the requested path and its binding has no apikey.kanali.io/default-rule annotation. Either * for every method or a comma separated list of HTTP methods. Denied if empty.",
+	}
+)
+
+// annotationBindingDefaultRule is the APIKeyBinding annotation holding the
+// rule applied when a bound key has no rule for the requested path
+const annotationBindingDefaultRule = "apikey.kanali.io/default-rule"
+
+// parseDefaultRule parses a default rule. A value of * results in a global
+// rule while a comma separated list of HTTP methods results in a granular rule.
+func parseDefaultRule(value string) (spec.Rule, error) {
+	if strings.TrimSpace(value) == allMethods {
+		return spec.Rule{Global: true}, nil
+	}
+
+	verbs := []string{}
+	for _, verb := range strings.Split(value, ",") {
+		if verb = strings.ToUpper(strings.TrimSpace(verb)); verb != "" {
+			verbs = append(verbs, verb)
+		}
+	}
+	if len(verbs) < 1 {
+		return spec.Rule{}, errors.New("default rule must be * or a comma separated list of HTTP methods")
+	}
+	return spec.Rule{Granular: &spec.GranularProxy{Verbs: verbs}}, nil
+}
+
+// getDefaultRule returns the rule applied when a key bound by the given
+// binding has no rule for the requested path. The binding's annotation takes
+// priority over the default_rule flag. False is returned if neither is set,
+// in which case such requests are denied.
+func getDefaultRule(binding spec.APIKeyBinding) (spec.Rule, bool) {
+	value, ok := binding.ObjectMeta.Annotations[annotationBindingDefaultRule]
+	if !ok {
+		value = viper.GetString(flagPluginsAPIKeyDefaultRule.GetLong())
+	}
+	if value == "" {
+		return spec.Rule{}, false
+	}
+
+	rule, err := parseDefaultRule(value)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"binding":   binding.ObjectMeta.Name,
+			"namespace": binding.ObjectMeta.Namespace,
+		}).Warnf("invalid default rule will be ignored: %s", err.Error())
+		return spec.Rule{}, false
+	}
+	return rule, true
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestDefaultRuleBinding(annotation string) spec.APIKeyBinding {
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{}
+	binding.Spec.Keys[0].Subpaths = []*spec.Path{
+		{Path: "/admin", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"DELETE"}}}},
+	}
+	if annotation != "" {
+		binding.ObjectMeta.Annotations = map[string]string{annotationBindingDefaultRule: annotation}
+	}
+	return binding
+}
+
+func TestParseDefaultRule(t *testing.T) {
+	assert := assert.New(t)
+
+	rule, err := parseDefaultRule(" * ")
+	assert.Nil(err)
+	assert.Equal(spec.Rule{Global: true}, rule)
+
+	rule, err = parseDefaultRule("get, HEAD,")
+	assert.Nil(err)
+	assert.Equal(spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET", "HEAD"}}}, rule)
+
+	_, err = parseDefaultRule(" , ")
+	assert.Equal("default rule must be * or a comma separated list of HTTP methods", err.Error())
+}
+
+func TestGetDefaultRule(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDefaultRule.GetLong(), "")
+
+	_, ok := getDefaultRule(getTestDefaultRuleBinding(""))
+	assert.False(ok, "requests should be denied by default")
+
+	viper.Set(flagPluginsAPIKeyDefaultRule.GetLong(), "*")
+	rule, ok := getDefaultRule(getTestDefaultRuleBinding(""))
+	assert.True(ok)
+	assert.True(rule.Global)
+
+	rule, ok = getDefaultRule(getTestDefaultRuleBinding("GET"))
+	assert.True(ok)
+	assert.Equal([]string{"GET"}, rule.Granular.Verbs, "the binding annotation should take priority")
+
+	_, ok = getDefaultRule(getTestDefaultRuleBinding(","))
+	assert.False(ok, "invalid default rules should be ignored")
+}
+
+func TestEvaluateRulesDefaultRule(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, _, err := evaluateRulesUncached(getTestDefaultRuleBinding(""), getTestAPIKey(), "GET", "/accounts")
+	assert.Equal(errNoRuleForPath, err)
+
+	binding := getTestDefaultRuleBinding("GET,HEAD")
+	_, rule, _, err := evaluateRulesUncached(binding, getTestAPIKey(), "GET", "/accounts")
+	assert.Nil(err)
+	assert.Equal([]string{"GET", "HEAD"}, rule.Granular.Verbs)
+
+	_, _, _, err = evaluateRulesUncached(binding, getTestAPIKey(), "POST", "/accounts")
+	assert.Equal("api key unauthorized", err.Error())
+
+	_, _, _, err = evaluateRulesUncached(binding, getTestAPIKey(), "GET", "/admin")
+	assert.Equal("api key unauthorized", err.Error(), "a matching rule should take priority over the default rule")
+
+	_, _, _, err = evaluateRulesUncached(binding, getTestGroupedAPIKey("apikeytwo", ""), "GET", "/accounts")
+	assert.Equal(errKeyNotBound, err, "the default rule should only apply to bound keys")
+}
+
+func TestOnRequestDefaultRule(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+
+	spec.BindingStore.Set(getTestDefaultRuleBinding(""))
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+
+	spec.BindingStore.Set(getTestDefaultRuleBinding("GET"))
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+}
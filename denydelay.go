@@ -0,0 +1,83 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDenyDelayMin,
+		flagPluginsAPIKeyDenyDelayMax,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDenyDelayMin = config.Flag{
+		Long:  "plugins.apiKey.deny_delay_min",
+		Short: "",
+		Value: "0s",
+		Usage: "Minimum delay before a denied request is answered, to slow apikey enumeration.",
+	}
+	flagPluginsAPIKeyDenyDelayMax = config.Flag{
+		Long:  "plugins.apiKey.deny_delay_max",
+		Short: "",
+		Value: "0s",
+		Usage: "Maximum delay before a denied request is answered. The delay is chosen at random between the minimum and maximum. Disabled if 0.",
+	}
+)
+
+// getDenyDelay returns a random duration in the range [min, max] of the
+// configured denial delay. A maximum below the minimum is raised to it.
+func getDenyDelay() time.Duration {
+	min := viper.GetDuration(flagPluginsAPIKeyDenyDelayMin.GetLong())
+	max := viper.GetDuration(flagPluginsAPIKeyDenyDelayMax.GetLong())
+	if min < 0 {
+		min = 0
+	}
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(rand.Int63n(int64(max-min)+1))
+}
+
+// delayDenial waits for the configured denial delay so that the time taken
+// to deny a request reveals little about why it was denied. It returns
+// early if the given context is done.
+func delayDenial(ctx context.Context) {
+	d := getDenyDelay()
+	if d <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetDenyDelay() {
+	viper.Set(flagPluginsAPIKeyDenyDelayMin.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyDenyDelayMax.GetLong(), "")
+}
+
+func TestGetDenyDelay(t *testing.T) {
+	assert := assert.New(t)
+	defer resetDenyDelay()
+	resetDenyDelay()
+
+	assert.Equal(time.Duration(0), getDenyDelay(), "there should be no delay by default")
+
+	viper.Set(flagPluginsAPIKeyDenyDelayMin.GetLong(), "10ms")
+	viper.Set(flagPluginsAPIKeyDenyDelayMax.GetLong(), "20ms")
+	for i := 0; i < 100; i++ {
+		d := getDenyDelay()
+		assert.True(d >= 10*time.Millisecond && d <= 20*time.Millisecond, "%s is out of bounds", d)
+	}
+
+	viper.Set(flagPluginsAPIKeyDenyDelayMax.GetLong(), "5ms")
+	assert.Equal(10*time.Millisecond, getDenyDelay(), "a maximum below the minimum should be raised to it")
+
+	viper.Set(flagPluginsAPIKeyDenyDelayMin.GetLong(), "-5ms")
+	viper.Set(flagPluginsAPIKeyDenyDelayMax.GetLong(), "")
+	assert.Equal(time.Duration(0), getDenyDelay())
+}
+
+func TestDelayDenial(t *testing.T) {
+	assert := assert.New(t)
+	defer resetDenyDelay()
+	viper.Set(flagPluginsAPIKeyDenyDelayMin.GetLong(), "20ms")
+	viper.Set(flagPluginsAPIKeyDenyDelayMax.GetLong(), "30ms")
+
+	start := time.Now()
+	delayDenial(context.Background())
+	assert.True(time.Since(start) >= 20*time.Millisecond)
+
+	viper.Set(flagPluginsAPIKeyDenyDelayMin.GetLong(), "1h0m0s")
+	viper.Set(flagPluginsAPIKeyDenyDelayMax.GetLong(), "1h0m0s")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start = time.Now()
+	delayDenial(ctx)
+	assert.True(time.Since(start) < time.Minute, "the delay should end when the context is cancelled")
+}
+
+func TestOnRequestDenyDelay(t *testing.T) {
+	assert := assert.New(t)
+	defer resetDenyDelay()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyDenyDelayMin.GetLong(), "20ms")
+	viper.Set(flagPluginsAPIKeyDenyDelayMax.GetLong(), "20ms")
+
+	r := getTestRequest()
+	r.Header.Del("Apikey")
+	start := time.Now()
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.True(time.Since(start) >= 20*time.Millisecond, "denials should be delayed")
+}
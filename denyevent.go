@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDenyEventStdout,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDenyEventStdout = config.Flag{
+		Long:  "plugins.apiKey.deny_event_stdout",
+		Short: "",
+		Value: false,
+		Usage: "Write every deny event to stdout as a single line of JSON, apart from the structured logs.",
+	}
+)
+
+var (
+	// denyEventWriter is where deny events are written. Each event is
+	// written as one line so that it can be scraped by a sidecar.
+	denyEventWriter io.Writer = os.Stdout
+	denyEventMutex  sync.Mutex
+)
+
+// writeDenyEvent will, if enabled, write the given event
+// to stdout using the same schema as the deny webhook
+func writeDenyEvent(event denyEvent) {
+	if !viper.GetBool(flagPluginsAPIKeyDenyEventStdout.GetLong()) {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.Warnf("could not encode deny event: %s", err.Error())
+		return
+	}
+
+	denyEventMutex.Lock()
+	defer denyEventMutex.Unlock()
+	if _, err := denyEventWriter.Write(append(line, '\n')); err != nil {
+		logrus.Warnf("could not write deny event: %s", err.Error())
+	}
+}
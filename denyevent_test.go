@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestDenyEventWriter() (*bytes.Buffer, func()) {
+	buf := &bytes.Buffer{}
+	denyEventWriter = buf
+	return buf, func() {
+		denyEventWriter = os.Stdout
+		viper.Set(flagPluginsAPIKeyDenyEventStdout.GetLong(), false)
+		viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), false)
+	}
+}
+
+func TestWriteDenyEvent(t *testing.T) {
+	assert := assert.New(t)
+	buf, reset := setTestDenyEventWriter()
+	defer reset()
+
+	event := newDenyEvent(getTestAPIProxy(), getTestDenyRequest(), "abc123", &utils.StatusError{http.StatusUnauthorized, errors.New("api key unauthorized")}, time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC))
+	writeDenyEvent(event)
+	assert.Equal("", buf.String(), "deny events should not be written unless enabled")
+
+	viper.Set(flagPluginsAPIKeyDenyEventStdout.GetLong(), true)
+	writeDenyEvent(event)
+	writeDenyEvent(event)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Equal(2, len(lines))
+	assert.Equal(`{"version":1,"decision_id":"abc123","time":"2017-10-01T12:00:00Z","status":401,"reason":"api key unauthorized","method":"GET","path":"/api/v1/accounts","remote_addr":"1.2.3.4:5678","proxy_name":"APIProxyone","proxy_namespace":"foo","key":"","binding":""}`, lines[0])
+}
+
+func TestOnRequestDenyEventStdout(t *testing.T) {
+	assert := assert.New(t)
+	buf, reset := setTestDenyEventWriter()
+	defer reset()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyDenyEventStdout.GetLong(), true)
+	viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), true)
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(binding)
+
+	assert.NotNil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+
+	event := map[string]interface{}{}
+	assert.Nil(json.Unmarshal(buf.Bytes(), &event))
+	for _, field := range []string{"version", "decision_id", "time", "status", "reason", "method", "path", "remote_addr", "proxy_name", "proxy_namespace", "key", "binding"} {
+		assert.Contains(event, field)
+	}
+	assert.Equal(12, len(event))
+	assert.Equal("api key unauthorized", event["reason"])
+	assert.Equal("foo/apikeybindingone", event["binding"])
+	assert.Equal(displayKeyName("apikeyone"), event["key"])
+	assert.NotEqual("apikeyone", event["key"], "the key name should be masked if configured")
+	_, err := time.Parse(time.RFC3339, event["time"].(string))
+	assert.Nil(err)
+}
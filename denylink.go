@@ -0,0 +1,76 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDenyDocsURL,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDenyDocsURL = config.Flag{
+		Long:  "plugins.apiKey.deny_docs_url",
+		Short: "",
+		Value: "",
+		Usage: "Base URL of the documentation explaining why requests are denied. The messages of denied responses end with this URL followed by the deny reason code, or with {reason} replaced by it. Disabled if empty.",
+	}
+)
+
+// denyDocsReasonPlaceholder is replaced by the deny reason code
+// when it appears in the configured documentation URL
+const denyDocsReasonPlaceholder = "{reason}"
+
+// getDenyDocsURL returns the URL of the documentation explaining the
+// given deny reason code. An empty string is returned if disabled.
+func getDenyDocsURL(code string) string {
+	base := strings.TrimSpace(viper.GetString(flagPluginsAPIKeyDenyDocsURL.GetLong()))
+	if base == "" {
+		return ""
+	}
+	if strings.Contains(base, denyDocsReasonPlaceholder) {
+		return strings.Replace(base, denyDocsReasonPlaceholder, code, -1)
+	}
+	return strings.TrimSuffix(base, "/") + "/" + code
+}
+
+// withDenyLink will, if enabled, return a copy of the given error whose
+// message ends with the URL of the documentation of the given deny reason
+// code. Kanali writes the message of a plugin error to the response but
+// none of its headers, so the URL cannot be sent in a Link header.
+func withDenyLink(err error, code string) error {
+	if err == nil {
+		return err
+	}
+	url := getDenyDocsURL(code)
+	if url == "" {
+		return err
+	}
+	return withErrorMessage(err, fmt.Sprintf("%s (docs: %s)", err.Error(), url))
+}
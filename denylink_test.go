@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDenyDocsURL(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "")
+
+	assert.Equal("", getDenyDocsURL("api_key_unauthorized"))
+
+	viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "https://docs.example.com/errors/")
+	assert.Equal("https://docs.example.com/errors/api_key_unauthorized", getDenyDocsURL("api_key_unauthorized"))
+	viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "https://docs.example.com/errors")
+	assert.Equal("https://docs.example.com/errors/api_key_unauthorized", getDenyDocsURL("api_key_unauthorized"))
+	viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "https://docs.example.com/errors#{reason}")
+	assert.Equal("https://docs.example.com/errors#api_key_unauthorized", getDenyDocsURL("api_key_unauthorized"))
+}
+
+func TestWithDenyLink(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "")
+	err := &utils.StatusError{http.StatusForbidden, errors.New("api key unauthorized")}
+
+	assert.Nil(withDenyLink(nil, ""))
+	assert.Equal(err, withDenyLink(err, "api_key_unauthorized"), "errors should be unchanged when disabled")
+
+	viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "https://docs.example.com/errors")
+	linked := withDenyLink(err, "api_key_unauthorized")
+	assert.Equal(http.StatusForbidden, getStatusCode(linked))
+	assert.Equal("api key unauthorized (docs: https://docs.example.com/errors/api_key_unauthorized)", linked.Error())
+	assert.Nil(withDenyLink(nil, ""))
+}
+
+func TestOnRequestDenyLink(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyDenyDocsURL.GetLong(), "https://docs.example.com/errors/{reason}")
+	cleanup := testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))})
+	defer cleanup()
+
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Contains(err.Error(), "(docs: https://docs.example.com/errors/apikey_not_found_in_request)")
+
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("POST", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Contains(err.Error(), "(docs: https://docs.example.com/errors/api_key_unauthorized)")
+
+	defer viper.Set(flagPluginsAPIKeyDenyReasonInMessage.GetLong(), false)
+	viper.Set(flagPluginsAPIKeyDenyReasonInMessage.GetLong(), true)
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span())
+	assert.Contains(err.Error(), "(reason: apikey_not_found_in_request) (docs: https://docs.example.com/errors/apikey_not_found_in_request)", "the link should use the reason of the original message")
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+}
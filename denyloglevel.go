@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDenyLogLevels,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDenyLogLevels = config.Flag{
+		Long:  "plugins.apiKey.deny_log_levels",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of reason=level pairs setting the level at which denials with the given reason code are logged, such as apikey_not_found_in_request=debug.",
+	}
+)
+
+// defaultDenyLogLevels holds the level at which denials are logged when
+// their reason code is not configured. Denials caused by clients that
+// routinely omit their apikey are noisy, while denials caused by missing
+// configuration are actionable by an administrator.
+var defaultDenyLogLevels = map[string]logrus.Level{
+	"apikey_not_found_in_request":              logrus.DebugLevel,
+	"no_binding_found_for_associated_apiproxy": logrus.ErrorLevel,
+	"openapi_spec_could_not_be_loaded":         logrus.ErrorLevel,
+}
+
+// getDenyLogLevel returns the level at which denials with the given reason
+// code are logged. Unknown levels are ignored, as are the panic and fatal
+// levels so that a denial can never stop Kanali.
+func getDenyLogLevel(reason string) logrus.Level {
+	if value, ok := getStringMap(flagPluginsAPIKeyDenyLogLevels.GetLong())[reason]; ok {
+		level, err := logrus.ParseLevel(strings.ToLower(value))
+		if err == nil && level > logrus.FatalLevel {
+			return level
+		}
+		logrus.Warnf("invalid log level %s for deny reason %s will be ignored", value, reason)
+	}
+	if level, ok := defaultDenyLogLevels[reason]; ok {
+		return level
+	}
+	return logrus.InfoLevel
+}
+
+// logAtLevel logs the given message with the given entry at the given level
+func logAtLevel(entry *logrus.Entry, level logrus.Level, message string) {
+	switch level {
+	case logrus.ErrorLevel:
+		entry.Error(message)
+	case logrus.WarnLevel:
+		entry.Warn(message)
+	case logrus.DebugLevel:
+		entry.Debug(message)
+	default:
+		entry.Info(message)
+	}
+}
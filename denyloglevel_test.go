@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDenyLogLevel(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyLogLevels.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyDenyLogLevels.GetLong(), "")
+	assert.Equal(logrus.DebugLevel, getDenyLogLevel("apikey_not_found_in_request"))
+	assert.Equal(logrus.ErrorLevel, getDenyLogLevel("no_binding_found_for_associated_apiproxy"))
+	assert.Equal(logrus.InfoLevel, getDenyLogLevel("apikey_not_found_in_k8s_cluster"))
+
+	viper.Set(flagPluginsAPIKeyDenyLogLevels.GetLong(), "apikey_not_found_in_request=WARN, apikey_not_found_in_k8s_cluster=error, api_key_unauthorized=fatal, quota_limit_reached=loud")
+	assert.Equal(logrus.WarnLevel, getDenyLogLevel("apikey_not_found_in_request"))
+	assert.Equal(logrus.ErrorLevel, getDenyLogLevel("apikey_not_found_in_k8s_cluster"))
+	assert.Equal(logrus.ErrorLevel, getDenyLogLevel("no_binding_found_for_associated_apiproxy"))
+	assert.Equal(logrus.InfoLevel, getDenyLogLevel("api_key_unauthorized"), "fatal levels should be ignored")
+	assert.Equal(logrus.InfoLevel, getDenyLogLevel("quota_limit_reached"), "unknown levels should be ignored")
+}
+
+func TestLogDecisionLevel(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyLogLevels.GetLong(), "")
+	defer logrus.SetLevel(logrus.GetLevel())
+	logrus.SetLevel(logrus.DebugLevel)
+	viper.Set(flagPluginsAPIKeyDenyLogLevels.GetLong(), "apikey_not_found_in_k8s_cluster=warning")
+
+	hook := test.NewGlobal()
+	r := getTestRequest()
+
+	tests := []struct {
+		message string
+		level   logrus.Level
+	}{
+		{"apikey not found in request", logrus.DebugLevel},
+		{"no binding found for associated APIProxy", logrus.ErrorLevel},
+		{"apikey not found in k8s cluster", logrus.WarnLevel},
+		{"quota limit reached. please contact your administrator", logrus.InfoLevel},
+	}
+	for _, tt := range tests {
+		hook.Reset()
+		logDecision(getTestAPIProxy(), r, "abc123", &utils.StatusError{http.StatusUnauthorized, errors.New(tt.message)})
+		assert.NotNil(hook.LastEntry(), tt.message)
+		assert.Equal("request denied", hook.LastEntry().Message, tt.message)
+		assert.Equal(tt.level, hook.LastEntry().Level, tt.message)
+		assert.Equal(tt.message, hook.LastEntry().Data["reason"], tt.message)
+	}
+}
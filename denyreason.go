@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDenyReasonInMessage,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDenyReasonInMessage = config.Flag{
+		Long:  "plugins.apiKey.deny_reason_in_message",
+		Short: "",
+		Value: false,
+		Usage: "Append a code describing why a request was denied to the message of denied responses. Intended for debugging environments only.",
+	}
+)
+
+// getDenyReasonCode derives a stable code from the first sentence of the
+// message of the given error, such as apikey_not_found_in_request
+func getDenyReasonCode(err error) string {
+	message := strings.ToLower(err.Error())
+	if i := strings.Index(message, ". "); i >= 0 {
+		message = message[:i]
+	}
+
+	code := strings.FieldsFunc(message, func(c rune) bool {
+		return (c < 'a' || c > 'z') && (c < '0' || c > '9')
+	})
+	return strings.Join(code, "_")
+}
+
+// withDenyReason will, if enabled, return a copy of the given error whose
+// message ends with the given reason code. Kanali writes the message of a plugin
+// error to the response but none of its headers, so this is how the code
+// reaches a developer. The code is never added when disabled, so that
+// production environments do not leak the reason.
+func withDenyReason(err error, code string) error {
+	if err == nil || !viper.GetBool(flagPluginsAPIKeyDenyReasonInMessage.GetLong()) {
+		return err
+	}
+	return withErrorMessage(err, fmt.Sprintf("%s (reason: %s)", err.Error(), code))
+}
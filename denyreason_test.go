@@ -0,0 +1,72 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDenyReasonCode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("apikey_not_found_in_request", getDenyReasonCode(errors.New("apikey not found in request")))
+	assert.Equal("apikey_store_unavailable", getDenyReasonCode(errors.New("apikey store unavailable. please retry later")))
+	assert.Equal("request_denied_by_custom_authorizer", getDenyReasonCode(errors.New("Request denied -- by custom authorizer!")))
+}
+
+func TestWithDenyReason(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyReasonInMessage.GetLong(), false)
+
+	err := &utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request")}
+
+	viper.Set(flagPluginsAPIKeyDenyReasonInMessage.GetLong(), false)
+	assert.Equal(err, withDenyReason(err, "apikey_not_found_in_request"), "the reason should never be added when disabled")
+	assert.Nil(withDenyReason(nil, ""))
+
+	viper.Set(flagPluginsAPIKeyDenyReasonInMessage.GetLong(), true)
+	assert.Equal(&utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request (reason: apikey_not_found_in_request)")}, withDenyReason(err, "apikey_not_found_in_request"))
+	assert.Nil(withDenyReason(nil, ""))
+}
+
+func TestOnRequestDenyReason(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyReasonInMessage.GetLong(), false)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	for _, enabled := range []bool{false, true} {
+		viper.Set(flagPluginsAPIKeyDenyReasonInMessage.GetLong(), enabled)
+
+		err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span())
+		assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+		assert.Equal(enabled, strings.Contains(err.Error(), "(reason: apikey_not_found_in_request)"))
+	}
+}
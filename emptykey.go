@@ -0,0 +1,73 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDistinguishEmptyKey,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDistinguishEmptyKey = config.Flag{
+		Long:  "plugins.apiKey.distinguish_empty_key",
+		Short: "",
+		Value: false,
+		Usage: "Reject a request whose apikey header is present but empty with its own 401 message and metric instead of those of a request missing its apikey.",
+	}
+)
+
+// errAnonymousNotPermitted is returned for requests to paths that are
+// not anonymous whose apikey header was deliberately sent empty
+var errAnonymousNotPermitted = &utils.StatusError{http.StatusUnauthorized, errors.New("anonymous access is not permitted for this path")}
+
+// hasEmptyKeyHeader will return true if the given request carries the
+// apikey header and every value of it is empty or whitespace
+func hasEmptyKeyHeader(r *http.Request) bool {
+	values, ok := r.Header[http.CanonicalHeaderKey(viper.GetString(flagPluginsAPIKeyHeaderKey.GetLong()))]
+	if !ok {
+		return false
+	}
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmptyKeyDistinguished will return true if the given request, which
+// must not carry an apikey in any location, should be rejected as an
+// attempt at anonymous access because its apikey header was deliberately
+// sent empty. Anonymous paths have already been proxied by then.
+func isEmptyKeyDistinguished(r *http.Request) bool {
+	return viper.GetBool(flagPluginsAPIKeyDistinguishEmptyKey.GetLong()) && hasEmptyKeyHeader(r)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasEmptyKeyHeader(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	r := testutil.Request("GET", testutil.ProxyPath, "")
+	assert.False(hasEmptyKeyHeader(r), "a missing header should not be empty")
+
+	r.Header.Set("apikey", "")
+	assert.True(hasEmptyKeyHeader(r))
+	r.Header.Set("apikey", "  ")
+	assert.True(hasEmptyKeyHeader(r))
+	r.Header.Add("apikey", testutil.KeyData)
+	assert.False(hasEmptyKeyHeader(r))
+	r.Header.Set("apikey", testutil.KeyData)
+	assert.False(hasEmptyKeyHeader(r))
+}
+
+func TestOnRequestEmptyKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDistinguishEmptyKey.GetLong(), false)
+	defer viper.Set(flagPluginsAPIKeyAnonymousPaths.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyAnonymousPaths.GetLong(), testutil.ProxyPath+"/public")
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "apikey")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	emptyKeyRequest := func(path string) *http.Request {
+		r := testutil.Request("GET", path, "")
+		r.Header.Set("apikey", "")
+		return r
+	}
+
+	for _, distinguish := range []bool{false, true} {
+		viper.Set(flagPluginsAPIKeyDistinguishEmptyKey.GetLong(), distinguish)
+
+		assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), emptyKeyRequest(testutil.ProxyPath+"/public"), testutil.Span()), "anonymous paths should never need an apikey")
+
+		r := emptyKeyRequest(testutil.ProxyPath)
+		r.URL.RawQuery = "apikey=" + testutil.KeyData
+		assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()), "an apikey in another location should still be used")
+
+		err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span())
+		assert.Equal("apikey not found in request", err.Error(), "a missing header should not be distinguished")
+	}
+
+	viper.Set(flagPluginsAPIKeyDistinguishEmptyKey.GetLong(), false)
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), emptyKeyRequest(testutil.ProxyPath), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("apikey not found in request", err.Error())
+
+	viper.Set(flagPluginsAPIKeyDistinguishEmptyKey.GetLong(), true)
+	m := &metrics.Metrics{}
+	err = Plugin.OnRequest(context.Background(), m, testutil.Proxy(), emptyKeyRequest(testutil.ProxyPath), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("anonymous access is not permitted for this path", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_anonymous_denied", "true", true})
+}
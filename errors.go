@@ -0,0 +1,63 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+// getStatusCode returns the HTTP status code associated with an error
+func getStatusCode(err error) int {
+	if e, ok := err.(*utils.StatusError); ok {
+		return e.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// withStatusCode returns a copy of the given error with a new status
+// code. The message of the original error is preserved.
+func withStatusCode(err error, code int) error {
+	if e, ok := err.(*utils.StatusError); ok {
+		return &utils.StatusError{code, e.Err}
+	}
+	return err
+}
+
+// withErrorMessage returns a copy of the given error with a new
+// message. The status code of the original error is preserved.
+func withErrorMessage(err error, msg string) error {
+	if e, ok := err.(*utils.StatusError); ok {
+		return &utils.StatusError{e.Code, errors.New(msg)}
+	}
+	return errors.New(msg)
+}
+
+// withRetryAfter returns a copy of the given error whose message asks the
+// client to retry after the given number of seconds. Kanali writes the
+// message of a plugin error to the response but none of its headers, so
+// this is how a client learns when to retry.
+func withRetryAfter(err error, seconds int) error {
+	return withErrorMessage(err, fmt.Sprintf("%s - retry after %d seconds", err.Error(), seconds))
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStatusCode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(http.StatusUnauthorized, getStatusCode(&utils.StatusError{http.StatusUnauthorized, errors.New("foo")}))
+	assert.Equal(http.StatusInternalServerError, getStatusCode(errors.New("foo")))
+}
+
+func TestWithErrorMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(&utils.StatusError{http.StatusUnauthorized, errors.New("bar")}, withErrorMessage(&utils.StatusError{http.StatusUnauthorized, errors.New("foo")}, "bar"))
+	assert.Equal(errors.New("bar"), withErrorMessage(errors.New("foo"), "bar"))
+}
+
+func TestWithStatusCode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(&utils.StatusError{http.StatusUnauthorized, errors.New("foo")}, withStatusCode(&utils.StatusError{http.StatusForbidden, errors.New("foo")}, http.StatusUnauthorized))
+	assert.Equal(errors.New("foo"), withStatusCode(errors.New("foo"), http.StatusUnauthorized))
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	err := withRetryAfter(&utils.StatusError{http.StatusServiceUnavailable, errors.New("foo")}, 30)
+	assert.Equal("foo - retry after 30 seconds", err.Error())
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+}
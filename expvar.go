@@ -0,0 +1,102 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyExpvarName,
+	)
+}
+
+var (
+	flagPluginsAPIKeyExpvarName = config.Flag{
+		Long:  "plugins.apiKey.expvar_name",
+		Short: "",
+		Value: "kanali_plugin_apikey",
+		Usage: "Name under which decision counters, active key counts, and processing time summaries are published with expvar. An empty value disables them.",
+	}
+)
+
+const (
+	expvarAllowed        = "allowed"
+	expvarDenied         = "denied"
+	expvarDeniedByReason = "denied_by_reason"
+	expvarActiveKeys     = "active_keys"
+	expvarProcessingTime = "processing_time_us"
+)
+
+// expvarMutex serializes the lookup and publishing of decision counters,
+// as expvar panics when the same name is published twice
+var expvarMutex sync.Mutex
+
+// getDecisionVars returns the decision counters published under the
+// configured name, publishing them if needed. Counters published by a
+// previous load of this plugin are reused, so that a reload never panics.
+func getDecisionVars() *expvar.Map {
+	name := viper.GetString(flagPluginsAPIKeyExpvarName.GetLong())
+	if name == "" {
+		return nil
+	}
+
+	expvarMutex.Lock()
+	defer expvarMutex.Unlock()
+
+	existing := expvar.Get(name)
+	if existing == nil {
+		vars := expvar.NewMap(name)
+		vars.Set(expvarDeniedByReason, new(expvar.Map).Init())
+		return vars
+	}
+	if vars, ok := existing.(*expvar.Map); ok {
+		if _, ok := vars.Get(expvarDeniedByReason).(*expvar.Map); !ok {
+			vars.Set(expvarDeniedByReason, new(expvar.Map).Init())
+		}
+		return vars
+	}
+	logrus.Warnf("expvar %s is already published by another component and will not hold decision counters", name)
+	return nil
+}
+
+// recordDecisionVars increments the expvar counters for the given decision.
+// Denials are also counted by the reason code of the given error.
+func recordDecisionVars(err error) {
+	vars := getDecisionVars()
+	if vars == nil {
+		return
+	}
+	if err == nil {
+		vars.Add(expvarAllowed, 1)
+		return
+	}
+	vars.Add(expvarDenied, 1)
+	if reasons, ok := vars.Get(expvarDeniedByReason).(*expvar.Map); ok {
+		reasons.Add(getDenyReasonCode(err), 1)
+	}
+}
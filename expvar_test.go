@@ -0,0 +1,114 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// readDecisionVars decodes the decision counters published under the given name
+func readDecisionVars(t *testing.T, name string) map[string]interface{} {
+	v := expvar.Get(name)
+	if v == nil {
+		return nil
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(v.String()), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestGetDecisionVars(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+	assert.Nil(getDecisionVars(), "counters should be disabled without a name")
+
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_get_decision_vars")
+	vars := getDecisionVars()
+	assert.NotNil(vars)
+	assert.True(vars == getDecisionVars(), "counters should only be published once")
+
+	existing := expvar.NewMap("test_get_decision_vars_reload")
+	existing.Add(expvarAllowed, 5)
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_get_decision_vars_reload")
+	assert.True(existing == getDecisionVars(), "counters from a previous load should be reused")
+	_, ok := existing.Get(expvarDeniedByReason).(*expvar.Map)
+	assert.True(ok)
+
+	expvar.NewString("test_get_decision_vars_taken")
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_get_decision_vars_taken")
+	assert.Nil(getDecisionVars(), "names held by other types should not be reused")
+}
+
+func TestRecordDecisionVars(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_record_decision_vars")
+
+	recordDecisionVars(nil)
+	recordDecisionVars(nil)
+	recordDecisionVars(errKeyNotBound)
+	recordDecisionVars(&utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request")})
+	recordDecisionVars(&utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request")})
+
+	vars := readDecisionVars(t, "test_record_decision_vars")
+	assert.Equal(float64(2), vars[expvarAllowed])
+	assert.Equal(float64(3), vars[expvarDenied])
+	assert.Equal(map[string]interface{}{
+		getDenyReasonCode(errKeyNotBound): float64(1),
+		"apikey_not_found_in_request":     float64(2),
+	}, vars[expvarDeniedByReason])
+
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+	recordDecisionVars(nil)
+	assert.Equal(float64(2), readDecisionVars(t, "test_record_decision_vars")[expvarAllowed], "disabled counters should not change")
+}
+
+func TestOnRequestDecisionVars(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_on_request_decision_vars")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding(testutil.GlobalKey(testutil.KeyName))})()
+
+	Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span())
+
+	vars := readDecisionVars(t, "test_on_request_decision_vars")
+	assert.Equal(float64(1), vars[expvarAllowed])
+	assert.Equal(float64(1), vars[expvarDenied])
+	assert.Equal(1, len(vars[expvarDeniedByReason].(map[string]interface{})))
+}
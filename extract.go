@@ -0,0 +1,146 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyHeaderKeyPattern,
+	)
+}
+
+var (
+	flagPluginsAPIKeyHeaderKeyPattern = config.Flag{
+		Long:  "plugins.apiKey.header_key_pattern",
+		Short: "",
+		Value: "",
+		Usage: "Regular expression, with a named capture group key, used to extract the apikey from the value of the apikey header. The whole value is used if empty.",
+	}
+)
+
+// keyPatternGroup is the name of the capture group holding the apikey
+const keyPatternGroup = "key"
+
+// maxAPIKeyBytes bounds the length of the values apikeys are extracted
+// from, so that hostile values are rejected before any processing
+const maxAPIKeyBytes = 4096
+
+// keyPattern caches the compiled apikey pattern along with the
+// configuration it was compiled from
+var keyPattern = struct {
+	sync.Mutex
+	source  string
+	pattern *regexp.Regexp
+	group   int
+	err     error
+}{}
+
+// getKeyPattern returns the compiled apikey pattern and the index of its
+// key capture group. The pattern is only recompiled if the configuration
+// changes. A nil pattern is returned if no pattern is configured.
+func getKeyPattern() (*regexp.Regexp, int, error) {
+	keyPattern.Lock()
+	defer keyPattern.Unlock()
+
+	source := viper.GetString(flagPluginsAPIKeyHeaderKeyPattern.GetLong())
+	if source == keyPattern.source {
+		return keyPattern.pattern, keyPattern.group, keyPattern.err
+	}
+
+	keyPattern.source, keyPattern.pattern, keyPattern.group, keyPattern.err = source, nil, 0, nil
+	if source == "" {
+		return nil, 0, nil
+	}
+
+	pattern, err := regexp.Compile(source)
+	if err != nil {
+		keyPattern.err = err
+	} else {
+		for i, name := range pattern.SubexpNames() {
+			if name == keyPatternGroup {
+				keyPattern.pattern, keyPattern.group = pattern, i
+			}
+		}
+		if keyPattern.pattern == nil {
+			keyPattern.err = errors.New("pattern does not have a capture group named " + keyPatternGroup)
+		}
+	}
+
+	if keyPattern.err != nil {
+		logrus.Errorf("invalid apikey header pattern - every request will be rejected: %s", keyPattern.err.Error())
+	}
+	return keyPattern.pattern, keyPattern.group, keyPattern.err
+}
+
+// extractAPIKey returns the apikey held by the given value of the apikey
+// header. If a pattern is configured, the apikey is the text captured by
+// its key group. An empty string is returned if the pattern is invalid or
+// does not match.
+func extractAPIKey(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) > maxAPIKeyBytes {
+		logrus.Debugf("apikey header is longer than %d bytes and will be ignored", maxAPIKeyBytes)
+		return ""
+	}
+
+	pattern, group, err := getKeyPattern()
+	if err != nil {
+		return ""
+	}
+	if pattern == nil {
+		return value
+	}
+
+	match := pattern.FindStringSubmatch(value)
+	if match == nil {
+		logrus.Debug("apikey header does not match the configured pattern")
+		return ""
+	}
+	return match[group]
+}
+
+// isWellFormedAPIKey returns false if the given apikey is too long, is not
+// valid UTF-8, or contains control characters. Such keys cannot have been
+// issued and are rejected without a store lookup.
+func isWellFormedAPIKey(apiKey string) bool {
+	if len(apiKey) > maxAPIKeyBytes || !utf8.ValidString(apiKey) {
+		return false
+	}
+	for _, c := range apiKey {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+	return true
+}
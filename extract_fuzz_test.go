@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+// FuzzGetAPIKey exercises apikey extraction from the header, with and
+// without a bearer pattern, and from the query string. Seeds are kept in
+// testdata/fuzz/FuzzGetAPIKey. Run with go test -fuzz=FuzzGetAPIKey.
+func FuzzGetAPIKey(f *testing.F) {
+	defer viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "apikey")
+
+	f.Add("myapikey", "", false)
+	f.Add("Bearer myapikey", "", true)
+	f.Add("", "apikey=myapikey", false)
+	f.Add("Bearer ", "apikey=%ff%00", true)
+	f.Add("my\x00api\nkey", "apikey=a&apikey=b", false)
+
+	f.Fuzz(func(t *testing.T, header, query string, bearer bool) {
+		pattern := ""
+		if bearer {
+			pattern = `^Bearer (?P<key>\S+)$`
+		}
+		viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), pattern)
+
+		r := &http.Request{
+			Header: http.Header{"Apikey": []string{header}},
+			URL:    &url.URL{Path: "/", RawQuery: query},
+		}
+		apiKey, location := getAPIKey(r)
+
+		switch location {
+		case keyLocationHeader:
+			if len(header) > maxAPIKeyBytes {
+				t.Fatalf("apikey extracted from a header of %d bytes", len(header))
+			}
+		case keyLocationQuery, "":
+		default:
+			t.Fatalf("unexpected apikey location %q", location)
+		}
+		if (apiKey == "") != (location == "") {
+			t.Fatalf("apikey %q found in location %q", apiKey, location)
+		}
+		if isWellFormedAPIKey(apiKey) && !utf8.ValidString(apiKey) {
+			t.Fatalf("apikey %q is not valid UTF-8 but is well formed", apiKey)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetKeyPattern(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), "")
+	pattern, _, err := getKeyPattern()
+	assert.Nil(pattern)
+	assert.Nil(err)
+
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), `^(Token|Key) (?P<key>\w+)$`)
+	pattern, group, err := getKeyPattern()
+	assert.Nil(err)
+	assert.NotNil(pattern)
+	assert.Equal(2, group)
+	cached, _, _ := getKeyPattern()
+	assert.True(pattern == cached, "the pattern should be compiled once")
+
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), `^Token (\w+)$`)
+	_, _, err = getKeyPattern()
+	assert.NotNil(err, "patterns without a key group should be invalid")
+
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), `^Token (?P<key>\w+$`)
+	_, _, err = getKeyPattern()
+	assert.NotNil(err)
+}
+
+func TestExtractAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), "")
+	assert.Equal("myapikey", extractAPIKey("myapikey"), "the whole value should be used without a pattern")
+	assert.Equal("", extractAPIKey(""))
+	assert.Equal("", extractAPIKey(strings.Repeat("a", maxAPIKeyBytes+1)), "oversized values should be ignored")
+
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), `client=\w+;key=(?P<key>[a-z]+)`)
+	assert.Equal("myapikey", extractAPIKey("client=acme;key=myapikey;region=us"))
+	assert.Equal("", extractAPIKey("client=acme;region=us"), "values that do not match should not yield a key")
+	assert.Equal("", extractAPIKey(""))
+
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), `key=([a-z]+)`)
+	assert.Equal("", extractAPIKey("client=acme;key=myapikey"), "patterns without a key group should not yield a key")
+}
+
+func TestIsWellFormedAPIKey(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isWellFormedAPIKey("myapikey"))
+	assert.True(isWellFormedAPIKey("clé-ünïcode"))
+	assert.True(isWellFormedAPIKey(strings.Repeat("a", maxAPIKeyBytes)))
+	assert.False(isWellFormedAPIKey(strings.Repeat("a", maxAPIKeyBytes+1)))
+	assert.False(isWellFormedAPIKey("my\x00apikey"))
+	assert.False(isWellFormedAPIKey("my\napikey"))
+	assert.False(isWellFormedAPIKey("my\x7fapikey"))
+	assert.False(isWellFormedAPIKey("my\xffapikey"))
+}
+
+func TestOnRequestMalformedAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "apikey")
+
+	r := getTestRequest()
+	r.Header.Del("apikey")
+	r.URL.RawQuery = "apikey=my%00apikey"
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("apikey is malformed", err.Error())
+}
+
+func TestOnRequestKeyPattern(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyHeaderKeyPattern.GetLong(), `^Bearer (?P<key>\S+)$`)
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	r.Header.Set("apikey", "Bearer myapikey")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("apikey not found in request", err.Error())
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+// annotationKeyExtraMethods is the APIKey annotation holding a comma
+// separated list of http methods the key is permitted to use in addition
+// to those permitted by the rules of its binding
+const annotationKeyExtraMethods = "apikey.kanali.io/extra-methods"
+
+// getExtraMethods returns the distinct, upper cased
+// extra http methods granted to the given APIKey
+func getExtraMethods(key spec.APIKey) []string {
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, method := range splitStringSlice(key.ObjectMeta.Annotations[annotationKeyExtraMethods]) {
+		method = strings.ToUpper(method)
+		if !seen[method] {
+			seen[method] = true
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// withExtraMethods returns a copy of the given rule whose granular verbs
+// are the union of its own and the extra methods of the given APIKey.
+// Global rules, and granular rules whose empty verbs already permit every
+// method, are returned unchanged.
+func withExtraMethods(rule spec.Rule, key spec.APIKey) spec.Rule {
+	extra := getExtraMethods(key)
+	if len(extra) < 1 || (rule.Global && !isGlobalRuleIntersected(rule)) {
+		return rule
+	}
+
+	verbs := []string{}
+	if rule.Granular != nil {
+		if len(rule.Granular.Verbs) < 1 && viper.GetBool(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong()) {
+			return rule
+		}
+		verbs = append(verbs, rule.Granular.Verbs...)
+	}
+	rule.Granular = &spec.GranularProxy{Verbs: append(verbs, extra...)}
+	return rule
+}
+
+// isAuthorizedByExtraMethods will return true if the given method is
+// permitted only because it is one of the extra methods of the given APIKey
+func isAuthorizedByExtraMethods(rule spec.Rule, key spec.APIKey, method string) bool {
+	return !validateAPIKey(rule, method) && validateAPIKey(withExtraMethods(rule, key), method)
+}
+
+// auditExtraMethods will, if the given request was permitted only because of
+// an extra method of the given APIKey, log that access was broadened. True is
+// returned if the request was audited.
+func auditExtraMethods(m *metrics.Metrics, p spec.APIProxy, r *http.Request, key spec.APIKey, rule spec.Rule) bool {
+	if !isAuthorizedByExtraMethods(rule, key, r.Method) {
+		return false
+	}
+
+	fields := logrus.Fields{
+		"decision_id":     getDecisionID(r),
+		"method":          r.Method,
+		"remote_addr":     getClientAddr(r),
+		"proxy_name":      p.ObjectMeta.Name,
+		"proxy_namespace": p.ObjectMeta.Namespace,
+		"key_name":        displayKeyName(key.ObjectMeta.Name),
+		"binding":         getBindingID(r),
+	}
+	if r.URL != nil {
+		fields["path"] = r.URL.Path
+	}
+	logrus.WithFields(fields).Warn("apikey authorized by an extra method beyond its binding rule")
+	m.Add(metrics.Metric{"api_key_extra_method", strings.ToUpper(r.Method), true})
+	return true
+}
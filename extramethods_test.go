@@ -0,0 +1,109 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestExtraMethodsAPIKey(methods string) spec.APIKey {
+	key := testutil.Key()
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyExtraMethods: methods}
+	return key
+}
+
+func TestGetExtraMethods(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"DELETE", "PUT"}, getExtraMethods(getTestExtraMethodsAPIKey(" delete, PUT,,Delete ")))
+	assert.Equal([]string{}, getExtraMethods(testutil.Key()))
+}
+
+func TestWithExtraMethods(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong(), false)
+	key := getTestExtraMethodsAPIKey("DELETE")
+
+	// the binding's verbs are unioned with the key's extra methods
+	rule := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+	assert.Equal([]string{"GET", "DELETE"}, withExtraMethods(rule, key).Granular.Verbs)
+	assert.Equal([]string{"GET"}, rule.Granular.Verbs, "the binding's rule should not be modified")
+	assert.Equal([]string{"DELETE"}, withExtraMethods(spec.Rule{}, key).Granular.Verbs)
+	assert.Equal(rule, withExtraMethods(rule, testutil.Key()))
+
+	// rules that already permit every method are unchanged
+	global := spec.Rule{Global: true}
+	assert.Equal(global, withExtraMethods(global, key))
+	empty := spec.Rule{Granular: &spec.GranularProxy{}}
+	assert.Equal([]string{"DELETE"}, withExtraMethods(empty, key).Granular.Verbs)
+	viper.Set(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong(), true)
+	assert.Equal(empty, withExtraMethods(empty, key))
+}
+
+func TestIsAuthorizedByExtraMethods(t *testing.T) {
+	assert := assert.New(t)
+	key := getTestExtraMethodsAPIKey("DELETE")
+	rule := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+
+	assert.True(isAuthorizedByExtraMethods(rule, key, "DELETE"))
+	assert.False(isAuthorizedByExtraMethods(rule, key, "GET"), "methods the binding permits should not be audited")
+	assert.False(isAuthorizedByExtraMethods(rule, key, "POST"))
+	assert.False(isAuthorizedByExtraMethods(rule, testutil.Key(), "DELETE"))
+}
+
+func TestOnRequestExtraMethods(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	cleanup := testutil.Stores([]spec.APIKey{getTestExtraMethodsAPIKey("DELETE")}, []spec.APIKeyBinding{testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))})
+	defer cleanup()
+
+	m := &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+	assert.NotContains(*m, metrics.Metric{"api_key_extra_method", "GET", true})
+
+	m = &metrics.Metrics{}
+	hook.Reset()
+	assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("DELETE", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+	assert.Contains(*m, metrics.Metric{"api_key_extra_method", "DELETE", true})
+	audited := false
+	for _, entry := range hook.Entries {
+		if entry.Level == logrus.WarnLevel && entry.Message == "apikey authorized by an extra method beyond its binding rule" {
+			audited = true
+			assert.Equal("DELETE", entry.Data["method"])
+			assert.Equal(testutil.KeyName, entry.Data["key_name"])
+		}
+	}
+	assert.True(audited, "extra methods should be audited")
+
+	assert.Equal(http.StatusForbidden, getStatusCode(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("POST", testutil.ProxyPath, testutil.KeyData), testutil.Span())))
+}
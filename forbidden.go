@@ -0,0 +1,54 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyForbiddenAsUnauthorized,
+	)
+}
+
+var (
+	flagPluginsAPIKeyForbiddenAsUnauthorized = config.Flag{
+		Long:  "plugins.apiKey.forbidden_as_unauthorized",
+		Short: "",
+		Value: false,
+		Usage: "Respond with a 401, as earlier releases did, instead of a 403 when a valid apikey lacks permission for a request.",
+	}
+)
+
+// withForbiddenStatus returns the given error, which denies a request made
+// with a valid apikey that lacks permission, with the status code clients
+// expect. A 403 is used unless the forbidden_as_unauthorized compatibility
+// flag is set, in which case it becomes a 401 as in earlier releases.
+func withForbiddenStatus(err error) error {
+	if err == nil || getStatusCode(err) != http.StatusForbidden || !viper.GetBool(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong()) {
+		return err
+	}
+	return withStatusCode(err, http.StatusUnauthorized)
+}
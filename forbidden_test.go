@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithForbiddenStatus(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), false)
+
+	forbidden := &utils.StatusError{http.StatusForbidden, errors.New("api key unauthorized")}
+	notAllowed := &utils.StatusError{http.StatusMethodNotAllowed, errors.New("http method not allowed for this api key")}
+
+	assert.Nil(withForbiddenStatus(nil))
+	assert.Equal(forbidden, withForbiddenStatus(forbidden))
+
+	viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), true)
+	err := withForbiddenStatus(forbidden)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("api key unauthorized", err.Error())
+	assert.Equal(notAllowed, withForbiddenStatus(notAllowed), "other status codes should be preserved")
+}
+
+func TestOnRequestForbidden(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), false)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))})()
+
+	tests := []struct {
+		name   string
+		apiKey string
+		method string
+		status int
+		legacy int
+	}{
+		{"no apikey", "", "GET", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"unknown apikey", "notmyapikey", "GET", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"insufficient permission", testutil.KeyData, "POST", http.StatusForbidden, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), false)
+		err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request(test.method, testutil.ProxyPath, test.apiKey), testutil.Span())
+		assert.Equal(test.status, getStatusCode(err), test.name)
+
+		viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), true)
+		err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request(test.method, testutil.ProxyPath, test.apiKey), testutil.Span())
+		assert.Equal(test.legacy, getStatusCode(err), "%s with forbidden_as_unauthorized", test.name)
+	}
+}
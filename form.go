@@ -0,0 +1,110 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyFormKey,
+		flagPluginsAPIKeyFormMaxBytes,
+	)
+}
+
+var (
+	flagPluginsAPIKeyFormKey = config.Flag{
+		Long:  "plugins.apiKey.form_key",
+		Short: "",
+		Value: "",
+		Usage: "Name of the form field holding the apikey of form-encoded requests when it is not found in the apikey header or query parameter. Form bodies are not consulted if empty.",
+	}
+	flagPluginsAPIKeyFormMaxBytes = config.Flag{
+		Long:  "plugins.apiKey.form_max_bytes",
+		Short: "",
+		Value: 65536,
+		Usage: "Maximum size, in bytes, of a form body that is parsed for the apikey. Larger bodies are proxied unparsed.",
+	}
+)
+
+// isFormRequest will return true if the given request
+// has an application/x-www-form-urlencoded body
+func isFormRequest(r *http.Request) bool {
+	if r.Body == nil {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// getFormAPIKey returns the apikey held by the configured field of the form
+// body of the given request. The body is buffered and restored so that it
+// is proxied unchanged. Bodies larger than the configured maximum are not
+// parsed, and only the maximum is buffered. An empty string is returned if
+// no apikey is found.
+func getFormAPIKey(r *http.Request) string {
+	field := viper.GetString(flagPluginsAPIKeyFormKey.GetLong())
+	if field == "" || !isFormRequest(r) {
+		return ""
+	}
+
+	max := int64(viper.GetInt(flagPluginsAPIKeyFormMaxBytes.GetLong()))
+	if max <= 0 || r.ContentLength > max {
+		return ""
+	}
+
+	body := r.Body
+	b, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	if err != nil || int64(len(b)) > max {
+		// the rest of the body has not been read, so it is proxied after
+		// the buffered part
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(b), body), body}
+		if err != nil {
+			logrus.Debugf("could not read form body: %s", err.Error())
+		}
+		return ""
+	}
+	body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return ""
+	}
+	return values.Get(field)
+}
+
+// readCloser reads from a reader and closes a different closer, so that
+// a partly buffered body still closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
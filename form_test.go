@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestFormRequest returns a POST request with the given form body
+func getTestFormRequest(contentType, body string) *http.Request {
+	r := testutil.Request("POST", testutil.ProxyPath, "")
+	r.Header.Set("Content-Type", contentType)
+	r.Body = ioutil.NopCloser(strings.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return r
+}
+
+func TestGetFormAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyFormKey.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyFormMaxBytes.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyFormMaxBytes.GetLong(), 1024)
+
+	body := "name=foo&api_key=myapikey&amount=10"
+	r := getTestFormRequest("application/x-www-form-urlencoded", body)
+	assert.Equal("", getFormAPIKey(r), "form bodies should not be consulted unless configured")
+
+	viper.Set(flagPluginsAPIKeyFormKey.GetLong(), "api_key")
+	assert.Equal("myapikey", getFormAPIKey(r))
+	assert.Equal("myapikey", getFormAPIKey(r), "the body should be restored after it is parsed")
+	restored, err := ioutil.ReadAll(r.Body)
+	assert.Nil(err)
+	assert.Equal(body, string(restored))
+
+	r = getTestFormRequest("application/x-www-form-urlencoded; charset=utf-8", body)
+	assert.Equal("myapikey", getFormAPIKey(r))
+
+	r = getTestFormRequest("application/json", `{"api_key": "myapikey"}`)
+	assert.Equal("", getFormAPIKey(r), "other bodies should not be parsed")
+	restored, _ = ioutil.ReadAll(r.Body)
+	assert.Equal(`{"api_key": "myapikey"}`, string(restored))
+}
+
+func TestGetFormAPIKeyMaxBytes(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyFormKey.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyFormMaxBytes.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyFormKey.GetLong(), "api_key")
+	viper.Set(flagPluginsAPIKeyFormMaxBytes.GetLong(), 16)
+
+	body := "api_key=myapikey&padding=" + strings.Repeat("a", 100)
+	r := getTestFormRequest("application/x-www-form-urlencoded", body)
+	assert.Equal("", getFormAPIKey(r), "bodies with a larger content length should not be read")
+	restored, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(body, string(restored))
+
+	// a body of unknown length is only buffered up to the maximum
+	r = getTestFormRequest("application/x-www-form-urlencoded", body)
+	r.ContentLength = -1
+	assert.Equal("", getFormAPIKey(r))
+	restored, _ = ioutil.ReadAll(r.Body)
+	assert.Equal(body, string(restored), "a partly read body should be restored in full")
+}
+
+func TestOnRequestFormAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyFormKey.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyFormMaxBytes.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyFormKey.GetLong(), "api_key")
+	viper.Set(flagPluginsAPIKeyFormMaxBytes.GetLong(), 1024)
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	body := "api_key=" + testutil.KeyData + "&action=submit"
+	r := getTestFormRequest("application/x-www-form-urlencoded", body)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	assert.Equal(keyLocationForm, getAPIKeyLocation(r))
+	restored, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(body, string(restored), "the body should be proxied unchanged")
+}
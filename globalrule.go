@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyGlobalRuleMode,
+	)
+}
+
+var (
+	flagPluginsAPIKeyGlobalRuleMode = config.Flag{
+		Long:  "plugins.apiKey.global_rule_mode",
+		Short: "",
+		Value: globalRuleOverride,
+		Usage: "How a global rule combines with a granular rule in the same rule. Either override, where global grants every http method, or intersect, where the granular rule further restricts it.",
+	}
+)
+
+const (
+	globalRuleOverride  = "override"
+	globalRuleIntersect = "intersect"
+)
+
+// isGlobalRuleIntersected will return true if the global grant of the
+// given rule should be restricted by its granular rule. Rules without a
+// granular rule, or whose granular rule lists no verbs, have nothing to
+// intersect with, and unknown modes override, as they would if the flag
+// was not set.
+func isGlobalRuleIntersected(rule spec.Rule) bool {
+	if !rule.Global || rule.Granular == nil || len(rule.Granular.Verbs) < 1 {
+		return false
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(viper.GetString(flagPluginsAPIKeyGlobalRuleMode.GetLong()))); mode {
+	case globalRuleIntersect:
+		return true
+	case "", globalRuleOverride:
+		return false
+	default:
+		logrus.Warnf("unknown global rule mode %s - global rules will override granular rules", mode)
+		return false
+	}
+}
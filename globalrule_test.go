@@ -0,0 +1,92 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsGlobalRuleIntersected(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), "")
+
+	rule := spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+	viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), globalRuleOverride)
+	assert.False(isGlobalRuleIntersected(rule))
+	viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), "bogus")
+	assert.False(isGlobalRuleIntersected(rule), "unknown modes should override")
+
+	viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), globalRuleIntersect)
+	assert.True(isGlobalRuleIntersected(rule))
+	assert.False(isGlobalRuleIntersected(spec.Rule{Global: true}), "missing granular rules should not restrict")
+	assert.False(isGlobalRuleIntersected(spec.Rule{Global: true, Granular: &spec.GranularProxy{}}), "granular rules without verbs should not restrict")
+	assert.False(isGlobalRuleIntersected(spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}))
+}
+
+func TestValidateAPIKeyGlobalRuleMode(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), "")
+
+	tests := []struct {
+		rule      spec.Rule
+		method    string
+		override  bool
+		intersect bool
+	}{
+		{spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}, "GET", true, true},
+		{spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}, "GET", true, false},
+		{spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{}}}, "DELETE", true, true},
+		{spec.Rule{Global: true}, "DELETE", true, true},
+		{spec.Rule{Global: false, Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}, "GET", false, false},
+	}
+
+	for i, test := range tests {
+		viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), globalRuleOverride)
+		assert.Equal(test.override, validateAPIKey(test.rule, test.method), "override case %d", i)
+		viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), globalRuleIntersect)
+		assert.Equal(test.intersect, validateAPIKey(test.rule, test.method), "intersect case %d", i)
+	}
+}
+
+func TestOnRequestGlobalRuleIntersect(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyGlobalRuleMode.GetLong(), globalRuleIntersect)
+
+	binding := testutil.Binding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+	cleanup := testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{binding})
+	defer cleanup()
+
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	r = testutil.Request("POST", testutil.ProxyPath, testutil.KeyData)
+	assert.Equal(http.StatusForbidden, getStatusCode(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span())))
+}
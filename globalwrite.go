@@ -0,0 +1,160 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyGlobalWriteAudit,
+	)
+}
+
+var (
+	flagPluginsAPIKeyGlobalWriteAudit = config.Flag{
+		Long:  "plugins.apiKey.global_write_audit",
+		Short: "",
+		Value: "",
+		Usage: "Channel, either log or stdout, that an audit event is emitted to whenever a global rule authorizes a method that is not GET or HEAD. Disabled if empty.",
+	}
+)
+
+const (
+	globalWriteAuditLog    = "log"
+	globalWriteAuditStdout = "stdout"
+)
+
+// globalWriteEventVersion is incremented whenever a breaking change
+// is made to the globalWriteEvent schema
+const globalWriteEventVersion = 1
+
+// globalWriteEvent is the audit event emitted whenever a key
+// with a global rule is authorized to make a write
+type globalWriteEvent struct {
+	Version        int    `json:"version"`
+	Type           string `json:"type"`
+	DecisionID     string `json:"decision_id"`
+	Time           string `json:"time"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	RemoteAddr     string `json:"remote_addr"`
+	ProxyName      string `json:"proxy_name"`
+	ProxyNamespace string `json:"proxy_namespace"`
+	// Key is the name of the APIKey, masked if configured
+	Key string `json:"key"`
+	// Binding is the namespace and name of the APIKeyBinding
+	Binding string `json:"binding"`
+}
+
+var (
+	// globalWriteAuditWriter is where audit events are written when the
+	// stdout channel is configured, one line per event
+	globalWriteAuditWriter io.Writer = os.Stdout
+	globalWriteAuditMutex  sync.Mutex
+)
+
+// isGlobalWrite will return true if the given rule grants every method and
+// the given method is not safe, so that the request may modify data
+func isGlobalWrite(rule spec.Rule, method string) bool {
+	return rule.Global && !isSafeMethod(method)
+}
+
+// newGlobalWriteEvent creates a globalWriteEvent describing the given request
+func newGlobalWriteEvent(p spec.APIProxy, r *http.Request, currTime time.Time) globalWriteEvent {
+	event := globalWriteEvent{
+		Version:        globalWriteEventVersion,
+		Type:           "global_write",
+		DecisionID:     getDecisionID(r),
+		Time:           currTime.UTC().Format(time.RFC3339),
+		Method:         r.Method,
+		RemoteAddr:     getClientAddr(r),
+		ProxyName:      p.ObjectMeta.Name,
+		ProxyNamespace: p.ObjectMeta.Namespace,
+		Key:            displayKeyName(getAPIKeyName(r)),
+		Binding:        getBindingID(r),
+	}
+	if r.URL != nil {
+		event.Path = r.URL.Path
+	}
+	return event
+}
+
+// auditGlobalWrite will, if enabled and the given rule authorized a write,
+// emit an audit event describing the given request to the configured
+// channel. True is returned if an event was emitted.
+func auditGlobalWrite(m *metrics.Metrics, p spec.APIProxy, r *http.Request, rule spec.Rule, currTime time.Time) bool {
+	channel := strings.ToLower(strings.TrimSpace(viper.GetString(flagPluginsAPIKeyGlobalWriteAudit.GetLong())))
+	if channel == "" || !isGlobalWrite(rule, r.Method) {
+		return false
+	}
+
+	event := newGlobalWriteEvent(p, r, currTime)
+	switch channel {
+	case globalWriteAuditLog:
+		logrus.WithFields(logrus.Fields{
+			"decision_id":     event.DecisionID,
+			"method":          event.Method,
+			"path":            event.Path,
+			"remote_addr":     event.RemoteAddr,
+			"proxy_name":      event.ProxyName,
+			"proxy_namespace": event.ProxyNamespace,
+			"key_name":        event.Key,
+			"binding":         event.Binding,
+		}).Warn("global rule authorized a write")
+	case globalWriteAuditStdout:
+		writeGlobalWriteEvent(event)
+	default:
+		logrus.Warnf("unknown %s channel %s - global writes will not be audited", flagPluginsAPIKeyGlobalWriteAudit.GetLong(), channel)
+		return false
+	}
+
+	m.Add(metrics.Metric{"api_key_global_write", "true", true})
+	return true
+}
+
+// writeGlobalWriteEvent writes the given event to stdout as a single line
+func writeGlobalWriteEvent(event globalWriteEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.Warnf("could not encode global write event: %s", err.Error())
+		return
+	}
+
+	globalWriteAuditMutex.Lock()
+	defer globalWriteAuditMutex.Unlock()
+	if _, err := globalWriteAuditWriter.Write(append(line, '\n')); err != nil {
+		logrus.Warnf("could not write global write event: %s", err.Error())
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestGlobalWriteAuditWriter() (*bytes.Buffer, func()) {
+	buf := &bytes.Buffer{}
+	globalWriteAuditWriter = buf
+	return buf, func() {
+		globalWriteAuditWriter = os.Stdout
+		viper.Set(flagPluginsAPIKeyGlobalWriteAudit.GetLong(), "")
+	}
+}
+
+func TestIsGlobalWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	global := spec.Rule{Global: true}
+	granular := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE", "delete"} {
+		assert.True(isGlobalWrite(global, method), method)
+		assert.False(isGlobalWrite(granular, method), method)
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		assert.False(isGlobalWrite(global, method), method)
+	}
+}
+
+func TestOnRequestGlobalWriteAudit(t *testing.T) {
+	assert := assert.New(t)
+	buf, reset := setTestGlobalWriteAuditWriter()
+	defer reset()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	request := func(method string) *metrics.Metrics {
+		m := &metrics.Metrics{}
+		assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request(method, testutil.ProxyPath+"/1", testutil.KeyData), testutil.Span()))
+		return m
+	}
+
+	request("DELETE")
+	assert.Equal("", buf.String(), "global writes should not be audited unless enabled")
+
+	viper.Set(flagPluginsAPIKeyGlobalWriteAudit.GetLong(), "stdout")
+	m := request("GET")
+	assert.Equal("", buf.String(), "global reads should not be audited")
+	assert.NotContains(*m, metrics.Metric{"api_key_global_write", "true", true})
+
+	m = request("DELETE")
+	assert.Contains(*m, metrics.Metric{"api_key_global_write", "true", true})
+	var event globalWriteEvent
+	assert.Nil(json.Unmarshal(buf.Bytes(), &event))
+	assert.Equal(globalWriteEventVersion, event.Version)
+	assert.Equal("global_write", event.Type)
+	assert.Equal("DELETE", event.Method)
+	assert.Equal(testutil.ProxyPath+"/1", event.Path)
+	assert.Equal(testutil.ProxyName, event.ProxyName)
+	assert.Equal(testutil.KeyName, event.Key)
+	assert.Equal(testutil.Namespace+"/"+testutil.Binding().ObjectMeta.Name, event.Binding)
+	assert.NotEqual("", event.DecisionID)
+}
+
+func TestOnRequestGlobalWriteAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	buf, reset := setTestGlobalWriteAuditWriter()
+	defer reset()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	binding := testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET", "POST"))
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{binding})()
+
+	hook := test.NewGlobal()
+	viper.Set(flagPluginsAPIKeyGlobalWriteAudit.GetLong(), "log")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("POST", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+	for _, entry := range hook.Entries {
+		assert.NotEqual("global rule authorized a write", entry.Message, "granular writes should not be audited")
+	}
+
+	spec.BindingStore.Set(testutil.Binding())
+	hook.Reset()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("POST", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+	var audited *logrus.Entry
+	for _, entry := range hook.Entries {
+		if entry.Message == "global rule authorized a write" {
+			audited = entry
+		}
+	}
+	assert.NotNil(audited)
+	assert.Equal(logrus.WarnLevel, audited.Level)
+	assert.Equal("POST", audited.Data["method"])
+	assert.Equal("", buf.String(), "events should only be written to the configured channel")
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyHeadMirrorsGet,
+	)
+}
+
+var (
+	flagPluginsAPIKeyHeadMirrorsGet = config.Flag{
+		Long:  "plugins.apiKey.head_mirrors_get",
+		Short: "",
+		Value: true,
+		Usage: "Authorize HEAD requests for any api key whose granular rule permits GET.",
+	}
+)
+
+// isHeadMirroringGet returns true if the given method is HEAD and
+// HEAD requests are authorized by the rules that permit GET
+func isHeadMirroringGet(method string) bool {
+	return strings.ToUpper(method) == http.MethodHead && viper.GetBool(flagPluginsAPIKeyHeadMirrorsGet.GetLong())
+}
+
+// withoutHeadBody returns a copy of the given error with an empty message
+// if the given request is a HEAD request. Kanali writes the message of an
+// error as the body of its failure response, which a response to HEAD must
+// not have, so denials of HEAD requests carry only their status code. The
+// plugin cannot keep Kanali from framing even an empty message in its own
+// failure response; the Go HTTP server discards any body written in
+// response to HEAD.
+func withoutHeadBody(r *http.Request, err error) error {
+	if err == nil || strings.ToUpper(r.Method) != http.MethodHead {
+		return err
+	}
+	return withErrorMessage(err, "")
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsHeadMirroringGet(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), false)
+
+	viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), false)
+	assert.False(isHeadMirroringGet("HEAD"))
+
+	viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), true)
+	assert.True(isHeadMirroringGet("HEAD"))
+	assert.True(isHeadMirroringGet("head"))
+	assert.False(isHeadMirroringGet("GET"))
+}
+
+func TestValidateAPIKeyHead(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), false)
+
+	get := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+	post := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}
+	head := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"HEAD"}}}
+
+	viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), false)
+	assert.False(validateAPIKey(get, "HEAD"))
+	assert.True(validateAPIKey(head, "HEAD"))
+
+	viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), true)
+	assert.True(validateAPIKey(get, "HEAD"))
+	assert.True(validateAPIKey(head, "HEAD"))
+	assert.False(validateAPIKey(post, "HEAD"))
+	assert.False(validateAPIKey(head, "GET"), "GET should not mirror HEAD")
+}
+
+func TestWithoutHeadBody(t *testing.T) {
+	assert := assert.New(t)
+
+	r := getTestRequest()
+	err := &utils.StatusError{http.StatusUnauthorized, errors.New("api key unauthorized")}
+	assert.Nil(withoutHeadBody(r, nil))
+	assert.Equal(err, withoutHeadBody(r, err), "denials of other methods should keep their message")
+
+	r.Method = "head"
+	assert.Nil(withoutHeadBody(r, nil))
+	headErr := withoutHeadBody(r, err)
+	assert.Equal("", headErr.Error())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(headErr), "the status code should be preserved")
+}
+
+func TestOnRequestHead(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), false)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyHeadMirrorsGet.GetLong(), true)
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(binding)
+
+	r := getTestRequest()
+	r.Method = "HEAD"
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+
+	r = getTestRequest()
+	r.Method = "HEAD"
+	r.Header.Set("apikey", "stuffed")
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("", err.Error(), "denials of HEAD requests should have no body")
+
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("", err.Error(), "a repeated HEAD decision should have no body")
+
+	r = getTestRequest()
+	r.Method = "HEAD"
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}
+	spec.BindingStore.Set(binding)
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("", err.Error(), "HEAD requests denied by their rule should have no body")
+
+	r = getTestRequest()
+	r.Header.Set("apikey", "stuffed")
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal("apikey not found in k8s cluster", err.Error(), "denials of GET requests should keep their message")
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeySuspiciousHeaderRules,
+	)
+}
+
+var (
+	flagPluginsAPIKeySuspiciousHeaderRules = config.Flag{
+		Long:  "plugins.apiKey.suspicious_header_rules",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of the header rules that reject requests with suspicious header combinations. Built-in rules are key_and_authorization, duplicate_key_header, and key_in_header_and_query.",
+	}
+)
+
+// HeaderRule reports whether the headers of a request are
+// contradictory or otherwise suspicious
+type HeaderRule func(r *http.Request) bool
+
+// headerRules holds every rule that can be enabled by name
+var headerRules = struct {
+	sync.RWMutex
+	rules map[string]HeaderRule
+}{rules: map[string]HeaderRule{
+	"key_and_authorization":   hasConflictingAuthorization,
+	"duplicate_key_header":    hasDuplicateKeyHeader,
+	"key_in_header_and_query": hasKeyInHeaderAndQuery,
+}}
+
+// RegisterHeaderRule makes a custom rule available under the given name,
+// replacing any rule already registered under it. Like the built-in rules,
+// it only runs when named in plugins.apiKey.suspicious_header_rules. It can
+// be retrieved via plugin.Lookup and called at startup. Passing nil removes
+// the rule.
+func RegisterHeaderRule(name string, rule HeaderRule) {
+	headerRules.Lock()
+	defer headerRules.Unlock()
+	if rule == nil {
+		delete(headerRules.rules, name)
+		return
+	}
+	headerRules.rules[name] = rule
+}
+
+// hasConflictingAuthorization will return true if the given request carries
+// both an apikey and an Authorization header holding a different credential
+func hasConflictingAuthorization(r *http.Request) bool {
+	if http.CanonicalHeaderKey(viper.GetString(flagPluginsAPIKeyHeaderKey.GetLong())) == "Authorization" {
+		return false
+	}
+	authorization := strings.Fields(r.Header.Get("Authorization"))
+	if len(authorization) < 1 {
+		return false
+	}
+	apiKey, _ := getAPIKey(r)
+	return apiKey != "" && authorization[len(authorization)-1] != apiKey
+}
+
+// hasDuplicateKeyHeader will return true if the apikey header of the given
+// request is repeated with different values
+func hasDuplicateKeyHeader(r *http.Request) bool {
+	values := r.Header[http.CanonicalHeaderKey(viper.GetString(flagPluginsAPIKeyHeaderKey.GetLong()))]
+	for _, value := range values {
+		if value != values[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasKeyInHeaderAndQuery will return true if the given request carries
+// different apikeys in the apikey header and the query parameter
+func hasKeyInHeaderAndQuery(r *http.Request) bool {
+	param := viper.GetString(flagPluginsAPIKeyQueryKey.GetLong())
+	if param == "" || r.URL == nil {
+		return false
+	}
+	headerKey := extractAPIKey(r.Header.Get(viper.GetString(flagPluginsAPIKeyHeaderKey.GetLong())))
+	queryKey := r.URL.Query().Get(param)
+	return headerKey != "" && queryKey != "" && headerKey != queryKey
+}
+
+// getSuspiciousHeaderRule returns the name of the first enabled rule that
+// flags the headers of the given request. An empty string is returned if
+// no rule does.
+func getSuspiciousHeaderRule(r *http.Request) string {
+	headerRules.RLock()
+	defer headerRules.RUnlock()
+
+	for _, name := range getStringSlice(flagPluginsAPIKeySuspiciousHeaderRules.GetLong()) {
+		rule, ok := headerRules.rules[name]
+		if !ok {
+			logrus.Warnf("unknown header rule %s will be ignored", name)
+			continue
+		}
+		if rule(r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// validateHeaderRules returns a 400 error, along with the name of the rule
+// responsible, if the headers of the given request are suspicious
+func validateHeaderRules(r *http.Request) (string, error) {
+	name := getSuspiciousHeaderRule(r)
+	if name == "" {
+		return "", nil
+	}
+	return name, &utils.StatusError{http.StatusBadRequest, errors.New("request headers are contradictory")}
+}
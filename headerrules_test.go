@@ -0,0 +1,132 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasConflictingAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	r := getTestRequest()
+	assert.False(hasConflictingAuthorization(r))
+
+	r.Header.Set("Authorization", "Bearer myapikey")
+	assert.False(hasConflictingAuthorization(r), "an authorization header holding the same apikey is not a conflict")
+
+	r.Header.Set("Authorization", "Bearer sometoken")
+	assert.True(hasConflictingAuthorization(r))
+
+	r.Header.Del("Apikey")
+	assert.False(hasConflictingAuthorization(r), "requests without an apikey cannot conflict")
+}
+
+func TestHasDuplicateKeyHeader(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	r := getTestRequest()
+	assert.False(hasDuplicateKeyHeader(r))
+
+	r.Header.Add("Apikey", "myapikey")
+	assert.False(hasDuplicateKeyHeader(r), "repeating the same apikey is not a conflict")
+
+	r.Header.Add("Apikey", "otherapikey")
+	assert.True(hasDuplicateKeyHeader(r))
+}
+
+func TestHasKeyInHeaderAndQuery(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	r := getTestRequest()
+	r.URL.RawQuery = "apikey=otherapikey"
+	assert.False(hasKeyInHeaderAndQuery(r), "query parameters should be ignored unless configured")
+
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "apikey")
+	assert.True(hasKeyInHeaderAndQuery(r))
+
+	r.URL.RawQuery = "apikey=myapikey"
+	assert.False(hasKeyInHeaderAndQuery(r))
+}
+
+func TestValidateHeaderRules(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySuspiciousHeaderRules.GetLong(), "")
+	defer RegisterHeaderRule("has_cookie", nil)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	r := getTestRequest()
+	r.Header.Set("Authorization", "Bearer sometoken")
+	r.Header.Set("Cookie", "session=1")
+	rule, err := validateHeaderRules(r)
+	assert.Equal("", rule)
+	assert.Nil(err, "rules should only run when enabled")
+
+	viper.Set(flagPluginsAPIKeySuspiciousHeaderRules.GetLong(), "unknown,duplicate_key_header,key_and_authorization")
+	rule, err = validateHeaderRules(r)
+	assert.Equal("key_and_authorization", rule)
+	assert.Equal(http.StatusBadRequest, getStatusCode(err))
+	assert.Equal("request headers are contradictory", err.Error())
+
+	RegisterHeaderRule("has_cookie", func(r *http.Request) bool {
+		return r.Header.Get("Cookie") != ""
+	})
+	viper.Set(flagPluginsAPIKeySuspiciousHeaderRules.GetLong(), "has_cookie")
+	rule, _ = validateHeaderRules(r)
+	assert.Equal("has_cookie", rule, "custom rules should run when enabled")
+
+	RegisterHeaderRule("has_cookie", nil)
+	rule, err = validateHeaderRules(r)
+	assert.Equal("", rule)
+	assert.Nil(err, "removed rules should no longer run")
+}
+
+func TestOnRequestHeaderRules(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySuspiciousHeaderRules.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeySuspiciousHeaderRules.GetLong(), "key_and_authorization")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+
+	r := getTestRequest()
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusBadRequest, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_suspicious_headers", "key_and_authorization", true})
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyMaxHeaderBytes,
+	)
+}
+
+var (
+	flagPluginsAPIKeyMaxHeaderBytes = config.Flag{
+		Long:  "plugins.apiKey.max_header_bytes",
+		Short: "",
+		Value: 0,
+		Usage: "Maximum total size, in bytes, of a request's headers. Requests with larger headers are rejected before the apikey is read. Disabled if 0.",
+	}
+)
+
+// getHeaderSize returns the size of the given headers as they would be
+// written on the wire, each value on its own "name: value\r\n" line
+func getHeaderSize(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(": ") + len(value) + len("\r\n")
+		}
+	}
+	return size
+}
+
+// isHeaderTooLarge will return true if the headers of the
+// given request exceed the configured maximum size
+func isHeaderTooLarge(r *http.Request) bool {
+	max := viper.GetInt(flagPluginsAPIKeyMaxHeaderBytes.GetLong())
+	return max > 0 && getHeaderSize(r.Header) > max
+}
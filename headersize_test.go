@@ -0,0 +1,87 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHeaderSize(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0, getHeaderSize(nil))
+	assert.Equal(0, getHeaderSize(http.Header{}))
+	assert.Equal(len("Apikey: myapikey\r\n"), getHeaderSize(http.Header{"Apikey": []string{"myapikey"}}))
+	assert.Equal(len("X-Foo: a\r\nX-Foo: bc\r\nX-Bar: \r\n"), getHeaderSize(http.Header{
+		"X-Foo": []string{"a", "bc"},
+		"X-Bar": []string{""},
+	}))
+}
+
+func TestIsHeaderTooLarge(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMaxHeaderBytes.GetLong(), 0)
+
+	r := getTestRequest()
+	for i := 0; i < 100; i++ {
+		r.Header.Add("X-Padding", strings.Repeat("a", 100))
+	}
+
+	viper.Set(flagPluginsAPIKeyMaxHeaderBytes.GetLong(), 0)
+	assert.False(isHeaderTooLarge(r), "header size should not be limited when disabled")
+
+	viper.Set(flagPluginsAPIKeyMaxHeaderBytes.GetLong(), 8192)
+	assert.True(isHeaderTooLarge(r), "many small headers should count towards the limit")
+	assert.False(isHeaderTooLarge(getTestRequest()))
+
+	viper.Set(flagPluginsAPIKeyMaxHeaderBytes.GetLong(), getHeaderSize(getTestRequest().Header))
+	assert.False(isHeaderTooLarge(getTestRequest()), "headers exactly at the limit should be allowed")
+}
+
+func TestOnRequestHeaderTooLarge(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMaxHeaderBytes.GetLong(), 0)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyMaxHeaderBytes.GetLong(), 1024)
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	r.Header.Set("Cookie", strings.Repeat("a", 1024))
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusRequestHeaderFieldsTooLarge, getStatusCode(err))
+	assert.Equal("request headers too large", err.Error())
+	assert.Equal(metrics.Metrics{{"api_key_header_too_large", "true", true}, {"api_key_denied", "true", true}, {"api_key_denied_status", "431", true}}, *m, "the apikey should not be processed")
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+}
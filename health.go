@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyHealthPath,
+		flagPluginsAPIKeyHealthStatus,
+		flagPluginsAPIKeyHealthBody,
+	)
+}
+
+var (
+	flagPluginsAPIKeyHealthPath = config.Flag{
+		Long:  "plugins.apiKey.health_path",
+		Short: "",
+		Value: "",
+		Usage: "Request path answered directly by the plugin without an apikey or proxying. Disabled if empty.",
+	}
+	flagPluginsAPIKeyHealthStatus = config.Flag{
+		Long:  "plugins.apiKey.health_status",
+		Short: "",
+		Value: http.StatusOK,
+		Usage: "HTTP status code of the health path response.",
+	}
+	flagPluginsAPIKeyHealthBody = config.Flag{
+		Long:  "plugins.apiKey.health_body",
+		Short: "",
+		Value: "ok",
+		Usage: "Message of the health path response.",
+	}
+)
+
+// isHealthPath will return true if the given request targets the configured health path
+func isHealthPath(r *http.Request) bool {
+	path := viper.GetString(flagPluginsAPIKeyHealthPath.GetLong())
+	return path != "" && r.URL != nil && r.URL.Path == path
+}
+
+// getHealthResponse returns the response to a request for the health path.
+// A plugin cannot write a response itself, so the response is expressed as
+// a utils.StatusError carrying the configured status code and message.
+// Kanali responds with it immediately and the upstream service is never
+// contacted, which allows liveness probes to succeed while it is down.
+func getHealthResponse() error {
+	status := viper.GetInt(flagPluginsAPIKeyHealthStatus.GetLong())
+	if status < 100 {
+		status = http.StatusOK
+	}
+	return &utils.StatusError{status, errors.New(viper.GetString(flagPluginsAPIKeyHealthBody.GetLong()))}
+}
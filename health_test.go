@@ -0,0 +1,95 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsHealthPath(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHealthPath.GetLong(), "")
+
+	u, _ := url.Parse("http://host.com/healthz")
+
+	viper.Set(flagPluginsAPIKeyHealthPath.GetLong(), "")
+	assert.False(isHealthPath(&http.Request{URL: u}))
+
+	viper.Set(flagPluginsAPIKeyHealthPath.GetLong(), "/healthz")
+	assert.True(isHealthPath(&http.Request{URL: u}))
+	assert.False(isHealthPath(getTestRequest()))
+	assert.False(isHealthPath(&http.Request{}))
+}
+
+func TestGetHealthResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	viper.Set(flagPluginsAPIKeyHealthStatus.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyHealthBody.GetLong(), "ok")
+	err := getHealthResponse()
+	assert.Equal(http.StatusOK, getStatusCode(err))
+	assert.Equal("ok", err.Error())
+
+	viper.Set(flagPluginsAPIKeyHealthStatus.GetLong(), http.StatusNoContent)
+	viper.Set(flagPluginsAPIKeyHealthBody.GetLong(), "healthy")
+	err = getHealthResponse()
+	assert.Equal(http.StatusNoContent, getStatusCode(err))
+	assert.Equal("healthy", err.Error())
+}
+
+func TestOnRequestHealthPath(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyHealthPath.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "")
+	defer resetFederatedStore("")
+
+	lookups := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	resetFederatedStore(server.URL)
+
+	viper.Set(flagPluginsAPIKeyHealthPath.GetLong(), "/healthz")
+	viper.Set(flagPluginsAPIKeyHealthStatus.GetLong(), http.StatusOK)
+	viper.Set(flagPluginsAPIKeyHealthBody.GetLong(), "ok")
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), keyStoreFederated)
+
+	r := getTestRequest()
+	r.URL, _ = url.Parse("http://host.com/healthz")
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusOK, getStatusCode(err))
+	assert.Equal("ok", err.Error())
+	assert.Equal(0, lookups, "health path should be answered without a store lookup")
+
+	Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(1, lookups)
+}
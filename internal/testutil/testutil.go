@@ -0,0 +1,212 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package testutil provides fixtures and fakes that reduce the
+// boilerplate needed to test the apikey plugin.
+package testutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+const (
+	// ProxyName is the name of the APIProxy returned by Proxy
+	ProxyName = "APIProxyone"
+	// ProxyPath is the path of the APIProxy returned by Proxy
+	ProxyPath = "/api/v1/accounts"
+	// Namespace holds every resource returned by this package
+	Namespace = "foo"
+	// KeyName is the name of the APIKey returned by Key
+	KeyName = "apikeyone"
+	// KeyData is the apikey of the APIKey returned by Key
+	KeyData = "myapikey"
+	// BindingName is the name of the APIKeyBinding returned by Binding
+	BindingName = "apikeybindingone"
+)
+
+// Proxy returns an APIProxy named ProxyName for ProxyPath
+func Proxy() spec.APIProxy {
+	return spec.APIProxy{
+		TypeMeta: unversioned.TypeMeta{},
+		ObjectMeta: api.ObjectMeta{
+			Name:      ProxyName,
+			Namespace: Namespace,
+		},
+		Spec: spec.APIProxySpec{
+			Path:   ProxyPath,
+			Target: "/",
+			Service: spec.Service{
+				Name:      "my-service",
+				Namespace: Namespace,
+				Port:      8080,
+			},
+			Plugins: []spec.Plugin{
+				{
+					Name: "apikey",
+				},
+			},
+		},
+	}
+}
+
+// Key returns an APIKey named KeyName holding KeyData
+func Key() spec.APIKey {
+	return NamedKey(KeyName, KeyData)
+}
+
+// NamedKey returns an APIKey with the given name holding the given apikey
+func NamedKey(name, data string) spec.APIKey {
+	return spec.APIKey{
+		TypeMeta: unversioned.TypeMeta{},
+		ObjectMeta: api.ObjectMeta{
+			Name:      name,
+			Namespace: Namespace,
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: data,
+		},
+	}
+}
+
+// Binding returns an APIKeyBinding for the APIProxy returned by Proxy
+// that binds the given keys. If no keys are given, KeyName is bound
+// with a global default rule.
+func Binding(keys ...spec.Key) spec.APIKeyBinding {
+	if len(keys) < 1 {
+		keys = []spec.Key{GlobalKey(KeyName)}
+	}
+	return spec.APIKeyBinding{
+		TypeMeta: unversioned.TypeMeta{},
+		ObjectMeta: api.ObjectMeta{
+			Name:      BindingName,
+			Namespace: Namespace,
+		},
+		Spec: spec.APIKeyBindingSpec{
+			APIProxyName: ProxyName,
+			Keys:         keys,
+		},
+	}
+}
+
+// GlobalKey returns a binding entry permitting every method on every path
+func GlobalKey(name string) spec.Key {
+	return spec.Key{
+		Name: name,
+		DefaultRule: spec.Rule{
+			Global: true,
+		},
+	}
+}
+
+// GranularKey returns a binding entry permitting the given methods on every path
+func GranularKey(name string, verbs ...string) spec.Key {
+	return spec.Key{
+		Name: name,
+		DefaultRule: spec.Rule{
+			Granular: &spec.GranularProxy{Verbs: verbs},
+		},
+	}
+}
+
+// Request returns a request with the given method for the given path of
+// host.com. The apikey header is only set if apiKey is not empty.
+func Request(method, path, apiKey string) *http.Request {
+	u, _ := url.Parse("http://host.com" + path)
+	r := &http.Request{
+		Method: method,
+		Header: http.Header{},
+		URL:    u,
+	}
+	if apiKey != "" {
+		r.Header.Set("Apikey", apiKey)
+	}
+	return r
+}
+
+// Span returns a span that records nothing
+func Span() opentracing.Span {
+	return opentracing.NoopTracer{}.StartSpan("test span")
+}
+
+// Context returns a context that is cancelled after the given timeout so
+// that a test waiting on it cannot hang forever
+func Context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// Stores replaces the contents of Kanali's in-memory APIKey and
+// APIKeyBinding stores with the given resources. The returned
+// function clears both stores again.
+func Stores(keys []spec.APIKey, bindings []spec.APIKeyBinding) func() {
+	spec.KeyStore.Clear()
+	spec.BindingStore.Clear()
+	for _, key := range keys {
+		spec.KeyStore.Set(key)
+	}
+	for _, binding := range bindings {
+		spec.BindingStore.Set(binding)
+	}
+	return func() {
+		spec.KeyStore.Clear()
+		spec.BindingStore.Clear()
+	}
+}
+
+// BindingStore is an in-memory binding store that, unlike Kanali's, can
+// enumerate its bindings. Its bindings are keyed by APIProxy name and
+// namespace. It is not safe for concurrent modification.
+type BindingStore struct {
+	Bindings []spec.APIKeyBinding
+	// Err, if set, is returned by every lookup
+	Err error
+}
+
+// Get returns the binding for the APIProxy with the given name and
+// namespace, or nil if there is none
+func (s *BindingStore) Get(params ...interface{}) (interface{}, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	if len(params) != 2 {
+		return nil, errors.New("two parameters are required")
+	}
+	proxyName, _ := params[0].(string)
+	namespace, _ := params[1].(string)
+	for _, binding := range s.Bindings {
+		if binding.Spec.APIProxyName == proxyName && binding.ObjectMeta.Namespace == namespace {
+			return binding, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListBindings returns every binding in the store
+func (s *BindingStore) ListBindings() []spec.APIKeyBinding {
+	return s.Bindings
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package testutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindingStore(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &BindingStore{Bindings: []spec.APIKeyBinding{Binding()}}
+	binding, err := store.Get(ProxyName, Namespace)
+	assert.Nil(err)
+	assert.Equal(Binding(), binding)
+
+	binding, err = store.Get(ProxyName, "bar")
+	assert.Nil(err)
+	assert.Nil(binding)
+
+	_, err = store.Get(ProxyName)
+	assert.NotNil(err)
+
+	store.Err = errors.New("store unavailable")
+	_, err = store.Get(ProxyName, Namespace)
+	assert.Equal("store unavailable", err.Error())
+	assert.Equal([]spec.APIKeyBinding{Binding()}, store.ListBindings())
+}
+
+func TestStores(t *testing.T) {
+	assert := assert.New(t)
+
+	clear := Stores([]spec.APIKey{Key()}, []spec.APIKeyBinding{Binding()})
+	key, _ := spec.KeyStore.Get(KeyData)
+	assert.Equal(Key(), key)
+	binding, _ := spec.BindingStore.Get(ProxyName, Namespace)
+	assert.Equal(Binding(), binding)
+
+	clear()
+	assert.True(spec.KeyStore.IsEmpty())
+	assert.True(spec.BindingStore.IsEmpty())
+}
+
+func TestContext(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := Context(time.Hour)
+	assert.Nil(ctx.Err())
+	cancel()
+	assert.NotNil(ctx.Err())
+}
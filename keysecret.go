@@ -0,0 +1,136 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeySecretSeparator,
+	)
+}
+
+var (
+	flagPluginsAPIKeySecretSeparator = config.Flag{
+		Long:  "plugins.apiKey.secret_separator",
+		Short: "",
+		Value: "",
+		Usage: "Separator between the key ID and the secret of two-part apikeys. The key ID is looked up in the stores and the secret is verified against the ApiKey's secret annotations. Two-part apikeys are disabled if empty.",
+	}
+)
+
+const (
+	// annotationKeySecretSHA256 is the APIKey annotation holding the hex
+	// encoded SHA-256 hash of the current secret of a two-part apikey
+	annotationKeySecretSHA256 = "apikey.kanali.io/secret-sha256"
+	// annotationKeyPreviousSecretSHA256 is the APIKey annotation holding
+	// the hex encoded SHA-256 hash of the secret being rotated out
+	annotationKeyPreviousSecretSHA256 = "apikey.kanali.io/previous-secret-sha256"
+	// annotationKeyPreviousSecretExpires is the APIKey annotation holding the
+	// RFC 3339 time after which the previous secret is no longer accepted
+	annotationKeyPreviousSecretExpires = "apikey.kanali.io/previous-secret-expires"
+)
+
+const (
+	secretCurrent  = "current"
+	secretPrevious = "previous"
+)
+
+var (
+	errTwoPartKeyMalformed = &utils.StatusError{http.StatusUnauthorized, errors.New("apikey is malformed")}
+	errKeySecretInvalid    = &utils.StatusError{http.StatusUnauthorized, errors.New("apikey secret is invalid")}
+)
+
+// isTwoPartMode will return true if apikeys consist of a key ID and a secret
+func isTwoPartMode() bool {
+	return viper.GetString(flagPluginsAPIKeySecretSeparator.GetLong()) != ""
+}
+
+// splitKeySecret splits a two-part apikey into its key ID and secret.
+// An error is returned if either part is missing.
+func splitKeySecret(apiKey string) (string, string, error) {
+	parts := strings.SplitN(apiKey, viper.GetString(flagPluginsAPIKeySecretSeparator.GetLong()), 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errTwoPartKeyMalformed
+	}
+	return parts[0], parts[1], nil
+}
+
+// secretMatches compares the hash of the given secret with the given hex
+// encoded hash in constant time
+func secretMatches(secret, expected string) bool {
+	expectedSum, err := hex.DecodeString(strings.TrimSpace(expected))
+	if err != nil || len(expectedSum) != sha256.Size {
+		return false
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(sum[:], expectedSum) == 1
+}
+
+// isPreviousSecretAccepted will return true if the previous secret of the
+// given APIKey has a valid expiry that has not yet passed
+func isPreviousSecretAccepted(key spec.APIKey, currTime time.Time) bool {
+	expires, err := time.Parse(time.RFC3339, strings.TrimSpace(key.ObjectMeta.Annotations[annotationKeyPreviousSecretExpires]))
+	if err != nil {
+		return false
+	}
+	return currTime.Before(expires)
+}
+
+// verifyKeySecret returns which of the secrets of the given APIKey, current
+// or previous, the given secret matches. During a rotation the previous
+// secret is accepted until it expires, and a previous secret without a
+// valid expiry is never accepted. Both secrets are always compared so that
+// the time taken does not reveal which one matched.
+func verifyKeySecret(key spec.APIKey, secret string, currTime time.Time) (string, error) {
+	current := secretMatches(secret, key.ObjectMeta.Annotations[annotationKeySecretSHA256])
+	previous := secretMatches(secret, key.ObjectMeta.Annotations[annotationKeyPreviousSecretSHA256])
+
+	fields := logrus.Fields{
+		"key":       displayKeyName(key.ObjectMeta.Name),
+		"namespace": key.ObjectMeta.Namespace,
+	}
+	switch {
+	case current:
+		logrus.WithFields(fields).Debug("apikey matched its current secret")
+		return secretCurrent, nil
+	case previous && isPreviousSecretAccepted(key, currTime):
+		logrus.WithFields(fields).Info("apikey matched its previous secret")
+		return secretPrevious, nil
+	case previous:
+		logrus.WithFields(fields).Info("apikey matched its previous secret after it expired")
+	}
+	return "", errKeySecretInvalid
+}
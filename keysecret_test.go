@@ -0,0 +1,135 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestSecretHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func getTestTwoPartAPIKey(expires time.Time) spec.APIKey {
+	key := testutil.NamedKey(testutil.KeyName, "keyid")
+	key.ObjectMeta.Annotations = map[string]string{
+		annotationKeySecretSHA256:          getTestSecretHash("newsecret"),
+		annotationKeyPreviousSecretSHA256:  getTestSecretHash("oldsecret"),
+		annotationKeyPreviousSecretExpires: expires.Format(time.RFC3339),
+	}
+	return key
+}
+
+func TestSplitKeySecret(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySecretSeparator.GetLong(), "")
+	viper.Set(flagPluginsAPIKeySecretSeparator.GetLong(), ".")
+
+	id, secret, err := splitKeySecret("keyid.sec.ret")
+	assert.Nil(err)
+	assert.Equal("keyid", id)
+	assert.Equal("sec.ret", secret)
+
+	for _, apiKey := range []string{"keyid", "keyid.", ".secret"} {
+		_, _, err = splitKeySecret(apiKey)
+		assert.Equal(errTwoPartKeyMalformed, err, apiKey)
+	}
+}
+
+func TestSecretMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(secretMatches("secret", getTestSecretHash("secret")))
+	assert.True(secretMatches("secret", " "+getTestSecretHash("secret")+" "))
+	assert.False(secretMatches("other", getTestSecretHash("secret")))
+	assert.False(secretMatches("secret", ""))
+	assert.False(secretMatches("secret", "not hex"))
+	assert.False(secretMatches("secret", "abcd"))
+}
+
+func TestVerifyKeySecret(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	key := getTestTwoPartAPIKey(now.Add(time.Hour))
+
+	matched, err := verifyKeySecret(key, "newsecret", now)
+	assert.Nil(err)
+	assert.Equal(secretCurrent, matched)
+
+	matched, err = verifyKeySecret(key, "oldsecret", now)
+	assert.Nil(err)
+	assert.Equal(secretPrevious, matched, "the previous secret should be accepted during the rotation window")
+
+	_, err = verifyKeySecret(key, "oldsecret", now.Add(time.Hour))
+	assert.Equal(errKeySecretInvalid, err, "the previous secret should be rejected once it expires")
+
+	matched, err = verifyKeySecret(key, "newsecret", now.Add(time.Hour))
+	assert.Nil(err)
+	assert.Equal(secretCurrent, matched)
+
+	_, err = verifyKeySecret(key, "wrongsecret", now)
+	assert.Equal(errKeySecretInvalid, err)
+
+	delete(key.ObjectMeta.Annotations, annotationKeyPreviousSecretExpires)
+	_, err = verifyKeySecret(key, "oldsecret", now)
+	assert.Equal(errKeySecretInvalid, err, "a previous secret without an expiry should never be accepted")
+
+	_, err = verifyKeySecret(testutil.Key(), "newsecret", now)
+	assert.Equal(errKeySecretInvalid, err, "keys without a secret should be rejected")
+}
+
+func TestOnRequestTwoPartKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySecretSeparator.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeySecretSeparator.GetLong(), ".")
+	defer testutil.Stores([]spec.APIKey{getTestTwoPartAPIKey(time.Now().Add(time.Hour))}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	m := &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, "keyid.oldsecret"), testutil.Span()))
+	assert.Contains(*m, metrics.Metric{"api_key_secret", secretPrevious, true})
+
+	m = &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, "keyid.wrongsecret"), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("apikey secret is invalid", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_secret_denied", "true", true})
+
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, "keyid"), testutil.Span())
+	assert.Equal("apikey is malformed", err.Error())
+
+	results := ValidateKeys([]string{"keyid.newsecret", "keyid.wrongsecret"}, map[string]string{"proxy_name": testutil.ProxyName, "proxy_namespace": testutil.Namespace})
+	assert.True(results[0].Allowed)
+	assert.False(results[1].Allowed)
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyKeyStoreOrder,
+		flagPluginsAPIKeyFederatedStoreURL,
+		flagPluginsAPIKeyFederatedStoreTimeout,
+		flagPluginsAPIKeyFederatedStoreCacheTTL,
+		flagPluginsAPIKeyFederatedStoreNegativeCacheTTL,
+		flagPluginsAPIKeyFederatedStoreCacheMaxEntries,
+	)
+}
+
+var (
+	flagPluginsAPIKeyKeyStoreOrder = config.Flag{
+		Long:  "plugins.apiKey.key_store_order",
+		Short: "",
+		Value: keyStoreLocal,
+		Usage: "Comma separated list of the stores consulted, in order, when looking up an apikey. Valid stores are local and federated.",
+	}
+	flagPluginsAPIKeyFederatedStoreURL = config.Flag{
+		Long:  "plugins.apiKey.federated_store_url",
+		Short: "",
+		Value: "",
+		Usage: "URL of the federated apikey store.",
+	}
+	flagPluginsAPIKeyFederatedStoreTimeout = config.Flag{
+		Long:  "plugins.apiKey.federated_store_timeout",
+		Short: "",
+		Value: "0h0m1s",
+		Usage: "Timeout of each request made to the federated apikey store.",
+	}
+	flagPluginsAPIKeyFederatedStoreCacheTTL = config.Flag{
+		Long:  "plugins.apiKey.federated_store_cache_ttl",
+		Short: "",
+		Value: "0h0m30s",
+		Usage: "Duration for which an apikey found in the federated store is cached. Disabled if 0.",
+	}
+	flagPluginsAPIKeyFederatedStoreNegativeCacheTTL = config.Flag{
+		Long:  "plugins.apiKey.federated_store_negative_cache_ttl",
+		Short: "",
+		Value: "0h0m5s",
+		Usage: "Duration for which an apikey not found in the federated store is cached. Disabled if 0.",
+	}
+	flagPluginsAPIKeyFederatedStoreCacheMaxEntries = config.Flag{
+		Long:  "plugins.apiKey.federated_store_cache_max_entries",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of federated store lookups cached at once.",
+	}
+)
+
+const (
+	keyStoreLocal     = "local"
+	keyStoreFederated = "federated"
+
+	// headerFederatedAPIKey is the header used to send
+	// an apikey to the federated store
+	headerFederatedAPIKey = "X-Apikey"
+)
+
+// keyStore is implemented by every store an apikey can be found in
+type keyStore interface {
+	Get(params ...interface{}) (interface{}, error)
+}
+
+// contextKeyStore is implemented by stores whose lookups
+// can be abandoned when the request is cancelled
+type contextKeyStore interface {
+	GetContext(ctx context.Context, apiKey string) (interface{}, error)
+}
+
+// localKeyStore and localBindingStore are the stores apikeys and bindings
+// are looked up in. They may be replaced by benchmarks that should not
+// depend on the global stores.
+var (
+	localKeyStore     keyStore      = spec.KeyStore
+	localBindingStore bindingGetter = spec.BindingStore
+)
+
+// federatedStoreSettings are the settings a federated store is created with
+type federatedStoreSettings struct {
+	url     string
+	timeout time.Duration
+}
+
+// federatedStore holds the federated store created with the current settings
+var federatedStore = struct {
+	sync.RWMutex
+	loaded   bool
+	settings federatedStoreSettings
+	instance *httpKeyStore
+}{}
+
+func getFederatedStoreSettings() federatedStoreSettings {
+	return federatedStoreSettings{
+		url:     viper.GetString(flagPluginsAPIKeyFederatedStoreURL.GetLong()),
+		timeout: viper.GetDuration(flagPluginsAPIKeyFederatedStoreTimeout.GetLong()),
+	}
+}
+
+// getFederatedStore returns the configured federated store, creating it on
+// first use. If no url has been configured, nil is returned.
+func getFederatedStore() *httpKeyStore {
+	federatedStore.RLock()
+	if federatedStore.loaded {
+		defer federatedStore.RUnlock()
+		return federatedStore.instance
+	}
+	federatedStore.RUnlock()
+
+	federatedStore.Lock()
+	defer federatedStore.Unlock()
+	if !federatedStore.loaded {
+		createFederatedStore(getFederatedStoreSettings())
+	}
+	return federatedStore.instance
+}
+
+// reloadFederatedStore replaces a created federated
+// store if its settings have changed
+func reloadFederatedStore() {
+	federatedStore.Lock()
+	defer federatedStore.Unlock()
+
+	if settings := getFederatedStoreSettings(); federatedStore.loaded && settings != federatedStore.settings {
+		createFederatedStore(settings)
+	}
+}
+
+// createFederatedStore creates a federated store with the given settings,
+// if a url is set. It must be called with the lock held.
+func createFederatedStore(settings federatedStoreSettings) {
+	federatedStore.loaded, federatedStore.settings, federatedStore.instance = true, settings, nil
+	if settings.url != "" {
+		federatedStore.instance = newHTTPKeyStore(settings.url, &http.Client{Timeout: settings.timeout})
+	}
+}
+
+// getKeyStore returns the store with the given name. Nil is returned
+// if the store is unknown or has not been configured.
+func getKeyStore(name string) keyStore {
+	switch name {
+	case keyStoreLocal:
+		return localKeyStore
+	case keyStoreFederated:
+		if store := getFederatedStore(); store != nil {
+			return store
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// findAPIKey consults each configured store, in order, until the given
+// apikey is found. The name of the store that answered is returned along
+// with the key. If no store found the key, an error is returned only if
+// one of the stores was unable to answer. Stores that support it abandon
+// their lookup when the given context is cancelled.
+func findAPIKey(ctx context.Context, apiKey string) (interface{}, string, error) {
+	var lastErr error
+
+	order := getStringSlice(flagPluginsAPIKeyKeyStoreOrder.GetLong())
+	if len(order) < 1 {
+		order = []string{keyStoreLocal}
+	}
+
+	for _, name := range order {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		store := getKeyStore(name)
+		if store == nil {
+			logrus.Warnf("apikey store %s is unknown or not configured and will be skipped", name)
+			continue
+		}
+
+		untypedKey, err := getWithRetry(ctx, func() (interface{}, error) {
+			if store, ok := store.(contextKeyStore); ok {
+				return store.GetContext(ctx, apiKey)
+			}
+			return store.Get(apiKey)
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if untypedKey != nil {
+			return untypedKey, name, nil
+		}
+	}
+
+	return nil, "", lastErr
+}
+
+// httpKeyStore looks up apikeys from a remote service. The apikey is sent
+// in the X-Apikey header and the service is expected to respond with the
+// matching ApiKey resource as JSON, or a 404 if the key does not exist.
+// Found and missing apikeys are cached, by a hash of the apikey, for the
+// configured durations so that the service is not consulted on every
+// request. Lookups that fail are never cached.
+type httpKeyStore struct {
+	url    string
+	client *http.Client
+
+	mutex   sync.Mutex
+	entries map[[sha256.Size]byte]cachedFederatedKey
+}
+
+// cachedFederatedKey is the outcome of a federated store lookup.
+// A nil key means that the apikey does not exist.
+type cachedFederatedKey struct {
+	key     interface{}
+	expires time.Time
+}
+
+func newHTTPKeyStore(url string, client *http.Client) *httpKeyStore {
+	return &httpKeyStore{
+		url:     url,
+		client:  client,
+		entries: map[[sha256.Size]byte]cachedFederatedKey{},
+	}
+}
+
+// Get retrieves the ApiKey matching the apikey given as the first parameter
+func (s *httpKeyStore) Get(params ...interface{}) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, errors.New("must pass exactly one parameter")
+	}
+	apiKey, ok := params[0].(string)
+	if !ok {
+		return nil, errors.New("parameter must be of type string")
+	}
+	return s.GetContext(context.Background(), apiKey)
+}
+
+// GetContext retrieves the ApiKey matching the given apikey, abandoning
+// the request to the service if the given context is cancelled
+func (s *httpKeyStore) GetContext(ctx context.Context, apiKey string) (interface{}, error) {
+	id := sha256.Sum256([]byte(apiKey))
+	currTime := time.Now()
+
+	s.mutex.Lock()
+	cached, ok := s.entries[id]
+	s.mutex.Unlock()
+	if ok && currTime.Before(cached.expires) {
+		return cached.key, nil
+	}
+
+	key, err := s.fetch(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := viper.GetDuration(flagPluginsAPIKeyFederatedStoreCacheTTL.GetLong())
+	if key == nil {
+		ttl = viper.GetDuration(flagPluginsAPIKeyFederatedStoreNegativeCacheTTL.GetLong())
+	}
+	if ttl > 0 {
+		s.mutex.Lock()
+		s.makeRoom(currTime)
+		s.entries[id] = cachedFederatedKey{key, currTime.Add(ttl)}
+		s.mutex.Unlock()
+	}
+	return key, nil
+}
+
+// fetch requests the ApiKey matching the given apikey from the service.
+// A response describing a different apikey is treated as a failure
+// so that a misbehaving service cannot authorize the wrong key.
+func (s *httpKeyStore) fetch(ctx context.Context, apiKey string) (interface{}, error) {
+	req, err := http.NewRequest("GET", s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(headerFederatedAPIKey, apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var key spec.APIKey
+		if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare([]byte(key.Spec.APIKeyData), []byte(apiKey)) != 1 {
+			logrus.Errorf("federated store responded with apikey %s/%s which does not match the requested apikey", key.ObjectMeta.Namespace, key.ObjectMeta.Name)
+			return nil, errors.New("federated store responded with a different apikey")
+		}
+		return key, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("federated store responded with status %d", resp.StatusCode)
+	}
+}
+
+// makeRoom ensures that there is room for another cached lookup by
+// removing expired lookups or, if none have expired, an arbitrary one.
+// It must be called with the lock held.
+func (s *httpKeyStore) makeRoom(currTime time.Time) {
+	max := viper.GetInt(flagPluginsAPIKeyFederatedStoreCacheMaxEntries.GetLong())
+	if max <= 0 || len(s.entries) < max {
+		return
+	}
+
+	for id, cached := range s.entries {
+		if !currTime.Before(cached.expires) {
+			delete(s.entries, id)
+		}
+	}
+	for id := range s.entries {
+		if len(s.entries) < max {
+			break
+		}
+		delete(s.entries, id)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetKeyStore(t *testing.T) {
+	assert := assert.New(t)
+	defer resetFederatedStore("")
+
+	assert.Equal(spec.KeyStore, getKeyStore(keyStoreLocal))
+	assert.Nil(getKeyStore("foo"))
+
+	resetFederatedStore("")
+	assert.Nil(getKeyStore(keyStoreFederated), "federated store should not be used until configured")
+
+	resetFederatedStore("http://host.com")
+	assert.NotNil(getKeyStore(keyStoreFederated))
+}
+
+func TestHTTPKeyStore(t *testing.T) {
+	assert := assert.New(t)
+
+	server := getTestFederatedStore()
+	defer server.Close()
+	store := newHTTPKeyStore(server.URL, http.DefaultClient)
+
+	key, err := store.Get("myapikey")
+	assert.Nil(err)
+	assert.Equal(getTestAPIKey(), key)
+
+	key, err = store.Get("unknown")
+	assert.Nil(err)
+	assert.Nil(key)
+
+	key, err = store.Get("error")
+	assert.Equal("federated store responded with status 500", err.Error())
+	assert.Nil(key)
+
+	key, err = store.Get("mismatch")
+	assert.Equal("federated store responded with a different apikey", err.Error(), "a key other than the one requested should be rejected")
+	assert.Nil(key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	key, err = store.GetContext(ctx, "myapikey")
+	assert.NotNil(err, "a cancelled lookup should not reach the store")
+	assert.Nil(key)
+
+	_, err = store.Get()
+	assert.NotNil(err)
+	_, err = store.Get(1)
+	assert.NotNil(err)
+}
+
+func TestFindAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "")
+	defer resetFederatedStore("")
+
+	server := getTestFederatedStore()
+	defer server.Close()
+	resetFederatedStore(server.URL)
+	spec.KeyStore.Clear()
+
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "")
+	key, store, err := findAPIKey(context.Background(), "myapikey")
+	assert.Nil(key, "only the local store should be consulted by default")
+	assert.Equal("", store)
+	assert.Nil(err)
+
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "local,federated")
+	key, store, err = findAPIKey(context.Background(), "myapikey")
+	assert.Equal(getTestAPIKey(), key, "key should have been found in the secondary store")
+	assert.Equal(keyStoreFederated, store)
+	assert.Nil(err)
+
+	local := getTestAPIKey()
+	local.ObjectMeta.Namespace = "local"
+	spec.KeyStore.Set(local)
+	defer spec.KeyStore.Clear()
+
+	key, store, err = findAPIKey(context.Background(), "myapikey")
+	assert.Equal(local, key, "primary store should be consulted first")
+	assert.Equal(keyStoreLocal, store)
+
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "federated,local")
+	key, store, err = findAPIKey(context.Background(), "myapikey")
+	assert.Equal(getTestAPIKey(), key, "store order should be configurable")
+	assert.Equal(keyStoreFederated, store)
+
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "foo,federated")
+	key, store, err = findAPIKey(context.Background(), "error")
+	assert.Nil(key)
+	assert.Equal("federated store responded with status 500", err.Error())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	key, store, err = findAPIKey(ctx, "myapikey")
+	assert.Nil(key)
+	assert.Equal(context.Canceled, err)
+}
+
+func TestHTTPKeyStoreCache(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyFederatedStoreCacheTTL.GetLong(), "0s")
+	defer viper.Set(flagPluginsAPIKeyFederatedStoreNegativeCacheTTL.GetLong(), "0s")
+	defer viper.Set(flagPluginsAPIKeyFederatedStoreCacheMaxEntries.GetLong(), 0)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		serveTestFederatedStore(w, r)
+	}))
+	defer server.Close()
+	store := newHTTPKeyStore(server.URL, http.DefaultClient)
+
+	store.Get("myapikey")
+	store.Get("myapikey")
+	assert.Equal(2, requests, "lookups should not be cached by default")
+
+	viper.Set(flagPluginsAPIKeyFederatedStoreCacheTTL.GetLong(), "1m")
+	viper.Set(flagPluginsAPIKeyFederatedStoreNegativeCacheTTL.GetLong(), "1m")
+	requests = 0
+	key, err := store.Get("myapikey")
+	assert.Equal(getTestAPIKey(), key)
+	assert.Nil(err)
+	key, err = store.Get("myapikey")
+	assert.Equal(getTestAPIKey(), key, "a cached key should be returned")
+	assert.Nil(err)
+	assert.Equal(1, requests)
+
+	store.Get("unknown")
+	key, err = store.Get("unknown")
+	assert.Nil(key, "a missing key should be cached as missing")
+	assert.Nil(err)
+	assert.Equal(2, requests)
+
+	store.Get("error")
+	_, err = store.Get("error")
+	assert.NotNil(err)
+	assert.Equal(4, requests, "failed lookups should not be cached")
+
+	viper.Set(flagPluginsAPIKeyFederatedStoreCacheMaxEntries.GetLong(), 2)
+	store.Get("another")
+	assert.Equal(2, len(store.entries), "the cache should be bounded")
+}
+
+func TestOnRequestFederatedStore(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "")
+	defer resetFederatedStore("")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	server := getTestFederatedStore()
+	defer server.Close()
+	resetFederatedStore(server.URL)
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "local,federated")
+
+	spec.KeyStore.Clear()
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	defer spec.BindingStore.Clear()
+
+	m := &metrics.Metrics{}
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestRequest(), span))
+	assert.Equal(keyStoreFederated, span.Tag("kanali.api_key_store"))
+	assert.Contains(*m, metrics.Metric{"api_key_store", keyStoreFederated, true})
+}
+
+func TestOnRequestFederatedStoreCancelled(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "")
+	defer resetFederatedStore("")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	// the client goes away while the federated store is being consulted
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	resetFederatedStore(server.URL)
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), keyStoreFederated)
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(ctx, m, getTestAPIProxy(), getTestRequest(), mocktracer.New().StartSpan("test span"))
+	assert.Equal(errRequestCancelled, err)
+	assert.Contains(*m, metrics.Metric{"api_key_cancelled", "true", false})
+	assert.NotContains(*m, metrics.Metric{"api_key_store_unavailable", "true", true}, "a cancelled lookup should not be reported as a store failure")
+	assert.NotContains(*m, metrics.Metric{"api_key_denied", "true", true})
+}
+
+func resetFederatedStore(url string) {
+	viper.Set(flagPluginsAPIKeyFederatedStoreURL.GetLong(), url)
+	federatedStore.Lock()
+	federatedStore.loaded = false
+	federatedStore.Unlock()
+}
+
+func getTestFederatedStore() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(serveTestFederatedStore))
+}
+
+func serveTestFederatedStore(w http.ResponseWriter, r *http.Request) {
+	switch r.Header.Get(headerFederatedAPIKey) {
+	case "myapikey", "mismatch":
+		json.NewEncoder(w).Encode(getTestAPIKey())
+	case "error":
+		http.Error(w, errors.New("unavailable").Error(), http.StatusInternalServerError)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyMinKeyLength,
+		flagPluginsAPIKeyMinKeyDistinctChars,
+	)
+}
+
+var (
+	flagPluginsAPIKeyMinKeyLength = config.Flag{
+		Long:  "plugins.apiKey.min_key_length",
+		Short: "",
+		Value: 0,
+		Usage: "Minimum number of characters in an apikey, or in its secret for two-part apikeys. Shorter apikeys are rejected. Disabled if 0.",
+	}
+	flagPluginsAPIKeyMinKeyDistinctChars = config.Flag{
+		Long:  "plugins.apiKey.min_key_distinct_chars",
+		Short: "",
+		Value: 0,
+		Usage: "Minimum number of distinct characters in an apikey, or in its secret for two-part apikeys. Apikeys with less diversity are rejected. Disabled if 0.",
+	}
+)
+
+var errWeakAPIKey = &utils.StatusError{http.StatusUnauthorized, errors.New("apikey does not meet the minimum strength")}
+
+// countDistinctChars returns the number of distinct characters in the given string
+func countDistinctChars(value string) int {
+	seen := map[rune]bool{}
+	for _, c := range value {
+		seen[c] = true
+	}
+	return len(seen)
+}
+
+// isWeakAPIKey will return true if the given apikey is shorter, or has
+// fewer distinct characters, than configured
+func isWeakAPIKey(apiKey string) bool {
+	if min := viper.GetInt(flagPluginsAPIKeyMinKeyLength.GetLong()); min > 0 && utf8.RuneCountInString(apiKey) < min {
+		return true
+	}
+	if min := viper.GetInt(flagPluginsAPIKeyMinKeyDistinctChars.GetLong()); min > 0 && countDistinctChars(apiKey) < min {
+		return true
+	}
+	return false
+}
+
+// validateKeyStrength returns an error if the given apikey, presented for
+// the given APIKey, is weak. Weak keys are logged so that the APIKey
+// resources holding them can be found and rotated.
+func validateKeyStrength(key spec.APIKey, apiKey string) error {
+	if !isWeakAPIKey(apiKey) {
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{
+		"key_name":       displayKeyName(key.ObjectMeta.Name),
+		"key_namespace":  key.ObjectMeta.Namespace,
+		"length":         utf8.RuneCountInString(apiKey),
+		"distinct_chars": countDistinctChars(apiKey),
+	}).Warn("apikey rejected for not meeting the minimum strength")
+	return errWeakAPIKey
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountDistinctChars(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0, countDistinctChars(""))
+	assert.Equal(1, countDistinctChars("aaaa"))
+	assert.Equal(4, countDistinctChars("abcdabcd"))
+	assert.Equal(2, countDistinctChars("ééa"))
+}
+
+func TestIsWeakAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMinKeyLength.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyMinKeyDistinctChars.GetLong(), 0)
+
+	assert.False(isWeakAPIKey("a"), "strength should not be enforced by default")
+
+	viper.Set(flagPluginsAPIKeyMinKeyLength.GetLong(), 8)
+	assert.True(isWeakAPIKey("test"))
+	assert.True(isWeakAPIKey("ééééééé"), "length should be counted in characters")
+	assert.False(isWeakAPIKey("aaaaaaaa"))
+
+	viper.Set(flagPluginsAPIKeyMinKeyDistinctChars.GetLong(), 6)
+	assert.True(isWeakAPIKey("aaaaaaaa"))
+	assert.True(isWeakAPIKey("abcabcabc"))
+	assert.False(isWeakAPIKey("k3Y9-xQ2mZ"))
+}
+
+func TestOnRequestWeakAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyMinKeyLength.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyMinKeyDistinctChars.GetLong(), 0)
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	cleanup := testutil.Stores([]spec.APIKey{testutil.Key(), testutil.NamedKey("strongkey", "k3Y9-xQ2mZ7pL0wT")}, []spec.APIKeyBinding{testutil.Binding(testutil.GlobalKey(testutil.KeyName), testutil.GlobalKey("strongkey"))})
+	defer cleanup()
+	viper.Set(flagPluginsAPIKeyMinKeyLength.GetLong(), 12)
+	viper.Set(flagPluginsAPIKeyMinKeyDistinctChars.GetLong(), 10)
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_weak", "true", true})
+	warned := false
+	for _, entry := range hook.Entries {
+		if entry.Message == "apikey rejected for not meeting the minimum strength" {
+			warned = true
+			assert.Equal(testutil.KeyName, entry.Data["key_name"])
+		}
+	}
+	assert.True(warned, "weak keys should be logged")
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, "k3Y9-xQ2mZ7pL0wT"), testutil.Span()))
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/metrics"
+)
+
+// setRequestTime stores the time at which the plugin began
+// processing the given request in the request's context
+func setRequestTime(r *http.Request, currTime time.Time) {
+	*r = *r.WithContext(context.WithValue(r.Context(), ContextKeyRequestTime, currTime))
+}
+
+// getRequestTime retrieves the time at which the plugin began processing
+// the given request. False is returned if the time is not present.
+func getRequestTime(r *http.Request) (time.Time, bool) {
+	t, ok := r.Context().Value(ContextKeyRequestTime).(time.Time)
+	return t, ok
+}
+
+// recordTimeToFirstByte records the time elapsed between the plugin
+// receiving a request and the upstream service's response becoming
+// available, in milliseconds, labeled by the binding that was consulted
+// for the request
+func recordTimeToFirstByte(m *metrics.Metrics, r *http.Request, currTime time.Time) {
+	start, ok := getRequestTime(r)
+	if !ok {
+		logrus.Debug("request time not found in context - time to first byte will not be recorded")
+		return
+	}
+
+	binding := getBindingID(r)
+	if binding == "" {
+		binding = "unknown"
+	}
+	m.Add(metrics.Metric{"api_key_binding", binding, true})
+	m.Add(metrics.Metric{"api_key_ttfb_ms", strconv.FormatInt(int64(currTime.Sub(start)/time.Millisecond), 10), false})
+}
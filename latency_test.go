@@ -0,0 +1,89 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRequestTime(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &http.Request{}
+	_, ok := getRequestTime(r)
+	assert.False(ok)
+
+	now := time.Now()
+	setRequestTime(r, now)
+	actual, ok := getRequestTime(r)
+	assert.True(ok)
+	assert.Equal(now, actual)
+}
+
+func TestRecordTimeToFirstByte(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	r := &http.Request{}
+	setRequestTime(r, now)
+
+	m := &metrics.Metrics{}
+	recordTimeToFirstByte(m, r, now)
+	assert.Contains(*m, metrics.Metric{"api_key_binding", "unknown", true}, "requests without a binding should be labeled unknown")
+
+	setBinding(r, getTestAPIKeyBinding())
+	m = &metrics.Metrics{}
+	recordTimeToFirstByte(m, r, now.Add(150*time.Millisecond))
+	assert.Equal(metrics.Metrics{
+		{"api_key_binding", "foo/apikeybindingone", true},
+		{"api_key_ttfb_ms", "150", false},
+	}, *m)
+
+	m = &metrics.Metrics{}
+	recordTimeToFirstByte(m, &http.Request{}, now)
+	assert.Equal(0, len(*m), "nothing should be recorded without a request time")
+}
+
+func TestOnResponseTimeToFirstByte(t *testing.T) {
+	assert := assert.New(t)
+
+	r := getTestRequest()
+	r.Header.Del("apikey")
+	Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+
+	m := &metrics.Metrics{}
+	assert.Nil(Plugin.OnResponse(context.Background(), m, getTestAPIProxy(), r, &http.Response{}, opentracing.StartSpan("test span")))
+
+	found := false
+	for _, metric := range *m {
+		if metric.Name == "api_key_ttfb_ms" {
+			found = true
+		}
+	}
+	assert.True(found, "time to first byte should have been recorded")
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+// allMethods is the method of a Permission granted by a global rule
+const allMethods = "*"
+
+// knownMethods are the HTTP methods a granular rule is expected to list
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "CONNECT": true, "OPTIONS": true, "TRACE": true,
+}
+
+// Permission is an HTTP method and path that an apikey is permitted to use
+type Permission struct {
+	// Key is the name of the APIKey resource
+	Key string
+	// Method is the permitted HTTP method, or * if every method is permitted
+	Method string
+	// Path is the permitted path, relative to the APIProxy, and every path below it
+	Path string
+}
+
+// RuleIssue describes a rule that is ambiguous or contradictory
+type RuleIssue struct {
+	// Key is the name of the APIKey resource the rule belongs to
+	Key string
+	// Path is the path of the rule, or / for the default rule
+	Path string
+	// Message describes the issue
+	Message string
+}
+
+// RuleSet is the effective set of permissions granted by a binding along
+// with any issues found in its rules
+type RuleSet struct {
+	Permissions []Permission
+	Issues      []RuleIssue
+}
+
+// LintBinding returns the permissions granted by the given binding and flags
+// rules that are ambiguous or contradictory, so that bindings can be
+// reviewed before they are applied. Keys without a default rule of their own
+// are given the binding's default rule, if any. Rules are interpreted with
+// the current rule_precedence, empty_verbs_means_all, normalize_trailing_slash,
+// and default_rule configuration.
+func LintBinding(binding spec.APIKeyBinding) RuleSet {
+	set := RuleSet{}
+	seen := map[string]bool{}
+	defaultRule, hasDefaultRule := getDefaultRule(binding)
+
+	for _, keyObj := range binding.Spec.Keys {
+		if seen[keyObj.Name] {
+			set.Issues = append(set.Issues, RuleIssue{keyObj.Name, "/", "key is bound more than once - only the first entry will be used"})
+			continue
+		}
+		seen[keyObj.Name] = true
+		if hasDefaultRule && !keyObj.DefaultRule.Global && keyObj.DefaultRule.Granular == nil {
+			keyObj.DefaultRule = defaultRule
+		}
+		lintKey(&set, keyObj)
+	}
+
+	sort.Slice(set.Permissions, func(i, j int) bool {
+		a, b := set.Permissions[i], set.Permissions[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	return set
+}
+
+// LintStoredBinding runs LintBinding against the binding stored for the
+// APIProxy with the given name and namespace
+func LintStoredBinding(proxyName, namespace string) (RuleSet, error) {
+	untypedBinding, err := localBindingStore.Get(proxyName, namespace)
+	if err != nil {
+		return RuleSet{}, err
+	}
+	binding, ok := untypedBinding.(spec.APIKeyBinding)
+	if !ok {
+		return RuleSet{}, errors.New("no binding found for associated APIProxy")
+	}
+	return LintBinding(binding), nil
+}
+
+// lintKey adds the permissions and issues of a single key to the given set
+func lintKey(set *RuleSet, keyObj spec.Key) {
+	lintRule(set, keyObj.Name, "/", keyObj.DefaultRule, true)
+
+	firstMatch := strings.ToLower(viper.GetString(flagPluginsAPIKeyRulePrecedence.GetLong())) == rulePrecedenceFirstMatch
+	paths := map[string]spec.Rule{}
+	var previous []string
+
+	for _, subpath := range keyObj.Subpaths {
+		if subpath == nil {
+			continue
+		}
+		path := normalizeRulePath(subpath.Path)
+
+		if rule, ok := paths[path]; ok {
+			message := "duplicate rule for this path"
+			if !rulesEqual(rule, subpath.Rule) {
+				message = "contradictory rules for this path - the rule used depends on rule precedence"
+			}
+			set.Issues = append(set.Issues, RuleIssue{keyObj.Name, path, message})
+			continue
+		}
+
+		if firstMatch {
+			shadowed := false
+			for _, p := range previous {
+				if pathMatches(p, path) {
+					set.Issues = append(set.Issues, RuleIssue{keyObj.Name, path, fmt.Sprintf("rule is shadowed by the rule for %s and will never be used", p)})
+					shadowed = true
+					break
+				}
+			}
+			if shadowed {
+				continue
+			}
+		}
+
+		paths[path] = subpath.Rule
+		previous = append(previous, path)
+		lintRule(set, keyObj.Name, path, subpath.Rule, false)
+	}
+}
+
+// lintRule adds the permissions and issues of a single rule to the given set.
+// A default rule that grants nothing is not an issue, as it is how a key is
+// restricted to its subpaths.
+func lintRule(set *RuleSet, key, path string, rule spec.Rule, isDefault bool) {
+	if rule.Global {
+		if rule.Granular != nil {
+			set.Issues = append(set.Issues, RuleIssue{key, path, "rule is both global and granular - the granular verbs are ignored"})
+		}
+		set.Permissions = append(set.Permissions, Permission{key, allMethods, path})
+		return
+	}
+
+	if rule.Granular == nil {
+		if !isDefault {
+			set.Issues = append(set.Issues, RuleIssue{key, path, "rule is neither global nor granular and denies every method"})
+		}
+		return
+	}
+
+	if len(rule.Granular.Verbs) < 1 {
+		if viper.GetBool(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong()) {
+			set.Permissions = append(set.Permissions, Permission{key, allMethods, path})
+		} else {
+			set.Issues = append(set.Issues, RuleIssue{key, path, "granular rule lists no verbs and denies every method"})
+		}
+		return
+	}
+
+	methods := map[string]bool{}
+	for _, verb := range rule.Granular.Verbs {
+		method := strings.ToUpper(verb)
+		if !knownMethods[method] {
+			set.Issues = append(set.Issues, RuleIssue{key, path, fmt.Sprintf("unknown HTTP method %s", verb)})
+		}
+		if methods[method] {
+			continue
+		}
+		methods[method] = true
+		set.Permissions = append(set.Permissions, Permission{key, method, path})
+	}
+}
+
+// rulesEqual returns true if the given rules permit the same methods
+func rulesEqual(a, b spec.Rule) bool {
+	if a.Global || b.Global {
+		return a.Global == b.Global
+	}
+	if (a.Granular == nil) != (b.Granular == nil) {
+		return false
+	}
+	if a.Granular == nil {
+		return true
+	}
+
+	verbs := func(r spec.Rule) map[string]bool {
+		set := map[string]bool{}
+		for _, verb := range r.Granular.Verbs {
+			set[strings.ToUpper(verb)] = true
+		}
+		return set
+	}
+	va, vb := verbs(a), verbs(b)
+	if len(va) != len(vb) {
+		return false
+	}
+	for verb := range va {
+		if !vb[verb] {
+			return false
+		}
+	}
+	return true
+}
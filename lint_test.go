@@ -0,0 +1,157 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestLintBinding() spec.APIKeyBinding {
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys = []spec.Key{
+		{
+			Name: "apikeyone",
+			Subpaths: []*spec.Path{
+				{Path: "/accounts", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET", "get", "POST"}}}},
+				{Path: "/accounts/", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"DELETE"}}}},
+				{Path: "/accounts/details", Rule: spec.Rule{Global: true}},
+				nil,
+				{Path: "/reports", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"post", "get"}}}},
+				{Path: "reports", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET", "POST"}}}},
+				{Path: "/admin", Rule: spec.Rule{}},
+			},
+		},
+		{
+			Name: "apikeytwo",
+			DefaultRule: spec.Rule{
+				Global:   true,
+				Granular: &spec.GranularProxy{Verbs: []string{"GET"}},
+			},
+		},
+		{
+			Name:        "apikeytwo",
+			DefaultRule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}},
+		},
+		{
+			Name:        "apikeythree",
+			DefaultRule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"FETCH"}}},
+		},
+	}
+	return binding
+}
+
+func TestLintBinding(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+	viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), true)
+
+	set := LintBinding(getTestLintBinding())
+	assert.Equal([]Permission{
+		{"apikeyone", "GET", "/accounts"},
+		{"apikeyone", "POST", "/accounts"},
+		{"apikeyone", "*", "/accounts/details"},
+		{"apikeyone", "GET", "/reports"},
+		{"apikeyone", "POST", "/reports"},
+		{"apikeythree", "FETCH", "/"},
+		{"apikeytwo", "*", "/"},
+	}, set.Permissions)
+	assert.Equal([]RuleIssue{
+		{"apikeyone", "/accounts", "contradictory rules for this path - the rule used depends on rule precedence"},
+		{"apikeyone", "/reports", "duplicate rule for this path"},
+		{"apikeyone", "/admin", "rule is neither global nor granular and denies every method"},
+		{"apikeytwo", "/", "rule is both global and granular - the granular verbs are ignored"},
+		{"apikeytwo", "/", "key is bound more than once - only the first entry will be used"},
+		{"apikeythree", "/", "unknown HTTP method FETCH"},
+	}, set.Issues)
+}
+
+func TestLintBindingFirstMatch(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRulePrecedence.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+	viper.Set(flagPluginsAPIKeyRulePrecedence.GetLong(), rulePrecedenceFirstMatch)
+	viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), true)
+
+	set := LintBinding(getTestLintBinding())
+	assert.Contains(set.Issues, RuleIssue{"apikeyone", "/accounts/details", "rule is shadowed by the rule for /accounts and will never be used"})
+	assert.NotContains(set.Permissions, Permission{"apikeyone", "*", "/accounts/details"})
+}
+
+func TestLintBindingTrailingSlash(t *testing.T) {
+	assert := assert.New(t)
+
+	set := LintBinding(getTestLintBinding())
+	assert.Contains(set.Permissions, Permission{"apikeyone", "DELETE", "/accounts/"}, "trailing slashes should be significant when not normalized")
+	assert.NotContains(set.Issues, RuleIssue{"apikeyone", "/accounts", "contradictory rules for this path - the rule used depends on rule precedence"})
+}
+
+func TestLintBindingEmptyVerbs(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong(), false)
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys = []spec.Key{{Name: "apikeyone", DefaultRule: spec.Rule{Granular: &spec.GranularProxy{}}}}
+
+	set := LintBinding(binding)
+	assert.Equal(0, len(set.Permissions))
+	assert.Equal([]RuleIssue{{"apikeyone", "/", "granular rule lists no verbs and denies every method"}}, set.Issues)
+
+	viper.Set(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong(), true)
+	set = LintBinding(binding)
+	assert.Equal([]Permission{{"apikeyone", "*", "/"}}, set.Permissions)
+	assert.Equal(0, len(set.Issues))
+}
+
+func TestLintBindingDefaultRule(t *testing.T) {
+	assert := assert.New(t)
+
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingDefaultRule: "GET"}
+	binding.Spec.Keys = []spec.Key{
+		{Name: "apikeyone"},
+		{Name: "apikeytwo", DefaultRule: spec.Rule{Global: true}},
+	}
+
+	set := LintBinding(binding)
+	assert.Equal([]Permission{
+		{"apikeyone", "GET", "/"},
+		{"apikeytwo", "*", "/"},
+	}, set.Permissions, "the binding default rule should only apply to keys without one")
+}
+
+func TestLintStoredBinding(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.BindingStore.Clear()
+	spec.BindingStore.Clear()
+
+	_, err := LintStoredBinding("APIProxyone", "foo")
+	assert.Equal("no binding found for associated APIProxy", err.Error())
+
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	set, err := LintStoredBinding("APIProxyone", "foo")
+	assert.Nil(err)
+	assert.Equal([]Permission{{"apikeyone", "*", "/"}}, set.Permissions)
+	assert.Equal(0, len(set.Issues))
+}
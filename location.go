@@ -0,0 +1,188 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyQueryKey,
+		flagPluginsAPIKeyDeprecatedKeyLocations,
+	)
+}
+
+var (
+	flagPluginsAPIKeyQueryKey = config.Flag{
+		Long:  "plugins.apiKey.query_key",
+		Short: "",
+		Value: "",
+		Usage: "Name of the query parameter holding the apikey when it is not found in the apikey header. Query parameters are not consulted if empty.",
+	}
+	flagPluginsAPIKeyDeprecatedKeyLocations = config.Flag{
+		Long:  "plugins.apiKey.deprecated_key_locations",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of deprecated apikey locations. Valid locations are header, query, and form. Requests using them are warned, but not rejected.",
+	}
+)
+
+const (
+	keyLocationHeader = "header"
+	keyLocationQuery  = "query"
+	keyLocationForm   = "form"
+)
+
+// getAPIKey returns the apikey held by the given request along with the
+// location it was found in. The apikey header takes priority over the
+// query parameter, which takes priority over the form body. Empty strings
+// are returned if no apikey is found.
+func getAPIKey(r *http.Request) (string, string) {
+	if apiKey := extractAPIKey(r.Header.Get(viper.GetString(flagPluginsAPIKeyHeaderKey.GetLong()))); apiKey != "" {
+		return apiKey, keyLocationHeader
+	}
+
+	if param := viper.GetString(flagPluginsAPIKeyQueryKey.GetLong()); param != "" && r.URL != nil {
+		if apiKey := r.URL.Query().Get(param); apiKey != "" {
+			return apiKey, keyLocationQuery
+		}
+	}
+
+	if apiKey := getFormAPIKey(r); apiKey != "" {
+		return apiKey, keyLocationForm
+	}
+
+	return "", ""
+}
+
+// setAPIKeyLocation stores the location of the apikey
+// of the given request in the request's context
+func setAPIKeyLocation(r *http.Request, location string) {
+	*r = *r.WithContext(context.WithValue(r.Context(), ContextKeyAPIKeyLocation, location))
+}
+
+// getAPIKeyLocation retrieves the location of the apikey of the given
+// request. An empty string is returned if no apikey has been found.
+func getAPIKeyLocation(r *http.Request) string {
+	location, _ := r.Context().Value(ContextKeyAPIKeyLocation).(string)
+	return location
+}
+
+// isDeprecatedKeyLocation will return true if
+// the given apikey location is deprecated
+func isDeprecatedKeyLocation(location string) bool {
+	if location == "" {
+		return false
+	}
+	for _, deprecated := range getStringSlice(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong()) {
+		if strings.EqualFold(deprecated, location) {
+			return true
+		}
+	}
+	return false
+}
+
+// getDeprecationWarning returns the value of the Warning header sent to
+// clients whose apikey was found in the given deprecated location
+func getDeprecationWarning(location string) string {
+	return fmt.Sprintf(`299 - "apikey in %s is deprecated"`, location)
+}
+
+// setDeprecationWarning will, if the apikey of the given request was found
+// in a deprecated location, add a Warning header to the given response
+func setDeprecationWarning(r *http.Request, resp *http.Response) {
+	location := getAPIKeyLocation(r)
+	if resp == nil || !isDeprecatedKeyLocation(location) {
+		return
+	}
+
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	resp.Header.Add("Warning", getDeprecationWarning(location))
+}
+
+// redactedQueryValue replaces apikeys in query strings that are logged
+const redactedQueryValue = "REDACTED"
+
+// redactQueryKey returns the given raw query with the value of every
+// occurrence of the configured apikey query parameter replaced, so that
+// apikeys are never logged. Other parameters are left as they are.
+func redactQueryKey(rawQuery string) string {
+	param := viper.GetString(flagPluginsAPIKeyQueryKey.GetLong())
+	if param == "" || rawQuery == "" {
+		return rawQuery
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		name := pair
+		if j := strings.IndexAny(pair, "=;"); j >= 0 {
+			name = pair[:j]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if name == param {
+			pairs[i] = url.QueryEscape(param) + "=" + redactedQueryValue
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// getRedactedRequestURI returns the request URI of the given request
+// with any apikey in its query string redacted
+func getRedactedRequestURI(r *http.Request) string {
+	if r.URL == nil {
+		return ""
+	}
+	u := *r.URL
+	u.RawQuery = redactQueryKey(u.RawQuery)
+	return u.RequestURI()
+}
+
+// getRedactedReferer returns the Referer of the given request safe to be
+// logged. When apikeys may be sent as query parameters, the query of the
+// referring page, which may hold its apikey, is redacted entirely. Referers
+// that cannot be parsed are omitted.
+func getRedactedReferer(r *http.Request) string {
+	referer := r.Referer()
+	if referer == "" || viper.GetString(flagPluginsAPIKeyQueryKey.GetLong()) == "" {
+		return referer
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	if u.RawQuery != "" || u.ForceQuery {
+		u.RawQuery = redactedQueryValue
+	}
+	u.Fragment = ""
+	return u.String()
+}
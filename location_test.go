@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	r := getTestRequest()
+	r.URL.RawQuery = "apikey=fromquery"
+	apiKey, location := getAPIKey(r)
+	assert.Equal("myapikey", apiKey)
+	assert.Equal(keyLocationHeader, location)
+
+	r.Header.Del("apikey")
+	apiKey, location = getAPIKey(r)
+	assert.Equal("", apiKey, "query parameters should not be consulted unless configured")
+	assert.Equal("", location)
+
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "apikey")
+	apiKey, location = getAPIKey(r)
+	assert.Equal("fromquery", apiKey)
+	assert.Equal(keyLocationQuery, location)
+
+	r.Header.Set("apikey", "myapikey")
+	apiKey, location = getAPIKey(r)
+	assert.Equal("myapikey", apiKey, "the header should take priority")
+	assert.Equal(keyLocationHeader, location)
+}
+
+func TestIsDeprecatedKeyLocation(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong(), "")
+	assert.False(isDeprecatedKeyLocation(keyLocationQuery))
+
+	viper.Set(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong(), "Query")
+	assert.True(isDeprecatedKeyLocation(keyLocationQuery))
+	assert.False(isDeprecatedKeyLocation(keyLocationHeader))
+	assert.False(isDeprecatedKeyLocation(""))
+}
+
+func TestDeprecationWarning(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong(), "query")
+
+	r := getTestRequest()
+	resp := &http.Response{}
+	setDeprecationWarning(r, resp)
+	assert.Nil(resp.Header)
+
+	setAPIKeyLocation(r, keyLocationHeader)
+	setDeprecationWarning(r, resp)
+	assert.Nil(resp.Header)
+
+	setAPIKeyLocation(r, keyLocationQuery)
+	setDeprecationWarning(r, resp)
+	assert.Equal(`299 - "apikey in query is deprecated"`, resp.Header.Get("Warning"))
+}
+
+func TestOnRequestDeprecatedKeyLocation(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyDeprecatedKeyLocations.GetLong(), "query")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	r.Header.Del("apikey")
+	r.URL.RawQuery = "apikey=myapikey"
+	m := &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span")), "deprecated locations should not be rejected")
+	assert.Contains(*m, metrics.Metric{"api_key_deprecated_location", "query", true})
+	resp := &http.Response{}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, resp, opentracing.StartSpan("test span")))
+	assert.Equal(`299 - "apikey in query is deprecated"`, resp.Header.Get("Warning"))
+
+	r = getTestRequest()
+	m = &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.NotContains(*m, metrics.Metric{"api_key_deprecated_location", "query", true})
+	resp = &http.Response{}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, resp, opentracing.StartSpan("test span")))
+	assert.Equal("", resp.Header.Get("Warning"))
+}
+
+func TestRedactQueryKey(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+
+	assert.Equal("api_key=secret", redactQueryKey("api_key=secret"), "queries should be unchanged when query keys are disabled")
+
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "api_key")
+	assert.Equal("", redactQueryKey(""))
+	assert.Equal("limit=10", redactQueryKey("limit=10"))
+	assert.Equal("api_key=REDACTED", redactQueryKey("api_key=secret"))
+	assert.Equal("a=1&api_key=REDACTED&api_key=REDACTED", redactQueryKey("a=1&api_key=one&api_key=two"))
+	assert.Equal("api_key=REDACTED", redactQueryKey("api%5Fkey=secret"), "escaped names should be redacted")
+	assert.Equal("api_key=REDACTED", redactQueryKey("api_key"))
+	assert.Equal("api_keys=secret", redactQueryKey("api_keys=secret"))
+}
+
+func TestGetRedactedReferer(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "")
+	r := getTestRequest()
+
+	r.Header.Set("Referer", "https://example.com/page?api_key=secret")
+	assert.Equal("https://example.com/page?api_key=secret", getRedactedReferer(r), "referers should be unchanged when query keys are disabled")
+
+	viper.Set(flagPluginsAPIKeyQueryKey.GetLong(), "api_key")
+	assert.Equal("https://example.com/page?REDACTED", getRedactedReferer(r))
+	r.Header.Set("Referer", "https://example.com/page")
+	assert.Equal("https://example.com/page", getRedactedReferer(r))
+	r.Header.Set("Referer", "%zz?api_key=secret")
+	assert.Equal("", getRedactedReferer(r), "unparseable referers should be omitted")
+}
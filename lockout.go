@@ -0,0 +1,204 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyLockoutThreshold,
+		flagPluginsAPIKeyLockoutDuration,
+		flagPluginsAPIKeyLockoutBy,
+		flagPluginsAPIKeyLockoutMaxEntries,
+	)
+}
+
+var (
+	flagPluginsAPIKeyLockoutThreshold = config.Flag{
+		Long:  "plugins.apiKey.lockout_threshold",
+		Short: "",
+		Value: 0,
+		Usage: "Number of consecutive authentication failures after which a source is locked out. Disabled if 0.",
+	}
+	flagPluginsAPIKeyLockoutDuration = config.Flag{
+		Long:  "plugins.apiKey.lockout_duration",
+		Short: "",
+		Value: "5m0s",
+		Usage: "Duration a source is locked out for. Failures older than this are forgotten.",
+	}
+	flagPluginsAPIKeyLockoutBy = config.Flag{
+		Long:  "plugins.apiKey.lockout_by",
+		Short: "",
+		Value: "ip",
+		Usage: "Source that failures are tracked by. Either ip or key.",
+	}
+	flagPluginsAPIKeyLockoutMaxEntries = config.Flag{
+		Long:  "plugins.apiKey.lockout_max_entries",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of sources tracked at once. The least recently failed source is evicted when full.",
+	}
+)
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var lockouts = struct {
+	sync.Mutex
+	entries map[string]*lockoutEntry
+}{entries: map[string]*lockoutEntry{}}
+
+// getLockoutSource returns the identifier that failures of the given request
+// are tracked by. Apikeys are hashed so that they are not kept in memory.
+func getLockoutSource(r *http.Request) string {
+	if viper.GetString(flagPluginsAPIKeyLockoutBy.GetLong()) == "key" {
+		apiKey, _ := getAPIKey(r)
+		if apiKey == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	return "ip:" + getClientIP(r)
+}
+
+func isLockoutEnabled() bool {
+	return viper.GetInt(flagPluginsAPIKeyLockoutThreshold.GetLong()) > 0 && viper.GetDuration(flagPluginsAPIKeyLockoutDuration.GetLong()) > 0
+}
+
+// checkLockout returns a 429 error if the source of the given request is
+// currently locked out.
+func checkLockout(m *metrics.Metrics, r *http.Request, currTime time.Time) error {
+	if !isLockoutEnabled() {
+		return nil
+	}
+	source := getLockoutSource(r)
+	if source == "" {
+		return nil
+	}
+
+	lockouts.Lock()
+	entry, ok := lockouts.entries[source]
+	var lockedUntil time.Time
+	if ok {
+		lockedUntil = entry.lockedUntil
+	}
+	lockouts.Unlock()
+
+	if !currTime.Before(lockedUntil) {
+		return nil
+	}
+
+	m.Add(metrics.Metric{"api_key_locked_out", "true", true})
+	err := &utils.StatusError{http.StatusTooManyRequests, errors.New("too many failed attempts. please retry later")}
+	retryAfter := int(math.Ceil(lockedUntil.Sub(currTime).Seconds()))
+	return withRetryAfter(err, retryAfter)
+}
+
+// recordLockoutResult records the outcome of validating the given request.
+// Authentication failures count towards a lockout while a request made with
+// a valid apikey resets the count for its source.
+func recordLockoutResult(r *http.Request, err error, currTime time.Time) {
+	if !isLockoutEnabled() {
+		return
+	}
+	source := getLockoutSource(r)
+	if source == "" {
+		return
+	}
+
+	lockouts.Lock()
+	defer lockouts.Unlock()
+
+	if err == nil {
+		if getAPIKeyName(r) != "" {
+			delete(lockouts.entries, source)
+		}
+		return
+	}
+	if getStatusCode(err) != http.StatusUnauthorized {
+		return
+	}
+
+	threshold := viper.GetInt(flagPluginsAPIKeyLockoutThreshold.GetLong())
+	duration := viper.GetDuration(flagPluginsAPIKeyLockoutDuration.GetLong())
+
+	entry, ok := lockouts.entries[source]
+	if !ok || !currTime.Before(entry.lastFailure.Add(duration)) {
+		if !ok {
+			makeLockoutRoom(currTime, duration)
+		}
+		entry = &lockoutEntry{}
+		lockouts.entries[source] = entry
+	}
+	entry.failures++
+	entry.lastFailure = currTime
+
+	if entry.failures >= threshold {
+		entry.failures = 0
+		entry.lockedUntil = currTime.Add(duration)
+		logrus.WithFields(logrus.Fields{
+			"source":   source,
+			"duration": duration.String(),
+		}).Warn("too many consecutive apikey failures - locking out source")
+	}
+}
+
+// makeLockoutRoom ensures that there is room for another entry by removing
+// expired entries or, if none have expired, the least recently failed one.
+// It must be called with the lock held.
+func makeLockoutRoom(currTime time.Time, duration time.Duration) {
+	max := viper.GetInt(flagPluginsAPIKeyLockoutMaxEntries.GetLong())
+	if max <= 0 || len(lockouts.entries) < max {
+		return
+	}
+
+	var oldest string
+	for source, entry := range lockouts.entries {
+		if !currTime.Before(entry.lastFailure.Add(duration)) && !currTime.Before(entry.lockedUntil) {
+			delete(lockouts.entries, source)
+			continue
+		}
+		if oldest == "" || entry.lastFailure.Before(lockouts.entries[oldest].lastFailure) {
+			oldest = source
+		}
+	}
+	if len(lockouts.entries) >= max && oldest != "" {
+		delete(lockouts.entries, oldest)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetLockouts() {
+	lockouts.Lock()
+	lockouts.entries = map[string]*lockoutEntry{}
+	lockouts.Unlock()
+}
+
+func TestLockout(t *testing.T) {
+	assert := assert.New(t)
+	defer resetLockouts()
+	defer viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "")
+	resetLockouts()
+
+	failure := &utils.StatusError{http.StatusUnauthorized, nil}
+	now := time.Now()
+	r := getTestRequest()
+	r.RemoteAddr = "10.0.0.1:1234"
+
+	recordLockoutResult(r, failure, now)
+	assert.Nil(checkLockout(&metrics.Metrics{}, r, now))
+	assert.Equal(0, len(lockouts.entries))
+
+	viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 3)
+	viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "1m0s")
+
+	recordLockoutResult(r, failure, now)
+	recordLockoutResult(r, failure, now)
+	recordLockoutResult(r, &utils.StatusError{http.StatusForbidden, nil}, now)
+	assert.Nil(checkLockout(&metrics.Metrics{}, r, now))
+
+	m := &metrics.Metrics{}
+	recordLockoutResult(r, failure, now)
+	err := checkLockout(m, r, now.Add(time.Second))
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Equal("too many failed attempts. please retry later - retry after 59 seconds", err.Error())
+	assert.Equal(metrics.Metrics{{"api_key_locked_out", "true", true}}, *m)
+
+	other := getTestRequest()
+	other.RemoteAddr = "10.0.0.2:1234"
+	assert.Nil(checkLockout(&metrics.Metrics{}, other, now))
+
+	assert.Nil(checkLockout(&metrics.Metrics{}, r, now.Add(time.Minute)))
+
+	// failures are forgotten once they are older than the lockout duration
+	recordLockoutResult(r, failure, now.Add(time.Minute))
+	recordLockoutResult(r, failure, now.Add(time.Minute))
+	recordLockoutResult(r, failure, now.Add(3*time.Minute))
+	assert.Nil(checkLockout(&metrics.Metrics{}, r, now.Add(3*time.Minute)))
+
+	// a successful request resets the failure count
+	recordLockoutResult(r, failure, now.Add(3*time.Minute))
+	success := getTestRequest()
+	success.RemoteAddr = r.RemoteAddr
+	setAPIKey(success, getTestAPIKey())
+	recordLockoutResult(success, nil, now.Add(3*time.Minute))
+	recordLockoutResult(r, failure, now.Add(3*time.Minute))
+	recordLockoutResult(r, failure, now.Add(3*time.Minute))
+	assert.Nil(checkLockout(&metrics.Metrics{}, r, now.Add(3*time.Minute)))
+}
+
+func TestLockoutByKey(t *testing.T) {
+	assert := assert.New(t)
+	defer resetLockouts()
+	defer viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyLockoutBy.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 1)
+	viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "1m0s")
+	viper.Set(flagPluginsAPIKeyLockoutBy.GetLong(), "key")
+	resetLockouts()
+
+	now := time.Now()
+	r := getTestRequest()
+	r.Header.Set("apikey", "guess")
+	assert.NotContains(getLockoutSource(r), "guess")
+
+	recordLockoutResult(r, &utils.StatusError{http.StatusUnauthorized, nil}, now)
+	assert.NotNil(checkLockout(&metrics.Metrics{}, r, now))
+
+	r.Header.Set("apikey", "another")
+	assert.Nil(checkLockout(&metrics.Metrics{}, r, now))
+
+	r.Header.Del("apikey")
+	assert.Equal("", getLockoutSource(r))
+	recordLockoutResult(r, &utils.StatusError{http.StatusUnauthorized, nil}, now)
+	assert.Nil(checkLockout(&metrics.Metrics{}, r, now))
+}
+
+func TestLockoutMaxEntries(t *testing.T) {
+	assert := assert.New(t)
+	defer resetLockouts()
+	defer viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyLockoutMaxEntries.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 5)
+	viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "1m0s")
+	viper.Set(flagPluginsAPIKeyLockoutMaxEntries.GetLong(), 2)
+	resetLockouts()
+
+	now := time.Now()
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		r := getTestRequest()
+		r.RemoteAddr = ip + ":1234"
+		recordLockoutResult(r, &utils.StatusError{http.StatusUnauthorized, nil}, now.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(2, len(lockouts.entries))
+	assert.Nil(lockouts.entries["ip:10.0.0.1"])
+	assert.NotNil(lockouts.entries["ip:10.0.0.3"])
+
+	r := getTestRequest()
+	r.RemoteAddr = "10.0.0.4:1234"
+	recordLockoutResult(r, &utils.StatusError{http.StatusUnauthorized, nil}, now.Add(2*time.Minute))
+	assert.Equal(1, len(lockouts.entries))
+}
+
+func TestOnRequestLockout(t *testing.T) {
+	assert := assert.New(t)
+	defer resetLockouts()
+	defer viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyLockoutThreshold.GetLong(), 2)
+	viper.Set(flagPluginsAPIKeyLockoutDuration.GetLong(), "1m0s")
+	resetLockouts()
+
+	for i := 0; i < 2; i++ {
+		r := getTestRequest()
+		r.Header.Set("apikey", "stuffed")
+		err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+		assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	}
+
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Contains(err.Error(), "retry after")
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyMaintenanceMode,
+		flagPluginsAPIKeyMaintenanceStart,
+		flagPluginsAPIKeyMaintenanceEnd,
+		flagPluginsAPIKeyMaintenanceRetryAfter,
+		flagPluginsAPIKeyMaintenanceSkipPaths,
+	)
+}
+
+var (
+	flagPluginsAPIKeyMaintenanceMode = config.Flag{
+		Long:  "plugins.apiKey.maintenance_mode",
+		Short: "",
+		Value: false,
+		Usage: "Reject every request with a 503 until disabled.",
+	}
+	flagPluginsAPIKeyMaintenanceStart = config.Flag{
+		Long:  "plugins.apiKey.maintenance_start",
+		Short: "",
+		Value: "",
+		Usage: "RFC3339 time at which a scheduled maintenance window begins.",
+	}
+	flagPluginsAPIKeyMaintenanceEnd = config.Flag{
+		Long:  "plugins.apiKey.maintenance_end",
+		Short: "",
+		Value: "",
+		Usage: "RFC3339 time at which a scheduled maintenance window ends.",
+	}
+	flagPluginsAPIKeyMaintenanceRetryAfter = config.Flag{
+		Long:  "plugins.apiKey.maintenance_retry_after",
+		Short: "",
+		Value: 300,
+		Usage: "Number of seconds after which clients are asked to retry during maintenance when no window end is known. Clients are not told when to retry if 0.",
+	}
+	flagPluginsAPIKeyMaintenanceSkipPaths = config.Flag{
+		Long:  "plugins.apiKey.maintenance_skip_paths",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of request paths, and the paths below them, that are processed as usual during maintenance.",
+	}
+)
+
+// maintenance tracks whether maintenance was active for the previous
+// request so that activation and deactivation are each logged once
+var maintenance = struct {
+	sync.Mutex
+	active bool
+}{}
+
+// getMaintenanceWindow returns the configured maintenance window.
+// False is returned if no valid window is configured.
+func getMaintenanceWindow() (time.Time, time.Time, bool) {
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(viper.GetString(flagPluginsAPIKeyMaintenanceStart.GetLong())))
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	end, err := time.Parse(time.RFC3339, strings.TrimSpace(viper.GetString(flagPluginsAPIKeyMaintenanceEnd.GetLong())))
+	if err != nil || !end.After(start) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// isMaintenanceActive returns true if maintenance mode is enabled or the
+// given time falls within the maintenance window. The time at which
+// maintenance ends is returned if it is known.
+func isMaintenanceActive(currTime time.Time) (bool, time.Time) {
+	active, end := viper.GetBool(flagPluginsAPIKeyMaintenanceMode.GetLong()), time.Time{}
+	if start, windowEnd, ok := getMaintenanceWindow(); ok && !currTime.Before(start) && currTime.Before(windowEnd) {
+		active, end = true, windowEnd
+	}
+
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	if active != maintenance.active {
+		maintenance.active = active
+		if active {
+			logrus.Warn("maintenance mode activated - requests will be rejected")
+		} else {
+			logrus.Info("maintenance mode deactivated")
+		}
+	}
+	return active, end
+}
+
+// isMaintenanceSkipPath returns true if the given request
+// should be processed as usual during maintenance. Paths that
+// cannot be cleaned are never skipped.
+func isMaintenanceSkipPath(r *http.Request) bool {
+	if r.URL == nil {
+		return false
+	}
+	requestPath, ok := cleanRequestPath(r.URL.Path)
+	if !ok {
+		return false
+	}
+	for _, path := range getStringSlice(flagPluginsAPIKeyMaintenanceSkipPaths.GetLong()) {
+		if pathMatches(path, requestPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaintenance returns a 503 error if maintenance is active and
+// the given request is not for a skip path
+func checkMaintenance(r *http.Request, currTime time.Time) error {
+	active, end := isMaintenanceActive(currTime)
+	if !active || isMaintenanceSkipPath(r) {
+		return nil
+	}
+
+	err := &utils.StatusError{http.StatusServiceUnavailable, errors.New("service is undergoing maintenance. please retry later")}
+
+	retryAfter := viper.GetInt(flagPluginsAPIKeyMaintenanceRetryAfter.GetLong())
+	if !end.IsZero() {
+		retryAfter = int(math.Ceil(end.Sub(currTime).Seconds()))
+	}
+	if retryAfter <= 0 {
+		return err
+	}
+	return withRetryAfter(err, retryAfter)
+}
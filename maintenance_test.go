@@ -0,0 +1,148 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetMaintenance() {
+	viper.Set(flagPluginsAPIKeyMaintenanceMode.GetLong(), false)
+	viper.Set(flagPluginsAPIKeyMaintenanceStart.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyMaintenanceEnd.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyMaintenanceRetryAfter.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyMaintenanceSkipPaths.GetLong(), "")
+	maintenance.active = false
+}
+
+func TestCheckMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	defer resetMaintenance()
+	resetMaintenance()
+	hook := test.NewGlobal()
+
+	now := time.Now()
+	assert.Nil(checkMaintenance(getTestRequest(), now))
+
+	viper.Set(flagPluginsAPIKeyMaintenanceMode.GetLong(), true)
+	err := checkMaintenance(getTestRequest(), now)
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+	assert.Equal("service is undergoing maintenance. please retry later", err.Error())
+
+	viper.Set(flagPluginsAPIKeyMaintenanceRetryAfter.GetLong(), 120)
+	err = checkMaintenance(getTestRequest(), now)
+	assert.Equal("service is undergoing maintenance. please retry later - retry after 120 seconds", err.Error())
+
+	activations := 0
+	for _, entry := range hook.Entries {
+		if entry.Message == "maintenance mode activated - requests will be rejected" {
+			activations++
+		}
+	}
+	assert.Equal(1, activations, "activation should only be logged once")
+
+	viper.Set(flagPluginsAPIKeyMaintenanceMode.GetLong(), false)
+	assert.Nil(checkMaintenance(getTestRequest(), now))
+	assert.Equal("maintenance mode deactivated", hook.LastEntry().Message)
+}
+
+func TestCheckMaintenanceWindow(t *testing.T) {
+	assert := assert.New(t)
+	defer resetMaintenance()
+	resetMaintenance()
+
+	start := time.Date(2017, 10, 1, 2, 0, 0, 0, time.UTC)
+	viper.Set(flagPluginsAPIKeyMaintenanceStart.GetLong(), start.Format(time.RFC3339))
+	viper.Set(flagPluginsAPIKeyMaintenanceEnd.GetLong(), start.Add(time.Hour).Format(time.RFC3339))
+	viper.Set(flagPluginsAPIKeyMaintenanceRetryAfter.GetLong(), 120)
+
+	assert.Nil(checkMaintenance(getTestRequest(), start.Add(-time.Second)))
+
+	err := checkMaintenance(getTestRequest(), start)
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+	assert.Equal("service is undergoing maintenance. please retry later - retry after 3600 seconds", err.Error(), "the window end should be preferred")
+
+	err = checkMaintenance(getTestRequest(), start.Add(59*time.Minute+500*time.Millisecond))
+	assert.Equal("service is undergoing maintenance. please retry later - retry after 60 seconds", err.Error())
+
+	assert.Nil(checkMaintenance(getTestRequest(), start.Add(time.Hour)))
+
+	viper.Set(flagPluginsAPIKeyMaintenanceEnd.GetLong(), "soon")
+	assert.Nil(checkMaintenance(getTestRequest(), start), "invalid windows should be ignored")
+}
+
+func TestIsMaintenanceSkipPath(t *testing.T) {
+	assert := assert.New(t)
+	defer resetMaintenance()
+
+	assert.False(isMaintenanceSkipPath(getTestRequest()))
+	assert.False(isMaintenanceSkipPath(&http.Request{}))
+
+	viper.Set(flagPluginsAPIKeyMaintenanceSkipPaths.GetLong(), "/status,/api/v1")
+	assert.True(isMaintenanceSkipPath(getTestRequest()))
+
+	viper.Set(flagPluginsAPIKeyMaintenanceSkipPaths.GetLong(), "/status,/api/v2")
+	assert.False(isMaintenanceSkipPath(getTestRequest()))
+	// dot segments cannot escape a skip path
+	r := getTestRequest()
+	r.URL.Path = "/status/../api/v1/accounts"
+	assert.False(isMaintenanceSkipPath(r))
+	r.URL.Path = "/status/..accounts"
+	assert.False(isMaintenanceSkipPath(r))
+	r.URL.Path = "/api/../status/./health"
+	assert.True(isMaintenanceSkipPath(r))
+}
+
+func TestOnRequestMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	defer resetMaintenance()
+	defer viper.Set(flagPluginsAPIKeyHealthPath.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	resetMaintenance()
+	viper.Set(flagPluginsAPIKeyMaintenanceMode.GetLong(), true)
+	viper.Set(flagPluginsAPIKeyHealthPath.GetLong(), "/healthz")
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+	assert.Equal(metrics.Metrics{{"api_key_maintenance", "true", true}, {"api_key_denied_status", "503", true}}, *m)
+
+	r := getTestRequest()
+	r.URL.Path = "/healthz"
+	assert.Equal(http.StatusOK, getStatusCode(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))), "health checks should be answered during maintenance")
+
+	viper.Set(flagPluginsAPIKeyMaintenanceSkipPaths.GetLong(), "/api/v1/accounts")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+}
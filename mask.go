@@ -0,0 +1,59 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyMaskKeyName,
+	)
+}
+
+var (
+	flagPluginsAPIKeyMaskKeyName = config.Flag{
+		Long:  "plugins.apiKey.mask_key_name",
+		Short: "",
+		Value: false,
+		Usage: "Replace APIKey resource names in logs, metrics, and span tags with a hash prefix.",
+	}
+)
+
+// maskedKeyNameLength is the number of hex characters of the
+// SHA-256 hash that are used in place of a masked key name
+const maskedKeyNameLength = 8
+
+// displayKeyName returns the representation of an APIKey resource name
+// that is safe to use in logs, metrics, and span tags. If key name
+// masking is enabled, the name is replaced by a prefix of its hash.
+func displayKeyName(name string) string {
+	if !viper.GetBool(flagPluginsAPIKeyMaskKeyName.GetLong()) {
+		return name
+	}
+	hash := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(hash[:])[:maskedKeyNameLength]
+}
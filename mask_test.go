@@ -0,0 +1,99 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisplayKeyName(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), false)
+
+	viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), false)
+	assert.Equal("apikeyone", displayKeyName("apikeyone"))
+
+	viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), true)
+	assert.Equal("165f1d94", displayKeyName("apikeyone"))
+	assert.Equal(maskedKeyNameLength, len(displayKeyName("")))
+}
+
+func TestMaskKeyName(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), false)
+	viper.Set(flagPluginsAPIKeyMaskKeyName.GetLong(), true)
+
+	hook := test.NewGlobal()
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(logrus.InfoLevel)
+
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+
+	u, _ := url.Parse("http://host.com/api/v1/accounts")
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	m := &metrics.Metrics{}
+
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), &http.Request{
+		Header: http.Header{
+			"Apikey": []string{"myapikey"},
+		},
+		URL: u,
+	}, span))
+
+	getRule(&spec.Key{
+		Name: "apikeyone",
+		Subpaths: []*spec.Path{
+			{Path: "/foo"},
+			{Path: "/foo/"},
+		},
+	}, "/foo")
+
+	assert.Equal(displayKeyName("apikeyone"), span.Tag("kanali.api_key_name"))
+	assert.NotEmpty(hook.Entries)
+	for _, value := range span.Tags() {
+		assert.NotContains(fmt.Sprint(value), "apikeyone", "raw key name should not be present in span tags")
+	}
+	for _, metric := range *m {
+		assert.NotContains(metric.Value, "apikeyone", "raw key name should not be present in metrics")
+	}
+	for _, entry := range hook.Entries {
+		assert.NotContains(entry.Message, "apikeyone", "raw key name should not be present in logs")
+		for _, value := range entry.Data {
+			assert.NotContains(fmt.Sprint(value), "apikeyone", "raw key name should not be present in logs")
+		}
+	}
+}
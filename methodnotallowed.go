@@ -0,0 +1,82 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyMethodNotAllowed,
+	)
+}
+
+var (
+	flagPluginsAPIKeyMethodNotAllowed = config.Flag{
+		Long:  "plugins.apiKey.method_not_allowed",
+		Short: "",
+		Value: false,
+		Usage: "Respond with a 405 listing the permitted methods when an api key uses an HTTP method its granular rule does not permit.",
+	}
+)
+
+// getUnauthorizedMethodError returns the error used when an api key is not
+// permitted to use the requested HTTP method. If enabled, and the rule
+// permits at least one method, a 405 whose message lists the permitted
+// methods is returned in place of a 403. Kanali does not write the headers
+// of a plugin error, so the methods cannot be sent in an Allow header.
+func getUnauthorizedMethodError(rule spec.Rule) error {
+	methods := getAllowedMethods(rule)
+	if !viper.GetBool(flagPluginsAPIKeyMethodNotAllowed.GetLong()) || len(methods) < 1 {
+		return &utils.StatusError{http.StatusForbidden, errors.New("api key unauthorized")}
+	}
+
+	return &utils.StatusError{http.StatusMethodNotAllowed, fmt.Errorf("http method not allowed for this api key. allowed methods: %s", strings.Join(methods, ", "))}
+}
+
+// getAllowedMethods returns the distinct, upper cased HTTP
+// methods permitted by the granular portion of a rule
+func getAllowedMethods(rule spec.Rule) []string {
+	methods := []string{}
+	if rule.Granular == nil {
+		return methods
+	}
+
+	seen := map[string]bool{}
+	for _, verb := range rule.Granular.Verbs {
+		verb = strings.ToUpper(strings.TrimSpace(verb))
+		if verb == "" || seen[verb] {
+			continue
+		}
+		seen[verb] = true
+		methods = append(methods, verb)
+	}
+	return methods
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUnauthorizedMethodError(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMethodNotAllowed.GetLong(), false)
+
+	rule := spec.Rule{
+		Granular: &spec.GranularProxy{
+			Verbs: []string{"get", "POST", "GET", " put "},
+		},
+	}
+	unauthorized := &utils.StatusError{http.StatusForbidden, errors.New("api key unauthorized")}
+
+	viper.Set(flagPluginsAPIKeyMethodNotAllowed.GetLong(), false)
+	assert.Equal(unauthorized, getUnauthorizedMethodError(rule), "default behavior should be preserved")
+
+	viper.Set(flagPluginsAPIKeyMethodNotAllowed.GetLong(), true)
+	err := getUnauthorizedMethodError(rule)
+	assert.Equal(http.StatusMethodNotAllowed, getStatusCode(err))
+	assert.Equal("http method not allowed for this api key. allowed methods: GET, POST, PUT", err.Error())
+
+	assert.Equal(unauthorized, getUnauthorizedMethodError(spec.Rule{}), "keys without any permitted methods are unauthorized")
+	assert.Equal(unauthorized, getUnauthorizedMethodError(spec.Rule{Granular: &spec.GranularProxy{}}), "keys without any permitted methods are unauthorized")
+}
+
+func TestGetAllowedMethods(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{}, getAllowedMethods(spec.Rule{}))
+	assert.Equal([]string{}, getAllowedMethods(spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"", " "}}}))
+	assert.Equal([]string{"GET", "DELETE"}, getAllowedMethods(spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"get", "delete", "Get"}}}))
+}
+
+func TestOnRequestMethodNotAllowed(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMethodNotAllowed.GetLong(), false)
+	viper.Set(flagPluginsAPIKeyMethodNotAllowed.GetLong(), true)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{
+		Granular: &spec.GranularProxy{
+			Verbs: []string{"GET", "HEAD"},
+		},
+	}
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(binding)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+
+	r := getTestRequest()
+	r.Method = "DELETE"
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusMethodNotAllowed, getStatusCode(err))
+	assert.Equal("http method not allowed for this api key. allowed methods: GET, HEAD", err.Error())
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyMetricsExcluded,
+		flagPluginsAPIKeyMetricsUnindexed,
+	)
+}
+
+var (
+	flagPluginsAPIKeyMetricsExcluded = config.Flag{
+		Long:  "plugins.apiKey.metrics_excluded",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of metrics that are not emitted by this plugin.",
+	}
+	flagPluginsAPIKeyMetricsUnindexed = config.Flag{
+		Long:  "plugins.apiKey.metrics_unindexed",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of metrics that are emitted by this plugin as values rather than indexed labels.",
+	}
+)
+
+// applyMetricLabels removes excluded metrics from, and unindexes metrics
+// in, the given metrics starting at the given position, so that only the
+// metrics added by this plugin are affected. It is deferred by each plugin
+// method so that every metric the method emits is covered.
+func applyMetricLabels(m *metrics.Metrics, from int) {
+	if m == nil || from >= len(*m) {
+		return
+	}
+
+	excluded := toSet(getStringSlice(flagPluginsAPIKeyMetricsExcluded.GetLong()))
+	unindexed := toSet(getStringSlice(flagPluginsAPIKeyMetricsUnindexed.GetLong()))
+	if len(excluded) < 1 && len(unindexed) < 1 {
+		return
+	}
+
+	filtered := (*m)[:from]
+	for _, metric := range (*m)[from:] {
+		if excluded[metric.Name] {
+			continue
+		}
+		if unindexed[metric.Name] {
+			metric.Index = false
+		}
+		filtered = append(filtered, metric)
+	}
+	*m = filtered
+}
+
+// metricCount returns the number of metrics already present
+func metricCount(m *metrics.Metrics) int {
+	if m == nil {
+		return 0
+	}
+	return len(*m)
+}
+
+// toSet returns a set containing each of the given values
+func toSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
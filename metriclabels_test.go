@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyMetricLabels(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMetricsExcluded.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyMetricsUnindexed.GetLong(), "")
+
+	getMetrics := func() *metrics.Metrics {
+		return &metrics.Metrics{
+			{"api_key_name", "kanali", true},
+			{"api_key_name", "abc123", true},
+			{"api_key_namespace", "foo", true},
+			{"api_key_binding", "bar", true},
+		}
+	}
+
+	m := getMetrics()
+	applyMetricLabels(m, 1)
+	assert.Equal(*getMetrics(), *m)
+
+	viper.Set(flagPluginsAPIKeyMetricsExcluded.GetLong(), "api_key_name")
+	m = getMetrics()
+	applyMetricLabels(m, 1)
+	assert.Equal(metrics.Metrics{
+		{"api_key_name", "kanali", true},
+		{"api_key_namespace", "foo", true},
+		{"api_key_binding", "bar", true},
+	}, *m)
+
+	viper.Set(flagPluginsAPIKeyMetricsUnindexed.GetLong(), "api_key_namespace")
+	m = getMetrics()
+	applyMetricLabels(m, 1)
+	assert.Equal(metrics.Metrics{
+		{"api_key_name", "kanali", true},
+		{"api_key_namespace", "foo", false},
+		{"api_key_binding", "bar", true},
+	}, *m)
+
+	m = getMetrics()
+	applyMetricLabels(m, 4)
+	assert.Equal(*getMetrics(), *m)
+
+	applyMetricLabels(nil, 0)
+	assert.Equal(0, metricCount(nil))
+	assert.Equal(4, metricCount(getMetrics()))
+}
+
+func TestOnRequestMetricLabels(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyMetricsExcluded.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyMetricsExcluded.GetLong(), "api_key_name,api_key_denied")
+
+	r := getTestRequest()
+	r.Header.Set("apikey", "stuffed")
+	m := &metrics.Metrics{{"kanali_metric", "true", true}}
+	assert.NotNil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+
+	names := map[string]bool{}
+	for _, metric := range *m {
+		names[metric.Name] = true
+	}
+	assert.True(names["kanali_metric"])
+	assert.True(names["api_key_namespace"])
+	assert.False(names["api_key_name"])
+	assert.False(names["api_key_denied"])
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyNamespaceScoped,
+	)
+}
+
+var (
+	flagPluginsAPIKeyNamespaceScoped = config.Flag{
+		Long:  "plugins.apiKey.namespace_scoped",
+		Short: "",
+		Value: false,
+		Usage: "Only allow an apikey to be used with APIProxies in its own namespace, or in the namespaces it is annotated for.",
+	}
+)
+
+// annotationKeyNamespaces is the APIKey annotation holding a comma separated
+// list of the additional namespaces a key may be used in when keys are
+// scoped to namespaces
+const annotationKeyNamespaces = "apikey.kanali.io/namespaces"
+
+// isNamespaceAllowed will return true if the given APIKey may be used with
+// an APIProxy in the given namespace. Every namespace is allowed unless
+// keys are scoped to namespaces.
+func isNamespaceAllowed(key spec.APIKey, namespace string) bool {
+	if !viper.GetBool(flagPluginsAPIKeyNamespaceScoped.GetLong()) || key.ObjectMeta.Namespace == namespace {
+		return true
+	}
+
+	for _, allowed := range strings.Split(key.ObjectMeta.Annotations[annotationKeyNamespaces], ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNamespace will return an error if the given APIKey
+// may not be used with an APIProxy in the given namespace
+func validateNamespace(key spec.APIKey, namespace string) error {
+	if isNamespaceAllowed(key, namespace) {
+		return nil
+	}
+	return &utils.StatusError{http.StatusForbidden, errors.New("api key not authorized for this namespace")}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsNamespaceAllowed(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyNamespaceScoped.GetLong(), false)
+
+	key := getTestAPIKey()
+	viper.Set(flagPluginsAPIKeyNamespaceScoped.GetLong(), false)
+	assert.True(isNamespaceAllowed(key, "foo"))
+	assert.True(isNamespaceAllowed(key, "bar"), "keys should be global by default")
+
+	viper.Set(flagPluginsAPIKeyNamespaceScoped.GetLong(), true)
+	assert.True(isNamespaceAllowed(key, "foo"))
+	assert.False(isNamespaceAllowed(key, "bar"))
+	assert.False(isNamespaceAllowed(key, ""))
+
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyNamespaces: "bar, baz"}
+	assert.True(isNamespaceAllowed(key, "bar"))
+	assert.True(isNamespaceAllowed(key, "baz"))
+	assert.False(isNamespaceAllowed(key, "qux"))
+	assert.False(isNamespaceAllowed(key, ""), "empty annotation entries should not match")
+}
+
+func TestOnRequestNamespaceScoped(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyNamespaceScoped.GetLong(), false)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyNamespaceScoped.GetLong(), true)
+
+	proxy := getTestAPIProxy()
+	proxy.ObjectMeta.Namespace = "bar"
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Namespace = "bar"
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	spec.BindingStore.Set(binding)
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")), "same namespace keys should be allowed")
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, proxy, getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("api key not authorized for this namespace", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_namespace_denied", "true", true})
+
+	key := getTestAPIKey()
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyNamespaces: "bar"}
+	spec.KeyStore.Set(key)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, proxy, getTestRequest(), opentracing.StartSpan("test span")), "keys annotated for a namespace should be allowed")
+}
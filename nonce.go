@@ -0,0 +1,176 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+// annotationBindingRequireNonce is the APIKeyBinding annotation that, when
+// true, requires every request to carry a unique nonce and a recent timestamp
+const annotationBindingRequireNonce = "apikey.kanali.io/require-nonce"
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyNonceHeader,
+		flagPluginsAPIKeyNonceTimestampHeader,
+		flagPluginsAPIKeyNonceWindow,
+		flagPluginsAPIKeyNonceMaxEntries,
+	)
+}
+
+var (
+	flagPluginsAPIKeyNonceHeader = config.Flag{
+		Long:  "plugins.apiKey.nonce_header",
+		Short: "",
+		Value: "X-Nonce",
+		Usage: "Name of the HTTP header holding the nonce of a request made to a binding that requires one.",
+	}
+	flagPluginsAPIKeyNonceTimestampHeader = config.Flag{
+		Long:  "plugins.apiKey.nonce_timestamp_header",
+		Short: "",
+		Value: "X-Timestamp",
+		Usage: "Name of the HTTP header holding the Unix time, in seconds, at which a request that requires a nonce was made.",
+	}
+	flagPluginsAPIKeyNonceWindow = config.Flag{
+		Long:  "plugins.apiKey.nonce_window",
+		Short: "",
+		Value: "5m0s",
+		Usage: "Window within which a nonce may not be reused. Request timestamps must also fall within this window of the current time.",
+	}
+	flagPluginsAPIKeyNonceMaxEntries = config.Flag{
+		Long:  "plugins.apiKey.nonce_max_entries",
+		Short: "",
+		Value: 100000,
+		Usage: "Maximum number of nonces remembered at once. Requests that require a nonce are rejected while full.",
+	}
+)
+
+var (
+	errNonceUsed      = &utils.StatusError{http.StatusUnauthorized, errors.New("request nonce has already been used")}
+	errNonceStoreFull = &utils.StatusError{http.StatusServiceUnavailable, errors.New("too many recent nonces")}
+)
+
+// nonceEntry records a nonce and the time until which it is remembered
+type nonceEntry struct {
+	id      string
+	expires time.Time
+}
+
+// nonceQueue orders nonce entries by the time until which they are
+// remembered, so that expired nonces can be forgotten soonest first.
+// It implements heap.Interface.
+type nonceQueue []nonceEntry
+
+func (q nonceQueue) Len() int            { return len(q) }
+func (q nonceQueue) Less(i, j int) bool  { return q[i].expires.Before(q[j].expires) }
+func (q nonceQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nonceQueue) Push(x interface{}) { *q = append(*q, x.(nonceEntry)) }
+func (q *nonceQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// nonces holds the time until which every nonce seen is remembered
+var nonces = struct {
+	sync.Mutex
+	seen  map[string]time.Time
+	queue nonceQueue
+}{seen: map[string]time.Time{}}
+
+// isNonceRequired returns true if the given binding requires a nonce
+func isNonceRequired(binding spec.APIKeyBinding) bool {
+	required, _ := strconv.ParseBool(strings.TrimSpace(binding.ObjectMeta.Annotations[annotationBindingRequireNonce]))
+	return required
+}
+
+// validateNonce will, if the given binding requires it, return an error
+// unless the given request carries a timestamp within the nonce window and
+// a nonce that has not been used by the given key within that window
+func validateNonce(r *http.Request, binding spec.APIKeyBinding, key spec.APIKey, currTime time.Time) error {
+	if !isNonceRequired(binding) {
+		return nil
+	}
+
+	window := viper.GetDuration(flagPluginsAPIKeyNonceWindow.GetLong())
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+
+	nonce := r.Header.Get(viper.GetString(flagPluginsAPIKeyNonceHeader.GetLong()))
+	if nonce == "" {
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("request nonce not found")}
+	}
+
+	seconds, err := strconv.ParseInt(r.Header.Get(viper.GetString(flagPluginsAPIKeyNonceTimestampHeader.GetLong())), 10, 64)
+	if err != nil {
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("request timestamp is missing or outside the allowed window")}
+	}
+	if skew := currTime.Sub(time.Unix(seconds, 0)); skew > window || skew < -window {
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("request timestamp is missing or outside the allowed window")}
+	}
+
+	// a timestamp is accepted until window after it, so its nonce is remembered as long
+	return useNonce(key.ObjectMeta.Namespace+"/"+key.ObjectMeta.Name+"/"+nonce, time.Unix(seconds, 0).Add(window), currTime)
+}
+
+// useNonce records the given nonce until the given expiry. An error is
+// returned if the nonce is still remembered, or if it cannot be
+// remembered because the maximum number of nonces has been reached.
+func useNonce(id string, expires, currTime time.Time) error {
+	nonces.Lock()
+	defer nonces.Unlock()
+
+	for len(nonces.queue) > 0 && !currTime.Before(nonces.queue[0].expires) {
+		entry := heap.Pop(&nonces.queue).(nonceEntry)
+		if used, ok := nonces.seen[entry.id]; ok && used.Equal(entry.expires) {
+			delete(nonces.seen, entry.id)
+		}
+	}
+
+	if remembered, ok := nonces.seen[id]; ok && currTime.Before(remembered) {
+		return errNonceUsed
+	}
+
+	max := viper.GetInt(flagPluginsAPIKeyNonceMaxEntries.GetLong())
+	if max > 0 && len(nonces.queue) >= max {
+		logrus.Warn("nonce store is full - requests that require a nonce will be rejected")
+		return errNonceStoreFull
+	}
+
+	nonces.seen[id] = expires
+	heap.Push(&nonces.queue, nonceEntry{id, expires})
+	return nil
+}
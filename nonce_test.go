@@ -0,0 +1,156 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetNonces() {
+	nonces.Lock()
+	nonces.seen = map[string]time.Time{}
+	nonces.queue = nil
+	nonces.Unlock()
+}
+
+func getTestNonceBinding() spec.APIKeyBinding {
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingRequireNonce: "true"}
+	return binding
+}
+
+func getTestNonceRequest(nonce string, timestamp time.Time) *http.Request {
+	r := getTestRequest()
+	r.Header.Set("X-Nonce", nonce)
+	r.Header.Set("X-Timestamp", strconv.FormatInt(timestamp.Unix(), 10))
+	return r
+}
+
+func TestIsNonceRequired(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(isNonceRequired(getTestAPIKeyBinding()))
+	assert.True(isNonceRequired(getTestNonceBinding()))
+
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingRequireNonce: "yes please"}
+	assert.False(isNonceRequired(binding))
+}
+
+func TestValidateNonce(t *testing.T) {
+	assert := assert.New(t)
+	defer resetNonces()
+	defer viper.Set(flagPluginsAPIKeyNonceWindow.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyNonceHeader.GetLong(), "X-Nonce")
+	viper.SetDefault(flagPluginsAPIKeyNonceTimestampHeader.GetLong(), "X-Timestamp")
+	viper.Set(flagPluginsAPIKeyNonceWindow.GetLong(), "1m0s")
+	resetNonces()
+
+	now := time.Unix(1507643736, 0)
+	key := getTestAPIKey()
+
+	assert.Nil(validateNonce(getTestRequest(), getTestAPIKeyBinding(), key, now), "nonces should only be required when opted into")
+
+	err := validateNonce(getTestRequest(), getTestNonceBinding(), key, now)
+	assert.Equal("request nonce not found", err.Error())
+
+	r := getTestRequest()
+	r.Header.Set("X-Nonce", "abc")
+	err = validateNonce(r, getTestNonceBinding(), key, now)
+	assert.Equal("request timestamp is missing or outside the allowed window", err.Error())
+
+	err = validateNonce(getTestNonceRequest("abc", now.Add(-61*time.Second)), getTestNonceBinding(), key, now)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("request timestamp is missing or outside the allowed window", err.Error())
+	err = validateNonce(getTestNonceRequest("abc", now.Add(61*time.Second)), getTestNonceBinding(), key, now)
+	assert.Equal("request timestamp is missing or outside the allowed window", err.Error())
+
+	assert.Nil(validateNonce(getTestNonceRequest("abc", now), getTestNonceBinding(), key, now))
+	err = validateNonce(getTestNonceRequest("abc", now), getTestNonceBinding(), key, now.Add(30*time.Second))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("request nonce has already been used", err.Error())
+
+	other := getTestAPIKey()
+	other.ObjectMeta.Name = "apikeytwo"
+	assert.Nil(validateNonce(getTestNonceRequest("abc", now), getTestNonceBinding(), other, now), "nonces should be scoped to a key")
+
+	// an expired nonce may be used again once its timestamp is refreshed
+	later := now.Add(time.Minute)
+	assert.Nil(validateNonce(getTestNonceRequest("abc", later), getTestNonceBinding(), key, later))
+
+	// a nonce with a future timestamp is remembered for as long as that timestamp is accepted
+	future := now.Add(50 * time.Second)
+	assert.Nil(validateNonce(getTestNonceRequest("xyz", future), getTestNonceBinding(), key, now))
+	err = validateNonce(getTestNonceRequest("xyz", future), getTestNonceBinding(), key, now.Add(100*time.Second))
+	assert.Equal("request nonce has already been used", err.Error())
+}
+
+func TestUseNonceMaxEntries(t *testing.T) {
+	assert := assert.New(t)
+	defer resetNonces()
+	defer viper.Set(flagPluginsAPIKeyNonceMaxEntries.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyNonceMaxEntries.GetLong(), 2)
+	resetNonces()
+
+	now := time.Now()
+	assert.Nil(useNonce("a", now.Add(2*time.Minute), now))
+	assert.Nil(useNonce("b", now.Add(time.Minute), now))
+	err := useNonce("c", now.Add(time.Minute), now)
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err), "new nonces should be rejected when full")
+	assert.Equal(errNonceUsed, useNonce("a", now.Add(2*time.Minute), now), "remembered nonces should not be forgotten when full")
+
+	assert.Nil(useNonce("c", now.Add(2*time.Minute), now.Add(time.Minute)))
+	assert.Equal(2, len(nonces.seen), "expired nonces should be forgotten")
+	assert.Equal(2, len(nonces.queue))
+
+	assert.Nil(useNonce("d", now.Add(3*time.Minute), now.Add(2*time.Minute)))
+	assert.Equal(1, len(nonces.seen))
+}
+
+func TestOnRequestNonce(t *testing.T) {
+	assert := assert.New(t)
+	defer resetNonces()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.SetDefault(flagPluginsAPIKeyNonceHeader.GetLong(), "X-Nonce")
+	viper.SetDefault(flagPluginsAPIKeyNonceTimestampHeader.GetLong(), "X-Timestamp")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestNonceBinding())
+	resetNonces()
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestNonceRequest("fresh", time.Now()), opentracing.StartSpan("test span")))
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestNonceRequest("fresh", time.Now()), opentracing.StartSpan("test span"))
+	assert.Equal("request nonce has already been used", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_replay_denied", "true", true})
+}
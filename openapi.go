@@ -0,0 +1,278 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyOpenAPIDir,
+	)
+}
+
+var (
+	flagPluginsAPIKeyOpenAPIDir = config.Flag{
+		Long:  "plugins.apiKey.openapi_dir",
+		Short: "",
+		Value: "/etc/kanali/openapi",
+		Usage: "Directory holding the ConfigMaps referenced by apikey.kanali.io/openapi-spec, each mounted in a subdirectory named after it.",
+	}
+)
+
+// annotationProxyOpenAPISpec is the APIProxy annotation referencing, as
+// <configmap>/<key>, the OpenAPI spec that the methods permitted on each
+// path are derived from
+const annotationProxyOpenAPISpec = "apikey.kanali.io/openapi-spec"
+
+// openAPIMethods are the HTTP methods an OpenAPI path item can define
+var openAPIMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+var errOpenAPISpecUnavailable = &utils.StatusError{http.StatusInternalServerError, errors.New("openapi spec could not be loaded")}
+
+// openAPISpec holds the methods defined for each path of an OpenAPI spec
+type openAPISpec struct {
+	paths []openAPIPath
+}
+
+// openAPIPath is a path template, split into segments, and its methods
+type openAPIPath struct {
+	segments []string
+	methods  []string
+}
+
+// cachedOpenAPISpec is a parsed spec along with the modification time of
+// the file it was parsed from
+type cachedOpenAPISpec struct {
+	spec    *openAPISpec
+	modTime time.Time
+}
+
+var openAPISpecs = struct {
+	sync.Mutex
+	entries map[string]cachedOpenAPISpec
+}{entries: map[string]cachedOpenAPISpec{}}
+
+// splitOpenAPIPath splits a path into its segments, ignoring
+// leading and trailing slashes
+func splitOpenAPIPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// isOpenAPIParam will return true if the given segment is a path parameter
+func isOpenAPIParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// parseOpenAPISpec parses the paths of an OpenAPI or Swagger document in JSON
+func parseOpenAPISpec(document []byte) (*openAPISpec, error) {
+	doc := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Paths == nil {
+		return nil, errors.New("spec does not define any paths")
+	}
+
+	s := &openAPISpec{}
+	for path, item := range doc.Paths {
+		methods := []string{}
+		for method := range item {
+			if openAPIMethods[strings.ToLower(method)] {
+				methods = append(methods, strings.ToUpper(method))
+			}
+		}
+		sort.Strings(methods)
+		s.paths = append(s.paths, openAPIPath{splitOpenAPIPath(path), methods})
+	}
+	return s, nil
+}
+
+// getMethods returns the methods defined for the path template matching the
+// given path. Templates with more literal segments win, as OpenAPI requires
+// concrete paths to be matched before templated ones. False is returned if
+// no template matches.
+func (s *openAPISpec) getMethods(path string) ([]string, bool) {
+	segments := splitOpenAPIPath(path)
+
+	best, bestLiterals := -1, -1
+	for i, p := range s.paths {
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		literals, matches := 0, true
+		for j, segment := range p.segments {
+			if isOpenAPIParam(segment) {
+				continue
+			}
+			if segment != segments[j] {
+				matches = false
+				break
+			}
+			literals++
+		}
+		if matches && literals > bestLiterals {
+			best, bestLiterals = i, literals
+		}
+	}
+
+	if best < 0 {
+		return nil, false
+	}
+	return s.paths[best].methods, true
+}
+
+// getOpenAPISpecFile returns the file holding the OpenAPI spec referenced
+// by the given reference of the form <configmap>/<key>
+func getOpenAPISpecFile(ref string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(ref), "/")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("%s must be of the form <configmap>/<key>", annotationProxyOpenAPISpec)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("%s must be of the form <configmap>/<key>", annotationProxyOpenAPISpec)
+		}
+	}
+	return filepath.Join(viper.GetString(flagPluginsAPIKeyOpenAPIDir.GetLong()), parts[0], parts[1]), nil
+}
+
+// loadOpenAPISpec returns the spec held by the given file. A spec is only
+// parsed again once its file has been modified, such as when the ConfigMap
+// it is mounted from is updated.
+func loadOpenAPISpec(file string) (*openAPISpec, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	openAPISpecs.Lock()
+	cached, ok := openAPISpecs.entries[file]
+	openAPISpecs.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.spec, nil
+	}
+
+	document, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseOpenAPISpec(document)
+	if err != nil {
+		return nil, err
+	}
+
+	openAPISpecs.Lock()
+	openAPISpecs.entries[file] = cachedOpenAPISpec{s, info.ModTime()}
+	openAPISpecs.Unlock()
+	return s, nil
+}
+
+// getOpenAPISpec returns the OpenAPI spec referenced by the given APIProxy.
+// Nil is returned if the proxy does not reference a spec, and an error if
+// the referenced spec cannot be loaded.
+func getOpenAPISpec(p spec.APIProxy) (*openAPISpec, error) {
+	ref, ok := p.ObjectMeta.Annotations[annotationProxyOpenAPISpec]
+	if !ok {
+		return nil, nil
+	}
+
+	fields := logrus.Fields{
+		"proxy":     p.ObjectMeta.Name,
+		"namespace": p.ObjectMeta.Namespace,
+	}
+	file, err := getOpenAPISpecFile(ref)
+	if err != nil {
+		logrus.WithFields(fields).Error(err.Error())
+		return nil, errOpenAPISpecUnavailable
+	}
+	s, err := loadOpenAPISpec(file)
+	if err != nil {
+		logrus.WithFields(fields).Errorf("could not load openapi spec: %s", err.Error())
+		return nil, errOpenAPISpecUnavailable
+	}
+	return s, nil
+}
+
+// evaluateOpenAPIRules returns the entry for the given key in the given
+// binding and the rule that applies to the given target path, or an error
+// if the key may not make a request with the given method to that path.
+// The binding decides which paths the key may access, but the methods
+// permitted on each path are those the given spec defines, in place of the
+// verbs of the binding's rules. A rule that permits nothing still denies.
+// Paths the spec does not define are denied unless unmatched paths are
+// allowed, in which case the binding's rule, broadened by the extra
+// methods of the key, applies. The number of rules of the binding that
+// were evaluated is also returned.
+func evaluateOpenAPIRules(s *openAPISpec, binding spec.APIKeyBinding, key spec.APIKey, method, targetPath string) (*spec.Key, spec.Rule, int, error) {
+	keyObj, rule, evaluated, err := lookupRule(binding, key, targetPath)
+	if err != nil {
+		return keyObj, rule, evaluated, err
+	}
+	if !rule.Global && rule.Granular == nil {
+		return keyObj, rule, evaluated, getUnauthorizedMethodError(rule)
+	}
+
+	methods, ok := s.getMethods(targetPath)
+	if !ok {
+		// paths the spec does not define fall back to the binding's rule
+		// when unmatched paths are allowed
+		if !isUnmatchedPathAllowed() {
+			return keyObj, spec.Rule{}, evaluated, errNoRuleForPath
+		}
+		if granted := withExtraMethods(rule, key); !validateAPIKey(granted, method) {
+			return keyObj, rule, evaluated, getUnauthorizedMethodError(granted)
+		}
+		return keyObj, rule, evaluated, nil
+	}
+
+	rule = spec.Rule{Granular: &spec.GranularProxy{Verbs: methods}}
+	if !validateAPIKey(rule, method) {
+		return keyObj, rule, evaluated, getUnauthorizedMethodError(rule)
+	}
+	return keyObj, rule, evaluated, nil
+}
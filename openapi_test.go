@@ -0,0 +1,224 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const testOpenAPISpec = `{
+  "openapi": "3.0.0",
+  "paths": {
+    "/accounts": {
+      "get": {},
+      "post": {},
+      "parameters": []
+    },
+    "/accounts/{id}": {
+      "get": {},
+      "delete": {}
+    },
+    "/accounts/search": {
+      "put": {}
+    }
+  }
+}`
+
+// writeTestOpenAPISpec mounts the given document as the key spec.json of
+// the ConfigMap accounts-api in a new openapi_dir, returning its cleanup
+func writeTestOpenAPISpec(t *testing.T, document string) (string, func()) {
+	dir, err := ioutil.TempDir("", "openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "accounts-api"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "accounts-api", "spec.json")
+	if err := ioutil.WriteFile(file, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set(flagPluginsAPIKeyOpenAPIDir.GetLong(), dir)
+	return file, func() {
+		viper.Set(flagPluginsAPIKeyOpenAPIDir.GetLong(), "")
+		os.RemoveAll(dir)
+	}
+}
+
+func getTestOpenAPIProxy() spec.APIProxy {
+	p := testutil.Proxy()
+	p.ObjectMeta.Annotations = map[string]string{annotationProxyOpenAPISpec: "accounts-api/spec.json"}
+	return p
+}
+
+func TestParseOpenAPISpec(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := parseOpenAPISpec([]byte(testOpenAPISpec))
+	assert.Nil(err)
+	assert.Equal(3, len(s.paths))
+
+	_, err = parseOpenAPISpec([]byte(`{"openapi": "3.0.0"}`))
+	assert.Equal("spec does not define any paths", err.Error())
+
+	_, err = parseOpenAPISpec([]byte(`openapi: 3.0.0`))
+	assert.NotNil(err)
+}
+
+func TestOpenAPISpecGetMethods(t *testing.T) {
+	assert := assert.New(t)
+	s, _ := parseOpenAPISpec([]byte(testOpenAPISpec))
+
+	methods, ok := s.getMethods("/accounts/")
+	assert.True(ok)
+	assert.Equal([]string{"GET", "POST"}, methods)
+
+	methods, ok = s.getMethods("/accounts/1234")
+	assert.True(ok)
+	assert.Equal([]string{"DELETE", "GET"}, methods)
+
+	methods, ok = s.getMethods("/accounts/search")
+	assert.True(ok)
+	assert.Equal([]string{"PUT"}, methods, "concrete paths should be matched before templated ones")
+
+	_, ok = s.getMethods("/accounts/1234/details")
+	assert.False(ok)
+	_, ok = s.getMethods("/")
+	assert.False(ok)
+}
+
+func TestGetOpenAPISpecFile(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyOpenAPIDir.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyOpenAPIDir.GetLong(), "/etc/kanali/openapi")
+
+	file, err := getOpenAPISpecFile(" accounts-api/spec.json ")
+	assert.Nil(err)
+	assert.Equal("/etc/kanali/openapi/accounts-api/spec.json", file)
+
+	for _, ref := range []string{"spec.json", "accounts-api/", "../spec.json", "accounts-api/..", "a/b/c"} {
+		_, err = getOpenAPISpecFile(ref)
+		assert.NotNil(err, ref)
+	}
+}
+
+func TestLoadOpenAPISpec(t *testing.T) {
+	assert := assert.New(t)
+	file, cleanup := writeTestOpenAPISpec(t, testOpenAPISpec)
+	defer cleanup()
+
+	s, err := loadOpenAPISpec(file)
+	assert.Nil(err)
+	cached, _ := loadOpenAPISpec(file)
+	assert.True(s == cached, "specs should only be parsed once")
+
+	ioutil.WriteFile(file, []byte(`{"paths": {"/reports": {"get": {}}}}`), 0644)
+	os.Chtimes(file, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	s, err = loadOpenAPISpec(file)
+	assert.Nil(err)
+	_, ok := s.getMethods("/reports")
+	assert.True(ok, "modified specs should be parsed again")
+
+	_, err = loadOpenAPISpec(file + ".missing")
+	assert.NotNil(err)
+}
+
+func TestGetOpenAPISpec(t *testing.T) {
+	assert := assert.New(t)
+	_, cleanup := writeTestOpenAPISpec(t, testOpenAPISpec)
+	defer cleanup()
+
+	s, err := getOpenAPISpec(testutil.Proxy())
+	assert.Nil(s, "proxies without a spec should fall back to binding rules")
+	assert.Nil(err)
+
+	s, err = getOpenAPISpec(getTestOpenAPIProxy())
+	assert.NotNil(s)
+	assert.Nil(err)
+
+	p := getTestOpenAPIProxy()
+	p.ObjectMeta.Annotations[annotationProxyOpenAPISpec] = "accounts-api/missing.json"
+	_, err = getOpenAPISpec(p)
+	assert.Equal(errOpenAPISpecUnavailable, err)
+}
+
+func TestEvaluateOpenAPIRules(t *testing.T) {
+	assert := assert.New(t)
+	s, _ := parseOpenAPISpec([]byte(testOpenAPISpec))
+	key := testutil.Key()
+
+	binding := testutil.Binding(testutil.GranularKey(testutil.KeyName, "PATCH"))
+	_, rule, _, err := evaluateOpenAPIRules(s, binding, key, "DELETE", "/accounts/1234")
+	assert.Nil(err, "spec methods should take the place of the binding's verbs")
+	assert.Equal([]string{"DELETE", "GET"}, rule.Granular.Verbs)
+
+	_, _, _, err = evaluateOpenAPIRules(s, binding, key, "PATCH", "/accounts/1234")
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+
+	_, _, _, err = evaluateOpenAPIRules(s, binding, key, "GET", "/reports")
+	assert.Equal(errNoRuleForPath, err, "paths missing from the spec should be denied")
+
+	_, _, _, err = evaluateOpenAPIRules(s, testutil.Binding(testutil.GlobalKey("apikeytwo")), key, "GET", "/accounts")
+	assert.Equal(errKeyNotBound, err)
+
+	_, _, _, err = evaluateOpenAPIRules(s, testutil.Binding(spec.Key{Name: testutil.KeyName}), key, "GET", "/accounts")
+	assert.Equal(errNoRuleForPath, err)
+
+	binding = testutil.Binding(spec.Key{
+		Name:        testutil.KeyName,
+		DefaultRule: spec.Rule{Global: true},
+		Subpaths:    []*spec.Path{{Path: "/accounts/search"}},
+	})
+	_, _, _, err = evaluateOpenAPIRules(s, binding, key, "PUT", "/accounts/search")
+	assert.Equal(http.StatusForbidden, getStatusCode(err), "rules that permit nothing should still deny")
+}
+
+func TestOnRequestOpenAPI(t *testing.T) {
+	assert := assert.New(t)
+	_, cleanup := writeTestOpenAPISpec(t, testOpenAPISpec)
+	defer cleanup()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	p := getTestOpenAPIProxy()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, p, testutil.Request("DELETE", testutil.ProxyPath+"/accounts/1234", testutil.KeyData), testutil.Span()))
+
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, p, testutil.Request("PUT", testutil.ProxyPath+"/accounts/1234", testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("PUT", testutil.ProxyPath+"/accounts/1234", testutil.KeyData), testutil.Span()), "proxies without a spec should use binding rules")
+
+	p.ObjectMeta.Annotations[annotationProxyOpenAPISpec] = "accounts-api/missing.json"
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, p, testutil.Request("GET", testutil.ProxyPath+"/accounts", testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusInternalServerError, getStatusCode(err))
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyValidateOptions,
+		flagPluginsAPIKeyOptionsPolicy,
+	)
+}
+
+var (
+	flagPluginsAPIKeyValidateOptions = config.Flag{
+		Long:  "plugins.apiKey.validate_options",
+		Short: "",
+		Value: false,
+		Usage: "Validate the apikey of OPTIONS requests. If false, OPTIONS requests, such as CORS preflight requests, are never validated.",
+	}
+	flagPluginsAPIKeyOptionsPolicy = config.Flag{
+		Long:  "plugins.apiKey.options_policy",
+		Short: "",
+		Value: optionsPolicyVerb,
+		Usage: "How validated OPTIONS requests are authorized. Either verb, where the rule must permit OPTIONS, or bound, where any apikey bound to the proxy is permitted.",
+	}
+)
+
+const (
+	optionsPolicyVerb  = "verb"
+	optionsPolicyBound = "bound"
+)
+
+// isOptionsValidationSkipped will return true if the
+// apikey of a request with the given method is not validated
+func isOptionsValidationSkipped(method string) bool {
+	return strings.ToUpper(method) == http.MethodOptions && !viper.GetBool(flagPluginsAPIKeyValidateOptions.GetLong())
+}
+
+// isOptionsAllowedForBoundKeys will return true if a request with the
+// given method is permitted for any bound apikey, regardless of its rules.
+// Unknown policies require the verb, as they would if the flag was not set.
+func isOptionsAllowedForBoundKeys(method string) bool {
+	if strings.ToUpper(method) != http.MethodOptions {
+		return false
+	}
+	switch policy := strings.ToLower(strings.TrimSpace(viper.GetString(flagPluginsAPIKeyOptionsPolicy.GetLong()))); policy {
+	case optionsPolicyBound:
+		return true
+	case "", optionsPolicyVerb:
+		return false
+	default:
+		logrus.Warnf("unknown options policy %s - OPTIONS must be permitted by a rule", policy)
+		return false
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsOptionsValidationSkipped(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyValidateOptions.GetLong(), false)
+
+	viper.Set(flagPluginsAPIKeyValidateOptions.GetLong(), false)
+	assert.True(isOptionsValidationSkipped("OPTIONS"))
+	assert.True(isOptionsValidationSkipped("options"))
+	assert.False(isOptionsValidationSkipped("GET"))
+
+	viper.Set(flagPluginsAPIKeyValidateOptions.GetLong(), true)
+	assert.False(isOptionsValidationSkipped("OPTIONS"))
+}
+
+func TestIsOptionsAllowedForBoundKeys(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyOptionsPolicy.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyOptionsPolicy.GetLong(), optionsPolicyVerb)
+	assert.False(isOptionsAllowedForBoundKeys("OPTIONS"))
+	viper.Set(flagPluginsAPIKeyOptionsPolicy.GetLong(), "bogus")
+	assert.False(isOptionsAllowedForBoundKeys("OPTIONS"), "unknown policies should require the verb")
+
+	viper.Set(flagPluginsAPIKeyOptionsPolicy.GetLong(), optionsPolicyBound)
+	assert.True(isOptionsAllowedForBoundKeys("OPTIONS"))
+	assert.False(isOptionsAllowedForBoundKeys("GET"), "other methods should be unaffected")
+}
+
+func TestOnRequestOptions(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyValidateOptions.GetLong(), false)
+	defer viper.Set(flagPluginsAPIKeyOptionsPolicy.GetLong(), "")
+	cleanup := testutil.Stores([]spec.APIKey{testutil.Key(), testutil.NamedKey("apikeytwo", "unboundapikey"), testutil.NamedKey("apikeythree", "optionsapikey")}, []spec.APIKeyBinding{testutil.Binding(
+		testutil.GranularKey(testutil.KeyName, "GET"),
+		testutil.GranularKey("apikeythree", "GET", "OPTIONS"),
+	)})
+	defer cleanup()
+	onRequest := func(apiKey string) error {
+		return Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("OPTIONS", testutil.ProxyPath, apiKey), testutil.Span())
+	}
+
+	// OPTIONS requests bypass validation by default
+	assert.Nil(onRequest(""))
+	assert.Nil(onRequest(testutil.KeyData))
+
+	// the verb policy requires OPTIONS to be permitted by a rule
+	viper.Set(flagPluginsAPIKeyValidateOptions.GetLong(), true)
+	viper.Set(flagPluginsAPIKeyOptionsPolicy.GetLong(), optionsPolicyVerb)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(onRequest("")))
+	assert.Equal(http.StatusForbidden, getStatusCode(onRequest(testutil.KeyData)))
+	assert.Nil(onRequest("optionsapikey"))
+	assert.Equal(http.StatusForbidden, getStatusCode(onRequest("unboundapikey")))
+
+	// the bound policy permits any bound key
+	viper.Set(flagPluginsAPIKeyOptionsPolicy.GetLong(), optionsPolicyBound)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(onRequest("")))
+	assert.Nil(onRequest(testutil.KeyData))
+	assert.Nil(onRequest("optionsapikey"))
+	assert.Equal(http.StatusForbidden, getStatusCode(onRequest("unboundapikey")))
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyUnmatchedPathPolicy,
+	)
+}
+
+var (
+	flagPluginsAPIKeyUnmatchedPathPolicy = config.Flag{
+		Long:  "plugins.apiKey.unmatched_path_policy",
+		Short: "",
+		Value: unmatchedPathDeny,
+		Usage: "Policy for a request by a bound key to a path that matches none of its rules when no default rule applies. Either deny or allow.",
+	}
+)
+
+const (
+	unmatchedPathDeny  = "deny"
+	unmatchedPathAllow = "allow"
+)
+
+// isUnmatchedPathAllowed will return true if requests to paths that match
+// no rule should be allowed. Unknown policies deny, as they would if the
+// flag was not set.
+func isUnmatchedPathAllowed() bool {
+	switch policy := strings.ToLower(strings.TrimSpace(viper.GetString(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong()))); policy {
+	case unmatchedPathAllow:
+		return true
+	case "", unmatchedPathDeny:
+		return false
+	default:
+		logrus.Warnf("unknown unmatched path policy %s - requests to unmatched paths will be denied", policy)
+		return false
+	}
+}
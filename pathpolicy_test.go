@@ -0,0 +1,118 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUnmatchedPathAllowed(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong(), "")
+
+	for policy, allowed := range map[string]bool{
+		"":       false,
+		"deny":   false,
+		"allow":  true,
+		" ALLOW": true,
+		"permit": false,
+	} {
+		viper.Set(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong(), policy)
+		assert.Equal(allowed, isUnmatchedPathAllowed(), policy)
+	}
+}
+
+func TestLookupRuleUnmatchedPathPolicy(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyDefaultRule.GetLong(), "")
+
+	binding := testutil.Binding(spec.Key{
+		Name:     testutil.KeyName,
+		Subpaths: []*spec.Path{{Path: "/admin", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{}}}}},
+	})
+
+	viper.Set(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong(), unmatchedPathDeny)
+	_, _, _, err := lookupRule(binding, testutil.Key(), "/accounts")
+	assert.Equal(errNoRuleForPath, err)
+
+	viper.Set(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong(), unmatchedPathAllow)
+	_, rule, _, err := lookupRule(binding, testutil.Key(), "/accounts")
+	assert.Nil(err)
+	assert.Equal(spec.Rule{Global: true}, rule)
+
+	_, rule, _, err = lookupRule(binding, testutil.Key(), "/admin")
+	assert.Nil(err)
+	assert.False(validateAPIKey(rule, "GET"), "explicit rules should still deny")
+
+	viper.Set(flagPluginsAPIKeyDefaultRule.GetLong(), "GET")
+	_, rule, _, err = lookupRule(binding, testutil.Key(), "/accounts")
+	assert.Nil(err)
+	assert.Equal([]string{"GET"}, rule.Granular.Verbs, "a default rule should take priority over the policy")
+
+	_, _, _, err = lookupRule(testutil.Binding(testutil.GlobalKey("apikeytwo")), testutil.Key(), "/accounts")
+	assert.Equal(errKeyNotBound, err, "keys that are not bound should still be denied")
+}
+
+func TestOnRequestUnmatchedPathPolicy(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong(), "")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := testutil.Binding(spec.Key{
+		Name:     testutil.KeyName,
+		Subpaths: []*spec.Path{{Path: "/reports", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}}},
+	})
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{binding})()
+
+	tests := []struct {
+		policy string
+		method string
+		path   string
+		status int
+	}{
+		{unmatchedPathDeny, "GET", "/reports", 0},
+		{unmatchedPathDeny, "POST", "/reports", http.StatusForbidden},
+		{unmatchedPathDeny, "GET", "/accounts", http.StatusForbidden},
+		{unmatchedPathDeny, "DELETE", "/accounts", http.StatusForbidden},
+		{unmatchedPathAllow, "GET", "/reports", 0},
+		{unmatchedPathAllow, "POST", "/reports", http.StatusForbidden},
+		{unmatchedPathAllow, "GET", "/accounts", 0},
+		{unmatchedPathAllow, "DELETE", "/accounts", 0},
+	}
+	for _, test := range tests {
+		viper.Set(flagPluginsAPIKeyUnmatchedPathPolicy.GetLong(), test.policy)
+		err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request(test.method, testutil.ProxyPath+test.path, testutil.KeyData), testutil.Span())
+		if test.status == 0 {
+			assert.Nil(err, "%s %s %s", test.policy, test.method, test.path)
+		} else {
+			assert.Equal(test.status, getStatusCode(err), "%s %s %s", test.policy, test.method, test.path)
+		}
+	}
+}
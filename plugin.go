@@ -25,13 +25,13 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/northwesternmutual/kanali/config"
 	"github.com/northwesternmutual/kanali/metrics"
-	"github.com/northwesternmutual/kanali/server"
 	"github.com/northwesternmutual/kanali/spec"
 	"github.com/northwesternmutual/kanali/utils"
 	"github.com/opentracing/opentracing-go"
@@ -41,6 +41,7 @@ import (
 func init() {
 	config.Flags.Add(
 		flagPluginsAPIKeyHeaderKey,
+		flagPluginsAPIKeyEmptyVerbsMeansAll,
 	)
 }
 
@@ -51,32 +52,192 @@ var (
 		Value: "apikey",
 		Usage: "Name of the HTTP header holding the apikey.",
 	}
+	flagPluginsAPIKeyEmptyVerbsMeansAll = config.Flag{
+		Long:  "plugins.apiKey.empty_verbs_means_all",
+		Short: "",
+		Value: false,
+		Usage: "Interpret a granular rule with an empty list of verbs as permitting every HTTP method instead of none.",
+	}
 )
 
 // APIKeyFactory is factory that implements the Plugin interface
 type APIKeyFactory struct{}
 
 // OnRequest intercepts a request before it get proxied to an upstream service
-func (k APIKeyFactory) OnRequest(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, span opentracing.Span) error {
+func (k APIKeyFactory) OnRequest(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, span opentracing.Span) (err error) {
+
+	defer applyMetricLabels(m, metricCount(m))
+	defer recoverPanic(m, "OnRequest", &err)
+
+	// a missing span is replaced by one that records nothing so that
+	// nothing below needs to check for it
+	if span == nil {
+		span = opentracing.NoopTracer{}.StartSpan("")
+	}
+
+	// Kanali may invoke OnRequest more than once for the same request
+	if decision, ok := getPriorDecision(r, p); ok {
+		m.Add(metrics.Metric{"api_key_prior_decision", "true", false})
+		span.SetTag("kanali.decision_id", getDecisionID(r))
+		return decision.err
+	}
+
+	loadConfigDefaults()
+	startActiveKeysRefresh()
+	defer recordProcessingTime(m, r, time.Now())
+
+	if isHealthPath(r) {
+		return withoutHeadBody(r, getHealthResponse())
+	}
+
+	if err := checkMaintenance(r, time.Now()); err != nil {
+		m.Add(metrics.Metric{"api_key_maintenance", "true", true})
+		m.Add(metrics.Metric{"api_key_denied_status", strconv.Itoa(getStatusCode(err)), true})
+		return withoutHeadBody(r, err)
+	}
+
+	setRequestTime(r, time.Now())
+	id := newDecisionID()
+	setDecisionID(r, id)
+	span.SetTag("kanali.decision_id", id)
+
+	if err = checkLockout(m, r, time.Now()); err == nil {
+		err = validateRequest(ctx, m, p, r, span)
+		// nobody is waiting on a cancelled request, so it is neither
+		// recorded as a decision nor reported
+		if err == errRequestCancelled {
+			logrus.WithField("decision_id", id).Debug("request cancelled before a decision was made")
+			m.Add(metrics.Metric{"api_key_cancelled", "true", false})
+			return err
+		}
+		recordLockoutResult(r, err, time.Now())
+	}
+	logDecision(p, r, id, err)
+	recordDecisionVars(err)
+	if !notifyDecisionSink(newDecisionRecord(p, r, id, err, time.Now())) {
+		m.Add(metrics.Metric{"decision_sink_dropped", "true", false})
+	}
+	if err == nil {
+		setDecisionBaggage(span, r)
+	} else {
+		m.Add(metrics.Metric{"api_key_denied", "true", true})
+		event := newDenyEvent(p, r, id, err, time.Now())
+		if webhook := getDenyWebhook(); webhook != nil && !webhook.notify(event) {
+			m.Add(metrics.Metric{"deny_webhook_dropped", "true", false})
+		}
+		writeDenyEvent(event)
+		delayDenial(ctx)
+	}
+	// details appended to the message of a denial describe its original reason
+	if err = applySoftDeny(r, err); err != nil {
+		reason := getDenyReasonCode(err)
+		err = withoutHeadBody(r, withDecisionID(withChallenge(p, withDenyLink(withDenyReason(err, reason), reason)), id))
+		m.Add(metrics.Metric{"api_key_denied_status", strconv.Itoa(getStatusCode(err)), true})
+		writeAccessLog(r, getStatusCode(err), -1)
+	}
+	setPriorDecision(r, p, err)
+	return err
+
+}
+
+// validateRequest will return an error if the given request
+// is not authorized to be proxied to the upstream service
+func validateRequest(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, span opentracing.Span) error {
+
+	// reject oversized headers before any of them are processed
+	if isHeaderTooLarge(r) {
+		m.Add(metrics.Metric{"api_key_header_too_large", "true", true})
+		return &utils.StatusError{http.StatusRequestHeaderFieldsTooLarge, errors.New("request headers too large")}
+	}
+
+	// reject traffic from blocked user agents regardless of the api key used
+	if isUserAgentBlocked(r.UserAgent()) {
+		m.Add(metrics.Metric{"api_key_blocked_user_agent", "true", true})
+		return &utils.StatusError{http.StatusForbidden, errors.New("user agent not allowed")}
+	}
+
+	// reject contradictory headers from misconfigured or malicious clients
+	if rule, err := validateHeaderRules(r); err != nil {
+		m.Add(metrics.Metric{"api_key_suspicious_headers", rule, true})
+		return err
+	}
 
 	// do not preform API key validation if a request is made using the OPTIONS http method
-	if strings.ToUpper(r.Method) == "OPTIONS" {
+	// unless OPTIONS validation is enabled
+	if isOptionsValidationSkipped(r.Method) {
 		logrus.Debug("API key validation will not be preformed on HTTP OPTIONS requests")
 		return nil
 	}
 
+	// requests to anonymous paths are limited by client IP instead of apikey
+	if anonymous, err := checkAnonymousRequest(m, p, r, time.Now()); anonymous {
+		span.SetTag("kanali.anonymous", true)
+		return err
+	}
+
 	// extract the api key header
-	apiKey := r.Header.Get(viper.GetString(flagPluginsAPIKeyHeaderKey.GetLong()))
+	apiKey, location := getAPIKey(r)
+	setAPIKeyLocation(r, location)
+	if isDeprecatedKeyLocation(location) {
+		logrus.WithField("location", location).Info("apikey found in a deprecated location")
+		m.Add(metrics.Metric{"api_key_deprecated_location", location, true})
+	}
 	if apiKey == "" {
 		m.Add(metrics.Metric{"api_key_name", "unknown", true})
 		m.Add(metrics.Metric{"api_key_namespace", "unknown", true})
+		// anonymous requests to anonymous paths have already been proxied
+		if isEmptyKeyDistinguished(r) {
+			span.SetTag("kanali.anonymous", true)
+			m.Add(metrics.Metric{"api_key_anonymous_denied", "true", true})
+			return errAnonymousNotPermitted
+		}
 		return &utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request")}
 	}
 
+	// reject malformed api keys before they reach a store
+	if !isWellFormedAPIKey(apiKey) {
+		m.Add(metrics.Metric{"api_key_name", "unknown", true})
+		m.Add(metrics.Metric{"api_key_namespace", "unknown", true})
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("apikey is malformed")}
+	}
+	if !hasAllowedPrefix(apiKey) {
+		m.Add(metrics.Metric{"api_key_name", "unknown", true})
+		m.Add(metrics.Metric{"api_key_namespace", "unknown", true})
+		m.Add(metrics.Metric{"api_key_invalid_prefix", "true", true})
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("apikey is malformed")}
+	}
+
+	// keys are stored without an environment prefix
+	storeKey, keyPrefix := stripKeyPrefix(apiKey)
+	setAPIKeyPrefix(r, keyPrefix)
+
+	// two-part keys are stored by their key ID alone
+	var secret string
+	if isTwoPartMode() {
+		var err error
+		if storeKey, secret, err = splitKeySecret(storeKey); err != nil {
+			m.Add(metrics.Metric{"api_key_name", "unknown", true})
+			m.Add(metrics.Metric{"api_key_namespace", "unknown", true})
+			return err
+		}
+	}
+
 	// attempt to find a matching api key
-	keyStore := spec.KeyStore
-	untypedKey, err := keyStore.Get(apiKey)
-	if err != nil || untypedKey == nil {
+	if err := checkCancelled(ctx); err != nil {
+		return err
+	}
+	untypedKey, storeName, err := findAPIKey(ctx, storeKey)
+	// a lookup abandoned because the client went away is not a store failure
+	if err != nil && checkCancelled(ctx) != nil {
+		return errRequestCancelled
+	}
+	if err != nil {
+		m.Add(metrics.Metric{"api_key_name", "unknown", true})
+		m.Add(metrics.Metric{"api_key_namespace", "unknown", true})
+		m.Add(metrics.Metric{"api_key_store_unavailable", "true", true})
+		return getStoreUnavailableError()
+	}
+	if untypedKey == nil {
 		m.Add(metrics.Metric{"api_key_name", "unknown", true})
 		m.Add(metrics.Metric{"api_key_namespace", "unknown", true})
 		return &utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in k8s cluster")}
@@ -89,54 +250,254 @@ func (k APIKeyFactory) OnRequest(ctx context.Context, m *metrics.Metrics, p spec
 		return &utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in k8s cluster")}
 	}
 
-	span.SetTag("kanali.api_key_name", key.ObjectMeta.Name)
+	span.SetTag("kanali.api_key_store", storeName)
+	span.SetTag("kanali.api_key_name", displayKeyName(key.ObjectMeta.Name))
 	span.SetTag("kanali.api_key_namespace", key.ObjectMeta.Namespace)
+	setAnnotationSpanTags(span, key)
+	if keyPrefix != "" {
+		span.SetTag("kanali.api_key_prefix", keyPrefix)
+		m.Add(metrics.Metric{"api_key_prefix", keyPrefix, true})
+	}
+
+	setAPIKey(r, key)
+	setSunset(r, key, time.Now())
 
-	m.Add(metrics.Metric{"api_key_name", key.ObjectMeta.Name, true})
+	m.Add(metrics.Metric{"api_key_store", storeName, true})
+	m.Add(metrics.Metric{"api_key_name", displayKeyName(key.ObjectMeta.Name), true})
 	m.Add(metrics.Metric{"api_key_namespace", key.ObjectMeta.Namespace, true})
 
-	bindingsStore := spec.BindingStore
-	untypedBinding, err := bindingsStore.Get(p.ObjectMeta.Name, p.ObjectMeta.Namespace)
-	if err != nil || untypedBinding == nil {
-		return &utils.StatusError{http.StatusUnauthorized, errors.New("no binding found for associated APIProxy")}
+	if isTwoPartMode() {
+		matched, err := verifyKeySecret(key, secret, time.Now())
+		if err != nil {
+			m.Add(metrics.Metric{"api_key_secret_denied", "true", true})
+			return err
+		}
+		m.Add(metrics.Metric{"api_key_secret", matched, true})
+	}
+
+	// reject weak keys, judging two-part keys by their secret
+	credential := storeKey
+	if isTwoPartMode() {
+		credential = secret
+	}
+	if err := validateKeyStrength(key, credential); err != nil {
+		m.Add(metrics.Metric{"api_key_weak", "true", true})
+		return err
+	}
+
+	if err := validateNamespace(key, p.ObjectMeta.Namespace); err != nil {
+		m.Add(metrics.Metric{"api_key_namespace_denied", "true", true})
+		return withForbiddenStatus(err)
+	}
+
+	if err := verifySignature(r, key); err != nil {
+		return err
+	}
+
+	if err := validateClientCert(r, key); err != nil {
+		m.Add(metrics.Metric{"api_key_client_cert_denied", "true", true})
+		return err
+	}
+
+	if err := validateReferer(r, key); err != nil {
+		m.Add(metrics.Metric{"api_key_referer_denied", "true", true})
+		return err
+	}
+
+	if err := validateAllowedHours(key, time.Now()); err != nil {
+		m.Add(metrics.Metric{"api_key_allowed_hours_denied", "true", true})
+		return err
+	}
+
+	if err := detectKeySharing(m, r, key, time.Now()); err != nil {
+		return err
+	}
+
+	// consult bindings on behalf of the canonical key of an alias
+	resolved, err := resolveAlias(key, time.Now())
+	if err != nil {
+		return err
+	}
+	if resolved.ObjectMeta.Name != key.ObjectMeta.Name {
+		span.SetTag("kanali.api_key_alias_of", displayKeyName(resolved.ObjectMeta.Name))
+		key = resolved
+	}
+
+	if err := validateTierBudget(r, key); err != nil {
+		m.Add(metrics.Metric{"api_key_tier_budget_exceeded", getKeyTier(key), true})
+		return err
+	}
+
+	bindingName, err := getRequestedBindingName(p, r)
+	if err != nil {
+		m.Add(metrics.Metric{"api_key_binding_not_allowed", "true", true})
+		return err
+	}
+
+	if err := checkCancelled(ctx); err != nil {
+		return err
+	}
+	bindingsStore := localBindingStore
+	untypedBinding, err := getWithRetry(ctx, func() (interface{}, error) {
+		return bindingsStore.Get(bindingName, p.ObjectMeta.Namespace)
+	})
+	if err != nil && checkCancelled(ctx) != nil {
+		return errRequestCancelled
+	}
+	if err != nil {
+		m.Add(metrics.Metric{"api_key_store_unavailable", "true", true})
+		return getStoreUnavailableError()
+	}
+	if untypedBinding == nil {
+		return withForbiddenStatus(&utils.StatusError{http.StatusForbidden, errors.New("no binding found for associated APIProxy")})
 	}
 	binding, ok := untypedBinding.(spec.APIKeyBinding)
 	if !ok {
-		return &utils.StatusError{http.StatusUnauthorized, errors.New("no binding found for associated APIProxy")}
+		return withForbiddenStatus(&utils.StatusError{http.StatusForbidden, errors.New("no binding found for associated APIProxy")})
+	}
+	if err := validateBindingMetadata(binding, bindingName, p.ObjectMeta.Namespace); err != nil {
+		m.Add(metrics.Metric{"api_key_binding_mismatch", "true", true})
+		return withForbiddenStatus(err)
 	}
 
 	span.SetTag("kanali.api_binding_name", binding.ObjectMeta.Name)
 	span.SetTag("kanali.api_binding_namespace", binding.ObjectMeta.Namespace)
+	setBinding(r, binding)
 
-	keyObj := binding.GetAPIKey(key.ObjectMeta.Name)
-	if keyObj == nil {
-		return &utils.StatusError{http.StatusUnauthorized, errors.New("api key not authorized for this proxy")}
+	if err := validateNonce(r, binding, key, time.Now()); err != nil {
+		m.Add(metrics.Metric{"api_key_replay_denied", "true", true})
+		return err
 	}
 
-	rule := keyObj.GetRule(utils.ComputeTargetPath(p.Spec.Path, p.Spec.Target, r.URL.Path))
-
 	// validate api key
-	if !validateAPIKey(rule, r.Method) {
-		return &utils.StatusError{http.StatusUnauthorized, errors.New("api key unauthorized")}
+	keyObj, rule, reused, err := decideRule(ctx, m, p, r, span, apiKey, key, binding, getTargetPath(p, r))
+	if err != nil {
+		return err
 	}
 
-	if spec.TrafficStore.IsQuotaViolated(binding, key.ObjectMeta.Name) {
-		return &utils.StatusError{http.StatusTooManyRequests, errors.New("quota limit reached. please contact your administrator")}
+	exempt := isRateLimitExempt(r.Method)
+
+	if !exempt {
+		if err := checkQuota(binding, key, keyObj, time.Now()); err != nil {
+			return err
+		}
 	}
 
-	if spec.TrafficStore.IsRateLimitViolated(binding, key.ObjectMeta.Name, time.Now()) {
+	limited := !exempt && isRateLimitViolated(binding, key, keyObj, time.Now())
+	if limited || (!exempt && isMethodRateLimitViolated(binding, key, r.Method, time.Now())) {
 		time.Sleep(2 * time.Second)
 	}
 
-	go server.Emit(binding, key.ObjectMeta.Name, time.Now())
+	// do not report traffic for a request whose client has gone away
+	if err := checkCancelled(ctx); err != nil {
+		return err
+	}
+
+	setUpstreamTimeout(r, binding)
+	setUpstreamHeaders(r, binding)
+	if !exempt {
+		recordGroupTraffic(binding, key, time.Now())
+		recordQuotaTraffic(binding, key, time.Now())
+		recordMethodTraffic(binding, key, r.Method, time.Now())
+		emitTraffic(binding, key.ObjectMeta.Name, time.Now())
+	}
+	if limit, ok := getRateLimit(binding, key, keyObj, limited, time.Now()); ok {
+		setRateLimit(r, limit)
+	}
+	// audited requests are never skipped by a decision token,
+	// and requests authorized by one were not audited when it was issued
+	if reused {
+		return nil
+	}
+	extra := auditExtraMethods(m, p, r, key, rule)
+	if !auditGlobalWrite(m, p, r, rule, time.Now()) && !extra {
+		issueDecisionToken(apiKey, p, r, key, binding, keyObj, time.Now())
+	}
 	return nil
 
 }
 
+// decideRule makes the rule decision for a request made with the given
+// apikey, once its APIKey and APIKeyBinding have been found and validated.
+// The OpenAPI spec of the proxy or the rules of the binding are evaluated,
+// unless a decision token lets the request skip them, followed by the
+// admin path requirement and any custom authorizer. True is returned if a
+// decision token was reused.
+func decideRule(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, span opentracing.Span, apiKey string, key spec.APIKey, binding spec.APIKeyBinding, targetPath string) (*spec.Key, spec.Rule, bool, error) {
+	openAPISpec, err := getOpenAPISpec(p)
+	if err != nil {
+		return nil, spec.Rule{}, false, err
+	}
+	var rule spec.Rule
+	var evaluated int
+	// a decision token lets a chatty client skip rule evaluation
+	keyObj, reused := reuseDecisionToken(apiKey, p, r, key, binding, time.Now())
+	if reused {
+		m.Add(metrics.Metric{"api_key_decision_token", "true", true})
+	} else {
+		if openAPISpec != nil {
+			keyObj, rule, evaluated, err = evaluateOpenAPIRules(openAPISpec, binding, key, r.Method, targetPath)
+		} else {
+			keyObj, rule, evaluated, err = evaluateRules(binding, key, r.Method, targetPath, time.Now())
+		}
+		setRulesEvaluatedTag(span, evaluated)
+		if keyObj != nil {
+			logResolvedRule(binding, key, r.Method, targetPath, rule, err)
+		}
+	}
+	// bound keys may be permitted to make OPTIONS requests regardless of their rules
+	if err != nil && keyObj != nil && isOptionsAllowedForBoundKeys(r.Method) {
+		err = nil
+	}
+	if err == errNoRuleForPath {
+		m.Add(metrics.Metric{"api_key_no_rule_for_path", "true", true})
+	}
+	if err == errKeyNotBound {
+		m.Add(metrics.Metric{"api_key_not_bound", "true", true})
+		return nil, rule, false, withUnboundKeyStatus(err)
+	}
+	if err != nil {
+		return nil, rule, false, withForbiddenStatus(err)
+	}
+	if err := validateAdminPath(key, targetPath); err != nil {
+		m.Add(metrics.Metric{"api_key_admin_denied", "true", true})
+		return nil, rule, false, withForbiddenStatus(err)
+	}
+	if err := validateScopes(key, binding); err != nil {
+		m.Add(metrics.Metric{"api_key_insufficient_scope", "true", true})
+		return nil, rule, false, withForbiddenStatus(err)
+	}
+
+	// defer to a custom authorizer, if any
+	if a := getAuthorizer(); a != nil {
+		if ok, reason := a.Authorize(ctx, AuthContext{p, r, key, binding, rule}); !ok {
+			if reason == "" {
+				reason = "request denied by custom authorizer"
+			}
+			m.Add(metrics.Metric{"api_key_authorizer_denied", "true", true})
+			return nil, rule, false, &utils.StatusError{http.StatusForbidden, errors.New(reason)}
+		}
+	}
+	return keyObj, rule, reused, nil
+}
+
 // OnResponse intercepts a request after it has been proxied to an upstream service
 // but before the response gets returned to the client
-func (k APIKeyFactory) OnResponse(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, resp *http.Response, span opentracing.Span) error {
+func (k APIKeyFactory) OnResponse(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, resp *http.Response, span opentracing.Span) (err error) {
+
+	defer applyMetricLabels(m, metricCount(m))
+	defer recoverPanic(m, "OnResponse", &err)
+	loadConfigDefaults()
 
+	recordTimeToFirstByte(m, r, time.Now())
+	setDecisionIDHeader(r, resp)
+	setDecisionTokenHeader(r, resp)
+	setSunsetHeader(r, resp)
+	setRateLimitHeaders(r, resp, time.Now())
+	setDeprecationWarning(r, resp)
+	if resp != nil {
+		writeAccessLog(r, resp.StatusCode, resp.ContentLength)
+	}
 	return nil
 
 }
@@ -144,10 +505,18 @@ func (k APIKeyFactory) OnResponse(ctx context.Context, m *metrics.Metrics, p spe
 // validateAPIKey will return true if the given api key
 // is authorized to make the given request.
 // Global rule valudation will be given priority over
-// granular rule validation
+// granular rule validation unless the global rule mode
+// is intersect. HEAD requests may also be authorized
+// by a granular rule that permits GET.
 func validateAPIKey(rule spec.Rule, method string) bool {
 
-	return rule.Global || validateGranularRules(method, rule.Granular)
+	if rule.Global && !isGlobalRuleIntersected(rule) {
+		return true
+	}
+	if validateGranularRules(method, rule.Granular) {
+		return true
+	}
+	return isHeadMirroringGet(method) && validateGranularRules(http.MethodGet, rule.Granular)
 
 }
 
@@ -157,6 +526,9 @@ func validateGranularRules(method string, rule *spec.GranularProxy) bool {
 	if rule == nil {
 		return false
 	}
+	if len(rule.Verbs) < 1 {
+		return viper.GetBool(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong())
+	}
 	for _, verb := range rule.Verbs {
 		if strings.ToUpper(verb) == strings.ToUpper(method) {
 			return true
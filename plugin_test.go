@@ -22,10 +22,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io/ioutil"
 	"net/http"
-	"net/url"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
 	"github.com/northwesternmutual/kanali/metrics"
 	"github.com/northwesternmutual/kanali/spec"
 	"github.com/opentracing/opentracing-go"
@@ -37,49 +42,7 @@ import (
 
 func TestOnRequest(t *testing.T) {
 	assert := assert.New(t)
-
-	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), &http.Request{
-		Method: "OPTIONS",
-	}, opentracing.StartSpan("test span")))
-	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), &http.Request{
-		Method: "options",
-	}, opentracing.StartSpan("test span")))
-
-	assert.Equal("apikey not found in request", Plugin.OnRequest(context.Background(), &metrics.Metrics{}, spec.APIProxy{}, &http.Request{}, opentracing.StartSpan("test span")).Error(), "should have thrown error")
-
 	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
-
-	u, _ := url.Parse("http://host.com/api/v1/accounts")
-
-	assert.Equal("apikey not found in k8s cluster", Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), &http.Request{
-		Header: http.Header{
-			"Apikey": []string{"myapikey"},
-		},
-		URL: u,
-	}, opentracing.StartSpan("test span")).Error(), "should have thrown error")
-
-	apikeyStore := spec.KeyStore
-	apikeyStore.Set(getTestAPIKey())
-
-	assert.Equal("no binding found for associated APIProxy", Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), &http.Request{
-		Header: http.Header{
-			"Apikey": []string{"myapikey"},
-		},
-		URL: u,
-	}, opentracing.StartSpan("test span")).Error(), "should have thrown error")
-
-	binding := getTestAPIKeyBinding()
-	binding.Spec.Keys = []spec.Key{}
-	apikeybindingStore := spec.BindingStore
-	apikeybindingStore.Set(binding)
-
-	assert.Equal("api key not authorized for this proxy", Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), &http.Request{
-		Header: http.Header{
-			"Apikey": []string{"myapikey"},
-		},
-		URL: u,
-	}, opentracing.StartSpan("test span")).Error(), "should have thrown error")
-
 	spec.KanaliEndpoints = &api.Endpoints{
 		TypeMeta:   unversioned.TypeMeta{},
 		ObjectMeta: api.ObjectMeta{},
@@ -94,13 +57,185 @@ func TestOnRequest(t *testing.T) {
 		},
 	}
 
-	apikeybindingStore.Set(getTestAPIKeyBinding())
-	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), &http.Request{
-		Header: http.Header{
-			"Apikey": []string{"myapikey"},
+	tests := []struct {
+		name     string
+		keys     []spec.APIKey
+		bindings []spec.APIKeyBinding
+		proxy    spec.APIProxy
+		request  *http.Request
+		status   int
+		message  string
+	}{
+		{
+			name:    "options requests are not validated",
+			proxy:   testutil.Proxy(),
+			request: &http.Request{Method: "OPTIONS"},
+		},
+		{
+			name:    "options requests are matched case insensitively",
+			proxy:   testutil.Proxy(),
+			request: &http.Request{Method: "options"},
+		},
+		{
+			name:    "missing apikey",
+			proxy:   spec.APIProxy{},
+			request: &http.Request{},
+			status:  http.StatusUnauthorized,
+			message: "apikey not found in request",
+		},
+		{
+			name:    "malformed apikey",
+			proxy:   testutil.Proxy(),
+			request: testutil.Request("GET", testutil.ProxyPath, "my\x00apikey"),
+			status:  http.StatusUnauthorized,
+			message: "apikey is malformed",
+		},
+		{
+			name:    "unknown apikey",
+			proxy:   testutil.Proxy(),
+			request: testutil.Request("GET", testutil.ProxyPath, testutil.KeyData),
+			status:  http.StatusUnauthorized,
+			message: "apikey not found in k8s cluster",
+		},
+		{
+			name:    "no binding",
+			keys:    []spec.APIKey{testutil.Key()},
+			proxy:   testutil.Proxy(),
+			request: testutil.Request("GET", testutil.ProxyPath, testutil.KeyData),
+			status:  http.StatusForbidden,
+			message: "no binding found for associated APIProxy",
+		},
+		{
+			name:     "apikey not bound",
+			keys:     []spec.APIKey{testutil.Key()},
+			bindings: []spec.APIKeyBinding{testutil.Binding(testutil.GlobalKey("apikeytwo"))},
+			proxy:    testutil.Proxy(),
+			request:  testutil.Request("GET", testutil.ProxyPath, testutil.KeyData),
+			status:   http.StatusForbidden,
+			message:  "api key not authorized for this proxy",
+		},
+		{
+			name:     "no rule for path",
+			keys:     []spec.APIKey{testutil.Key()},
+			bindings: []spec.APIKeyBinding{testutil.Binding(spec.Key{Name: testutil.KeyName})},
+			proxy:    testutil.Proxy(),
+			request:  testutil.Request("GET", testutil.ProxyPath, testutil.KeyData),
+			status:   http.StatusForbidden,
+			message:  "api key has no rule for this path",
+		},
+		{
+			name:     "method not permitted",
+			keys:     []spec.APIKey{testutil.Key()},
+			bindings: []spec.APIKeyBinding{testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))},
+			proxy:    testutil.Proxy(),
+			request:  testutil.Request("POST", testutil.ProxyPath, testutil.KeyData),
+			status:   http.StatusForbidden,
+			message:  "api key unauthorized",
+		},
+		{
+			name:     "method permitted by a granular rule",
+			keys:     []spec.APIKey{testutil.Key()},
+			bindings: []spec.APIKeyBinding{testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))},
+			proxy:    testutil.Proxy(),
+			request:  testutil.Request("GET", testutil.ProxyPath, testutil.KeyData),
+		},
+		{
+			name:     "apikey authorized by a global rule",
+			keys:     []spec.APIKey{testutil.Key()},
+			bindings: []spec.APIKeyBinding{testutil.Binding()},
+			proxy:    testutil.Proxy(),
+			request:  testutil.Request("DELETE", testutil.ProxyPath, testutil.KeyData),
 		},
-		URL: u,
-	}, opentracing.StartSpan("test span")), "apikey should be authorized")
+	}
+
+	for _, test := range tests {
+		clear := testutil.Stores(test.keys, test.bindings)
+		m := &metrics.Metrics{}
+		err := Plugin.OnRequest(context.Background(), m, test.proxy, test.request, testutil.Span())
+		clear()
+
+		if test.status == 0 {
+			assert.Nil(err, test.name)
+			assert.Equal("", getDeniedStatusLabel(m), test.name)
+			continue
+		}
+		if assert.NotNil(err, test.name) {
+			assert.Equal(test.status, getStatusCode(err), test.name)
+			assert.Equal(test.message, err.Error(), test.name)
+			assert.Equal(strconv.Itoa(test.status), getDeniedStatusLabel(m), test.name)
+		}
+	}
+}
+
+// getDeniedStatusLabel returns the status code label of the denial
+// recorded in the given metrics, if any
+func getDeniedStatusLabel(m *metrics.Metrics) string {
+	for _, metric := range *m {
+		if metric.Name == "api_key_denied_status" {
+			return metric.Value
+		}
+	}
+	return ""
+}
+
+func TestOnRequestDeniedStatus(t *testing.T) {
+	assert := assert.New(t)
+	defer resetMaintenance()
+	defer viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), false)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	// quota violations are labeled with the 429 that is returned
+	key := testutil.GlobalKey(testutil.KeyName)
+	key.Quota = 1
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding(key)})()
+	defer spec.TrafficStore.Clear()
+	spec.TrafficStore.AddTraffic(testutil.Namespace, testutil.ProxyName, testutil.KeyName, time.Now())
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Equal("429", getDeniedStatusLabel(m))
+
+	// forbidden_as_unauthorized changes the label along with the response
+	testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding(testutil.GlobalKey("apikeytwo"))})
+	viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), true)
+	m = &metrics.Metrics{}
+	err = Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), false)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("401", getDeniedStatusLabel(m))
+
+	// soft denied requests are proxied, so no status is recorded
+	viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), true)
+	m = &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span()))
+	assert.Equal("", getDeniedStatusLabel(m))
+	viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), false)
+
+	// maintenance mode rejections are labeled with their 503
+	viper.Set(flagPluginsAPIKeyMaintenanceMode.GetLong(), true)
+	m = &metrics.Metrics{}
+	err = Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+	assert.Equal("503", getDeniedStatusLabel(m))
+}
+
+func TestOnRequestNilSpan(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestBaggageConfig()()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.NotPanics(func() {
+		assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, nil))
+	}, "requests should be authorized without a span")
+	assert.NotPanics(func() {
+		assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, nil))
+	}, "repeated decisions should be returned without a span")
+	assert.NotPanics(func() {
+		err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), nil)
+		assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	}, "requests should be denied without a span")
 }
 
 func TestOnResponse(t *testing.T) {
@@ -111,161 +246,122 @@ func TestOnResponse(t *testing.T) {
 func TestValidateAPIKey(t *testing.T) {
 	assert := assert.New(t)
 
-	assert.True(validateAPIKey(spec.Rule{
-		Global: true,
-		Granular: &spec.GranularProxy{
-			Verbs: []string{},
-		},
-	}, "GET"), "rule should be authorized")
-
-	assert.True(validateAPIKey(spec.Rule{
-		Global: true,
-		Granular: &spec.GranularProxy{
-			Verbs: []string{
-				"GET",
-			},
-		},
-	}, "GET"), "rule should be authorized")
-
-	assert.True(validateAPIKey(spec.Rule{
-		Global: false,
-		Granular: &spec.GranularProxy{
-			Verbs: []string{
-				"GET",
-			},
-		},
-	}, "GET"), "rule should be authorized")
-
-	assert.True(validateAPIKey(spec.Rule{
-		Global: true,
-		Granular: &spec.GranularProxy{
-			Verbs: []string{
-				"POST",
-			},
-		},
-	}, "GET"), "rule should be authorized")
+	tests := []struct {
+		rule       spec.Rule
+		method     string
+		authorized bool
+	}{
+		{spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{}}}, "GET", true},
+		{spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}, "GET", true},
+		{spec.Rule{Global: false, Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}, "GET", true},
+		{spec.Rule{Global: true, Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}, "GET", true},
+		{spec.Rule{Global: false, Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}, "GET", false},
+	}
 
-	assert.False(validateAPIKey(spec.Rule{
-		Global: false,
-		Granular: &spec.GranularProxy{
-			Verbs: []string{
-				"POST",
-			},
-		},
-	}, "GET"), "rule should not be authorized")
+	for i, test := range tests {
+		assert.Equal(test.authorized, validateAPIKey(test.rule, test.method), "case %d", i)
+	}
 }
 
 func TestValidateGranularRules(t *testing.T) {
 	assert := assert.New(t)
 
-	assert.True(validateGranularRules("GET", &spec.GranularProxy{
-		Verbs: []string{
-			"GET",
-		},
-	}), "http method should be authorized")
-
-	assert.True(validateGranularRules("get", &spec.GranularProxy{
-		Verbs: []string{
-			"GET",
-			"POST",
-			"PUT",
-		},
-	}), "http method should be authorized")
-
-	assert.True(validateGranularRules("put", &spec.GranularProxy{
-		Verbs: []string{
-			"GET",
-			"POST",
-			"PUT",
-		},
-	}), "http method should be authorized")
+	tests := []struct {
+		method     string
+		verbs      []string
+		authorized bool
+	}{
+		{"GET", []string{"GET"}, true},
+		{"get", []string{"GET", "POST", "PUT"}, true},
+		{"put", []string{"GET", "POST", "PUT"}, true},
+		{"GET", []string{"POST"}, false},
+		{"get", []string{"POST", "PUT"}, false},
+		{"", []string{"POST"}, false},
+		{"HTTP", []string{"POST"}, false},
+	}
 
-	assert.False(validateGranularRules("GET", &spec.GranularProxy{
-		Verbs: []string{
-			"POST",
-		},
-	}), "http method should be authorized")
+	for _, test := range tests {
+		assert.Equal(test.authorized, validateGranularRules(test.method, &spec.GranularProxy{Verbs: test.verbs}), "%s %v", test.method, test.verbs)
+	}
+}
 
-	assert.False(validateGranularRules("get", &spec.GranularProxy{
-		Verbs: []string{
-			"POST",
-			"PUT",
-		},
-	}), "http method should be authorized")
+func TestValidateGranularRulesEmptyVerbs(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong(), false)
 
-	assert.False(validateGranularRules("", &spec.GranularProxy{
-		Verbs: []string{
-			"POST",
-		},
-	}), "http method should be authorized")
+	viper.Set(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong(), false)
+	assert.False(validateGranularRules("GET", &spec.GranularProxy{}), "empty verbs should permit no http method by default")
+	assert.False(validateGranularRules("GET", &spec.GranularProxy{Verbs: []string{}}), "empty verbs should permit no http method by default")
 
-	assert.False(validateGranularRules("HTTP", &spec.GranularProxy{
-		Verbs: []string{
-			"POST",
-		},
-	}), "http method should be authorized")
+	viper.Set(flagPluginsAPIKeyEmptyVerbsMeansAll.GetLong(), true)
+	assert.True(validateGranularRules("GET", &spec.GranularProxy{}), "empty verbs should permit every http method")
+	assert.True(validateGranularRules("delete", &spec.GranularProxy{Verbs: []string{}}), "empty verbs should permit every http method")
+	assert.False(validateGranularRules("GET", &spec.GranularProxy{Verbs: []string{"POST"}}), "non empty verbs should be unaffected")
+	assert.False(validateGranularRules("GET", nil), "missing granular rules should be unaffected")
 }
 
 func getTestAPIProxy() spec.APIProxy {
-
-	return spec.APIProxy{
-		TypeMeta: unversioned.TypeMeta{},
-		ObjectMeta: api.ObjectMeta{
-			Name:      "APIProxyone",
-			Namespace: "foo",
-		},
-		Spec: spec.APIProxySpec{
-			Path:   "/api/v1/accounts",
-			Target: "/",
-			Service: spec.Service{
-				Name:      "my-service",
-				Namespace: "foo",
-				Port:      8080,
-			},
-			Plugins: []spec.Plugin{
-				{
-					Name: "apikey",
-				},
-			},
-		},
-	}
-
+	return testutil.Proxy()
 }
 
 func getTestAPIKey() spec.APIKey {
+	return testutil.Key()
+}
 
-	return spec.APIKey{
-		TypeMeta: unversioned.TypeMeta{},
-		ObjectMeta: api.ObjectMeta{
-			Name:      "apikeyone",
-			Namespace: "foo",
-		},
-		Spec: spec.APIKeySpec{
-			APIKeyData: "myapikey",
-		},
-	}
+func getTestAPIKeyBinding() spec.APIKeyBinding {
+	return testutil.Binding()
+}
 
+func getTestRequest() *http.Request {
+	return testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
 }
 
-func getTestAPIKeyBinding() spec.APIKeyBinding {
+// benchmarkStore is an in memory store of apikeys or bindings
+// used in place of the global stores by benchmarks
+type benchmarkStore map[string]interface{}
 
-	return spec.APIKeyBinding{
-		TypeMeta: unversioned.TypeMeta{},
-		ObjectMeta: api.ObjectMeta{
-			Name:      "apikeybindingone",
-			Namespace: "foo",
-		},
-		Spec: spec.APIKeyBindingSpec{
-			APIProxyName: "APIProxyone",
-			Keys: []spec.Key{
-				{
-					Name: "apikeyone",
-					DefaultRule: spec.Rule{
-						Global: true,
-					},
-				},
-			},
-		},
+func (s benchmarkStore) Get(params ...interface{}) (interface{}, error) {
+	if len(params) < 1 {
+		return nil, errors.New("expected at least one parameter")
+	}
+	name, _ := params[0].(string)
+	if obj, ok := s[name]; ok {
+		return obj, nil
 	}
+	return nil, nil
+}
 
+func BenchmarkOnRequest(b *testing.B) {
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer func(keys keyStore, bindings bindingGetter) {
+		localKeyStore, localBindingStore = keys, bindings
+	}(localKeyStore, localBindingStore)
+	localKeyStore = benchmarkStore{testutil.KeyData: testutil.Key()}
+	localBindingStore = benchmarkStore{testutil.ProxyName: testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))}
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+	logrus.SetOutput(ioutil.Discard)
+
+	proxy := testutil.Proxy()
+	span := testutil.Span()
+	benchmarks := []struct {
+		name   string
+		method string
+		apiKey string
+	}{
+		{"success", "GET", testutil.KeyData},
+		{"key-not-found", "GET", "unknownapikey"},
+		{"method-denied", "POST", testutil.KeyData},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			m := &metrics.Metrics{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				*m = (*m)[:0]
+				Plugin.OnRequest(context.Background(), m, proxy, testutil.Request(benchmark.method, testutil.ProxyPath, benchmark.apiKey), span)
+			}
+		})
+	}
 }
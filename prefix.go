@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyKeyPrefixes,
+		flagPluginsAPIKeyStripKeyPrefixes,
+	)
+}
+
+var (
+	flagPluginsAPIKeyKeyPrefixes = config.Flag{
+		Long:  "plugins.apiKey.key_prefixes",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of prefixes an apikey must start with. Keys are not checked if empty.",
+	}
+	flagPluginsAPIKeyStripKeyPrefixes = config.Flag{
+		Long:  "plugins.apiKey.strip_key_prefixes",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of prefixes that are removed from an apikey before it is looked up in a store.",
+	}
+)
+
+// hasAllowedPrefix will return true if the given apikey starts with one of
+// the configured prefixes. This allows malformed keys to be rejected
+// without a store lookup. If no prefixes are configured, every key is allowed.
+func hasAllowedPrefix(apiKey string) bool {
+	prefixes := getStringSlice(flagPluginsAPIKeyKeyPrefixes.GetLong())
+	if len(prefixes) < 1 {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(apiKey, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripKeyPrefix returns the given apikey without the longest configured
+// prefix it starts with, along with the prefix that was removed. Keys that
+// do not start with a configured prefix are returned unchanged.
+func stripKeyPrefix(apiKey string) (string, string) {
+	var stripped string
+	for _, prefix := range getStringSlice(flagPluginsAPIKeyStripKeyPrefixes.GetLong()) {
+		if len(prefix) > len(stripped) && len(prefix) < len(apiKey) && strings.HasPrefix(apiKey, prefix) {
+			stripped = prefix
+		}
+	}
+	return strings.TrimPrefix(apiKey, stripped), stripped
+}
+
+// setAPIKeyPrefix stores the prefix stripped from the apikey
+// of the given request in the request's context
+func setAPIKeyPrefix(r *http.Request, prefix string) {
+	*r = *r.WithContext(context.WithValue(r.Context(), ContextKeyAPIKeyPrefix, prefix))
+}
+
+// getAPIKeyPrefix retrieves the prefix stripped from the apikey of the
+// given request. An empty string is returned if no prefix was stripped.
+func getAPIKeyPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(ContextKeyAPIKeyPrefix).(string)
+	return prefix
+}
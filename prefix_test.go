@@ -0,0 +1,139 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasAllowedPrefix(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyKeyPrefixes.GetLong(), "")
+
+	viper.Set(flagPluginsAPIKeyKeyPrefixes.GetLong(), "")
+	assert.True(hasAllowedPrefix("myapikey"), "every key should be allowed when no prefixes are configured")
+
+	viper.Set(flagPluginsAPIKeyKeyPrefixes.GetLong(), "ak_, pk_")
+	assert.True(hasAllowedPrefix("ak_123"))
+	assert.True(hasAllowedPrefix("pk_123"))
+	assert.False(hasAllowedPrefix("sk_123"))
+	assert.False(hasAllowedPrefix("AK_123"))
+	assert.False(hasAllowedPrefix("ak"))
+}
+
+func TestOnRequestInvalidPrefix(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyKeyPrefixes.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "")
+	defer resetFederatedStore("")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	lookups := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	resetFederatedStore(server.URL)
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), keyStoreFederated)
+	viper.Set(flagPluginsAPIKeyKeyPrefixes.GetLong(), "ak_")
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("apikey is malformed", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_invalid_prefix", "true", true})
+	assert.Equal(0, lookups, "malformed keys should not reach a store")
+
+	r := getTestRequest()
+	r.Header.Set("apikey", "ak_myapikey")
+	m = &metrics.Metrics{}
+	err = Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal("apikey not found in k8s cluster", err.Error())
+	assert.NotContains(*m, metrics.Metric{"api_key_invalid_prefix", "true", true})
+	assert.Equal(1, lookups)
+}
+
+func TestStripKeyPrefix(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyStripKeyPrefixes.GetLong(), "")
+
+	key, prefix := stripKeyPrefix("prod_myapikey")
+	assert.Equal("prod_myapikey", key, "keys should be unchanged when no prefixes are configured")
+	assert.Equal("", prefix)
+
+	viper.Set(flagPluginsAPIKeyStripKeyPrefixes.GetLong(), "prod_,prod_eu_,test_")
+	key, prefix = stripKeyPrefix("prod_myapikey")
+	assert.Equal("myapikey", key)
+	assert.Equal("prod_", prefix)
+
+	key, prefix = stripKeyPrefix("prod_eu_myapikey")
+	assert.Equal("myapikey", key, "the longest matching prefix should be stripped")
+	assert.Equal("prod_eu_", prefix)
+
+	key, prefix = stripKeyPrefix("myapikey")
+	assert.Equal("myapikey", key)
+	assert.Equal("", prefix)
+
+	key, prefix = stripKeyPrefix("prod_")
+	assert.Equal("prod_", key, "a key should never be stripped to nothing")
+	assert.Equal("", prefix)
+}
+
+func TestOnRequestStripKeyPrefix(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyStripKeyPrefixes.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyStripKeyPrefixes.GetLong(), "prod_")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+	hook := test.NewGlobal()
+
+	r := getTestRequest()
+	r.Header.Set("apikey", "prod_myapikey")
+	m := &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.Equal("prod_", getAPIKeyPrefix(r))
+	assert.Equal("apikeyone", getAPIKeyName(r))
+	assert.Contains(*m, metrics.Metric{"api_key_prefix", "prod_", true})
+
+	r = getTestRequest()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")), "keys without a prefix should still be found")
+	assert.Equal("", getAPIKeyPrefix(r))
+
+	hook.Reset()
+	r = getTestRequest()
+	r.Header.Set("apikey", "prod_unknown")
+	assert.NotNil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.Equal("prod_", hook.LastEntry().Data["key_prefix"])
+}
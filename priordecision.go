@@ -0,0 +1,55 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/spec"
+)
+
+// priorDecision is the outcome of an earlier invocation of OnRequest for
+// the same request. A nil err is an authorized request.
+type priorDecision struct {
+	proxyName      string
+	proxyNamespace string
+	err            error
+}
+
+// setPriorDecision stores the outcome of OnRequest for the given proxy in
+// the context of the given request so that it can be returned if OnRequest
+// is invoked again
+func setPriorDecision(r *http.Request, p spec.APIProxy, err error) {
+	decision := priorDecision{p.ObjectMeta.Name, p.ObjectMeta.Namespace, err}
+	*r = *r.WithContext(context.WithValue(r.Context(), contextKeyPriorDecision, decision))
+}
+
+// getPriorDecision retrieves the outcome of an earlier invocation of
+// OnRequest for the given request and proxy. The returned bool is false if
+// no decision has been made, or if it was made for a different proxy.
+func getPriorDecision(r *http.Request, p spec.APIProxy) (priorDecision, bool) {
+	decision, ok := r.Context().Value(contextKeyPriorDecision).(priorDecision)
+	if !ok || decision.proxyName != p.ObjectMeta.Name || decision.proxyNamespace != p.ObjectMeta.Namespace {
+		return priorDecision{}, false
+	}
+	return decision, true
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPriorDecision(t *testing.T) {
+	assert := assert.New(t)
+	p := testutil.Proxy()
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+
+	_, ok := getPriorDecision(r, p)
+	assert.False(ok)
+
+	setPriorDecision(r, p, nil)
+	decision, ok := getPriorDecision(r, p)
+	assert.True(ok)
+	assert.Nil(decision.err, "authorized requests should be remembered")
+
+	err := &utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request")}
+	setPriorDecision(r, p, err)
+	decision, ok = getPriorDecision(r, p)
+	assert.True(ok)
+	assert.Equal(err, decision.err)
+
+	other := testutil.Proxy()
+	other.ObjectMeta.Name = "APIProxytwo"
+	_, ok = getPriorDecision(r, other)
+	assert.False(ok, "decisions should not be shared between proxies")
+}
+
+func TestOnRequestPriorDecision(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyDecisionIDHeader.GetLong(), "X-Decision-Id")
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding(testutil.GlobalKey(testutil.KeyName))})()
+
+	// an authorized request stays authorized even if its key is deleted in between
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	id := getDecisionID(r)
+	spec.KeyStore.Clear()
+	m := &metrics.Metrics{}
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), r, span))
+	assert.Contains(*m, metrics.Metric{"api_key_prior_decision", "true", false})
+	assert.Equal(id, getDecisionID(r), "a second invocation should not make a new decision")
+	assert.Equal(id, span.Tag("kanali.decision_id"))
+
+	// a denied request returns the same error, without being counted again
+	r = testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	first := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(first))
+	m = &metrics.Metrics{}
+	second := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), r, testutil.Span())
+	assert.Equal(first, second)
+	assert.NotContains(*m, metrics.Metric{"api_key_denied", "true", true})
+
+	// a new request is decided again
+	m = &metrics.Metrics{}
+	Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Contains(*m, metrics.Metric{"api_key_denied", "true", true})
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyProcessingTimeMetric,
+		flagPluginsAPIKeyProcessingTimeWindow,
+	)
+}
+
+var (
+	flagPluginsAPIKeyProcessingTimeMetric = config.Flag{
+		Long:  "plugins.apiKey.processing_time_metric",
+		Short: "",
+		Value: "",
+		Usage: "Type of the metric recording the time OnRequest takes per binding. Either histogram, recording each observation, or summary, publishing the p50, p95, and p99 of recent observations with expvar. Disabled if empty.",
+	}
+	flagPluginsAPIKeyProcessingTimeWindow = config.Flag{
+		Long:  "plugins.apiKey.processing_time_window",
+		Short: "",
+		Value: 1000,
+		Usage: "Number of recent observations per binding that processing time summaries are computed over.",
+	}
+)
+
+const (
+	processingTimeHistogram = "histogram"
+	processingTimeSummary   = "summary"
+)
+
+// processingTimeQuantiles are the quantiles reported by processing time
+// summaries, keyed by the suffix of their metric name
+var processingTimeQuantiles = []struct {
+	name     string
+	quantile float64
+}{
+	{"p50", 0.5},
+	{"p95", 0.95},
+	{"p99", 0.99},
+}
+
+// processingTimeWindow holds the most recent observations of a
+// binding in a ring so that memory is bounded by the window size
+type processingTimeWindow struct {
+	observations []int64
+	next         int
+}
+
+var processingTimes = struct {
+	sync.Mutex
+	windows map[string]*processingTimeWindow
+}{windows: map[string]*processingTimeWindow{}}
+
+// observeProcessingTime adds an observation, in microseconds,
+// to the window of the given binding
+func observeProcessingTime(binding string, micros int64, size int) {
+	if size < 1 {
+		size = 1
+	}
+
+	processingTimes.Lock()
+	defer processingTimes.Unlock()
+	w, ok := processingTimes.windows[binding]
+	if !ok {
+		w = &processingTimeWindow{}
+		processingTimes.windows[binding] = w
+	}
+	if len(w.observations) < size {
+		w.observations = append(w.observations, micros)
+	} else {
+		// the window may have shrunk since it was filled
+		w.observations = w.observations[:size]
+		w.observations[w.next%size] = micros
+	}
+	w.next = (w.next + 1) % size
+}
+
+// getProcessingTimeQuantiles returns the current quantiles, in microseconds,
+// of the window of every binding, keyed by binding and quantile name. The
+// observations are only sorted here, when the quantiles are read, so that
+// recording an observation stays cheap.
+func getProcessingTimeQuantiles() map[string]map[string]int64 {
+	processingTimes.Lock()
+	windows := make(map[string][]int64, len(processingTimes.windows))
+	for binding, w := range processingTimes.windows {
+		windows[binding] = append([]int64{}, w.observations...)
+	}
+	processingTimes.Unlock()
+
+	summaries := make(map[string]map[string]int64, len(windows))
+	for binding, sorted := range windows {
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		summary := make(map[string]int64, len(processingTimeQuantiles))
+		for _, q := range processingTimeQuantiles {
+			summary[q.name] = getQuantile(sorted, q.quantile)
+		}
+		summaries[binding] = summary
+	}
+	return summaries
+}
+
+// publishProcessingTimeQuantiles publishes the processing time quantiles
+// under the processing_time_us entry of the configured expvar, computed
+// each time the expvar is read
+func publishProcessingTimeQuantiles() {
+	vars := getDecisionVars()
+	if vars == nil || vars.Get(expvarProcessingTime) != nil {
+		return
+	}
+	vars.Set(expvarProcessingTime, expvar.Func(func() interface{} {
+		return getProcessingTimeQuantiles()
+	}))
+}
+
+// getQuantile returns the given quantile of the given sorted
+// observations using the nearest rank method
+func getQuantile(sorted []int64, quantile float64) int64 {
+	if len(sorted) < 1 {
+		return 0
+	}
+	rank := int(quantile*float64(len(sorted)) + 0.999999)
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// recordProcessingTime will, if enabled, record the time elapsed since the
+// plugin began processing the given request, labeled by the binding that
+// was consulted for it. It is deferred by OnRequest.
+func recordProcessingTime(m *metrics.Metrics, r *http.Request, start time.Time) {
+	metricType := strings.ToLower(strings.TrimSpace(viper.GetString(flagPluginsAPIKeyProcessingTimeMetric.GetLong())))
+	if metricType == "" {
+		return
+	}
+
+	binding := getBindingID(r)
+	if binding == "" {
+		binding = "unknown"
+	}
+	micros := int64(time.Since(start) / time.Microsecond)
+
+	switch metricType {
+	case processingTimeHistogram:
+		m.Add(metrics.Metric{"api_key_processing_binding", binding, true})
+		m.Add(metrics.Metric{"api_key_processing_us", strconv.FormatInt(micros, 10), false})
+	case processingTimeSummary:
+		observeProcessingTime(binding, micros, viper.GetInt(flagPluginsAPIKeyProcessingTimeWindow.GetLong()))
+		publishProcessingTimeQuantiles()
+	default:
+		logrus.Warnf("unknown %s %s - processing time will not be recorded", flagPluginsAPIKeyProcessingTimeMetric.GetLong(), metricType)
+	}
+}
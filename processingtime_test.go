@@ -0,0 +1,127 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// getMetric returns the value of the metric with the given name, if any
+func getMetric(m *metrics.Metrics, name string) (string, bool) {
+	for _, metric := range *m {
+		if metric.Name == name {
+			return metric.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestGetQuantile(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(int64(0), getQuantile([]int64{}, 0.5))
+	assert.Equal(int64(7), getQuantile([]int64{7}, 0.99))
+
+	sorted := make([]int64, 100)
+	for i := range sorted {
+		sorted[i] = int64(i + 1)
+	}
+	assert.Equal(int64(50), getQuantile(sorted, 0.5))
+	assert.Equal(int64(95), getQuantile(sorted, 0.95))
+	assert.Equal(int64(99), getQuantile(sorted, 0.99))
+}
+
+func TestObserveProcessingTime(t *testing.T) {
+	assert := assert.New(t)
+
+	for i := int64(1); i <= 101; i++ {
+		observeProcessingTime("test/observe", i, 100)
+	}
+	observeProcessingTime("test/other", 7, 100)
+	quantiles := getProcessingTimeQuantiles()
+	assert.Equal(map[string]int64{"p50": 51, "p95": 96, "p99": 100}, quantiles["test/observe"], "the oldest observation should be replaced")
+	assert.Equal(map[string]int64{"p50": 7, "p95": 7, "p99": 7}, quantiles["test/other"], "bindings should be observed separately")
+
+	observeProcessingTime("test/observe", 1000, 1)
+	assert.Equal(map[string]int64{"p50": 1000, "p95": 1000, "p99": 1000}, getProcessingTimeQuantiles()["test/observe"], "a shrunk window should only hold recent observations")
+	processingTimes.Lock()
+	assert.Equal(1, len(processingTimes.windows["test/observe"].observations))
+	processingTimes.Unlock()
+}
+
+func TestOnRequestProcessingTime(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyProcessingTimeMetric.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyProcessingTimeWindow.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyExpvarName.GetLong(), "test_on_request_processing_time")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	request := func() *metrics.Metrics {
+		m := &metrics.Metrics{}
+		assert.Nil(Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+		return m
+	}
+
+	m := request()
+	_, ok := getMetric(m, "api_key_processing_binding")
+	assert.False(ok, "processing time should not be recorded unless enabled")
+
+	viper.Set(flagPluginsAPIKeyProcessingTimeMetric.GetLong(), processingTimeHistogram)
+	m = request()
+	assert.Contains(*m, metrics.Metric{"api_key_processing_binding", testutil.Namespace + "/" + testutil.Binding().ObjectMeta.Name, true})
+	value, ok := getMetric(m, "api_key_processing_us")
+	assert.True(ok)
+	_, err := strconv.ParseInt(value, 10, 64)
+	assert.Nil(err)
+	_, ok = getMetric(m, "api_key_processing_p50_us")
+	assert.False(ok, "histograms should not record quantiles")
+
+	viper.Set(flagPluginsAPIKeyProcessingTimeMetric.GetLong(), processingTimeSummary)
+	viper.Set(flagPluginsAPIKeyProcessingTimeWindow.GetLong(), 10)
+	for i := 0; i < 20; i++ {
+		m = request()
+	}
+	_, ok = getMetric(m, "api_key_processing_p50_us")
+	assert.False(ok, "summaries should not be added to the request's metrics")
+	summary, ok := getDecisionVars().Get(expvarProcessingTime).(expvar.Func)
+	if assert.True(ok, "summaries should be published") {
+		assert.Contains(summary.String(), `"p99"`)
+	}
+	processingTimes.Lock()
+	assert.Equal(10, len(processingTimes.windows[testutil.Namespace+"/"+testutil.Binding().ObjectMeta.Name].observations), "observations should be bounded by the window")
+	processingTimes.Unlock()
+
+	viper.Set(flagPluginsAPIKeyProcessingTimeMetric.GetLong(), processingTimeHistogram)
+	m = &metrics.Metrics{}
+	Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, ""), testutil.Span())
+	assert.Contains(*m, metrics.Metric{"api_key_processing_binding", "unknown", true}, "requests denied before a binding was consulted should be recorded")
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyQuotaWindow,
+		flagPluginsAPIKeyQuotaTimezone,
+		flagPluginsAPIKeyQuotaMaxEntries,
+	)
+}
+
+var (
+	flagPluginsAPIKeyQuotaWindow = config.Flag{
+		Long:  "plugins.apiKey.quota_window",
+		Short: "",
+		Value: "",
+		Usage: "Calendar window quotas are granted for, either day or month. Quotas are counted over the lifetime of Kanali if empty.",
+	}
+	flagPluginsAPIKeyQuotaTimezone = config.Flag{
+		Long:  "plugins.apiKey.quota_timezone",
+		Short: "",
+		Value: "UTC",
+		Usage: "IANA time zone, such as America/Chicago, whose midnight starts each calendar quota window.",
+	}
+	flagPluginsAPIKeyQuotaMaxEntries = config.Flag{
+		Long:  "plugins.apiKey.quota_max_entries",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of apikeys whose calendar quota usage is tracked at once. Usage of earlier windows is discarded first, then the lowest usage, when full.",
+	}
+)
+
+const (
+	quotaWindowDay   = "day"
+	quotaWindowMonth = "month"
+)
+
+// annotationBindingQuotaWindow is the APIKeyBinding annotation holding the
+// calendar window, either day or month, its quotas are granted for
+const annotationBindingQuotaWindow = "apikey.kanali.io/quota-window"
+
+// calendarQuotas holds the usage of every API key with a calendar quota
+// window seen by this Kanali instance. Kanali's traffic store only counts
+// lifetime quotas, so calendar quotas are counted per instance, are not
+// shared between replicas, and start over when Kanali restarts.
+var calendarQuotas = newCalendarQuotaCounter()
+
+// calendarQuotaUsage is the number of requests made during a single
+// calendar period, identified by its local date, such as 2017-10 or
+// 2017-10-16, and the time at which the next period begins
+type calendarQuotaUsage struct {
+	period string
+	next   time.Time
+	count  int
+}
+
+// calendarQuotaCounter records the usage of the current calendar period
+// by an arbitrary identifier. Usage of earlier periods is discarded.
+type calendarQuotaCounter struct {
+	mutex sync.Mutex
+	usage map[string]calendarQuotaUsage
+}
+
+func newCalendarQuotaCounter() *calendarQuotaCounter {
+	return &calendarQuotaCounter{
+		usage: map[string]calendarQuotaUsage{},
+	}
+}
+
+// add records a request for the given identifier during the given
+// period, which ends at next, making room for it if necessary
+func (c *calendarQuotaCounter) add(id, period string, next, currTime time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	usage, ok := c.usage[id]
+	if !ok {
+		c.makeRoom(viper.GetInt(flagPluginsAPIKeyQuotaMaxEntries.GetLong()), currTime)
+	}
+	if usage.period != period {
+		usage = calendarQuotaUsage{period: period, next: next}
+	}
+	usage.count++
+	c.usage[id] = usage
+}
+
+// makeRoom ensures that there is room for another identifier by removing
+// the usage of earlier periods or, if every period is current, the lowest
+// usage. It must be called with the lock held.
+func (c *calendarQuotaCounter) makeRoom(max int, currTime time.Time) {
+	if max <= 0 || len(c.usage) < max {
+		return
+	}
+
+	var lowest string
+	for id, usage := range c.usage {
+		if !currTime.Before(usage.next) {
+			delete(c.usage, id)
+			continue
+		}
+		if lowest == "" || usage.count < c.usage[lowest].count {
+			lowest = id
+		}
+	}
+	if len(c.usage) >= max && lowest != "" {
+		delete(c.usage, lowest)
+	}
+}
+
+// count returns the number of requests recorded for the given
+// identifier during the given period
+func (c *calendarQuotaCounter) count(id, period string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if usage := c.usage[id]; usage.period == period {
+		return usage.count
+	}
+	return 0
+}
+
+// getQuotaWindow returns the calendar window quotas of the given binding
+// are granted for. The binding annotation takes priority over the
+// configured window. An empty string is returned for the lifetime quotas
+// tracked by Kanali, including when the window is unknown.
+func getQuotaWindow(binding spec.APIKeyBinding) string {
+	window, ok := binding.ObjectMeta.Annotations[annotationBindingQuotaWindow]
+	if !ok {
+		window = viper.GetString(flagPluginsAPIKeyQuotaWindow.GetLong())
+	}
+
+	switch window = strings.ToLower(strings.TrimSpace(window)); window {
+	case "", quotaWindowDay, quotaWindowMonth:
+		return window
+	default:
+		logrus.WithFields(logrus.Fields{
+			"binding":   binding.ObjectMeta.Name,
+			"namespace": binding.ObjectMeta.Namespace,
+		}).Warnf("unknown quota window %s will be ignored", window)
+		return ""
+	}
+}
+
+// quotaLocation caches the time zone of calendar quota
+// windows until the configured time zone changes
+var quotaLocation = struct {
+	sync.Mutex
+	name string
+	loc  *time.Location
+}{}
+
+// getQuotaLocation returns the configured time zone of calendar quota
+// windows. UTC is used if the time zone is empty or unknown.
+func getQuotaLocation() *time.Location {
+	name := viper.GetString(flagPluginsAPIKeyQuotaTimezone.GetLong())
+	if name == "" {
+		return time.UTC
+	}
+
+	quotaLocation.Lock()
+	defer quotaLocation.Unlock()
+	if quotaLocation.loc != nil && quotaLocation.name == name {
+		return quotaLocation.loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		logrus.Warnf("unknown quota time zone %s - UTC will be used: %s", name, err.Error())
+		loc = time.UTC
+	}
+	quotaLocation.name, quotaLocation.loc = name, loc
+	return loc
+}
+
+// getQuotaPeriod returns the calendar period of the given window that the
+// given time falls into, and the time at which the next period begins.
+// Periods are identified by their local date so that days that are 23 or
+// 25 hours long, because of a daylight saving time transition, are still
+// counted as a single day.
+func getQuotaPeriod(window string, loc *time.Location, currTime time.Time) (string, time.Time) {
+	local := currTime.In(loc)
+
+	format := "2006-01-02"
+	next := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+	if window == quotaWindowMonth {
+		format = "2006-01"
+		next = time.Date(local.Year(), local.Month()+1, 1, 0, 0, 0, 0, loc)
+	}
+	period := local.Format(format)
+
+	// where a transition skips midnight, the next period begins at the
+	// transition, which time.Date may place before it
+	for !next.After(currTime) || next.In(loc).Format(format) == period {
+		next = next.Add(time.Hour)
+	}
+	return period, next
+}
+
+// getQuotaTrafficID scopes the quota usage of a key to the APIProxy of a binding
+func getQuotaTrafficID(binding spec.APIKeyBinding, key spec.APIKey) string {
+	return fmt.Sprintf("%s/%s/%s", binding.ObjectMeta.Namespace, binding.Spec.APIProxyName, key.ObjectMeta.Name)
+}
+
+// checkQuota returns a 429 error if the given api key has used its quota.
+// Keys whose binding grants quotas for a calendar window are measured
+// against their usage during the current period, and the error asks them
+// to retry when the next one begins. All other keys fall
+// back to the lifetime quota tracked by Kanali.
+func checkQuota(binding spec.APIKeyBinding, key spec.APIKey, keyObj *spec.Key, currTime time.Time) error {
+	err := &utils.StatusError{http.StatusTooManyRequests, errors.New("quota limit reached. please contact your administrator")}
+
+	window := getQuotaWindow(binding)
+	if window == "" {
+		if spec.TrafficStore.IsQuotaViolated(binding, key.ObjectMeta.Name) {
+			return err
+		}
+		return nil
+	}
+
+	if keyObj == nil || keyObj.Quota < 1 {
+		return nil
+	}
+
+	period, next := getQuotaPeriod(window, getQuotaLocation(), currTime)
+	if calendarQuotas.count(getQuotaTrafficID(binding, key), period) < keyObj.Quota {
+		return nil
+	}
+	retryAfter := int(math.Ceil(next.Sub(currTime).Seconds()))
+	return withRetryAfter(err, retryAfter)
+}
+
+// recordQuotaTraffic accounts for a request against the calendar quota of
+// the given api key. Keys without a calendar quota window are ignored.
+func recordQuotaTraffic(binding spec.APIKeyBinding, key spec.APIKey, currTime time.Time) {
+	window := getQuotaWindow(binding)
+	if window == "" {
+		return
+	}
+	period, next := getQuotaPeriod(window, getQuotaLocation(), currTime)
+	calendarQuotas.add(getQuotaTrafficID(binding, key), period, next, currTime)
+}
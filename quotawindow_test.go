@@ -0,0 +1,301 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetQuotaWindow() {
+	viper.Set(flagPluginsAPIKeyQuotaWindow.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyQuotaTimezone.GetLong(), "")
+	calendarQuotas = newCalendarQuotaCounter()
+}
+
+func getTestQuotaBinding(window string, quota int) spec.APIKeyBinding {
+	key := testutil.GlobalKey(testutil.KeyName)
+	key.Quota = quota
+	binding := testutil.Binding(key)
+	if window != "" {
+		binding.ObjectMeta.Annotations = map[string]string{annotationBindingQuotaWindow: window}
+	}
+	return binding
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestGetQuotaWindow(t *testing.T) {
+	assert := assert.New(t)
+	defer resetQuotaWindow()
+
+	assert.Equal("", getQuotaWindow(getTestQuotaBinding("", 1)))
+	assert.Equal(quotaWindowDay, getQuotaWindow(getTestQuotaBinding(" Day ", 1)))
+	assert.Equal("", getQuotaWindow(getTestQuotaBinding("week", 1)), "unknown windows should be ignored")
+
+	viper.Set(flagPluginsAPIKeyQuotaWindow.GetLong(), "month")
+	assert.Equal(quotaWindowMonth, getQuotaWindow(getTestQuotaBinding("", 1)))
+	assert.Equal(quotaWindowDay, getQuotaWindow(getTestQuotaBinding("day", 1)), "the binding annotation should take priority")
+}
+
+func TestGetQuotaLocation(t *testing.T) {
+	assert := assert.New(t)
+	defer resetQuotaWindow()
+
+	assert.Equal(time.UTC, getQuotaLocation())
+	viper.Set(flagPluginsAPIKeyQuotaTimezone.GetLong(), "America/Chicago")
+	assert.Equal("America/Chicago", getQuotaLocation().String())
+	viper.Set(flagPluginsAPIKeyQuotaTimezone.GetLong(), "Mars/Olympus_Mons")
+	assert.Equal(time.UTC, getQuotaLocation())
+}
+
+func TestGetQuotaPeriod(t *testing.T) {
+	assert := assert.New(t)
+	chicago := mustLoadLocation(t, "America/Chicago")
+	newYork := mustLoadLocation(t, "America/New_York")
+	saoPaulo := mustLoadLocation(t, "America/Sao_Paulo")
+
+	tests := []struct {
+		name     string
+		window   string
+		loc      *time.Location
+		currTime time.Time
+		period   string
+		next     time.Time
+	}{
+		{
+			name:     "daily in utc",
+			window:   quotaWindowDay,
+			loc:      time.UTC,
+			currTime: time.Date(2017, 10, 16, 12, 0, 0, 0, time.UTC),
+			period:   "2017-10-16",
+			next:     time.Date(2017, 10, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "the last instant of a day",
+			window:   quotaWindowDay,
+			loc:      time.UTC,
+			currTime: time.Date(2017, 10, 16, 23, 59, 59, 999999999, time.UTC),
+			period:   "2017-10-16",
+			next:     time.Date(2017, 10, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "midnight starts a new day",
+			window:   quotaWindowDay,
+			loc:      time.UTC,
+			currTime: time.Date(2017, 10, 17, 0, 0, 0, 0, time.UTC),
+			period:   "2017-10-17",
+			next:     time.Date(2017, 10, 18, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "days follow the configured time zone",
+			window:   quotaWindowDay,
+			loc:      chicago,
+			currTime: time.Date(2017, 10, 17, 3, 0, 0, 0, time.UTC),
+			period:   "2017-10-16",
+			next:     time.Date(2017, 10, 17, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "a 23 hour day when daylight saving time begins",
+			window:   quotaWindowDay,
+			loc:      newYork,
+			currTime: time.Date(2017, 3, 12, 1, 0, 0, 0, newYork),
+			period:   "2017-03-12",
+			next:     time.Date(2017, 3, 12, 1, 0, 0, 0, newYork).Add(22 * time.Hour),
+		},
+		{
+			name:     "a 25 hour day when daylight saving time ends",
+			window:   quotaWindowDay,
+			loc:      newYork,
+			currTime: time.Date(2017, 11, 5, 1, 0, 0, 0, newYork),
+			period:   "2017-11-05",
+			next:     time.Date(2017, 11, 5, 1, 0, 0, 0, newYork).Add(24 * time.Hour),
+		},
+		{
+			name:     "daylight saving time begins at midnight",
+			window:   quotaWindowDay,
+			loc:      saoPaulo,
+			currTime: time.Date(2018, 11, 3, 23, 30, 0, 0, saoPaulo),
+			period:   "2018-11-03",
+			next:     time.Date(2018, 11, 4, 1, 0, 0, 0, saoPaulo),
+		},
+		{
+			name:     "monthly",
+			window:   quotaWindowMonth,
+			loc:      time.UTC,
+			currTime: time.Date(2017, 10, 16, 12, 0, 0, 0, time.UTC),
+			period:   "2017-10",
+			next:     time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "the last month of a year",
+			window:   quotaWindowMonth,
+			loc:      time.UTC,
+			currTime: time.Date(2017, 12, 31, 23, 0, 0, 0, time.UTC),
+			period:   "2017-12",
+			next:     time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "months follow the configured time zone",
+			window:   quotaWindowMonth,
+			loc:      chicago,
+			currTime: time.Date(2017, 11, 1, 2, 0, 0, 0, time.UTC),
+			period:   "2017-10",
+			next:     time.Date(2017, 11, 1, 5, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		period, next := getQuotaPeriod(test.window, test.loc, test.currTime)
+		assert.Equal(test.period, period, test.name)
+		assert.True(test.next.Equal(next), "%s: expected %s but got %s", test.name, test.next, next)
+	}
+}
+
+func TestCalendarQuotaCounter(t *testing.T) {
+	assert := assert.New(t)
+	c := newCalendarQuotaCounter()
+	currTime := time.Date(2017, 10, 16, 12, 0, 0, 0, time.UTC)
+	next := time.Date(2017, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(0, c.count("foo", "2017-10-16"))
+	c.add("foo", "2017-10-16", next, currTime)
+	c.add("foo", "2017-10-16", next, currTime)
+	c.add("bar", "2017-10-16", next, currTime)
+	assert.Equal(2, c.count("foo", "2017-10-16"))
+	assert.Equal(1, c.count("bar", "2017-10-16"))
+
+	c.add("foo", "2017-10-17", next.Add(24*time.Hour), next)
+	assert.Equal(1, c.count("foo", "2017-10-17"), "a new period should start from zero")
+	assert.Equal(0, c.count("foo", "2017-10-16"), "usage of earlier periods should be discarded")
+}
+
+func TestCalendarQuotaCounterMaxEntries(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyQuotaMaxEntries.GetLong(), 10000)
+	viper.Set(flagPluginsAPIKeyQuotaMaxEntries.GetLong(), 2)
+	c := newCalendarQuotaCounter()
+	currTime := time.Date(2017, 10, 16, 12, 0, 0, 0, time.UTC)
+	next := time.Date(2017, 10, 17, 0, 0, 0, 0, time.UTC)
+
+	c.add("foo", "2017-10-16", next, currTime)
+	c.add("foo", "2017-10-16", next, currTime)
+	c.add("bar", "2017-10-16", next, currTime)
+	c.add("baz", "2017-10-16", next, currTime)
+	assert.Len(c.usage, 2)
+	assert.Equal(2, c.count("foo", "2017-10-16"))
+	assert.Equal(0, c.count("bar", "2017-10-16"), "the lowest usage should be evicted when full")
+	assert.Equal(1, c.count("baz", "2017-10-16"))
+
+	c.add("qux", "2017-10-17", next.Add(24*time.Hour), next)
+	assert.Len(c.usage, 1, "usage of earlier periods should be discarded when full")
+	assert.Equal(1, c.count("qux", "2017-10-17"))
+}
+
+func TestGetQuotaLocationCached(t *testing.T) {
+	assert := assert.New(t)
+	defer resetQuotaWindow()
+
+	viper.Set(flagPluginsAPIKeyQuotaTimezone.GetLong(), "America/Chicago")
+	assert.True(getQuotaLocation() == getQuotaLocation(), "the time zone should be loaded once")
+	viper.Set(flagPluginsAPIKeyQuotaTimezone.GetLong(), "America/New_York")
+	assert.Equal("America/New_York", getQuotaLocation().String(), "a changed time zone should be loaded")
+}
+
+func TestCheckQuota(t *testing.T) {
+	assert := assert.New(t)
+	defer resetQuotaWindow()
+	resetQuotaWindow()
+	viper.Set(flagPluginsAPIKeyQuotaTimezone.GetLong(), "America/Chicago")
+
+	binding := getTestQuotaBinding(quotaWindowDay, 2)
+	key := testutil.Key()
+	keyObj := binding.GetAPIKey(testutil.KeyName)
+
+	// 11pm in Chicago on the 16th
+	currTime := time.Date(2017, 10, 17, 4, 0, 0, 0, time.UTC)
+	assert.Nil(checkQuota(binding, key, keyObj, currTime))
+	recordQuotaTraffic(binding, key, currTime)
+	recordQuotaTraffic(binding, key, currTime.Add(30*time.Minute))
+
+	err := checkQuota(binding, key, keyObj, currTime.Add(30*time.Minute))
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Equal("quota limit reached. please contact your administrator - retry after 1800 seconds", err.Error(), "clients should retry at midnight in the configured time zone")
+
+	assert.Nil(checkQuota(binding, key, keyObj, currTime.Add(time.Hour)), "the quota should reset at midnight")
+
+	unlimited := getTestQuotaBinding(quotaWindowDay, 0)
+	recordQuotaTraffic(unlimited, key, currTime)
+	assert.Nil(checkQuota(unlimited, key, unlimited.GetAPIKey(testutil.KeyName), currTime), "keys without a quota should not be limited")
+	assert.Nil(checkQuota(binding, key, nil, currTime))
+}
+
+func TestCheckQuotaLifetime(t *testing.T) {
+	assert := assert.New(t)
+	defer resetQuotaWindow()
+	defer spec.TrafficStore.Clear()
+	resetQuotaWindow()
+
+	// a key of its own keeps traffic emitted by other tests out of the count
+	key := testutil.NamedKey("apikeyquota", "myquotaapikey")
+	keyObj := testutil.GlobalKey(key.ObjectMeta.Name)
+	keyObj.Quota = 1
+	binding := testutil.Binding(keyObj)
+
+	assert.Nil(checkQuota(binding, key, &keyObj, time.Now()))
+	recordQuotaTraffic(binding, key, time.Now())
+	assert.Nil(checkQuota(binding, key, &keyObj, time.Now()), "lifetime quotas should only count traffic tracked by Kanali")
+
+	spec.TrafficStore.AddTraffic(testutil.Namespace, testutil.ProxyName, key.ObjectMeta.Name, time.Now())
+	err := checkQuota(binding, key, &keyObj, time.Now())
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Equal("quota limit reached. please contact your administrator", err.Error())
+}
+
+func TestOnRequestQuotaWindow(t *testing.T) {
+	assert := assert.New(t)
+	defer resetQuotaWindow()
+	resetQuotaWindow()
+	viper.Set(flagPluginsAPIKeyQuotaWindow.GetLong(), quotaWindowMonth)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{getTestQuotaBinding("", 2)})()
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+	}
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusTooManyRequests, getStatusCode(err))
+	assert.Contains(err.Error(), "retry after")
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyRateLimitExemptMethods,
+		flagPluginsAPIKeyRateLimitMaxEntries,
+	)
+}
+
+var (
+	flagPluginsAPIKeyRateLimitExemptMethods = config.Flag{
+		Long:  "plugins.apiKey.rate_limit_exempt_methods",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of HTTP methods that are neither limited by nor counted against rate limits and quotas.",
+	}
+	flagPluginsAPIKeyRateLimitMaxEntries = config.Flag{
+		Long:  "plugins.apiKey.rate_limit_max_entries",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of key groups, and of apikeys with read or write rate limits, whose traffic is tracked at once. The least recently seen one is evicted when full.",
+	}
+)
+
+const (
+	// annotationKeyGroup is the APIKey annotation that places a key into a
+	// group whose members share a single rate limit
+	annotationKeyGroup = "apikey.kanali.io/group"
+	// annotationBindingReadRate is the APIKeyBinding annotation holding the
+	// rate limit, of the form amount/unit, applied to each key's safe requests
+	annotationBindingReadRate = "apikey.kanali.io/read-rate"
+	// annotationBindingWriteRate is the APIKeyBinding annotation holding the
+	// rate limit, of the form amount/unit, applied to each key's unsafe requests
+	annotationBindingWriteRate = "apikey.kanali.io/write-rate"
+)
+
+// maxRateWindow is the largest window a rate limit can be expressed in
+var maxRateWindow = time.Hour
+
+// groupTraffic holds the traffic of every API key group seen by this
+// Kanali instance
+var groupTraffic = newTrafficCounter()
+
+// methodTraffic holds the read and write traffic of every
+// API key seen by this Kanali instance
+var methodTraffic = newTrafficCounter()
+
+// trafficCounter records request timestamps by an arbitrary identifier
+type trafficCounter struct {
+	mutex sync.Mutex
+	hits  map[string][]time.Time
+}
+
+func newTrafficCounter() *trafficCounter {
+	return &trafficCounter{
+		hits: map[string][]time.Time{},
+	}
+}
+
+// add records a request for the given identifier. Requests older than
+// maxRateWindow are discarded as they can no longer affect any limit.
+func (c *trafficCounter) add(id string, currTime time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.hits[id] = append(prune(c.hits[id], currTime.Add(-maxRateWindow)), currTime)
+}
+
+// count returns the number of requests recorded for the given
+// identifier since the given time
+func (c *trafficCounter) count(id string, since time.Time) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	total := 0
+	for _, t := range c.hits[id] {
+		if t.After(since) {
+			total++
+		}
+	}
+	return total
+}
+
+// oldest returns the earliest request recorded for the given identifier
+// since the given time. False is returned if there is no such request.
+func (c *trafficCounter) oldest(id string, since time.Time) (time.Time, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, t := range c.hits[id] {
+		if t.After(since) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// makeRoom ensures that there is room to record the given identifier by
+// removing identifiers without recent traffic or, if every identifier has
+// recent traffic, the least recently seen one. Identifiers already being
+// recorded and a max of zero or less are left alone.
+func (c *trafficCounter) makeRoom(max int, id string, currTime time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.hits[id]; ok || max <= 0 || len(c.hits) < max {
+		return
+	}
+
+	var oldest string
+	for other, hits := range c.hits {
+		hits = prune(hits, currTime.Add(-maxRateWindow))
+		if len(hits) < 1 {
+			delete(c.hits, other)
+			continue
+		}
+		c.hits[other] = hits
+		if oldest == "" || hits[len(hits)-1].Before(c.hits[oldest][len(c.hits[oldest])-1]) {
+			oldest = other
+		}
+	}
+	if len(c.hits) >= max && oldest != "" {
+		delete(c.hits, oldest)
+	}
+}
+
+// prune removes every timestamp that occurred before the given time
+func prune(hits []time.Time, before time.Time) []time.Time {
+	for i, t := range hits {
+		if t.After(before) {
+			return hits[i:]
+		}
+	}
+	return hits[:0]
+}
+
+// getRateWindow returns the duration of a spec.Rate unit. An unknown
+// unit results in a window of zero.
+func getRateWindow(unit string) time.Duration {
+	switch strings.ToLower(unit) {
+	case "second":
+		return time.Second
+	case "minute":
+		return time.Minute
+	case "hour":
+		return time.Hour
+	default:
+		return 0
+	}
+}
+
+// getKeyGroup returns the name of the group an APIKey belongs to.
+// An empty string is returned if the key does not belong to a group.
+func getKeyGroup(key spec.APIKey) string {
+	return strings.TrimSpace(key.ObjectMeta.Annotations[annotationKeyGroup])
+}
+
+// getGroupTrafficID scopes a group to the APIProxy of a binding so that
+// a group's traffic against one proxy does not count against another
+func getGroupTrafficID(binding spec.APIKeyBinding, group string) string {
+	return fmt.Sprintf("%s/%s/%s", binding.ObjectMeta.Namespace, binding.Spec.APIProxyName, group)
+}
+
+// isRateLimitViolated will return true if the given api key has exceeded
+// its rate limit. Keys belonging to a group are measured against the
+// combined traffic of every key in that group while all other keys
+// fall back to the per key limit tracked by Kanali.
+func isRateLimitViolated(binding spec.APIKeyBinding, key spec.APIKey, keyObj *spec.Key, currTime time.Time) bool {
+	group := getKeyGroup(key)
+	if group == "" {
+		return spec.TrafficStore.IsRateLimitViolated(binding, key.ObjectMeta.Name, currTime)
+	}
+
+	if keyObj == nil || keyObj.Rate == nil || keyObj.Rate.Amount < 1 {
+		return false
+	}
+
+	window := getRateWindow(keyObj.Rate.Unit)
+	if window == 0 {
+		return false
+	}
+
+	return groupTraffic.count(getGroupTrafficID(binding, group), currTime.Add(-window)) >= keyObj.Rate.Amount
+}
+
+// recordGroupTraffic accounts for a request against the group of the
+// given api key. Keys that do not belong to a group are ignored.
+func recordGroupTraffic(binding spec.APIKeyBinding, key spec.APIKey, currTime time.Time) {
+	if group := getKeyGroup(key); group != "" {
+		id := getGroupTrafficID(binding, group)
+		groupTraffic.makeRoom(viper.GetInt(flagPluginsAPIKeyRateLimitMaxEntries.GetLong()), id, currTime)
+		groupTraffic.add(id, currTime)
+	}
+}
+
+// isSafeMethod will return true if the given HTTP method only reads data
+func isSafeMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD":
+		return true
+	default:
+		return false
+	}
+}
+
+// getMethodRateAnnotation returns the binding annotation holding
+// the rate limit applied to requests using the given HTTP method
+func getMethodRateAnnotation(method string) string {
+	if isSafeMethod(method) {
+		return annotationBindingReadRate
+	}
+	return annotationBindingWriteRate
+}
+
+// getMethodTrafficID scopes the read or write traffic of a key to the
+// APIProxy of a binding
+func getMethodTrafficID(binding spec.APIKeyBinding, key spec.APIKey, method string) string {
+	class := "write"
+	if isSafeMethod(method) {
+		class = "read"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", binding.ObjectMeta.Namespace, binding.Spec.APIProxyName, key.ObjectMeta.Name, class)
+}
+
+// parseRate parses a rate limit of the form amount/unit, such as 100/minute
+func parseRate(value string) (*spec.Rate, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("rate %q must be of the form amount/unit", value)
+	}
+
+	amount, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || amount < 1 {
+		return nil, fmt.Errorf("rate %q must have a positive amount", value)
+	}
+
+	unit := strings.TrimSpace(parts[1])
+	if getRateWindow(unit) == 0 {
+		return nil, fmt.Errorf("rate %q has an unknown unit", value)
+	}
+
+	return &spec.Rate{Amount: amount, Unit: unit}, nil
+}
+
+// isMethodRateLimitViolated will return true if the given api key has
+// exceeded the rate limit its binding applies to either its safe (GET and
+// HEAD) or unsafe requests, depending on the given HTTP method. Reads and
+// writes are counted independently so that heavy writes do not starve reads.
+func isMethodRateLimitViolated(binding spec.APIKeyBinding, key spec.APIKey, method string, currTime time.Time) bool {
+	annotation := getMethodRateAnnotation(method)
+	value, ok := binding.ObjectMeta.Annotations[annotation]
+	if !ok {
+		return false
+	}
+
+	rate, err := parseRate(value)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"binding":   binding.ObjectMeta.Name,
+			"namespace": binding.ObjectMeta.Namespace,
+		}).Warnf("invalid %s annotation will be ignored: %s", annotation, err.Error())
+		return false
+	}
+
+	return methodTraffic.count(getMethodTrafficID(binding, key, method), currTime.Add(-getRateWindow(rate.Unit))) >= rate.Amount
+}
+
+// recordMethodTraffic accounts for a request against either the read or
+// write traffic of the given api key
+func recordMethodTraffic(binding spec.APIKeyBinding, key spec.APIKey, method string, currTime time.Time) {
+	if _, ok := binding.ObjectMeta.Annotations[getMethodRateAnnotation(method)]; ok {
+		id := getMethodTrafficID(binding, key, method)
+		methodTraffic.makeRoom(viper.GetInt(flagPluginsAPIKeyRateLimitMaxEntries.GetLong()), id, currTime)
+		methodTraffic.add(id, currTime)
+	}
+}
+
+// isRateLimitExempt returns true if requests made with the given
+// method are neither limited by nor counted against rate limits
+func isRateLimitExempt(method string) bool {
+	for _, exempt := range getStringSlice(flagPluginsAPIKeyRateLimitExemptMethods.GetLong()) {
+		if strings.EqualFold(exempt, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestTrafficCounter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	c := newTrafficCounter()
+	c.add("foo", now.Add(-2*maxRateWindow))
+	c.add("foo", now.Add(-30*time.Second))
+	c.add("foo", now)
+	c.add("bar", now)
+
+	assert.Equal(2, c.count("foo", now.Add(-time.Minute)))
+	assert.Equal(1, c.count("foo", now.Add(-time.Second)))
+	assert.Equal(1, c.count("bar", now.Add(-time.Minute)))
+	assert.Equal(0, c.count("baz", now.Add(-time.Minute)))
+	assert.Equal(2, len(c.hits["foo"]), "stale traffic should have been pruned")
+}
+
+func TestTrafficCounterMakeRoom(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	c := newTrafficCounter()
+	c.add("foo", now.Add(-2*maxRateWindow))
+	c.add("bar", now.Add(-time.Minute))
+	c.add("baz", now)
+
+	c.makeRoom(0, "qux", now)
+	assert.Equal(3, len(c.hits), "a max of zero should be unlimited")
+
+	c.makeRoom(2, "baz", now)
+	assert.Equal(3, len(c.hits), "recorded identifiers should not need room")
+
+	c.makeRoom(3, "qux", now)
+	assert.Equal(2, len(c.hits), "identifiers without recent traffic should have been removed")
+	assert.NotContains(c.hits, "foo")
+
+	c.makeRoom(2, "qux", now)
+	assert.Equal(1, len(c.hits))
+	assert.Contains(c.hits, "baz", "the least recently seen identifier should have been removed")
+}
+
+func TestGetRateWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Second, getRateWindow("second"))
+	assert.Equal(time.Minute, getRateWindow("Minute"))
+	assert.Equal(time.Hour, getRateWindow("HOUR"))
+	assert.Equal(time.Duration(0), getRateWindow("fortnight"))
+	assert.Equal(time.Duration(0), getRateWindow(""))
+}
+
+func TestGetKeyGroup(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", getKeyGroup(getTestAPIKey()))
+	assert.Equal("partner", getKeyGroup(getTestGroupedAPIKey("apikeyone", "partner")))
+}
+
+func TestIsRateLimitViolated(t *testing.T) {
+	assert := assert.New(t)
+	groupTraffic = newTrafficCounter()
+
+	// scope to a proxy no other test emits traffic for
+	binding := getTestAPIKeyBinding()
+	binding.Spec.APIProxyName = "ratelimitproxy"
+	binding.Spec.Keys = []spec.Key{
+		{
+			Name: "apikeyone",
+			Rate: &spec.Rate{Amount: 3, Unit: "minute"},
+		},
+		{
+			Name: "apikeytwo",
+			Rate: &spec.Rate{Amount: 3, Unit: "minute"},
+		},
+	}
+
+	one := getTestGroupedAPIKey("apikeyone", "partner")
+	two := getTestGroupedAPIKey("apikeytwo", "partner")
+	now := time.Now()
+
+	recordGroupTraffic(binding, one, now)
+	recordGroupTraffic(binding, two, now)
+	assert.False(isRateLimitViolated(binding, one, binding.GetAPIKey("apikeyone"), now))
+	assert.False(isRateLimitViolated(binding, two, binding.GetAPIKey("apikeytwo"), now))
+
+	recordGroupTraffic(binding, one, now)
+	assert.True(isRateLimitViolated(binding, one, binding.GetAPIKey("apikeyone"), now), "group should have reached its shared limit")
+	assert.True(isRateLimitViolated(binding, two, binding.GetAPIKey("apikeytwo"), now), "group should have reached its shared limit")
+	assert.False(isRateLimitViolated(binding, two, binding.GetAPIKey("apikeytwo"), now.Add(2*time.Minute)), "group traffic should have expired")
+
+	other := binding
+	other.Spec.APIProxyName = "APIProxytwo"
+	assert.False(isRateLimitViolated(other, one, other.GetAPIKey("apikeyone"), now), "group traffic should be scoped to a proxy")
+
+	assert.False(isRateLimitViolated(binding, getTestGroupedAPIKey("apikeythree", "partner"), nil, now))
+	assert.False(isRateLimitViolated(binding, getTestAPIKey(), binding.GetAPIKey("apikeyone"), now), "ungrouped keys should use the per key limit")
+}
+
+func getTestGroupedAPIKey(name, group string) spec.APIKey {
+
+	key := getTestAPIKey()
+	key.ObjectMeta = api.ObjectMeta{
+		Name:      name,
+		Namespace: "foo",
+		Annotations: map[string]string{
+			annotationKeyGroup: group,
+		},
+	}
+	return key
+
+}
+
+func TestIsSafeMethod(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isSafeMethod("GET"))
+	assert.True(isSafeMethod("head"))
+	assert.False(isSafeMethod("POST"))
+	assert.False(isSafeMethod("DELETE"))
+	assert.False(isSafeMethod(""))
+}
+
+func TestParseRate(t *testing.T) {
+	assert := assert.New(t)
+
+	rate, err := parseRate(" 100 / minute ")
+	assert.Nil(err)
+	assert.Equal(&spec.Rate{Amount: 100, Unit: "minute"}, rate)
+
+	for _, value := range []string{"", "100", "foo/minute", "0/minute", "-1/minute", "100/fortnight"} {
+		_, err := parseRate(value)
+		assert.NotNil(err, value)
+	}
+}
+
+func TestIsMethodRateLimitViolated(t *testing.T) {
+	assert := assert.New(t)
+	methodTraffic = newTrafficCounter()
+
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Annotations = map[string]string{
+		annotationBindingReadRate:  "3/minute",
+		annotationBindingWriteRate: "1/minute",
+	}
+	key := getTestAPIKey()
+	now := time.Now()
+
+	assert.False(isMethodRateLimitViolated(binding, key, "POST", now))
+	recordMethodTraffic(binding, key, "POST", now)
+	assert.True(isMethodRateLimitViolated(binding, key, "PUT", now), "writes should have reached their limit")
+	assert.False(isMethodRateLimitViolated(binding, key, "GET", now), "reads should not be affected by writes")
+
+	recordMethodTraffic(binding, key, "GET", now)
+	recordMethodTraffic(binding, key, "HEAD", now)
+	assert.False(isMethodRateLimitViolated(binding, key, "GET", now))
+	recordMethodTraffic(binding, key, "GET", now)
+	assert.True(isMethodRateLimitViolated(binding, key, "GET", now), "reads should have reached their limit")
+	assert.False(isMethodRateLimitViolated(binding, key, "GET", now.Add(2*time.Minute)), "read traffic should have expired")
+
+	other := getTestAPIKey()
+	other.ObjectMeta.Name = "apikeytwo"
+	assert.False(isMethodRateLimitViolated(binding, other, "GET", now), "limits should be enforced per key")
+
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingWriteRate: "foo"}
+	assert.False(isMethodRateLimitViolated(binding, key, "POST", now), "invalid rates should be ignored")
+	assert.False(isMethodRateLimitViolated(binding, key, "GET", now), "reads should be unlimited when no read rate is set")
+}
+
+func TestRecordTrafficMaxEntries(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRateLimitMaxEntries.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyRateLimitMaxEntries.GetLong(), 2)
+	groupTraffic = newTrafficCounter()
+	methodTraffic = newTrafficCounter()
+
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Annotations = map[string]string{
+		annotationBindingReadRate: "3/minute",
+	}
+	now := time.Now()
+
+	for i, name := range []string{"apikeyone", "apikeytwo", "apikeythree"} {
+		recordGroupTraffic(binding, getTestGroupedAPIKey(name, name), now.Add(time.Duration(i)*time.Second))
+		recordMethodTraffic(binding, getTestGroupedAPIKey(name, name), "GET", now.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(2, len(groupTraffic.hits), "group traffic should be bounded")
+	assert.NotContains(groupTraffic.hits, getGroupTrafficID(binding, "apikeyone"), "the least recently seen group should have been evicted")
+	assert.Equal(2, len(methodTraffic.hits), "method traffic should be bounded")
+	assert.NotContains(methodTraffic.hits, getMethodTrafficID(binding, getTestGroupedAPIKey("apikeyone", "apikeyone"), "GET"), "the least recently seen key should have been evicted")
+}
+
+func TestIsRateLimitExempt(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRateLimitExemptMethods.GetLong(), "")
+
+	assert.False(isRateLimitExempt("HEAD"))
+
+	viper.Set(flagPluginsAPIKeyRateLimitExemptMethods.GetLong(), "options, head")
+	assert.True(isRateLimitExempt("HEAD"))
+	assert.True(isRateLimitExempt("OPTIONS"))
+	assert.False(isRateLimitExempt("GET"))
+}
+
+func TestOnRequestRateLimitExempt(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRateLimitExemptMethods.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyRateLimitExemptMethods.GetLong(), "HEAD")
+	groupTraffic = newTrafficCounter()
+
+	// scope to a proxy no other test emits traffic for
+	proxy := getTestAPIProxy()
+	proxy.ObjectMeta.Name = "ratelimitexemptproxy"
+	binding := getTestAPIKeyBinding()
+	binding.Spec.APIProxyName = proxy.ObjectMeta.Name
+	binding.Spec.Keys[0].Rate = &spec.Rate{Amount: 2, Unit: "hour"}
+	// grouped keys are counted by this plugin, so their remaining requests are known
+	spec.KeyStore.Set(getTestGroupedAPIKey("apikeyone", "exempt"))
+	spec.BindingStore.Set(binding)
+
+	for i := 0; i < 3; i++ {
+		r := getTestRequest()
+		r.Method = "HEAD"
+		assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, proxy, r, opentracing.StartSpan("test span")))
+		limit, ok := getRateLimitFromContext(r)
+		assert.True(ok)
+		assert.Equal(2, limit.Remaining, "exempt methods should not count against the rate limit")
+	}
+
+	r := getTestRequest()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, proxy, r, opentracing.StartSpan("test span")))
+	limit, _ := getRateLimitFromContext(r)
+	assert.Equal(1, limit.Remaining)
+}
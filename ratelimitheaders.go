@@ -0,0 +1,188 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyRateLimitResetFormat,
+		flagPluginsAPIKeyRateLimitWarningPercent,
+	)
+}
+
+var (
+	flagPluginsAPIKeyRateLimitResetFormat = config.Flag{
+		Long:  "plugins.apiKey.rate_limit_reset_format",
+		Short: "",
+		Value: "epoch",
+		Usage: "Format of the X-RateLimit-Reset response header. Valid formats are epoch, the Unix time in seconds at which the limit resets, and seconds, the number of seconds until the limit resets.",
+	}
+	flagPluginsAPIKeyRateLimitWarningPercent = config.Flag{
+		Long:  "plugins.apiKey.rate_limit_warning_percent",
+		Short: "",
+		Value: 0,
+		Usage: "Percentage of the rate limit below which remaining requests cause the X-RateLimit-Warning response header to be set. Disabled if 0.",
+	}
+)
+
+const (
+	rateLimitResetFormatEpoch   = "epoch"
+	rateLimitResetFormatSeconds = "seconds"
+)
+
+// RateLimit describes the state of the rate limit applied to an APIKey
+// at the time a request was authorized
+type RateLimit struct {
+	// Limit is the number of requests allowed per window
+	Limit int
+	// Remaining is the number of requests left in the current window,
+	// or rateLimitRemainingUnknown if it cannot be determined
+	Remaining int
+	// Reset is the time at which the oldest request in the current
+	// window stops counting against the limit. It is the zero time
+	// if it cannot be determined.
+	Reset time.Time
+}
+
+// rateLimitRemainingUnknown is the remaining number of requests of a rate
+// limit enforced by Kanali's traffic store, which can only tell whether
+// the limit has been reached
+const rateLimitRemainingUnknown = -1
+
+// getRateLimit returns the state of the rate limit applied to the given api
+// key, including any traffic already recorded at the given time, from the
+// same traffic that enforces it. The rate limits of keys that belong to a
+// group are counted by this plugin. All other rate limits are enforced by
+// Kanali's traffic store, so only whether they were reached, as given by
+// limited, is known. False is returned if the key does not have a rate limit.
+func getRateLimit(binding spec.APIKeyBinding, key spec.APIKey, keyObj *spec.Key, limited bool, currTime time.Time) (RateLimit, bool) {
+	if keyObj == nil || keyObj.Rate == nil || keyObj.Rate.Amount < 1 {
+		return RateLimit{}, false
+	}
+
+	window := getRateWindow(keyObj.Rate.Unit)
+	if window == 0 {
+		return RateLimit{}, false
+	}
+
+	group := getKeyGroup(key)
+	if group == "" {
+		limit := RateLimit{Limit: keyObj.Rate.Amount, Remaining: rateLimitRemainingUnknown}
+		if limited {
+			limit.Remaining = 0
+		}
+		return limit, true
+	}
+	counter, id := groupTraffic, getGroupTrafficID(binding, group)
+
+	since := currTime.Add(-window)
+	remaining := keyObj.Rate.Amount - counter.count(id, since)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	oldest, ok := counter.oldest(id, since)
+	if !ok {
+		oldest = currTime
+	}
+
+	return RateLimit{
+		Limit:     keyObj.Rate.Amount,
+		Remaining: remaining,
+		Reset:     oldest.Add(window),
+	}, true
+}
+
+// setRateLimit stores the given rate limit in the context of the given request
+func setRateLimit(r *http.Request, limit RateLimit) {
+	*r = *r.WithContext(context.WithValue(r.Context(), ContextKeyRateLimit, limit))
+}
+
+// getRateLimitFromContext retrieves the rate limit applied to the given
+// request. False is returned if the rate limit is not present.
+func getRateLimitFromContext(r *http.Request) (RateLimit, bool) {
+	limit, ok := r.Context().Value(ContextKeyRateLimit).(RateLimit)
+	return limit, ok
+}
+
+// formatRateLimitReset formats the reset time of a rate limit as either
+// a Unix epoch or the number of whole seconds, rounded up, until the
+// given time reaches it. Unknown formats fall back to a Unix epoch.
+func formatRateLimitReset(reset, currTime time.Time) string {
+	switch format := strings.ToLower(viper.GetString(flagPluginsAPIKeyRateLimitResetFormat.GetLong())); format {
+	case rateLimitResetFormatSeconds:
+		remaining := reset.Sub(currTime)
+		if remaining < 0 {
+			remaining = 0
+		}
+		return strconv.FormatInt(int64((remaining+time.Second-1)/time.Second), 10)
+	case "", rateLimitResetFormatEpoch:
+	default:
+		logrus.Warnf("unknown rate limit reset format %s - epoch will be used", format)
+	}
+	return strconv.FormatInt(reset.Unix(), 10)
+}
+
+// setRateLimitHeaders sets the X-RateLimit headers describing the rate
+// limit applied to the given request on the given response
+func setRateLimitHeaders(r *http.Request, resp *http.Response, currTime time.Time) {
+	limit, ok := getRateLimitFromContext(r)
+	if !ok || resp == nil {
+		return
+	}
+
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	resp.Header.Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+	if limit.Remaining != rateLimitRemainingUnknown {
+		resp.Header.Set("X-RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+	}
+	if !limit.Reset.IsZero() {
+		resp.Header.Set("X-RateLimit-Reset", formatRateLimitReset(limit.Reset, currTime))
+	}
+	if isRateLimitNearlyExhausted(limit) {
+		resp.Header.Set("X-RateLimit-Warning", fmt.Sprintf("%d of %d requests remaining", limit.Remaining, limit.Limit))
+	}
+}
+
+// isRateLimitNearlyExhausted returns true if the remaining requests of the
+// given rate limit have dropped below the configured warning percentage
+func isRateLimitNearlyExhausted(limit RateLimit) bool {
+	percent := viper.GetFloat64(flagPluginsAPIKeyRateLimitWarningPercent.GetLong())
+	if percent <= 0 || limit.Limit < 1 || limit.Remaining == rateLimitRemainingUnknown {
+		return false
+	}
+	return float64(limit.Remaining)*100 < percent*float64(limit.Limit)
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficCounterOldest(t *testing.T) {
+	assert := assert.New(t)
+	counter := newTrafficCounter()
+	now := time.Now()
+
+	_, ok := counter.oldest("id", now.Add(-time.Minute))
+	assert.False(ok)
+
+	counter.add("id", now.Add(-2*time.Minute))
+	counter.add("id", now.Add(-30*time.Second))
+	counter.add("id", now)
+	oldest, ok := counter.oldest("id", now.Add(-time.Minute))
+	assert.True(ok)
+	assert.Equal(now.Add(-30*time.Second), oldest)
+}
+
+func TestGetRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	groupTraffic = newTrafficCounter()
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys = []spec.Key{
+		{
+			Name: "apikeyone",
+			Rate: &spec.Rate{Amount: 2, Unit: "minute"},
+		},
+	}
+	key := getTestAPIKey()
+	keyObj := binding.GetAPIKey("apikeyone")
+	now := time.Now()
+
+	_, ok := getRateLimit(binding, key, &spec.Key{Name: "apikeyone"}, false, now)
+	assert.False(ok, "keys without a rate should not have a rate limit")
+	_, ok = getRateLimit(binding, key, &spec.Key{Name: "apikeyone", Rate: &spec.Rate{Amount: 2, Unit: "fortnight"}}, false, now)
+	assert.False(ok, "rates with unknown units should not have a rate limit")
+
+	limit, ok := getRateLimit(binding, key, keyObj, false, now)
+	assert.True(ok)
+	assert.Equal(RateLimit{2, rateLimitRemainingUnknown, time.Time{}}, limit, "the traffic store cannot tell how many requests remain")
+	limit, _ = getRateLimit(binding, key, keyObj, true, now)
+	assert.Equal(RateLimit{2, 0, time.Time{}}, limit, "a limit reached in the traffic store should have none remaining")
+
+	grouped := getTestGroupedAPIKey("apikeyone", "partner")
+	limit, _ = getRateLimit(binding, grouped, keyObj, false, now)
+	assert.Equal(RateLimit{2, 2, now.Add(time.Minute)}, limit)
+
+	recordGroupTraffic(binding, grouped, now.Add(-10*time.Second))
+	recordGroupTraffic(binding, grouped, now)
+	recordGroupTraffic(binding, grouped, now)
+	limit, _ = getRateLimit(binding, grouped, keyObj, false, now)
+	assert.Equal(RateLimit{2, 0, now.Add(50 * time.Second)}, limit, "remaining should not be negative")
+}
+
+func TestFormatRateLimitReset(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRateLimitResetFormat.GetLong(), "")
+
+	now := time.Unix(1507643736, 0)
+	reset := now.Add(1500 * time.Millisecond)
+
+	viper.Set(flagPluginsAPIKeyRateLimitResetFormat.GetLong(), "")
+	assert.Equal("1507643737", formatRateLimitReset(reset, now), "epoch should be used by default")
+	viper.Set(flagPluginsAPIKeyRateLimitResetFormat.GetLong(), "epoch")
+	assert.Equal("1507643737", formatRateLimitReset(reset, now))
+	viper.Set(flagPluginsAPIKeyRateLimitResetFormat.GetLong(), "unknown")
+	assert.Equal("1507643737", formatRateLimitReset(reset, now))
+
+	viper.Set(flagPluginsAPIKeyRateLimitResetFormat.GetLong(), "seconds")
+	assert.Equal("2", formatRateLimitReset(reset, now), "seconds should be rounded up")
+	assert.Equal("0", formatRateLimitReset(reset, now.Add(time.Minute)), "seconds should not be negative")
+	assert.Equal("60", formatRateLimitReset(now.Add(time.Minute), now))
+}
+
+func TestSetRateLimitHeaders(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRateLimitResetFormat.GetLong(), "")
+
+	now := time.Unix(1507643736, 0)
+	r := getTestRequest()
+	resp := &http.Response{}
+	setRateLimitHeaders(r, resp, now)
+	assert.Nil(resp.Header, "headers should not be set without a rate limit")
+
+	setRateLimit(r, RateLimit{10, 4, now.Add(30 * time.Second)})
+	setRateLimitHeaders(r, nil, now)
+	setRateLimitHeaders(r, resp, now)
+	assert.Equal("10", resp.Header.Get("X-RateLimit-Limit"))
+	assert.Equal("4", resp.Header.Get("X-RateLimit-Remaining"))
+	assert.Equal("1507643766", resp.Header.Get("X-RateLimit-Reset"))
+
+	viper.Set(flagPluginsAPIKeyRateLimitResetFormat.GetLong(), "seconds")
+	setRateLimitHeaders(r, resp, now)
+	assert.Equal("30", resp.Header.Get("X-RateLimit-Reset"))
+
+	resp = &http.Response{}
+	setRateLimit(r, RateLimit{10, rateLimitRemainingUnknown, time.Time{}})
+	setRateLimitHeaders(r, resp, now)
+	assert.Equal("10", resp.Header.Get("X-RateLimit-Limit"))
+	assert.Equal(http.Header{"X-Ratelimit-Limit": []string{"10"}}, resp.Header, "unknown values should not be set")
+}
+
+func TestIsRateLimitNearlyExhausted(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRateLimitWarningPercent.GetLong(), 0)
+
+	assert.False(isRateLimitNearlyExhausted(RateLimit{10, 0, time.Now()}), "warnings should be disabled by default")
+
+	viper.Set(flagPluginsAPIKeyRateLimitWarningPercent.GetLong(), 20)
+	assert.False(isRateLimitNearlyExhausted(RateLimit{10, 3, time.Now()}))
+	assert.False(isRateLimitNearlyExhausted(RateLimit{10, 2, time.Now()}), "exactly at the threshold should not warn")
+	assert.True(isRateLimitNearlyExhausted(RateLimit{10, 1, time.Now()}))
+	assert.True(isRateLimitNearlyExhausted(RateLimit{10, 0, time.Now()}))
+	assert.False(isRateLimitNearlyExhausted(RateLimit{0, 0, time.Now()}))
+	assert.False(isRateLimitNearlyExhausted(RateLimit{10, rateLimitRemainingUnknown, time.Time{}}))
+
+	viper.Set(flagPluginsAPIKeyRateLimitWarningPercent.GetLong(), 12.5)
+	assert.False(isRateLimitNearlyExhausted(RateLimit{8, 1, time.Now()}))
+	assert.True(isRateLimitNearlyExhausted(RateLimit{8, 0, time.Now()}))
+}
+
+func TestSetRateLimitWarningHeader(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRateLimitWarningPercent.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeyRateLimitWarningPercent.GetLong(), 25)
+
+	now := time.Now()
+	r := getTestRequest()
+	resp := &http.Response{}
+	setRateLimit(r, RateLimit{100, 25, now})
+	setRateLimitHeaders(r, resp, now)
+	assert.Equal("", resp.Header.Get("X-RateLimit-Warning"))
+
+	setRateLimit(r, RateLimit{100, 24, now})
+	setRateLimitHeaders(r, resp, now)
+	assert.Equal("24 of 100 requests remaining", resp.Header.Get("X-RateLimit-Warning"))
+}
+
+func TestOnResponseRateLimitHeaders(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	groupTraffic = newTrafficCounter()
+
+	// scope to a proxy no other test emits traffic for
+	proxy := getTestAPIProxy()
+	proxy.ObjectMeta.Name = "ratelimitheadersproxy"
+	binding := getTestAPIKeyBinding()
+	binding.Spec.APIProxyName = proxy.ObjectMeta.Name
+	binding.Spec.Keys[0].Rate = &spec.Rate{Amount: 5, Unit: "hour"}
+	spec.KeyStore.Set(getTestGroupedAPIKey("apikeyone", "headers"))
+	spec.BindingStore.Set(binding)
+
+	r := getTestRequest()
+	before := time.Now()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, proxy, r, opentracing.StartSpan("test span")))
+	resp := &http.Response{}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, proxy, r, resp, opentracing.StartSpan("test span")))
+
+	assert.Equal("5", resp.Header.Get("X-RateLimit-Limit"))
+	assert.Equal("4", resp.Header.Get("X-RateLimit-Remaining"))
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	assert.Nil(err)
+	assert.True(reset >= before.Add(time.Hour).Unix() && reset <= time.Now().Add(time.Hour).Unix())
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyFailOpen,
+	)
+}
+
+var (
+	flagPluginsAPIKeyFailOpen = config.Flag{
+		Long:  "plugins.apiKey.fail_open",
+		Short: "",
+		Value: false,
+		Usage: "Allow requests to be proxied when the plugin fails unexpectedly instead of responding with a 500.",
+	}
+)
+
+// recoverPanic recovers from a panic in the named plugin method and replaces
+// the method's error with one that complies with the configured fail open
+// policy. It must be deferred directly by the method it protects.
+func recoverPanic(m *metrics.Metrics, method string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"method": method,
+		"panic":  fmt.Sprint(r),
+		"stack":  string(debug.Stack()),
+	}).Error("recovered from panic in apikey plugin")
+
+	if m != nil {
+		m.Add(metrics.Metric{"api_key_plugin_panic", method, false})
+	}
+
+	if viper.GetBool(flagPluginsAPIKeyFailOpen.GetLong()) {
+		*err = nil
+		return
+	}
+	*err = &utils.StatusError{http.StatusInternalServerError, errors.New("internal error in apikey plugin")}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyFailOpen.GetLong(), false)
+
+	panics := func(m *metrics.Metrics) (err error) {
+		defer recoverPanic(m, "test", &err)
+		panic("foo")
+	}
+
+	viper.Set(flagPluginsAPIKeyFailOpen.GetLong(), false)
+	m := &metrics.Metrics{}
+	err := panics(m)
+	assert.Equal(http.StatusInternalServerError, getStatusCode(err))
+	assert.Equal("internal error in apikey plugin", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_plugin_panic", "test", false})
+
+	viper.Set(flagPluginsAPIKeyFailOpen.GetLong(), true)
+	assert.Nil(panics(&metrics.Metrics{}), "panics should fail open when configured")
+	assert.Nil(panics(nil))
+
+	succeeds := func() (err error) {
+		defer recoverPanic(nil, "test", &err)
+		return nil
+	}
+	assert.Nil(succeeds())
+}
+
+func TestOnRequestPanic(t *testing.T) {
+	assert := assert.New(t)
+	viper.Set(flagPluginsAPIKeyFailOpen.GetLong(), false)
+
+	hook := test.NewGlobal()
+	m := &metrics.Metrics{}
+
+	var err error
+	assert.NotPanics(func() {
+		// a nil request cannot be inspected
+		err = Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), nil, opentracing.StartSpan("test span"))
+	})
+	assert.Equal(http.StatusInternalServerError, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_plugin_panic", "OnRequest", false})
+	assert.Equal("recovered from panic in apikey plugin", hook.LastEntry().Message)
+	assert.Contains(hook.LastEntry().Data["stack"], "OnRequest")
+
+	m = &metrics.Metrics{}
+	assert.NotPanics(func() {
+		err = Plugin.OnResponse(context.Background(), m, getTestAPIProxy(), nil, &http.Response{}, nil)
+	})
+	assert.Equal(http.StatusInternalServerError, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_plugin_panic", "OnResponse", false})
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyRefererStrict,
+	)
+}
+
+var (
+	flagPluginsAPIKeyRefererStrict = config.Flag{
+		Long:  "plugins.apiKey.referer_strict",
+		Short: "",
+		Value: false,
+		Usage: "Reject requests without an Origin or Referer header when their apikey restricts its allowed referers.",
+	}
+)
+
+// annotationKeyAllowedReferers is the APIKey annotation holding a comma
+// separated list of the origins that may use the key
+const annotationKeyAllowedReferers = "apikey.kanali.io/allowed-referers"
+
+// getAllowedReferers returns the origins that may use the given APIKey.
+// An empty list is returned if the key is not restricted.
+func getAllowedReferers(key spec.APIKey) []string {
+	referers := []string{}
+	for _, referer := range strings.Split(key.ObjectMeta.Annotations[annotationKeyAllowedReferers], ",") {
+		if referer = strings.ToLower(strings.TrimSpace(referer)); referer != "" {
+			referers = append(referers, referer)
+		}
+	}
+	return referers
+}
+
+// getRequestOrigin returns the origin of the given request, taken from its
+// Origin header or, failing that, its Referer header. Nil is returned if
+// the request has neither header or if the header cannot be parsed.
+func getRequestOrigin(r *http.Request) *url.URL {
+	value := r.Header.Get("Origin")
+	if value == "" || value == "null" {
+		value = r.Referer()
+	}
+	if value == "" {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	return u
+}
+
+// refererMatches will return true if the given origin is permitted by the
+// given allowed referer. An allowed referer is a host, optionally preceded
+// by a scheme, optionally followed by a port, and optionally starting with
+// a *. wildcard that matches any subdomain, such as https://*.example.com.
+func refererMatches(allowed string, origin *url.URL) bool {
+	host := allowed
+	if i := strings.Index(allowed, "://"); i >= 0 {
+		if !strings.EqualFold(allowed[:i], origin.Scheme) {
+			return false
+		}
+		host = allowed[i+3:]
+	}
+	host = strings.TrimRight(host, "/")
+
+	// ports are only compared if the allowed referer specifies one
+	originHost := strings.ToLower(origin.Hostname())
+	if strings.Contains(host, ":") {
+		originHost = strings.ToLower(origin.Host)
+	}
+	if strings.HasPrefix(host, "*.") {
+		return strings.HasSuffix(originHost, host[1:])
+	}
+	return originHost == host
+}
+
+// validateReferer will return an error if the given APIKey restricts the
+// origins that may use it and the given request is not from one of them
+func validateReferer(r *http.Request, key spec.APIKey) error {
+	allowed := getAllowedReferers(key)
+	if len(allowed) < 1 {
+		return nil
+	}
+
+	origin := getRequestOrigin(r)
+	if origin == nil {
+		if viper.GetBool(flagPluginsAPIKeyRefererStrict.GetLong()) {
+			return &utils.StatusError{http.StatusForbidden, errors.New("referer required for this api key")}
+		}
+		return nil
+	}
+
+	for _, referer := range allowed {
+		if refererMatches(referer, origin) {
+			return nil
+		}
+	}
+	return &utils.StatusError{http.StatusForbidden, errors.New("referer not allowed for this api key")}
+}
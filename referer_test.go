@@ -0,0 +1,136 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestRefererAPIKey(referers string) spec.APIKey {
+	key := getTestAPIKey()
+	key.ObjectMeta.Annotations = map[string]string{
+		annotationKeyAllowedReferers: referers,
+	}
+	return key
+}
+
+func TestGetAllowedReferers(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{}, getAllowedReferers(getTestAPIKey()))
+	assert.Equal([]string{"https://app.example.com", "*.example.org"}, getAllowedReferers(getTestRefererAPIKey(" https://App.example.com ,, *.example.org")))
+}
+
+func TestGetRequestOrigin(t *testing.T) {
+	assert := assert.New(t)
+
+	r := getTestRequest()
+	assert.Nil(getRequestOrigin(r))
+
+	r.Header.Set("Referer", "https://widget.example.com/page?id=1")
+	assert.Equal("widget.example.com", getRequestOrigin(r).Host)
+
+	r.Header.Set("Origin", "https://app.example.com")
+	assert.Equal("app.example.com", getRequestOrigin(r).Host, "origin should take priority over referer")
+
+	r.Header.Set("Origin", "null")
+	assert.Equal("widget.example.com", getRequestOrigin(r).Host, "opaque origins should fall back to referer")
+
+	r.Header.Del("Origin")
+	r.Header.Set("Referer", "not a url")
+	assert.Nil(getRequestOrigin(r))
+}
+
+func TestRefererMatches(t *testing.T) {
+	assert := assert.New(t)
+	origin, _ := url.Parse("https://app.example.com:8443/widget")
+
+	assert.True(refererMatches("app.example.com", origin))
+	assert.True(refererMatches("https://app.example.com", origin))
+	assert.True(refererMatches("https://app.example.com/", origin))
+	assert.True(refererMatches("app.example.com:8443", origin))
+	assert.True(refererMatches("*.example.com", origin))
+	assert.True(refererMatches("https://*.example.com", origin))
+	assert.False(refererMatches("http://app.example.com", origin), "schemes should be compared when specified")
+	assert.False(refererMatches("app.example.com:443", origin), "ports should be compared when specified")
+	assert.False(refererMatches("example.com", origin))
+	assert.False(refererMatches("*.app.example.com", origin))
+	assert.False(refererMatches("*.ample.com", origin))
+
+	evil, _ := url.Parse("https://app.example.com.evil.io")
+	assert.False(refererMatches("app.example.com", evil))
+	assert.False(refererMatches("*.example.com", evil))
+}
+
+func TestValidateReferer(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRefererStrict.GetLong(), false)
+
+	r := getTestRequest()
+	assert.Nil(validateReferer(r, getTestAPIKey()), "unrestricted keys should be allowed from anywhere")
+
+	key := getTestRefererAPIKey("https://app.example.com, *.example.org")
+	viper.Set(flagPluginsAPIKeyRefererStrict.GetLong(), false)
+	assert.Nil(validateReferer(r, key), "missing referers should be allowed when lax")
+	viper.Set(flagPluginsAPIKeyRefererStrict.GetLong(), true)
+	err := validateReferer(r, key)
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("referer required for this api key", err.Error())
+
+	r.Header.Set("Referer", "https://app.example.com/dashboard")
+	assert.Nil(validateReferer(r, key))
+	r.Header.Set("Origin", "https://shop.example.org")
+	assert.Nil(validateReferer(r, key))
+
+	r.Header.Set("Origin", "https://evil.io")
+	err = validateReferer(r, key)
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("referer not allowed for this api key", err.Error())
+}
+
+func TestOnRequestReferer(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestRefererAPIKey("https://app.example.com"))
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	r.Header.Set("Origin", "https://app.example.com")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+
+	r = getTestRequest()
+	r.Header.Set("Referer", "https://evil.io/embed")
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_referer_denied", "true", true})
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/spec"
+)
+
+// setAPIKey stores the name and namespace of the APIKey resource that
+// made the given request in the request's context so that they can be
+// read by the plugins that follow this one
+func setAPIKey(r *http.Request, key spec.APIKey) {
+	ctx := context.WithValue(r.Context(), ContextKeyAPIKeyName, key.ObjectMeta.Name)
+	ctx = context.WithValue(ctx, ContextKeyAPIKeyNamespace, key.ObjectMeta.Namespace)
+	*r = *r.WithContext(ctx)
+}
+
+// getAPIKeyName retrieves the name of the APIKey resource that made the
+// given request. An empty string is returned if no key has been found.
+func getAPIKeyName(r *http.Request) string {
+	name, _ := r.Context().Value(ContextKeyAPIKeyName).(string)
+	return name
+}
+
+// setBinding stores the name and namespace of the APIKeyBinding consulted
+// for the given request in the request's context so that they can be read
+// by the plugins that follow this one
+func setBinding(r *http.Request, binding spec.APIKeyBinding) {
+	ctx := context.WithValue(r.Context(), ContextKeyBindingName, binding.ObjectMeta.Name)
+	ctx = context.WithValue(ctx, ContextKeyBindingNamespace, binding.ObjectMeta.Namespace)
+	*r = *r.WithContext(ctx)
+}
+
+// getBindingID retrieves the namespace and name, joined by a slash, of the
+// APIKeyBinding consulted for the given request. An empty string is
+// returned if no binding has been found.
+func getBindingID(r *http.Request) string {
+	name, _ := r.Context().Value(ContextKeyBindingName).(string)
+	if name == "" {
+		return ""
+	}
+	namespace, _ := r.Context().Value(ContextKeyBindingNamespace).(string)
+	return namespace + "/" + name
+}
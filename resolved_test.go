@@ -0,0 +1,77 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetAPIKey(t *testing.T) {
+	assert := assert.New(t)
+
+	r := getTestRequest()
+	assert.Equal("", getAPIKeyName(r))
+	setAPIKey(r, getTestAPIKey())
+	assert.Equal("apikeyone", getAPIKeyName(r))
+	assert.Equal("apikeyone", r.Context().Value(ContextKeyAPIKeyName))
+	assert.Equal("foo", r.Context().Value(ContextKeyAPIKeyNamespace))
+}
+
+func TestSetBinding(t *testing.T) {
+	assert := assert.New(t)
+
+	r := getTestRequest()
+	assert.Nil(r.Context().Value(ContextKeyBindingName))
+	assert.Equal("", getBindingID(r))
+	setBinding(r, getTestAPIKeyBinding())
+	assert.Equal("apikeybindingone", r.Context().Value(ContextKeyBindingName))
+	assert.Equal("foo", r.Context().Value(ContextKeyBindingNamespace))
+	assert.Equal("foo/apikeybindingone", getBindingID(r))
+}
+
+func TestOnRequestPropagation(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.Equal("apikeyone", r.Context().Value(ContextKeyAPIKeyName))
+	assert.Equal("foo", r.Context().Value(ContextKeyAPIKeyNamespace))
+	assert.Equal("apikeybindingone", r.Context().Value(ContextKeyBindingName))
+	assert.Equal("foo", r.Context().Value(ContextKeyBindingNamespace))
+
+	r = getTestRequest()
+	r.Header.Set("apikey", "notmyapikey")
+	assert.NotNil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.Nil(r.Context().Value(ContextKeyAPIKeyName))
+	assert.Nil(r.Context().Value(ContextKeyBindingName))
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyStoreRetries,
+		flagPluginsAPIKeyStoreRetryDelay,
+	)
+}
+
+var (
+	flagPluginsAPIKeyStoreRetries = config.Flag{
+		Long:  "plugins.apiKey.store_retries",
+		Short: "",
+		Value: 2,
+		Usage: "Number of times a store lookup is retried when the store is unable to answer.",
+	}
+	flagPluginsAPIKeyStoreRetryDelay = config.Flag{
+		Long:  "plugins.apiKey.store_retry_delay",
+		Short: "",
+		Value: "10ms",
+		Usage: "Average delay between store lookup retries. The actual delay is jittered by up to 50%.",
+	}
+)
+
+// storeLookup retrieves an object from one of Kanali's stores. A nil
+// object and error means the object definitely does not exist while a
+// non nil error means the store was unable to answer.
+type storeLookup func() (interface{}, error)
+
+// getWithRetry performs the given lookup, retrying a bounded number of
+// times with a jittered delay if the store was unable to answer.
+// A lookup that finds nothing is never retried, and no retry is made
+// once the given context is done, in which case its error is returned.
+func getWithRetry(ctx context.Context, lookup storeLookup) (interface{}, error) {
+	retries := viper.GetInt(flagPluginsAPIKeyStoreRetries.GetLong())
+	delay := viper.GetDuration(flagPluginsAPIKeyStoreRetryDelay.GetLong())
+
+	obj, err := lookup()
+	for attempt := 0; err != nil && attempt < retries; attempt++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logrus.Debugf("store lookup failed, retrying: %s", err.Error())
+		timer := time.NewTimer(jitter(delay))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		obj, err = lookup()
+	}
+
+	if err != nil && ctx.Err() == nil {
+		logrus.Warnf("store was unable to answer after %d retries: %s", retries, err.Error())
+	}
+	return obj, err
+}
+
+// jitter returns a random duration in the range [d/2, 3d/2)
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWithRetry(t *testing.T) {
+	assert := assert.New(t)
+	viper.Set(flagPluginsAPIKeyStoreRetries.GetLong(), 2)
+	viper.Set(flagPluginsAPIKeyStoreRetryDelay.GetLong(), "1ms")
+
+	calls := 0
+	obj, err := getWithRetry(context.Background(), func() (interface{}, error) {
+		calls++
+		if calls < 2 {
+			return nil, errors.New("store is reloading")
+		}
+		return "foo", nil
+	})
+	assert.Nil(err)
+	assert.Equal("foo", obj, "transient failure should have been retried")
+	assert.Equal(2, calls)
+
+	calls = 0
+	obj, err = getWithRetry(context.Background(), func() (interface{}, error) {
+		calls++
+		return nil, nil
+	})
+	assert.Nil(err)
+	assert.Nil(obj)
+	assert.Equal(1, calls, "missing objects should not be retried")
+
+	calls = 0
+	obj, err = getWithRetry(context.Background(), func() (interface{}, error) {
+		calls++
+		return nil, errors.New("store is reloading")
+	})
+	assert.Equal("store is reloading", err.Error())
+	assert.Nil(obj)
+	assert.Equal(3, calls, "retries should be bounded")
+
+	viper.Set(flagPluginsAPIKeyStoreRetries.GetLong(), 0)
+	calls = 0
+	getWithRetry(context.Background(), func() (interface{}, error) {
+		calls++
+		return nil, errors.New("store is reloading")
+	})
+	assert.Equal(1, calls)
+}
+
+func TestGetWithRetryCancelled(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyStoreRetryDelay.GetLong(), "1ms")
+	viper.Set(flagPluginsAPIKeyStoreRetries.GetLong(), 2)
+	viper.Set(flagPluginsAPIKeyStoreRetryDelay.GetLong(), "1h")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	obj, err := getWithRetry(ctx, func() (interface{}, error) {
+		calls++
+		return nil, errors.New("store is reloading")
+	})
+	assert.Equal(context.Canceled, err)
+	assert.Nil(obj)
+	assert.Equal(1, calls, "lookups should not be retried once the context is done")
+	assert.True(time.Since(start) < time.Minute, "the retry delay should be abandoned once the context is done")
+
+	calls = 0
+	getWithRetry(ctx, func() (interface{}, error) {
+		calls++
+		return nil, errors.New("store is reloading")
+	})
+	assert.Equal(1, calls)
+}
+
+func TestJitter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Duration(0), jitter(0))
+	assert.Equal(time.Duration(0), jitter(-time.Second))
+	for i := 0; i < 100; i++ {
+		d := jitter(10 * time.Millisecond)
+		assert.True(d >= 5*time.Millisecond && d < 15*time.Millisecond, "jitter should be within bounds")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"github.com/opentracing/opentracing-go"
+)
+
+// spanTagRulesEvaluated is the span tag holding the number
+// of rules evaluated when authorizing a request
+const spanTagRulesEvaluated = "kanali.rules_evaluated"
+
+// setRulesEvaluatedTag tags the given span with the number of rules
+// evaluated when authorizing a request, so that pathologically large
+// bindings can be found in traces. Requests whose decision was cached, or
+// whose key is not bound, evaluated no rules and are not tagged.
+func setRulesEvaluatedTag(span opentracing.Span, evaluated int) {
+	if evaluated < 1 {
+		return
+	}
+	span.SetTag(spanTagRulesEvaluated, evaluated)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestSubpathBinding() spec.APIKeyBinding {
+	keyObj := spec.Key{Name: testutil.KeyName}
+	for _, path := range []string{"/reports", "/accounts", "/admin"} {
+		keyObj.Subpaths = append(keyObj.Subpaths, &spec.Path{
+			Path: path,
+			Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}},
+		})
+	}
+	return testutil.Binding(keyObj)
+}
+
+func TestLookupRuleEvaluated(t *testing.T) {
+	assert := assert.New(t)
+	binding := getTestSubpathBinding()
+
+	_, _, evaluated, _ := lookupRule(binding, testutil.Key(), "/accounts/1")
+	assert.Equal(3, evaluated, "every subpath should be counted")
+	_, _, evaluated, _ = lookupRule(binding, testutil.Key(), "/unknown")
+	assert.Equal(5, evaluated, "the key's and the binding's default rules should be counted when consulted")
+	_, _, evaluated, _ = lookupRule(testutil.Binding(), testutil.Key(), "/anything")
+	assert.Equal(1, evaluated)
+	_, _, evaluated, _ = lookupRule(binding, testutil.NamedKey("unbound", "unboundapikey"), "/accounts")
+	assert.Equal(0, evaluated)
+}
+
+func TestSetRulesEvaluatedTag(t *testing.T) {
+	assert := assert.New(t)
+
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	setRulesEvaluatedTag(span, 0)
+	assert.Nil(span.Tag(spanTagRulesEvaluated), "spans should not be tagged when no rules were evaluated")
+	setRulesEvaluatedTag(span, 3)
+	assert.Equal(3, span.Tag(spanTagRulesEvaluated))
+}
+
+func TestOnRequestRulesEvaluatedTag(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "0s")
+	cleanup := testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{getTestSubpathBinding()})
+	defer cleanup()
+
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath+"/accounts", testutil.KeyData), span))
+	assert.Equal(3, span.Tag(spanTagRulesEvaluated))
+
+	viper.Set(flagPluginsAPIKeyDecisionCacheTTL.GetLong(), "1m0s")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath+"/reports", testutil.KeyData), testutil.Span()))
+	span = mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath+"/reports", testutil.KeyData), span))
+	assert.Nil(span.Tag(spanTagRulesEvaluated), "cached decisions should not be tagged")
+}
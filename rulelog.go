@@ -0,0 +1,91 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyLogResolvedRule,
+	)
+}
+
+var (
+	flagPluginsAPIKeyLogResolvedRule = config.Flag{
+		Long:  "plugins.apiKey.log_resolved_rule",
+		Short: "",
+		Value: false,
+		Usage: "Log the rule that was resolved for each request as JSON at the debug level. Intended for debugging environments only.",
+	}
+)
+
+// loggedRule is the JSON representation of a resolved rule
+type loggedRule struct {
+	Global   bool                 `json:"global"`
+	Granular *loggedGranularProxy `json:"granular,omitempty"`
+}
+
+type loggedGranularProxy struct {
+	Verbs []string `json:"verbs"`
+}
+
+// marshalRule returns the JSON representation of the given rule
+func marshalRule(rule spec.Rule) string {
+	logged := loggedRule{Global: rule.Global}
+	if rule.Granular != nil {
+		logged.Granular = &loggedGranularProxy{Verbs: rule.Granular.Verbs}
+		if logged.Granular.Verbs == nil {
+			logged.Granular.Verbs = []string{}
+		}
+	}
+
+	b, err := json.Marshal(logged)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// logResolvedRule will, if enabled and the debug level is logged, log the
+// rule resolved for the given request along with whether it permitted the
+// request's method. The rule is only marshaled when it will be logged.
+func logResolvedRule(binding spec.APIKeyBinding, key spec.APIKey, method, targetPath string, rule spec.Rule, err error) {
+	if !viper.GetBool(flagPluginsAPIKeyLogResolvedRule.GetLong()) || logrus.GetLevel() < logrus.DebugLevel {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"binding_name":      binding.ObjectMeta.Name,
+		"binding_namespace": binding.ObjectMeta.Namespace,
+		"key_name":          displayKeyName(key.ObjectMeta.Name),
+		"method":            method,
+		"path":              targetPath,
+		"allowed":           err == nil,
+		"rule":              marshalRule(rule),
+	}).Debug("resolved rule")
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalRule(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`{"global":false}`, marshalRule(spec.Rule{}))
+	assert.Equal(`{"global":true}`, marshalRule(spec.Rule{Global: true}))
+	assert.Equal(`{"global":false,"granular":{"verbs":[]}}`, marshalRule(spec.Rule{Granular: &spec.GranularProxy{}}))
+	assert.Equal(`{"global":false,"granular":{"verbs":["GET","POST"]}}`, marshalRule(spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET", "POST"}}}))
+}
+
+// getResolvedRuleEntry returns the last resolved rule logged, if any
+func getResolvedRuleEntry(hook *test.Hook) *logrus.Entry {
+	for i := len(hook.Entries) - 1; i >= 0; i-- {
+		if hook.Entries[i].Message == "resolved rule" {
+			return hook.Entries[i]
+		}
+	}
+	return nil
+}
+
+func TestLogResolvedRule(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyLogResolvedRule.GetLong(), false)
+	defer logrus.SetLevel(logrus.GetLevel())
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := testutil.Binding(testutil.GranularKey(testutil.KeyName, "GET"))
+	defer testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{binding})()
+	hook := test.NewGlobal()
+
+	request := func(method string) error {
+		return Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request(method, testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	}
+
+	logrus.SetLevel(logrus.DebugLevel)
+	viper.Set(flagPluginsAPIKeyLogResolvedRule.GetLong(), false)
+	hook.Reset()
+	assert.Nil(request("GET"))
+	assert.Nil(getResolvedRuleEntry(hook), "rules should not be logged unless enabled")
+
+	viper.Set(flagPluginsAPIKeyLogResolvedRule.GetLong(), true)
+	hook.Reset()
+	assert.Nil(request("GET"))
+	entry := getResolvedRuleEntry(hook)
+	assert.NotNil(entry)
+	assert.Equal(logrus.DebugLevel, entry.Level)
+	assert.Equal(`{"global":false,"granular":{"verbs":["GET"]}}`, entry.Data["rule"])
+	assert.Equal(true, entry.Data["allowed"])
+	assert.Equal("GET", entry.Data["method"])
+	assert.Equal(testutil.KeyName, entry.Data["key_name"])
+
+	hook.Reset()
+	assert.NotNil(request("DELETE"))
+	entry = getResolvedRuleEntry(hook)
+	assert.NotNil(entry)
+	assert.Equal(`{"global":false,"granular":{"verbs":["GET"]}}`, entry.Data["rule"])
+	assert.Equal(false, entry.Data["allowed"])
+
+	logrus.SetLevel(logrus.InfoLevel)
+	hook.Reset()
+	assert.Nil(request("GET"))
+	assert.Nil(getResolvedRuleEntry(hook), "rules should not be logged above the debug level")
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyRulePrecedence,
+		flagPluginsAPIKeyNormalizeTrailingSlash,
+	)
+}
+
+const (
+	rulePrecedenceMostSpecific = "most_specific"
+	rulePrecedenceFirstMatch   = "first_match"
+)
+
+var (
+	flagPluginsAPIKeyRulePrecedence = config.Flag{
+		Long:  "plugins.apiKey.rule_precedence",
+		Short: "",
+		Value: rulePrecedenceMostSpecific,
+		Usage: "Precedence used when multiple subpath rules match a request. Either most_specific or first_match.",
+	}
+	flagPluginsAPIKeyNormalizeTrailingSlash = config.Flag{
+		Long:  "plugins.apiKey.normalize_trailing_slash",
+		Short: "",
+		Value: false,
+		Usage: "Ignore trailing slashes in request, APIProxy, and rule paths so that /orders and /orders/ match the same rules. Disabled by default.",
+	}
+)
+
+// getRule returns the rule that applies to the given target path.
+//
+// Under the default most_specific precedence the subpath with the longest
+// matching path wins. If several subpaths match equally well, granular
+// rules take priority over global rules. Under first_match precedence the
+// first matching subpath, in the order defined by the binding, wins.
+// In either case the key's default rule is used when no subpath matches.
+func getRule(keyObj *spec.Key, targetPath string) spec.Rule {
+	rule, _, _ := matchRule(keyObj, targetPath)
+	return rule
+}
+
+// matchRule returns the rule getRule selects for the given target path,
+// whether it was selected from a matching subpath, and the number of rules
+// evaluated to select it: every subpath of the key and, if none matches,
+// its default rule.
+func matchRule(keyObj *spec.Key, targetPath string) (spec.Rule, bool, int) {
+	evaluated := 0
+	matches := []*spec.Path{}
+	for _, subpath := range keyObj.Subpaths {
+		if subpath == nil {
+			continue
+		}
+		evaluated++
+		if pathMatches(subpath.Path, targetPath) {
+			matches = append(matches, subpath)
+		}
+	}
+
+	if len(matches) < 1 {
+		return keyObj.DefaultRule, false, evaluated + 1
+	}
+
+	if strings.ToLower(viper.GetString(flagPluginsAPIKeyRulePrecedence.GetLong())) == rulePrecedenceFirstMatch {
+		if len(matches) > 1 {
+			logrus.WithFields(logrus.Fields{
+				"key":  displayKeyName(keyObj.Name),
+				"path": targetPath,
+			}).Warn("multiple subpath rules match this path - the first one will be used")
+		}
+		return matches[0].Rule, true, evaluated
+	}
+
+	best := matches[0]
+	ambiguous := false
+	for _, match := range matches[1:] {
+		switch {
+		case len(normalizeRulePath(match.Path)) > len(normalizeRulePath(best.Path)):
+			best, ambiguous = match, false
+		case len(normalizeRulePath(match.Path)) == len(normalizeRulePath(best.Path)):
+			ambiguous = true
+			if best.Rule.Global && !match.Rule.Global {
+				best = match
+			}
+		}
+	}
+
+	if ambiguous {
+		logrus.WithFields(logrus.Fields{
+			"key":  displayKeyName(keyObj.Name),
+			"path": targetPath,
+		}).Warn("multiple subpath rules match this path equally - granular rules will be given priority")
+	}
+
+	return best.Rule, true, evaluated
+
+}
+
+// hasRuleForPath will return true if a rule has been defined for the given
+// target path, either by a matching subpath or by the key's default rule.
+// A default rule that is neither global nor granular is not a definition.
+func hasRuleForPath(keyObj *spec.Key, targetPath string) bool {
+	if keyObj.DefaultRule.Global || keyObj.DefaultRule.Granular != nil {
+		return true
+	}
+	for _, subpath := range keyObj.Subpaths {
+		if subpath != nil && pathMatches(subpath.Path, targetPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathMatches will return true if the given rule path is equal to or
+// a parent of the given target path. Paths are compared by segment so
+// that /foo matches /foo/bar but not /foobar. Unless trailing slashes
+// are normalized, a rule path with a trailing slash, such as /foo/,
+// only matches the paths below it.
+func pathMatches(rulePath, targetPath string) bool {
+	rulePath = normalizeRulePath(rulePath)
+	targetPath = normalizeRulePath(targetPath)
+
+	if rulePath == "/" || rulePath == targetPath {
+		return true
+	}
+	return strings.HasPrefix(targetPath, strings.TrimSuffix(rulePath, "/")+"/")
+}
+
+// normalizeRulePath ensures a path has a leading slash and, if trailing
+// slashes are normalized, no trailing slash
+func normalizeRulePath(path string) string {
+	if viper.GetBool(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong()) {
+		path = strings.TrimRight(path, "/")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// cleanRequestPath returns the given decoded request path with its dot
+// segments and repeated slashes resolved, keeping any trailing slash, so
+// that /public/../orders cannot be mistaken for a path below /public.
+// False is returned if the cleaned path still contains .., in which case
+// callers must not grant the path any special treatment.
+func cleanRequestPath(requestPath string) (string, bool) {
+	cleaned := path.Clean("/" + requestPath)
+	if strings.HasSuffix(requestPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	if strings.Contains(cleaned, "..") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// getTargetPath returns the path, relative to the given APIProxy, that the
+// given request targets. If trailing slashes are normalized, an APIProxy
+// path of /orders/ is treated as /orders so that a request to /orders is
+// not mistaken for a request to a different path.
+func getTargetPath(p spec.APIProxy, r *http.Request) string {
+	proxyPath := p.Spec.Path
+	if viper.GetBool(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong()) && proxyPath != "/" {
+		proxyPath = strings.TrimRight(proxyPath, "/")
+	}
+	return utils.ComputeTargetPath(proxyPath, p.Spec.Target, r.URL.Path)
+}
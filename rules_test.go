@@ -0,0 +1,219 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRule(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyRulePrecedence.GetLong(), rulePrecedenceMostSpecific)
+
+	global := spec.Rule{Global: true}
+	granular := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+	other := spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}
+
+	key := &spec.Key{
+		Name:        "apikeyone",
+		DefaultRule: other,
+		Subpaths: []*spec.Path{
+			{Path: "/accounts", Rule: global},
+			{Path: "/accounts/details", Rule: granular},
+			{Path: "/orders", Rule: global},
+			{Path: "/orders/", Rule: granular},
+			nil,
+		},
+	}
+
+	viper.Set(flagPluginsAPIKeyRulePrecedence.GetLong(), rulePrecedenceMostSpecific)
+	assert.Equal(global, getRule(key, "/accounts"))
+	assert.Equal(global, getRule(key, "/accounts/summary"))
+	assert.Equal(granular, getRule(key, "/accounts/details"), "most specific path should win")
+	assert.Equal(granular, getRule(key, "/accounts/details/1"), "most specific path should win")
+	assert.Equal(granular, getRule(key, "/orders/1"), "granular rule should win over an equally specific global rule")
+	assert.Equal(other, getRule(key, "/accountsfoo"), "paths should be matched by segment")
+	assert.Equal(other, getRule(key, "/"), "default rule should be used when no subpath matches")
+
+	viper.Set(flagPluginsAPIKeyRulePrecedence.GetLong(), rulePrecedenceFirstMatch)
+	assert.Equal(global, getRule(key, "/accounts/details/1"), "first matching path should win")
+	assert.Equal(global, getRule(key, "/orders/1"), "first matching path should win")
+	assert.Equal(other, getRule(key, "/"))
+}
+
+func TestHasRuleForPath(t *testing.T) {
+	assert := assert.New(t)
+
+	key := &spec.Key{
+		Name: "apikeyone",
+		Subpaths: []*spec.Path{
+			nil,
+			{Path: "/accounts", Rule: spec.Rule{Global: true}},
+		},
+	}
+	assert.True(hasRuleForPath(key, "/accounts"))
+	assert.True(hasRuleForPath(key, "/accounts/1"))
+	assert.False(hasRuleForPath(key, "/orders"))
+	assert.False(hasRuleForPath(key, "/accountsfoo"))
+
+	key.DefaultRule = spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"GET"}}}
+	assert.True(hasRuleForPath(key, "/orders"), "a granular default rule should apply to every path")
+	key.DefaultRule = spec.Rule{Global: true}
+	assert.True(hasRuleForPath(key, "/orders"), "a global default rule should apply to every path")
+}
+
+func TestOnRequestNoRuleForPath(t *testing.T) {
+	assert := assert.New(t)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{}
+	binding.Spec.Keys[0].Subpaths = []*spec.Path{
+		{Path: "/details", Rule: spec.Rule{Granular: &spec.GranularProxy{Verbs: []string{"POST"}}}},
+	}
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(binding)
+
+	r := getTestRequest()
+	r.URL.Path = "/api/v1/accounts/summary"
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("api key has no rule for this path", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_no_rule_for_path", "true", true})
+
+	r = getTestRequest()
+	r.URL.Path = "/api/v1/accounts/details"
+	m = &metrics.Metrics{}
+	err = Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal("api key unauthorized", err.Error(), "methods denied on a configured path should be reported separately")
+	assert.NotContains(*m, metrics.Metric{"api_key_no_rule_for_path", "true", true})
+}
+
+func TestCleanRequestPath(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		path    string
+		cleaned string
+		ok      bool
+	}{
+		{"/foo/bar", "/foo/bar", true},
+		{"/foo/bar/", "/foo/bar/", true},
+		{"foo//bar/./baz", "/foo/bar/baz", true},
+		{"/public/../orders", "/orders", true},
+		{"/public/../../orders/", "/orders/", true},
+		{"/", "/", true},
+		{"", "/", true},
+		{"/foo/..bar", "", false},
+		{"/foo/bar..", "", false},
+	}
+	for _, test := range tests {
+		cleaned, ok := cleanRequestPath(test.path)
+		assert.Equal(test.ok, ok, test.path)
+		assert.Equal(test.cleaned, cleaned, test.path)
+	}
+}
+
+func TestPathMatches(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(false, flagPluginsAPIKeyNormalizeTrailingSlash.Value, "trailing slash normalization should be opt-in")
+	defer viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+	viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), true)
+
+	assert.True(pathMatches("/", "/foo"))
+	assert.True(pathMatches("", "/foo"))
+	assert.True(pathMatches("/foo", "/foo"))
+	assert.True(pathMatches("/foo/", "/foo"))
+	assert.True(pathMatches("/foo", "/foo/"))
+	assert.True(pathMatches("foo", "/foo/bar"))
+	assert.True(pathMatches("/foo", "/foo/bar/"))
+	assert.False(pathMatches("/foo", "/foobar"))
+	assert.False(pathMatches("/foo/bar", "/foo"))
+
+	viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+	assert.True(pathMatches("/", "/foo"))
+	assert.True(pathMatches("/foo", "/foo"))
+	assert.True(pathMatches("/foo", "/foo/"))
+	assert.False(pathMatches("/foo/", "/foo"), "rules with a trailing slash should only match the paths below them")
+	assert.True(pathMatches("/foo/", "/foo/"))
+	assert.True(pathMatches("/foo/", "/foo/bar"))
+	assert.False(pathMatches("/foo", "/foobar"))
+}
+
+func TestGetTargetPath(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+
+	p := getTestAPIProxy()
+	p.Spec.Path = "/api/v1/accounts/"
+	r := getTestRequest()
+
+	viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+	assert.Equal("/api/v1/accounts", getTargetPath(p, r))
+
+	viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), true)
+	assert.Equal("/", getTargetPath(p, r))
+	r.URL.Path = "/api/v1/accounts/"
+	assert.Equal("/", getTargetPath(p, r))
+	r.URL.Path = "/api/v1/accounts/orders/"
+	assert.Equal("/orders/", getTargetPath(p, r))
+}
+
+func TestOnRequestNormalizeTrailingSlash(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].DefaultRule = spec.Rule{}
+	binding.Spec.Keys[0].Subpaths = []*spec.Path{
+		{Path: "/orders/", Rule: spec.Rule{Global: true}},
+	}
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(binding)
+
+	for _, path := range []string{"/api/v1/accounts/orders", "/api/v1/accounts/orders/"} {
+		r := getTestRequest()
+		r.URL.Path = path
+
+		viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), true)
+		assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")), path)
+	}
+
+	r := getTestRequest()
+	r.URL.Path = "/api/v1/accounts/orders"
+	viper.Set(flagPluginsAPIKeyNormalizeTrailingSlash.GetLong(), false)
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal("api key has no rule for this path", err.Error())
+}
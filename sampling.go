@@ -0,0 +1,58 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDenyLogSampleRate,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDenyLogSampleRate = config.Flag{
+		Long:  "plugins.apiKey.deny_log_sample_rate",
+		Short: "",
+		Value: 1,
+		Usage: "Log 1 in every N denied requests. Every denial is logged if 1 or less. Metrics are recorded for every denial regardless.",
+	}
+)
+
+// denialCount is the number of denials seen by this Kanali instance
+var denialCount uint64
+
+// shouldLogDenial counts a denial and will return true if it should be
+// logged under the configured sample rate. The first denial of every
+// sample is logged so that a burst of denials is always noticed.
+func shouldLogDenial() bool {
+	count := atomic.AddUint64(&denialCount, 1)
+	rate := viper.GetInt(flagPluginsAPIKeyDenyLogSampleRate.GetLong())
+	if rate <= 1 {
+		return true
+	}
+	return (count-1)%uint64(rate) == 0
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldLogDenial(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyLogSampleRate.GetLong(), 0)
+	denialCount = 0
+
+	viper.Set(flagPluginsAPIKeyDenyLogSampleRate.GetLong(), 0)
+	assert.True(shouldLogDenial())
+	assert.True(shouldLogDenial())
+
+	viper.Set(flagPluginsAPIKeyDenyLogSampleRate.GetLong(), 1)
+	assert.True(shouldLogDenial())
+
+	denialCount = 0
+	viper.Set(flagPluginsAPIKeyDenyLogSampleRate.GetLong(), 3)
+	logged := []bool{}
+	for i := 0; i < 7; i++ {
+		logged = append(logged, shouldLogDenial())
+	}
+	assert.Equal([]bool{true, false, false, true, false, false, true}, logged)
+}
+
+func TestOnRequestDenyLogSampling(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyDenyLogSampleRate.GetLong(), 0)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyDenyLogSampleRate.GetLong(), 10)
+	denialCount = 0
+	hook := test.NewGlobal()
+
+	denials := 0
+	for i := 0; i < 25; i++ {
+		r := getTestRequest()
+		r.Header.Set("apikey", "stuffed")
+		m := &metrics.Metrics{}
+		assert.NotNil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+		for _, metric := range *m {
+			if metric == (metrics.Metric{"api_key_denied", "true", true}) {
+				denials++
+			}
+		}
+	}
+
+	logged := 0
+	for _, entry := range hook.Entries {
+		if entry.Message == "request denied" {
+			logged++
+		}
+	}
+	assert.Equal(25, denials, "every denial should be recorded as a metric")
+	assert.Equal(3, logged, "1 in 10 denials should be logged")
+}
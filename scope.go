@@ -0,0 +1,83 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+const (
+	// annotationKeyScopes is the APIKey annotation holding a comma
+	// separated list of the scopes granted to the key
+	annotationKeyScopes = "apikey.kanali.io/scopes"
+	// annotationBindingRequiredScopes is the APIKeyBinding annotation
+	// holding a comma separated list of the scopes an apikey must be
+	// granted to use the binding's APIProxy
+	annotationBindingRequiredScopes = "apikey.kanali.io/required-scopes"
+)
+
+// getScopes returns the distinct, sorted scopes in the given
+// comma separated list
+func getScopes(value string) []string {
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, scope := range strings.Split(value, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" && !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// getInsufficientScopeError returns the 403 used when an api key lacks
+// scopes a request requires. The distinct, sorted scopes are listed in the
+// message, separated by spaces as in an OAuth scope parameter, so that
+// clients know which scopes to request.
+func getInsufficientScopeError(required []string) error {
+	return &utils.StatusError{http.StatusForbidden, fmt.Errorf("api key is missing required scopes: %s", strings.Join(getScopes(strings.Join(required, ",")), " "))}
+}
+
+// validateScopes will return an error listing the scopes required by the
+// given APIKeyBinding if the given APIKey has not been granted all of them
+func validateScopes(key spec.APIKey, binding spec.APIKeyBinding) error {
+	required := getScopes(binding.ObjectMeta.Annotations[annotationBindingRequiredScopes])
+	if len(required) < 1 {
+		return nil
+	}
+
+	granted := map[string]bool{}
+	for _, scope := range getScopes(key.ObjectMeta.Annotations[annotationKeyScopes]) {
+		granted[scope] = true
+	}
+	for _, scope := range required {
+		if !granted[scope] {
+			return getInsufficientScopeError(required)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetInsufficientScopeError(t *testing.T) {
+	assert := assert.New(t)
+
+	err := getInsufficientScopeError([]string{"accounts:write", " accounts:read ", "", "accounts:write"})
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("api key is missing required scopes: accounts:read accounts:write", err.Error())
+}
+
+func TestValidateScopes(t *testing.T) {
+	assert := assert.New(t)
+
+	key := testutil.Key()
+	binding := testutil.Binding()
+	assert.Nil(validateScopes(key, binding), "bindings without required scopes should not check scopes")
+
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingRequiredScopes: "accounts:write, accounts:read"}
+	assert.Equal("api key is missing required scopes: accounts:read accounts:write", validateScopes(key, binding).Error())
+
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyScopes: "accounts:read"}
+	assert.Equal("api key is missing required scopes: accounts:read accounts:write", validateScopes(key, binding).Error(), "every required scope should be listed")
+
+	key.ObjectMeta.Annotations[annotationKeyScopes] = "accounts:write,accounts:read,reports:read"
+	assert.Nil(validateScopes(key, binding))
+}
+
+func TestOnRequestScopes(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), false)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	key := testutil.Key()
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyScopes: "accounts:read"}
+	binding := testutil.Binding()
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingRequiredScopes: "accounts:read,accounts:write"}
+	cleanup := testutil.Stores([]spec.APIKey{key}, []spec.APIKeyBinding{binding})
+	defer cleanup()
+
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Contains(err.Error(), "api key is missing required scopes: accounts:read accounts:write")
+
+	viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), true)
+	err = Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span())
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Contains(err.Error(), "accounts:read accounts:write", "the scopes should survive the wrapping applied to every denial")
+	viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), false)
+
+	key.ObjectMeta.Annotations[annotationKeyScopes] = "accounts:read,accounts:write"
+	cleanup()
+	cleanup = testutil.Stores([]spec.APIKey{key}, []spec.APIKeyBinding{binding})
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span()))
+}
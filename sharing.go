@@ -0,0 +1,194 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeySharingThreshold,
+		flagPluginsAPIKeySharingWindow,
+		flagPluginsAPIKeySharingDeny,
+	)
+}
+
+var (
+	flagPluginsAPIKeySharingThreshold = config.Flag{
+		Long:  "plugins.apiKey.sharing_threshold",
+		Short: "",
+		Value: 0,
+		Usage: "Number of distinct client IPs an apikey may be used from within a window before it is suspected of being shared. Disabled if 0.",
+	}
+	flagPluginsAPIKeySharingWindow = config.Flag{
+		Long:  "plugins.apiKey.sharing_window",
+		Short: "",
+		Value: "1h0m0s",
+		Usage: "Window over which the distinct client IPs of an apikey are counted.",
+	}
+	flagPluginsAPIKeySharingDeny = config.Flag{
+		Long:  "plugins.apiKey.sharing_deny",
+		Short: "",
+		Value: false,
+		Usage: "Reject requests made with an apikey suspected of being shared instead of only reporting them.",
+	}
+)
+
+// hyperLogLogPrecision is the number of hash bits used to select a
+// register. 2^10 registers bound each sketch to 1KB with a standard
+// error of about 3%.
+const hyperLogLogPrecision = 10
+
+// hyperLogLog estimates the number of distinct values added to it
+// using a fixed amount of memory
+type hyperLogLog struct {
+	registers [1 << hyperLogLogPrecision]uint8
+}
+
+// add records the given value in the sketch
+func (h *hyperLogLog) add(value string) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	x := mix64(hasher.Sum64())
+
+	index := x >> (64 - hyperLogLogPrecision)
+	rank := uint8(1)
+	for w := x << hyperLogLogPrecision; rank <= 64-hyperLogLogPrecision && w&(1<<63) == 0; w <<= 1 {
+		rank++
+	}
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// count returns the estimated number of distinct values in the sketch
+func (h *hyperLogLog) count() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// mix64 scrambles the bits of a hash so that every bit is
+// equally likely to be set, as HyperLogLog requires
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// ipWindow holds the distinct client IPs of a key seen since a point in time
+type ipWindow struct {
+	start    time.Time
+	ips      *hyperLogLog
+	reported bool
+}
+
+// clientIPs tracks the distinct client IPs of every apikey seen by this
+// Kanali instance
+var clientIPs = struct {
+	sync.Mutex
+	windows map[string]*ipWindow
+}{windows: map[string]*ipWindow{}}
+
+// observeClientIP records the given client IP against the given key and
+// returns the estimated number of distinct client IPs the key has been used
+// from in the current window. True is also returned the first time in a
+// window that the estimate exceeds the given threshold.
+func observeClientIP(keyID, ip string, threshold uint64, window time.Duration, currTime time.Time) (uint64, bool) {
+	clientIPs.Lock()
+	defer clientIPs.Unlock()
+
+	w, ok := clientIPs.windows[keyID]
+	if !ok || !currTime.Before(w.start.Add(window)) {
+		w = &ipWindow{start: currTime, ips: &hyperLogLog{}}
+		clientIPs.windows[keyID] = w
+	}
+
+	w.ips.add(ip)
+	count := w.ips.count()
+	if count <= threshold || w.reported {
+		return count, false
+	}
+	w.reported = true
+	return count, true
+}
+
+// detectKeySharing will, if enabled, record the client IP of the given
+// request against the given APIKey and report the key if it has been used
+// from more distinct client IPs than the configured threshold. An error is
+// only returned if such keys are configured to be rejected.
+func detectKeySharing(m *metrics.Metrics, r *http.Request, key spec.APIKey, currTime time.Time) error {
+	threshold := viper.GetInt(flagPluginsAPIKeySharingThreshold.GetLong())
+	window := viper.GetDuration(flagPluginsAPIKeySharingWindow.GetLong())
+	if threshold <= 0 || window <= 0 {
+		return nil
+	}
+
+	count, crossed := observeClientIP(key.ObjectMeta.Namespace+"/"+key.ObjectMeta.Name, getClientIP(r), uint64(threshold), window, currTime)
+	m.Add(metrics.Metric{"api_key_distinct_ips", strconv.FormatUint(count, 10), false})
+	if crossed {
+		logrus.WithFields(logrus.Fields{
+			"key":          displayKeyName(key.ObjectMeta.Name),
+			"namespace":    key.ObjectMeta.Namespace,
+			"distinct_ips": count,
+			"window":       window.String(),
+		}).Warn("apikey used from more distinct client IPs than allowed - it may have been shared or leaked")
+	}
+	if count <= uint64(threshold) {
+		return nil
+	}
+
+	m.Add(metrics.Metric{"api_key_sharing_suspected", "true", true})
+	if viper.GetBool(flagPluginsAPIKeySharingDeny.GetLong()) {
+		return &utils.StatusError{http.StatusForbidden, errors.New("api key used from too many clients")}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &hyperLogLog{}
+	assert.Equal(uint64(0), h.count())
+
+	for i := 0; i < 100; i++ {
+		h.add("10.0.0.1")
+	}
+	assert.Equal(uint64(1), h.count(), "duplicates should not be counted")
+
+	for i := 2; i <= 5; i++ {
+		h.add(fmt.Sprintf("10.0.0.%d", i))
+	}
+	assert.Equal(uint64(5), h.count())
+
+	for _, n := range []int{1000, 50000} {
+		h = &hyperLogLog{}
+		for i := 0; i < n; i++ {
+			h.add(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff))
+		}
+		errorRate := math.Abs(float64(h.count())-float64(n)) / float64(n)
+		assert.True(errorRate < 0.1, "estimate of %d distinct values should be within 10%%, was %d", n, h.count())
+	}
+}
+
+func TestObserveClientIP(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+
+	count, crossed := observeClientIP("foo/observe", "10.0.0.1", 2, time.Minute, now)
+	assert.Equal(uint64(1), count)
+	assert.False(crossed)
+	count, crossed = observeClientIP("foo/observe", "10.0.0.2", 2, time.Minute, now)
+	assert.Equal(uint64(2), count)
+	assert.False(crossed)
+	count, crossed = observeClientIP("foo/observe", "10.0.0.3", 2, time.Minute, now)
+	assert.Equal(uint64(3), count)
+	assert.True(crossed, "exceeding the threshold should be reported")
+	count, crossed = observeClientIP("foo/observe", "10.0.0.4", 2, time.Minute, now)
+	assert.Equal(uint64(4), count)
+	assert.False(crossed, "exceeding the threshold should only be reported once per window")
+
+	count, _ = observeClientIP("foo/other", "10.0.0.1", 2, time.Minute, now)
+	assert.Equal(uint64(1), count, "keys should be counted independently")
+
+	count, crossed = observeClientIP("foo/observe", "10.0.0.5", 2, time.Minute, now.Add(time.Minute))
+	assert.Equal(uint64(1), count, "counts should reset when the window ends")
+	assert.False(crossed)
+}
+
+func TestOnRequestKeySharing(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySharingThreshold.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeySharingWindow.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeySharingDeny.GetLong(), false)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeySharingThreshold.GetLong(), 2)
+	viper.Set(flagPluginsAPIKeySharingWindow.GetLong(), "1h")
+
+	key := getTestAPIKey()
+	key.ObjectMeta.Name = "sharedapikey"
+	binding := getTestAPIKeyBinding()
+	binding.Spec.Keys[0].Name = "sharedapikey"
+	spec.KeyStore.Set(key)
+	spec.BindingStore.Set(binding)
+
+	send := func(ip string) (*metrics.Metrics, error) {
+		r := getTestRequest()
+		r.RemoteAddr = ip + ":52314"
+		m := &metrics.Metrics{}
+		return m, Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	}
+
+	m, err := send("10.0.0.1")
+	assert.Nil(err)
+	assert.Contains(*m, metrics.Metric{"api_key_distinct_ips", "1", false})
+	send("10.0.0.2")
+	m, err = send("10.0.0.3")
+	assert.Nil(err, "shared keys should only be reported by default")
+	assert.Contains(*m, metrics.Metric{"api_key_distinct_ips", "3", false})
+	assert.Contains(*m, metrics.Metric{"api_key_sharing_suspected", "true", true})
+
+	viper.Set(flagPluginsAPIKeySharingDeny.GetLong(), true)
+	_, err = send("10.0.0.1")
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("api key used from too many clients", err.Error())
+}
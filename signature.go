@@ -0,0 +1,318 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeySignatureRequired,
+		flagPluginsAPIKeySignatureHeader,
+		flagPluginsAPIKeySignatureAlgorithm,
+		flagPluginsAPIKeySignatureAlgorithmHeader,
+		flagPluginsAPIKeySignatureEmptyBody,
+		flagPluginsAPIKeySignatureSignedHeaders,
+		flagPluginsAPIKeySignatureMaxBodyBytes,
+		flagPluginsAPIKeySigningSecretDir,
+	)
+}
+
+var (
+	flagPluginsAPIKeySignatureRequired = config.Flag{
+		Long:  "plugins.apiKey.signature_required",
+		Short: "",
+		Value: false,
+		Usage: "Require every request to carry an HMAC signature computed with the signing secret of its apikey.",
+	}
+	flagPluginsAPIKeySignatureHeader = config.Flag{
+		Long:  "plugins.apiKey.signature_header",
+		Short: "",
+		Value: "X-Apikey-Signature",
+		Usage: "Name of the HTTP header holding the hex encoded request signature.",
+	}
+	flagPluginsAPIKeySignatureAlgorithm = config.Flag{
+		Long:  "plugins.apiKey.signature_algorithm",
+		Short: "",
+		Value: "hmac-sha256",
+		Usage: "Signature algorithm used when a request does not name one. Valid algorithms are hmac-sha256 and hmac-sha512.",
+	}
+	flagPluginsAPIKeySignatureAlgorithmHeader = config.Flag{
+		Long:  "plugins.apiKey.signature_algorithm_header",
+		Short: "",
+		Value: "X-Apikey-Signature-Algorithm",
+		Usage: "Name of the HTTP header a request can use to name its signature algorithm. Requests cannot choose an algorithm if empty.",
+	}
+	flagPluginsAPIKeySignatureEmptyBody = config.Flag{
+		Long:  "plugins.apiKey.signature_empty_body",
+		Short: "",
+		Value: signatureEmptyBodyHash,
+		Usage: "Body line of the canonical request of a request without a body. Either hash, for the SHA-256 hash of an empty string, or empty, for an empty line.",
+	}
+	flagPluginsAPIKeySignatureSignedHeaders = config.Flag{
+		Long:  "plugins.apiKey.signature_signed_headers",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of HTTP headers that must be present in, and covered by the signature of, every signed request.",
+	}
+	flagPluginsAPIKeySignatureMaxBodyBytes = config.Flag{
+		Long:  "plugins.apiKey.signature_max_body_bytes",
+		Short: "",
+		Value: 1048576,
+		Usage: "Maximum size, in bytes, of the body of a signed request. Larger requests are rejected with a 413.",
+	}
+	flagPluginsAPIKeySigningSecretDir = config.Flag{
+		Long:  "plugins.apiKey.signing_secret_dir",
+		Short: "",
+		Value: "/etc/kanali/signing-secrets",
+		Usage: "Directory holding the Secrets referenced by apikey.kanali.io/signing-secret, each mounted in a subdirectory named after it.",
+	}
+)
+
+// annotationKeySigningSecret is the APIKey annotation referencing, in the
+// form <secret>/<key>, the mounted Secret holding its signing secret
+const annotationKeySigningSecret = "apikey.kanali.io/signing-secret"
+
+// defaultSignatureMaxBodyBytes is the body size limit used
+// when signature_max_body_bytes is not positive
+const defaultSignatureMaxBodyBytes = 1048576
+
+const (
+	signatureEmptyBodyHash  = "hash"
+	signatureEmptyBodyEmpty = "empty"
+)
+
+// signatureAlgorithms holds every supported signature algorithm. Weaker
+// algorithms, such as hmac-sha1 and hmac-md5, are deliberately absent.
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// getSignatureAlgorithm returns the hash of the algorithm the
+// given request was signed with. The algorithm named by the request takes
+// priority over the configured default. False is returned if the
+// algorithm is not supported.
+func getSignatureAlgorithm(r *http.Request) (func() hash.Hash, bool) {
+	name := viper.GetString(flagPluginsAPIKeySignatureAlgorithm.GetLong())
+	if header := viper.GetString(flagPluginsAPIKeySignatureAlgorithmHeader.GetLong()); header != "" {
+		if value := r.Header.Get(header); value != "" {
+			name = value
+		}
+	}
+
+	h, ok := signatureAlgorithms[strings.ToLower(strings.TrimSpace(name))]
+	return h, ok
+}
+
+// getCanonicalRequest returns the string a request signature is computed
+// over: the HTTP method, the request URI, the canonical headers, if any
+// headers are signed, and the hex encoded SHA-256 hash of the body,
+// separated by newlines. The body of the request is restored so that it
+// can still be proxied. Bodies larger than signature_max_body_bytes are
+// rejected rather than buffered.
+func getCanonicalRequest(r *http.Request) (string, error) {
+	headers, err := getCanonicalHeaders(r)
+	if err != nil {
+		return "", err
+	}
+
+	body := []byte{}
+	if r.Body != nil {
+		max := viper.GetInt64(flagPluginsAPIKeySignatureMaxBodyBytes.GetLong())
+		if max <= 0 {
+			max = defaultSignatureMaxBodyBytes
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+		if err != nil {
+			return "", &utils.StatusError{http.StatusBadRequest, errors.New("could not read request body")}
+		}
+		if int64(len(b)) > max {
+			return "", &utils.StatusError{http.StatusRequestEntityTooLarge, errors.New("request body too large to verify its signature")}
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		body = b
+	}
+
+	lines := []string{strings.ToUpper(r.Method), r.URL.RequestURI()}
+	lines = append(lines, headers...)
+	return strings.Join(append(lines, getBodyHash(body)), "\n"), nil
+}
+
+// getSignedHeaders returns the lowercased, sorted and deduplicated names of
+// the configured signed headers. The signature header is never signed, as
+// it cannot cover itself.
+func getSignedHeaders() []string {
+	signatureHeader := strings.ToLower(viper.GetString(flagPluginsAPIKeySignatureHeader.GetLong()))
+	set := map[string]bool{}
+	for _, name := range getStringSlice(flagPluginsAPIKeySignatureSignedHeaders.GetLong()) {
+		if name = strings.ToLower(name); name != signatureHeader {
+			set[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getCanonicalHeaders returns the canonical header lines of the given
+// request: one name:value line per signed header, in the order returned by
+// getSignedHeaders, followed by a line listing the signed header names
+// separated by semicolons. The values of a repeated header are joined with
+// commas, and surrounding and repeated whitespace is removed from each. An
+// error is returned if a signed header is missing from the request.
+func getCanonicalHeaders(r *http.Request) ([]string, error) {
+	names := getSignedHeaders()
+	if len(names) < 1 {
+		return nil, nil
+	}
+
+	lines := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		values := r.Header[http.CanonicalHeaderKey(name)]
+		// the host header is moved out of the headers of a received request
+		if name == "host" && r.Host != "" {
+			values = []string{r.Host}
+		}
+		if len(values) < 1 {
+			return nil, &utils.StatusError{http.StatusUnauthorized, fmt.Errorf("signed header %s not found in request", name)}
+		}
+
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		lines = append(lines, name+":"+strings.Join(trimmed, ","))
+	}
+	return append(lines, strings.Join(names, ";")), nil
+}
+
+// getBodyHash returns the body line of a canonical request. A missing body
+// is treated the same as an empty one, such as that of most GET and DELETE
+// requests, and is hashed unless signature_empty_body is empty.
+func getBodyHash(body []byte) string {
+	if len(body) < 1 && strings.ToLower(viper.GetString(flagPluginsAPIKeySignatureEmptyBody.GetLong())) == signatureEmptyBodyEmpty {
+		return ""
+	}
+	bodyHash := sha256.Sum256(body)
+	return hex.EncodeToString(bodyHash[:])
+}
+
+// computeSignature returns the hex encoded HMAC of the given
+// canonical request, using the given hash and secret
+func computeSignature(h func() hash.Hash, secret []byte, canonicalRequest string) string {
+	mac := hmac.New(h, secret)
+	mac.Write([]byte(canonicalRequest))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getSigningSecret returns the signing secret of the given api key, read
+// from the file of the mounted Secret its annotation references. The apikey
+// itself is never used, as it is sent along with every request. A single
+// trailing newline is ignored.
+func getSigningSecret(key spec.APIKey) ([]byte, error) {
+	ref, ok := key.ObjectMeta.Annotations[annotationKeySigningSecret]
+	if !ok {
+		return nil, &utils.StatusError{http.StatusUnauthorized, errors.New("apikey has no signing secret")}
+	}
+
+	parts := strings.Split(strings.TrimSpace(ref), "/")
+	valid := len(parts) == 2
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			valid = false
+		}
+	}
+	if !valid {
+		logrus.WithField("key", displayKeyName(key.ObjectMeta.Name)).Errorf("%s must be of the form <secret>/<key>", annotationKeySigningSecret)
+		return nil, &utils.StatusError{http.StatusUnauthorized, errors.New("apikey has no signing secret")}
+	}
+
+	secret, err := ioutil.ReadFile(filepath.Join(viper.GetString(flagPluginsAPIKeySigningSecretDir.GetLong()), parts[0], parts[1]))
+	if err == nil {
+		secret = bytes.TrimSuffix(bytes.TrimSuffix(secret, []byte("\n")), []byte("\r"))
+	}
+	if err != nil || len(secret) < 1 {
+		logrus.WithField("key", displayKeyName(key.ObjectMeta.Name)).Errorf("could not read signing secret %s", ref)
+		return nil, &utils.StatusError{http.StatusUnauthorized, errors.New("apikey has no signing secret")}
+	}
+	return secret, nil
+}
+
+// verifySignature will, if signatures are required, return an error if the
+// given request does not carry a valid signature computed with the signing
+// secret of the given api key
+func verifySignature(r *http.Request, key spec.APIKey) error {
+	if !viper.GetBool(flagPluginsAPIKeySignatureRequired.GetLong()) {
+		return nil
+	}
+
+	signature := r.Header.Get(viper.GetString(flagPluginsAPIKeySignatureHeader.GetLong()))
+	if signature == "" {
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("request signature not found")}
+	}
+
+	h, ok := getSignatureAlgorithm(r)
+	if !ok {
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("unsupported request signature algorithm")}
+	}
+
+	secret, err := getSigningSecret(key)
+	if err != nil {
+		return err
+	}
+
+	canonicalRequest, err := getCanonicalRequest(r)
+	if err != nil {
+		return err
+	}
+
+	expected := computeSignature(h, secret, canonicalRequest)
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return &utils.StatusError{http.StatusUnauthorized, errors.New("request signature is invalid")}
+	}
+	return nil
+}
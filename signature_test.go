@@ -0,0 +1,342 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSigningSecret is the signing secret of the apikey
+// returned by getTestSigningAPIKey
+const testSigningSecret = "mysigningsecret"
+
+func setTestSignatureConfig() func() {
+	dir, _ := ioutil.TempDir("", "signing-secrets")
+	os.MkdirAll(filepath.Join(dir, "apikeyone"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "apikeyone", "secret"), []byte(testSigningSecret+"\n"), 0644)
+	viper.Set(flagPluginsAPIKeySigningSecretDir.GetLong(), dir)
+	viper.Set(flagPluginsAPIKeySignatureRequired.GetLong(), true)
+	viper.Set(flagPluginsAPIKeySignatureHeader.GetLong(), "X-Apikey-Signature")
+	viper.Set(flagPluginsAPIKeySignatureAlgorithm.GetLong(), "hmac-sha256")
+	viper.Set(flagPluginsAPIKeySignatureAlgorithmHeader.GetLong(), "X-Apikey-Signature-Algorithm")
+	viper.Set(flagPluginsAPIKeySignatureEmptyBody.GetLong(), signatureEmptyBodyHash)
+	return func() {
+		viper.Set(flagPluginsAPIKeySignatureRequired.GetLong(), false)
+		viper.Set(flagPluginsAPIKeySignatureHeader.GetLong(), "")
+		viper.Set(flagPluginsAPIKeySignatureAlgorithm.GetLong(), "")
+		viper.Set(flagPluginsAPIKeySignatureAlgorithmHeader.GetLong(), "")
+		viper.Set(flagPluginsAPIKeySignatureEmptyBody.GetLong(), "")
+		viper.Set(flagPluginsAPIKeySignatureSignedHeaders.GetLong(), "")
+		viper.Set(flagPluginsAPIKeySignatureMaxBodyBytes.GetLong(), 0)
+		viper.Set(flagPluginsAPIKeySigningSecretDir.GetLong(), "")
+		os.RemoveAll(dir)
+	}
+}
+
+func getTestSigningAPIKey() spec.APIKey {
+	key := getTestAPIKey()
+	key.ObjectMeta.Annotations = map[string]string{annotationKeySigningSecret: "apikeyone/secret"}
+	return key
+}
+
+func getTestSignedRequest(body string) *http.Request {
+	r := getTestRequest()
+	r.Method = "POST"
+	r.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+	return r
+}
+
+func TestGetSignatureAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+
+	r := getTestRequest()
+	h, ok := getSignatureAlgorithm(r)
+	assert.True(ok, "the configured algorithm should be used by default")
+	assert.Equal(sha256.Size, h().Size())
+
+	r.Header.Set("X-Apikey-Signature-Algorithm", "HMAC-SHA512")
+	h, ok = getSignatureAlgorithm(r)
+	assert.True(ok, "the algorithm named by the request should take priority")
+	assert.Equal(sha512.Size, h().Size())
+
+	for _, algorithm := range []string{"hmac-sha1", "hmac-md5", "none", "sha256"} {
+		r.Header.Set("X-Apikey-Signature-Algorithm", algorithm)
+		_, ok = getSignatureAlgorithm(r)
+		assert.False(ok, "%s should not be supported", algorithm)
+	}
+
+	viper.Set(flagPluginsAPIKeySignatureAlgorithmHeader.GetLong(), "")
+	h, ok = getSignatureAlgorithm(r)
+	assert.True(ok, "requests should not be able to choose an algorithm when the header is disabled")
+	assert.Equal(sha256.Size, h().Size())
+}
+
+func TestGetCanonicalRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	r := getTestSignedRequest(`{"amount":10}`)
+	r.URL.RawQuery = "limit=10"
+	canonicalRequest, err := getCanonicalRequest(r)
+	assert.Nil(err)
+	assert.Equal("POST\n/api/v1/accounts?limit=10\na8b88b82fe90a16048eb8851fe382405395cd395dafaa7ca9be90ec00f82a72b", canonicalRequest)
+
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(`{"amount":10}`, string(body), "the request body should be restored")
+}
+
+func TestGetSignedHeaders(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+
+	assert.Equal([]string{}, getSignedHeaders())
+
+	viper.Set(flagPluginsAPIKeySignatureSignedHeaders.GetLong(), "X-Date, host, Content-Type, x-date, X-Apikey-Signature")
+	assert.Equal([]string{"content-type", "host", "x-date"}, getSignedHeaders(), "the signature header should never be signed")
+}
+
+func TestGetCanonicalHeaders(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+
+	r := getTestSignedRequest("body")
+	headers, err := getCanonicalHeaders(r)
+	assert.Nil(err)
+	assert.Nil(headers, "the canonical request should not change unless headers are signed")
+
+	viper.Set(flagPluginsAPIKeySignatureSignedHeaders.GetLong(), "X-Date,Host,X-Tenant")
+	r.Host = "api.example.com"
+	r.Header.Set("X-Date", "  20171016T120000Z ")
+	r.Header.Add("X-Tenant", "a  b")
+	r.Header.Add("X-Tenant", "c")
+	headers, err = getCanonicalHeaders(r)
+	assert.Nil(err)
+	assert.Equal([]string{
+		"host:api.example.com",
+		"x-date:20171016T120000Z",
+		"x-tenant:a b,c",
+		"host;x-date;x-tenant",
+	}, headers)
+	assert.Equal([]string{"a  b", "c"}, r.Header["X-Tenant"], "the request headers should not be modified")
+
+	r.Header.Del("X-Date")
+	_, err = getCanonicalHeaders(r)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("signed header x-date not found in request", err.Error())
+}
+
+func TestVerifySignatureSignedHeaders(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+	viper.Set(flagPluginsAPIKeySignatureSignedHeaders.GetLong(), "X-Date,Content-Type")
+	key := getTestSigningAPIKey()
+
+	signed := func() *http.Request {
+		r := getTestSignedRequest("body")
+		r.Header.Set("X-Date", "20171016T120000Z")
+		r.Header.Set("Content-Type", "application/json")
+		canonicalRequest, _ := getCanonicalRequest(r)
+		r.Header.Set("X-Apikey-Signature", computeSignature(sha256.New, []byte(testSigningSecret), canonicalRequest))
+		return r
+	}
+
+	assert.Nil(verifySignature(signed(), key))
+
+	r := signed()
+	r.Header.Set("X-Date", "20171017T120000Z")
+	err := verifySignature(r, key)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("request signature is invalid", err.Error(), "modified signed headers should be detected")
+
+	r = signed()
+	r.Header.Add("Content-Type", "text/plain")
+	assert.Equal("request signature is invalid", verifySignature(r, key).Error(), "added values of signed headers should be detected")
+
+	r = signed()
+	r.Header.Del("Content-Type")
+	err = verifySignature(r, key)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("signed header content-type not found in request", err.Error())
+
+	r = signed()
+	r.Header.Set("X-Unsigned", "anything")
+	assert.Nil(verifySignature(r, key), "headers that are not signed may change")
+}
+
+func TestGetBodyHash(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+
+	emptyHash := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	assert.Equal(emptyHash, getBodyHash(nil))
+	assert.Equal(emptyHash, getBodyHash([]byte{}))
+
+	viper.Set(flagPluginsAPIKeySignatureEmptyBody.GetLong(), "EMPTY")
+	assert.Equal("", getBodyHash(nil))
+	assert.Equal("230d8358dc8e8890b4c58deeb62912ee2f20357ae92a5cc861b98e68fe31acb5", getBodyHash([]byte("body")), "non empty bodies should always be hashed")
+}
+
+func TestVerifySignatureEmptyBody(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+	key := getTestSigningAPIKey()
+
+	for _, mode := range []string{signatureEmptyBodyHash, signatureEmptyBodyEmpty} {
+		viper.Set(flagPluginsAPIKeySignatureEmptyBody.GetLong(), mode)
+		for _, method := range []string{"GET", "DELETE"} {
+			canonicalRequest := method + "\n/api/v1/accounts\n" + getBodyHash(nil)
+			signature := computeSignature(sha256.New, []byte(testSigningSecret), canonicalRequest)
+
+			for _, body := range []io.ReadCloser{nil, http.NoBody, ioutil.NopCloser(bytes.NewBufferString(""))} {
+				r := getTestRequest()
+				r.Method = method
+				r.Body = body
+				r.Header.Set("X-Apikey-Signature", signature)
+				assert.Nil(verifySignature(r, key), "%s requests without a body should be signed deterministically when empty_body is %s", method, mode)
+			}
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+	key := getTestSigningAPIKey()
+
+	viper.Set(flagPluginsAPIKeySignatureRequired.GetLong(), false)
+	assert.Nil(verifySignature(getTestSignedRequest("body"), key), "signatures should not be verified unless required")
+	viper.Set(flagPluginsAPIKeySignatureRequired.GetLong(), true)
+
+	err := verifySignature(getTestSignedRequest("body"), key)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("request signature not found", err.Error())
+
+	for algorithm, h := range signatureAlgorithms {
+		r := getTestSignedRequest("body")
+		canonicalRequest, _ := getCanonicalRequest(getTestSignedRequest("body"))
+		r.Header.Set("X-Apikey-Signature", computeSignature(h, []byte(testSigningSecret), canonicalRequest))
+		r.Header.Set("X-Apikey-Signature-Algorithm", algorithm)
+		assert.Nil(verifySignature(r, key), "%s signatures should be verified", algorithm)
+
+		r = getTestSignedRequest("tampered")
+		r.Header.Set("X-Apikey-Signature", computeSignature(h, []byte(testSigningSecret), canonicalRequest))
+		r.Header.Set("X-Apikey-Signature-Algorithm", algorithm)
+		err = verifySignature(r, key)
+		assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+		assert.Equal("request signature is invalid", err.Error())
+	}
+
+	canonicalRequest, _ := getCanonicalRequest(getTestSignedRequest("body"))
+	r := getTestSignedRequest("body")
+	r.Header.Set("X-Apikey-Signature", computeSignature(sha512.New, []byte(testSigningSecret), canonicalRequest))
+	err = verifySignature(r, key)
+	assert.Equal("request signature is invalid", err.Error(), "signatures should be verified with the configured algorithm by default")
+
+	r = getTestSignedRequest("body")
+	r.Header.Set("X-Apikey-Signature", computeSignature(sha256.New, []byte("notmyapikey"), canonicalRequest))
+	assert.Equal("request signature is invalid", verifySignature(r, key).Error())
+
+	r = getTestSignedRequest("body")
+	r.Header.Set("X-Apikey-Signature", computeSignature(sha256.New, []byte("myapikey"), canonicalRequest))
+	assert.Equal("request signature is invalid", verifySignature(r, key).Error(), "the apikey should not be usable as the signing secret")
+
+	r = getTestSignedRequest("body")
+	r.Header.Set("X-Apikey-Signature", computeSignature(sha256.New, []byte(testSigningSecret), canonicalRequest))
+	r.Header.Set("X-Apikey-Signature-Algorithm", "hmac-sha1")
+	err = verifySignature(r, key)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("unsupported request signature algorithm", err.Error())
+}
+
+func TestOnRequestSignature(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestSigningAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	err := Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+
+	r = getTestRequest()
+	canonicalRequest, _ := getCanonicalRequest(getTestRequest())
+	r.Header.Set("X-Apikey-Signature", computeSignature(sha512.New, []byte(testSigningSecret), canonicalRequest))
+	r.Header.Set("X-Apikey-Signature-Algorithm", "hmac-sha512")
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+}
+
+func TestGetSigningSecret(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+
+	secret, err := getSigningSecret(getTestSigningAPIKey())
+	assert.Nil(err)
+	assert.Equal([]byte(testSigningSecret), secret, "a trailing newline should be ignored")
+
+	for _, ref := range []string{"", "apikeyone", "apikeyone/missing", "../apikeyone/secret", "apikeyone/.."} {
+		key := getTestAPIKey()
+		key.ObjectMeta.Annotations = map[string]string{annotationKeySigningSecret: ref}
+		_, err := getSigningSecret(key)
+		assert.Equal(http.StatusUnauthorized, getStatusCode(err), ref)
+		assert.Equal("apikey has no signing secret", err.Error())
+	}
+
+	_, err = getSigningSecret(getTestAPIKey())
+	assert.Equal("apikey has no signing secret", err.Error())
+}
+
+func TestGetCanonicalRequestMaxBody(t *testing.T) {
+	assert := assert.New(t)
+	defer setTestSignatureConfig()()
+	viper.Set(flagPluginsAPIKeySignatureMaxBodyBytes.GetLong(), 4)
+
+	r := getTestSignedRequest("body")
+	_, err := getCanonicalRequest(r)
+	assert.Nil(err)
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.Equal("body", string(body), "the body should be restored")
+
+	_, err = getCanonicalRequest(getTestSignedRequest("bodies"))
+	assert.Equal(http.StatusRequestEntityTooLarge, getStatusCode(err))
+
+	viper.Set(flagPluginsAPIKeySignatureMaxBodyBytes.GetLong(), 0)
+	_, err = getCanonicalRequest(getTestSignedRequest(strings.Repeat("a", defaultSignatureMaxBodyBytes+1)))
+	assert.Equal(http.StatusRequestEntityTooLarge, getStatusCode(err), "a limit should always apply")
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeySoftDeny,
+	)
+}
+
+var (
+	flagPluginsAPIKeySoftDeny = config.Flag{
+		Long:  "plugins.apiKey.soft_deny",
+		Short: "",
+		Value: false,
+		Usage: "Forward denied requests to the upstream service with a verdict header instead of rejecting them. This disables enforcement by the gateway.",
+	}
+)
+
+const (
+	headerVerdict       = "X-Apikey-Verdict"
+	headerVerdictReason = "X-Apikey-Verdict-Reason"
+	verdictAllow        = "allow"
+	verdictDeny         = "deny"
+)
+
+// applySoftDeny will, if soft deny mode is enabled, record the verdict
+// for the given request in headers destined for the upstream service and
+// return nil so that the request is proxied regardless. Any verdict
+// headers sent by the client are overwritten so they cannot be spoofed.
+// If soft deny mode is disabled, the given error is returned unchanged.
+func applySoftDeny(r *http.Request, err error) error {
+	if !viper.GetBool(flagPluginsAPIKeySoftDeny.GetLong()) {
+		return err
+	}
+
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+
+	if err == nil {
+		r.Header.Set(headerVerdict, verdictAllow)
+		r.Header.Del(headerVerdictReason)
+		return nil
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"reason": err.Error(),
+	}).Warn("soft deny mode is enabled - a denied request is being forwarded to the upstream service")
+
+	r.Header.Set(headerVerdict, verdictDeny)
+	r.Header.Set(headerVerdictReason, err.Error())
+	return nil
+}
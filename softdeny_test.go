@@ -0,0 +1,87 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySoftDeny(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), false)
+
+	err := errors.New("api key unauthorized")
+
+	viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), false)
+	r := &http.Request{}
+	assert.Equal(err, applySoftDeny(r, err))
+	assert.Nil(applySoftDeny(r, nil))
+	assert.Equal("", r.Header.Get(headerVerdict))
+
+	viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), true)
+	r = &http.Request{}
+	assert.Nil(applySoftDeny(r, err))
+	assert.Equal("deny", r.Header.Get(headerVerdict))
+	assert.Equal("api key unauthorized", r.Header.Get(headerVerdictReason))
+
+	r = &http.Request{
+		Header: http.Header{
+			headerVerdict:       []string{"deny"},
+			headerVerdictReason: []string{"spoofed"},
+		},
+	}
+	assert.Nil(applySoftDeny(r, nil))
+	assert.Equal("allow", r.Header.Get(headerVerdict))
+	assert.Equal("", r.Header.Get(headerVerdictReason))
+
+	r = &http.Request{
+		Header: http.Header{
+			headerVerdict: []string{"allow"},
+		},
+	}
+	assert.Nil(applySoftDeny(r, err))
+	assert.Equal("deny", r.Header.Get(headerVerdict), "client supplied verdict should be overwritten")
+}
+
+func TestOnRequestSoftDeny(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), false)
+	viper.Set(flagPluginsAPIKeySoftDeny.GetLong(), true)
+
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.BindingStore.Clear()
+	spec.KeyStore.Set(getTestAPIKey())
+	defer spec.KeyStore.Clear()
+
+	r := getTestRequest()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.Equal("deny", r.Header.Get(headerVerdict))
+	assert.Equal("no binding found for associated APIProxy", r.Header.Get(headerVerdictReason))
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeySpanTagAnnotations,
+		flagPluginsAPIKeySpanTagMax,
+	)
+}
+
+var (
+	flagPluginsAPIKeySpanTagAnnotations = config.Flag{
+		Long:  "plugins.apiKey.span_tag_annotations",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of APIKey annotations copied into span tags prefixed with kanali.api_key_annotation.",
+	}
+	flagPluginsAPIKeySpanTagMax = config.Flag{
+		Long:  "plugins.apiKey.span_tag_max",
+		Short: "",
+		Value: 10,
+		Usage: "Maximum number of annotations copied into span tags for a single request.",
+	}
+)
+
+// spanTagAnnotationPrefix is prepended to the annotation key of every
+// span tag set by setAnnotationSpanTags
+const spanTagAnnotationPrefix = "kanali.api_key_annotation."
+
+// setAnnotationSpanTags copies the configured annotations of the given
+// APIKey, such as a tenant or plan, into tags on the given span. Annotations
+// the key does not have are skipped and at most span_tag_max tags are set.
+func setAnnotationSpanTags(span opentracing.Span, key spec.APIKey) {
+	max := viper.GetInt(flagPluginsAPIKeySpanTagMax.GetLong())
+	set := 0
+	for _, annotation := range getStringSlice(flagPluginsAPIKeySpanTagAnnotations.GetLong()) {
+		value, ok := key.ObjectMeta.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		if set >= max {
+			logrus.Debugf("only %d annotations may be copied into span tags - %s will be skipped", max, annotation)
+			continue
+		}
+		span.SetTag(spanTagAnnotationPrefix+annotation, value)
+		set++
+	}
+}
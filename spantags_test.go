@@ -0,0 +1,86 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestAnnotatedAPIKey() spec.APIKey {
+	key := getTestAPIKey()
+	key.ObjectMeta.Annotations = map[string]string{
+		"example.com/tenant": "acme",
+		"example.com/plan":   "gold",
+		"example.com/team":   "payments",
+	}
+	return key
+}
+
+func TestSetAnnotationSpanTags(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySpanTagAnnotations.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeySpanTagMax.GetLong(), 0)
+	viper.Set(flagPluginsAPIKeySpanTagMax.GetLong(), 10)
+
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	setAnnotationSpanTags(span, getTestAnnotatedAPIKey())
+	assert.Equal(0, len(span.Tags()), "no tags should be set by default")
+
+	viper.Set(flagPluginsAPIKeySpanTagAnnotations.GetLong(), "example.com/tenant,example.com/region,example.com/plan")
+	span = mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	setAnnotationSpanTags(span, getTestAnnotatedAPIKey())
+	assert.Equal(map[string]interface{}{
+		"kanali.api_key_annotation.example.com/tenant": "acme",
+		"kanali.api_key_annotation.example.com/plan":   "gold",
+	}, span.Tags())
+
+	viper.Set(flagPluginsAPIKeySpanTagAnnotations.GetLong(), "example.com/tenant,example.com/plan,example.com/team")
+	viper.Set(flagPluginsAPIKeySpanTagMax.GetLong(), 2)
+	span = mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	setAnnotationSpanTags(span, getTestAnnotatedAPIKey())
+	assert.Equal(2, len(span.Tags()))
+	assert.Nil(span.Tag("kanali.api_key_annotation.example.com/team"))
+}
+
+func TestOnRequestAnnotationSpanTags(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySpanTagAnnotations.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeySpanTagMax.GetLong(), 0)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeySpanTagAnnotations.GetLong(), "example.com/tenant")
+	viper.Set(flagPluginsAPIKeySpanTagMax.GetLong(), 10)
+	spec.KeyStore.Set(getTestAnnotatedAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	span := mocktracer.New().StartSpan("test span").(*mocktracer.MockSpan)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), span))
+	assert.Equal("acme", span.Tag("kanali.api_key_annotation.example.com/tenant"))
+	assert.Equal("apikeyone", span.Tag("kanali.api_key_name"))
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeySunsetWindow,
+	)
+}
+
+var (
+	flagPluginsAPIKeySunsetWindow = config.Flag{
+		Long:  "plugins.apiKey.sunset_window",
+		Short: "",
+		Value: "720h0m0s",
+		Usage: "Responses to requests made with an apikey that expires within this window carry a Sunset header holding its expiry. Sunset headers are not sent if 0.",
+	}
+)
+
+// annotationKeyExpires holds the RFC 3339 time an APIKey expires, which is
+// announced to clients with a Sunset header as it nears. Expiry is not
+// enforced.
+const annotationKeyExpires = "apikey.kanali.io/expires"
+
+// contextKeySunset holds the time.Time the apikey of a request expires,
+// if it is within the sunset window. It is unexported because the time is
+// only returned to the client.
+var contextKeySunset = contextKey("sunset")
+
+// getKeyExpiry returns the expiry of the given APIKey.
+// false is returned if it has no valid expiry.
+func getKeyExpiry(key spec.APIKey) (time.Time, bool) {
+	value, ok := key.ObjectMeta.Annotations[annotationKeyExpires]
+	if !ok {
+		return time.Time{}, false
+	}
+	expires, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+// isWithinSunsetWindow will return true if the given
+// expiry is within the configured sunset window
+func isWithinSunsetWindow(expires, currTime time.Time) bool {
+	window := viper.GetDuration(flagPluginsAPIKeySunsetWindow.GetLong())
+	return window > 0 && expires.Sub(currTime) <= window
+}
+
+// setSunset will, if the given APIKey expires within the sunset window,
+// store its expiry in the context of the given request
+func setSunset(r *http.Request, key spec.APIKey, currTime time.Time) {
+	expires, ok := getKeyExpiry(key)
+	if !ok || !isWithinSunsetWindow(expires, currTime) {
+		return
+	}
+	*r = *r.WithContext(context.WithValue(r.Context(), contextKeySunset, expires))
+}
+
+// setSunsetHeader sets a Sunset header, as defined by RFC 8594, on the
+// given response if the apikey of the given request expires soon
+func setSunsetHeader(r *http.Request, resp *http.Response) {
+	expires, ok := r.Context().Value(contextKeySunset).(time.Time)
+	if !ok || resp == nil {
+		return
+	}
+
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	resp.Header.Set("Sunset", expires.UTC().Format(http.TimeFormat))
+}
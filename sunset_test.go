@@ -0,0 +1,110 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestExpiringAPIKey(expires string) spec.APIKey {
+	key := testutil.Key()
+	key.ObjectMeta.Annotations = map[string]string{annotationKeyExpires: expires}
+	return key
+}
+
+func TestGetKeyExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	expires, ok := getKeyExpiry(getTestExpiringAPIKey("2017-10-01T12:00:00Z"))
+	assert.True(ok)
+	assert.Equal(time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC), expires.UTC())
+	_, ok = getKeyExpiry(getTestExpiringAPIKey("tomorrow"))
+	assert.False(ok)
+	_, ok = getKeyExpiry(testutil.Key())
+	assert.False(ok)
+}
+
+func TestOnRequestExpiredKey(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	cleanup := testutil.Stores([]spec.APIKey{getTestExpiringAPIKey(time.Now().Add(-time.Hour).Format(time.RFC3339))}, []spec.APIKeyBinding{testutil.Binding()})
+	defer cleanup()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, testutil.KeyData), testutil.Span()), "expiry should not be enforced")
+}
+
+func TestIsWithinSunsetWindow(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeySunsetWindow.GetLong(), "0h0m0s")
+	now := time.Now()
+
+	viper.Set(flagPluginsAPIKeySunsetWindow.GetLong(), "0h0m0s")
+	assert.False(isWithinSunsetWindow(now.Add(time.Minute), now))
+
+	viper.Set(flagPluginsAPIKeySunsetWindow.GetLong(), "24h0m0s")
+	assert.True(isWithinSunsetWindow(now.Add(time.Hour), now))
+	assert.True(isWithinSunsetWindow(now.Add(-time.Hour), now))
+	assert.False(isWithinSunsetWindow(now.Add(48*time.Hour), now))
+}
+
+func TestOnResponseSunset(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeySunsetWindow.GetLong(), "0h0m0s")
+	viper.Set(flagPluginsAPIKeySunsetWindow.GetLong(), "24h0m0s")
+
+	// keys inside the sunset window announce their expiry
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	cleanup := testutil.Stores([]spec.APIKey{getTestExpiringAPIKey(expires.Format(time.RFC3339))}, []spec.APIKeyBinding{testutil.Binding()})
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	resp := &http.Response{StatusCode: http.StatusOK}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, resp, testutil.Span()))
+	assert.Equal(expires.Format(http.TimeFormat), resp.Header.Get("Sunset"))
+	cleanup()
+
+	// keys outside the sunset window do not
+	cleanup = testutil.Stores([]spec.APIKey{getTestExpiringAPIKey(time.Now().Add(48 * time.Hour).Format(time.RFC3339))}, []spec.APIKeyBinding{testutil.Binding()})
+	r = testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	resp = &http.Response{StatusCode: http.StatusOK}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, resp, testutil.Span()))
+	assert.Equal("", resp.Header.Get("Sunset"))
+	cleanup()
+
+	// nor do keys without an expiry
+	cleanup = testutil.Stores([]spec.APIKey{testutil.Key()}, []spec.APIKeyBinding{testutil.Binding()})
+	defer cleanup()
+	r = testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	resp = &http.Response{StatusCode: http.StatusOK}
+	assert.Nil(Plugin.OnResponse(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, resp, testutil.Span()))
+	assert.Equal("", resp.Header.Get("Sunset"))
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyTierMaxHeaderBytes,
+		flagPluginsAPIKeyTierMaxBodyBytes,
+	)
+}
+
+var (
+	flagPluginsAPIKeyTierMaxHeaderBytes = config.Flag{
+		Long:  "plugins.apiKey.tier_max_header_bytes",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of tier=bytes pairs limiting the total size of the headers of requests made by apikeys of each tier.",
+	}
+	flagPluginsAPIKeyTierMaxBodyBytes = config.Flag{
+		Long:  "plugins.apiKey.tier_max_body_bytes",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of tier=bytes pairs limiting the size of the body of requests made by apikeys of each tier.",
+	}
+)
+
+// annotationKeyTier is the APIKey annotation holding the name of
+// the tier, or plan, that the key belongs to
+const annotationKeyTier = "apikey.kanali.io/tier"
+
+var errOverTierBudget = &utils.StatusError{http.StatusRequestEntityTooLarge, errors.New("request exceeds the size allowed for the tier of this apikey")}
+
+// getKeyTier returns the tier of the given APIKey. An
+// empty string is returned if the key has no tier.
+func getKeyTier(key spec.APIKey) string {
+	return strings.TrimSpace(key.ObjectMeta.Annotations[annotationKeyTier])
+}
+
+// getTierBudget returns the limit, in bytes, configured for the given
+// tier by the given flag. False is returned if the tier is not limited.
+func getTierBudget(flag config.Flag, tier string) (int64, bool) {
+	if tier == "" {
+		return 0, false
+	}
+	value, ok := getStringMap(flag.GetLong())[tier]
+	if !ok {
+		return 0, false
+	}
+	budget, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || budget < 0 {
+		logrus.Warnf("invalid %s for tier %s: %s", flag.GetLong(), tier, value)
+		return 0, false
+	}
+	return budget, true
+}
+
+// validateTierBudget will return an error if the headers or the body of the
+// given request exceed the budget of the tier of the given APIKey. A body
+// of unknown length is capped at the budget so that reading past it fails.
+func validateTierBudget(r *http.Request, key spec.APIKey) error {
+	tier := getKeyTier(key)
+
+	if budget, ok := getTierBudget(flagPluginsAPIKeyTierMaxHeaderBytes, tier); ok && int64(getHeaderSize(r.Header)) > budget {
+		return errOverTierBudget
+	}
+
+	budget, ok := getTierBudget(flagPluginsAPIKeyTierMaxBodyBytes, tier)
+	if !ok {
+		return nil
+	}
+	if r.ContentLength > budget {
+		return errOverTierBudget
+	}
+	if r.ContentLength < 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(nil, r.Body, budget)
+	}
+	return nil
+}
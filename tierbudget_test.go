@@ -0,0 +1,122 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTieredKey returns an APIKey of the given tier
+func getTieredKey(name, data, tier string) spec.APIKey {
+	key := testutil.NamedKey(name, data)
+	if tier != "" {
+		key.ObjectMeta.Annotations = map[string]string{annotationKeyTier: tier}
+	}
+	return key
+}
+
+func TestGetTierBudget(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyTierMaxBodyBytes.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyTierMaxBodyBytes.GetLong(), "free=1024, gold=1048576, broken=lots, negative=-1")
+
+	budget, ok := getTierBudget(flagPluginsAPIKeyTierMaxBodyBytes, "free")
+	assert.True(ok)
+	assert.Equal(int64(1024), budget)
+	budget, ok = getTierBudget(flagPluginsAPIKeyTierMaxBodyBytes, "gold")
+	assert.True(ok)
+	assert.Equal(int64(1048576), budget)
+
+	for _, tier := range []string{"", "platinum", "broken", "negative"} {
+		_, ok = getTierBudget(flagPluginsAPIKeyTierMaxBodyBytes, tier)
+		assert.False(ok, tier)
+	}
+	_, ok = getTierBudget(flagPluginsAPIKeyTierMaxHeaderBytes, "free")
+	assert.False(ok, "each budget should be configured separately")
+}
+
+func TestValidateTierBudget(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyTierMaxHeaderBytes.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyTierMaxBodyBytes.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyTierMaxHeaderBytes.GetLong(), "free=100,gold=1000")
+	viper.Set(flagPluginsAPIKeyTierMaxBodyBytes.GetLong(), "free=10,gold=100")
+
+	free := getTieredKey(testutil.KeyName, testutil.KeyData, "free")
+	gold := getTieredKey(testutil.KeyName, testutil.KeyData, "gold")
+	untiered := getTieredKey(testutil.KeyName, testutil.KeyData, "")
+
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	r.Header.Set("X-Padding", strings.Repeat("a", 200))
+	assert.Equal(errOverTierBudget, validateTierBudget(r, free))
+	assert.Nil(validateTierBudget(r, gold))
+	assert.Nil(validateTierBudget(r, untiered))
+
+	r = testutil.Request("POST", testutil.ProxyPath, testutil.KeyData)
+	r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 50)))
+	r.ContentLength = 50
+	assert.Equal(errOverTierBudget, validateTierBudget(r, free))
+	assert.Nil(validateTierBudget(r, gold))
+	assert.Nil(validateTierBudget(r, untiered))
+
+	// bodies of unknown length are capped rather than rejected
+	r.ContentLength = -1
+	assert.Nil(validateTierBudget(r, free))
+	body, err := ioutil.ReadAll(r.Body)
+	assert.NotNil(err)
+	assert.Equal(10, len(body))
+}
+
+func TestOnRequestTierBudget(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyTierMaxBodyBytes.GetLong(), "")
+	viper.Set(flagPluginsAPIKeyTierMaxBodyBytes.GetLong(), "free=10,gold=100")
+
+	free := getTieredKey("apikeyfree", "myfreekey", "free")
+	gold := getTieredKey("apikeygold", "mygoldkey", "gold")
+	binding := testutil.Binding(testutil.GlobalKey("apikeyfree"), testutil.GlobalKey("apikeygold"))
+	defer testutil.Stores([]spec.APIKey{free, gold}, []spec.APIKeyBinding{binding})()
+
+	request := func(apiKey string) *http.Request {
+		r := testutil.Request("POST", testutil.ProxyPath, apiKey)
+		r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 50)))
+		r.ContentLength = 50
+		return r
+	}
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, testutil.Proxy(), request("myfreekey"), testutil.Span())
+	assert.Equal(http.StatusRequestEntityTooLarge, getStatusCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_tier_budget_exceeded", "free", true})
+
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), request("mygoldkey"), testutil.Span()))
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/spec"
+)
+
+// annotationBindingTimeout is the APIKeyBinding annotation holding the
+// upstream timeout, as a duration string, requested by that binding
+const annotationBindingTimeout = "apikey.kanali.io/timeout"
+
+// getBindingTimeout returns the upstream timeout requested by a binding.
+// A zero duration is returned if the binding does not request a timeout.
+func getBindingTimeout(binding spec.APIKeyBinding) time.Duration {
+	value, ok := binding.ObjectMeta.Annotations[annotationBindingTimeout]
+	if !ok {
+		return 0
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		logrus.WithFields(logrus.Fields{
+			"binding":   binding.ObjectMeta.Name,
+			"namespace": binding.ObjectMeta.Namespace,
+		}).Warnf("invalid %s annotation %q will be ignored", annotationBindingTimeout, value)
+		return 0
+	}
+	return timeout
+}
+
+// setUpstreamTimeout stores the upstream timeout requested by a binding in
+// the context of the given request. The plugin does not own the upstream
+// call, so it is up to the proxy layer to read ContextKeyUpstreamTimeout
+// and apply the deadline.
+func setUpstreamTimeout(r *http.Request, binding spec.APIKeyBinding) {
+	if timeout := getBindingTimeout(binding); timeout > 0 {
+		*r = *r.WithContext(context.WithValue(r.Context(), ContextKeyUpstreamTimeout, timeout))
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBindingTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	binding := getTestAPIKeyBinding()
+	assert.Equal(time.Duration(0), getBindingTimeout(binding))
+
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingTimeout: "250ms"}
+	assert.Equal(250*time.Millisecond, getBindingTimeout(binding))
+
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingTimeout: "foo"}
+	assert.Equal(time.Duration(0), getBindingTimeout(binding))
+
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingTimeout: "-1s"}
+	assert.Equal(time.Duration(0), getBindingTimeout(binding))
+}
+
+func TestSetUpstreamTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	r, _ := http.NewRequest("GET", "http://host.com", nil)
+	setUpstreamTimeout(r, getTestAPIKeyBinding())
+	assert.Nil(r.Context().Value(ContextKeyUpstreamTimeout))
+
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingTimeout: "3s"}
+	setUpstreamTimeout(r, binding)
+	assert.Equal(3*time.Second, r.Context().Value(ContextKeyUpstreamTimeout))
+}
+
+func TestOnRequestUpstreamTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	binding := getTestAPIKeyBinding()
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingTimeout: "5s"}
+
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(binding)
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+
+	r := getTestRequest()
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.Equal(5*time.Second, r.Context().Value(ContextKeyUpstreamTimeout), "timeout should be propagated to the proxy layer")
+}
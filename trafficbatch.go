@@ -0,0 +1,350 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/server"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyTrafficBatchSize,
+		flagPluginsAPIKeyTrafficBatchInterval,
+		flagPluginsAPIKeyTrafficBatchMaxPending,
+	)
+}
+
+var (
+	flagPluginsAPIKeyTrafficBatchSize = config.Flag{
+		Long:  "plugins.apiKey.traffic_batch_size",
+		Short: "",
+		Value: 0,
+		Usage: "Number of traffic points reported together. Each traffic point is reported by its own goroutine as soon as it is recorded if 0.",
+	}
+	flagPluginsAPIKeyTrafficBatchInterval = config.Flag{
+		Long:  "plugins.apiKey.traffic_batch_interval",
+		Short: "",
+		Value: "0h0m1s",
+		Usage: "Interval at which a partial batch of traffic points is reported. Partial batches are only reported on shutdown if 0.",
+	}
+	flagPluginsAPIKeyTrafficBatchMaxPending = config.Flag{
+		Long:  "plugins.apiKey.traffic_batch_max_pending",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of traffic points waiting to be reported before new traffic points are dropped.",
+	}
+)
+
+// TrafficPoint is a request made by an apikey that is reported
+// to Kanali's traffic store
+type TrafficPoint struct {
+	Binding spec.APIKeyBinding
+	KeyName string
+	Time    time.Time
+}
+
+// TrafficReporter reports a batch of traffic points. Reporters that can
+// write a batch to the traffic store in a single call reduce the load
+// placed on the controller by batching.
+type TrafficReporter interface {
+	ReportTraffic(points []TrafficPoint)
+}
+
+// TrafficReporterFunc allows an ordinary function to be used as a TrafficReporter
+type TrafficReporterFunc func(points []TrafficPoint)
+
+// ReportTraffic calls f(points)
+func (f TrafficReporterFunc) ReportTraffic(points []TrafficPoint) {
+	f(points)
+}
+
+// emitTrafficPoints is the default TrafficReporter. Kanali reports
+// traffic points one at a time, so each point of the batch is emitted
+// in turn.
+var emitTrafficPoints = TrafficReporterFunc(func(points []TrafficPoint) {
+	for _, point := range points {
+		server.Emit(point.Binding, point.KeyName, point.Time)
+	}
+})
+
+var trafficReporter = struct {
+	sync.RWMutex
+	reporter TrafficReporter
+}{}
+
+// SetTrafficReporter configures the TrafficReporter that batches of traffic
+// points are reported with. It can be retrieved via plugin.Lookup and called
+// by Kanali, or another plugin, at startup. Passing nil restores the default,
+// which emits the points of a batch one at a time.
+func SetTrafficReporter(r TrafficReporter) {
+	trafficReporter.Lock()
+	defer trafficReporter.Unlock()
+	trafficReporter.reporter = r
+}
+
+// getTrafficReporter returns the configured TrafficReporter
+func getTrafficReporter() TrafficReporter {
+	trafficReporter.RLock()
+	defer trafficReporter.RUnlock()
+	if trafficReporter.reporter == nil {
+		return emitTrafficPoints
+	}
+	return trafficReporter.reporter
+}
+
+// trafficBatchSettings are the settings a traffic batcher is started with
+type trafficBatchSettings struct {
+	size       int
+	interval   time.Duration
+	maxPending int
+}
+
+// trafficBatcher buffers traffic points in memory and reports them together
+// from a single goroutine, either once a batch is full or when the interval
+// elapses. Full batches wait in a bounded channel so that a slow controller
+// never holds more than the configured number of traffic points in memory.
+type trafficBatcher struct {
+	sync.Mutex
+	size     int
+	interval time.Duration
+	report   func([]TrafficPoint)
+	pending  []TrafficPoint
+	batches  chan []TrafficPoint
+	closed   bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// trafficBatchers holds the traffic batcher started with the current settings
+var trafficBatchers = struct {
+	sync.RWMutex
+	loaded   bool
+	settings trafficBatchSettings
+	instance *trafficBatcher
+}{}
+
+func getTrafficBatchSettings() trafficBatchSettings {
+	return trafficBatchSettings{
+		size:       viper.GetInt(flagPluginsAPIKeyTrafficBatchSize.GetLong()),
+		interval:   viper.GetDuration(flagPluginsAPIKeyTrafficBatchInterval.GetLong()),
+		maxPending: viper.GetInt(flagPluginsAPIKeyTrafficBatchMaxPending.GetLong()),
+	}
+}
+
+// newTrafficBatcher creates a traffic batcher that holds at most maxPending
+// traffic points, counting the partial batch, and reports them with report
+func newTrafficBatcher(settings trafficBatchSettings, report func([]TrafficPoint)) *trafficBatcher {
+	// one batch is always pending, the rest wait to be reported
+	waiting := settings.maxPending/settings.size - 1
+	if waiting < 1 {
+		waiting = 1
+	}
+	return &trafficBatcher{
+		size:     settings.size,
+		interval: settings.interval,
+		report:   report,
+		pending:  make([]TrafficPoint, 0, settings.size),
+		batches:  make(chan []TrafficPoint, waiting),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// reportTraffic reports a batch of traffic points with the configured
+// TrafficReporter
+func reportTraffic(points []TrafficPoint) {
+	getTrafficReporter().ReportTraffic(points)
+}
+
+// getTrafficBatcher returns the configured traffic batcher, starting it on
+// first use. If traffic batching is disabled, nil is returned.
+func getTrafficBatcher() *trafficBatcher {
+	trafficBatchers.RLock()
+	if trafficBatchers.loaded {
+		defer trafficBatchers.RUnlock()
+		return trafficBatchers.instance
+	}
+	trafficBatchers.RUnlock()
+
+	trafficBatchers.Lock()
+	defer trafficBatchers.Unlock()
+	if !trafficBatchers.loaded {
+		startTrafficBatcher(getTrafficBatchSettings())
+	}
+	return trafficBatchers.instance
+}
+
+// reloadTrafficBatcher replaces a started traffic batcher if its settings
+// have changed. Traffic points held by the previous batcher are still
+// reported.
+func reloadTrafficBatcher() {
+	trafficBatchers.Lock()
+	defer trafficBatchers.Unlock()
+
+	settings := getTrafficBatchSettings()
+	if !trafficBatchers.loaded || settings == trafficBatchers.settings {
+		return
+	}
+	if previous := trafficBatchers.instance; previous != nil {
+		go previous.close()
+	}
+	startTrafficBatcher(settings)
+}
+
+// startTrafficBatcher starts a traffic batcher with the given settings,
+// unless they disable it. It must be called with the lock held.
+func startTrafficBatcher(settings trafficBatchSettings) {
+	trafficBatchers.loaded, trafficBatchers.settings, trafficBatchers.instance = true, settings, nil
+	if settings.size < 1 {
+		return
+	}
+	trafficBatchers.instance = newTrafficBatcher(settings, reportTraffic)
+	go trafficBatchers.instance.run()
+}
+
+// emitTraffic reports a request made by the given key, either in
+// its own goroutine or as part of a batch if batching is enabled
+func emitTraffic(binding spec.APIKeyBinding, keyName string, currTime time.Time) {
+	point := TrafficPoint{binding, keyName, currTime}
+	batcher := getTrafficBatcher()
+	if batcher == nil {
+		go reportTraffic([]TrafficPoint{point})
+		return
+	}
+	if !batcher.add(point) {
+		logrus.Warnf("dropped traffic point for apikey %s as too many are waiting to be reported", displayKeyName(keyName))
+	}
+}
+
+// add buffers a traffic point, handing the partial batch to the reporting
+// goroutine once it is full. It never blocks on the controller and will
+// return false if the point was dropped because too many are waiting.
+// Traffic points added once the batcher has been closed are reported in
+// their own goroutine.
+func (b *trafficBatcher) add(point TrafficPoint) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		go b.report([]TrafficPoint{point})
+		return true
+	}
+	if len(b.pending) >= b.size && !b.handOff() {
+		return false
+	}
+	b.pending = append(b.pending, point)
+	if len(b.pending) >= b.size {
+		b.handOff()
+	}
+	return true
+}
+
+// handOff queues the partial batch to be reported, returning false if
+// too many batches are already waiting. It must be called with the lock
+// held.
+func (b *trafficBatcher) handOff() bool {
+	select {
+	case b.batches <- b.pending:
+		b.pending = make([]TrafficPoint, 0, b.size)
+		return true
+	default:
+		return false
+	}
+}
+
+// takePending removes and returns the partial batch
+func (b *trafficBatcher) takePending() []TrafficPoint {
+	b.Lock()
+	defer b.Unlock()
+	points := b.pending
+	b.pending = make([]TrafficPoint, 0, b.size)
+	return points
+}
+
+// flush reports the given traffic points, if there are any
+func (b *trafficBatcher) flush(points []TrafficPoint) {
+	if len(points) > 0 {
+		b.report(points)
+	}
+}
+
+// run reports full batches as they are handed off and the partial batch
+// at the configured interval until close is called, at which point every
+// remaining traffic point is reported
+func (b *trafficBatcher) run() {
+	defer close(b.done)
+
+	var tick <-chan time.Time
+	if b.interval > 0 {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case points := <-b.batches:
+			b.flush(points)
+		case <-tick:
+			b.flush(b.takePending())
+		case <-b.stop:
+			// no batch is handed off once closed
+			b.Lock()
+			b.closed = true
+			b.Unlock()
+			for {
+				select {
+				case points := <-b.batches:
+					b.flush(points)
+				default:
+					b.flush(b.takePending())
+					return
+				}
+			}
+		}
+	}
+}
+
+// close stops the batcher and waits until every
+// traffic point it holds has been reported
+func (b *trafficBatcher) close() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+}
+
+// FlushTraffic reports every traffic point that is waiting to be reported
+// and stops batching. It should be called once when Kanali is shutting down
+// so that no traffic is lost. Traffic points recorded afterwards are
+// reported in their own goroutine. It does nothing if
+// plugins.apiKey.traffic_batch_size is not set.
+func FlushTraffic() {
+	if batcher := getTrafficBatcher(); batcher != nil {
+		batcher.close()
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingReporter collects the names of the keys of each reported batch
+type recordingReporter struct {
+	sync.Mutex
+	batches  [][]string
+	reported chan struct{}
+}
+
+func newRecordingReporter() *recordingReporter {
+	return &recordingReporter{reported: make(chan struct{}, 100)}
+}
+
+func (r *recordingReporter) report(points []TrafficPoint) {
+	keys := []string{}
+	for _, point := range points {
+		keys = append(keys, point.KeyName)
+	}
+	r.Lock()
+	r.batches = append(r.batches, keys)
+	r.Unlock()
+	r.reported <- struct{}{}
+}
+
+func (r *recordingReporter) getBatches() [][]string {
+	r.Lock()
+	defer r.Unlock()
+	return append([][]string{}, r.batches...)
+}
+
+// waitFor waits until n batches have been reported
+func (r *recordingReporter) waitFor(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.reported:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+func TestTrafficBatcherSize(t *testing.T) {
+	assert := assert.New(t)
+	reporter := newRecordingReporter()
+	binding := testutil.Binding()
+	batcher := newTrafficBatcher(trafficBatchSettings{size: 2, maxPending: 100}, reporter.report)
+	go batcher.run()
+	defer batcher.close()
+
+	assert.True(batcher.add(TrafficPoint{binding, "one", time.Now()}))
+	assert.False(reporter.waitFor(1, 50*time.Millisecond), "a partial batch should not be reported without an interval")
+	assert.True(batcher.add(TrafficPoint{binding, "two", time.Now()}))
+	assert.True(batcher.add(TrafficPoint{binding, "three", time.Now()}))
+	assert.True(batcher.add(TrafficPoint{binding, "four", time.Now()}))
+	assert.True(reporter.waitFor(2, time.Second), "full batches should be reported")
+	assert.Equal([][]string{{"one", "two"}, {"three", "four"}}, reporter.getBatches(), "each full batch should be reported in a single call, in order")
+}
+
+func TestTrafficBatcherInterval(t *testing.T) {
+	assert := assert.New(t)
+	reporter := newRecordingReporter()
+	binding := testutil.Binding()
+	batcher := newTrafficBatcher(trafficBatchSettings{size: 100, interval: 20 * time.Millisecond, maxPending: 1000}, reporter.report)
+	go batcher.run()
+	defer batcher.close()
+
+	assert.True(batcher.add(TrafficPoint{binding, "one", time.Now()}))
+	assert.True(batcher.add(TrafficPoint{binding, "two", time.Now()}))
+	assert.True(reporter.waitFor(1, time.Second), "a partial batch should be reported when the interval elapses")
+	assert.Equal([][]string{{"one", "two"}}, reporter.getBatches())
+
+	assert.False(reporter.waitFor(1, 60*time.Millisecond), "empty batches should not be reported")
+}
+
+func TestTrafficBatcherMaxPending(t *testing.T) {
+	assert := assert.New(t)
+	reporter := newRecordingReporter()
+	binding := testutil.Binding()
+	batcher := newTrafficBatcher(trafficBatchSettings{size: 2, maxPending: 4}, reporter.report)
+
+	// nothing is reported until run is called, as if the controller were slow
+	for _, key := range []string{"one", "two", "three", "four"} {
+		assert.True(batcher.add(TrafficPoint{binding, key, time.Now()}))
+	}
+	assert.False(batcher.add(TrafficPoint{binding, "five", time.Now()}), "traffic should be dropped once too many points are waiting")
+
+	go batcher.run()
+	batcher.close()
+	assert.Equal([][]string{{"one", "two"}, {"three", "four"}}, reporter.getBatches(), "waiting batches should be reported on close")
+}
+
+func TestTrafficBatcherClose(t *testing.T) {
+	assert := assert.New(t)
+	reporter := newRecordingReporter()
+	binding := testutil.Binding()
+	batcher := newTrafficBatcher(trafficBatchSettings{size: 10, interval: time.Hour, maxPending: 100}, reporter.report)
+	go batcher.run()
+
+	assert.True(batcher.add(TrafficPoint{binding, "one", time.Now()}))
+	batcher.close()
+	batcher.close()
+	assert.Equal([][]string{{"one"}}, reporter.getBatches(), "the partial batch should be reported on close")
+
+	assert.True(batcher.add(TrafficPoint{binding, "two", time.Now()}), "traffic should still be reported once the batcher is closed")
+	assert.True(reporter.waitFor(2, time.Second))
+}
+
+func TestFlushTraffic(t *testing.T) {
+	assert := assert.New(t)
+	reporter := newRecordingReporter()
+	SetTrafficReporter(TrafficReporterFunc(reporter.report))
+	defer SetTrafficReporter(nil)
+	defer func() {
+		viper.Set(flagPluginsAPIKeyTrafficBatchSize.GetLong(), 0)
+		viper.Set(flagPluginsAPIKeyTrafficBatchInterval.GetLong(), "0s")
+		reloadTrafficBatcher()
+	}()
+
+	viper.Set(flagPluginsAPIKeyTrafficBatchSize.GetLong(), 10)
+	viper.Set(flagPluginsAPIKeyTrafficBatchInterval.GetLong(), "1h")
+	reloadTrafficBatcher()
+	getTrafficBatcher()
+
+	emitTraffic(testutil.Binding(), "one", time.Now())
+	emitTraffic(testutil.Binding(), "two", time.Now())
+	assert.Equal(0, len(reporter.getBatches()))
+	FlushTraffic()
+	assert.Equal([][]string{{"one", "two"}}, reporter.getBatches(), "traffic should be reported with the configured reporter on shutdown")
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyStoreRetryAfter,
+	)
+}
+
+var (
+	flagPluginsAPIKeyStoreRetryAfter = config.Flag{
+		Long:  "plugins.apiKey.store_retry_after",
+		Short: "",
+		Value: 5,
+		Usage: "Number of seconds after which clients are asked to retry when a store is unable to answer. Clients are not told when to retry if 0.",
+	}
+)
+
+// getStoreUnavailableError returns the error used when a store is unable
+// to answer, such as while it is reloading. Unlike a missing apikey or
+// binding, this condition is temporary, so clients are asked to retry.
+// This applies regardless of fail_open, which only covers unexpected
+// failures, so that an unavailable store never bypasses authorization.
+func getStoreUnavailableError() error {
+	err := &utils.StatusError{http.StatusServiceUnavailable, errors.New("apikey store unavailable. please retry later")}
+
+	retryAfter := viper.GetInt(flagPluginsAPIKeyStoreRetryAfter.GetLong())
+	if retryAfter <= 0 {
+		return err
+	}
+	return withRetryAfter(err, retryAfter)
+}
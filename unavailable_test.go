@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStoreUnavailableError(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyStoreRetryAfter.GetLong(), 0)
+
+	viper.Set(flagPluginsAPIKeyStoreRetryAfter.GetLong(), 0)
+	err := getStoreUnavailableError()
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+	assert.Equal("apikey store unavailable. please retry later", err.Error())
+
+	viper.Set(flagPluginsAPIKeyStoreRetryAfter.GetLong(), 7)
+	err = getStoreUnavailableError()
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+	assert.Equal("apikey store unavailable. please retry later - retry after 7 seconds", err.Error())
+}
+
+func TestOnRequestStoreUnavailable(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyStoreRetryAfter.GetLong(), 0)
+	defer viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), "")
+	defer resetFederatedStore("")
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyStoreRetryAfter.GetLong(), 3)
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	// simulate a store that is reloading until told otherwise
+	reloading := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reloading {
+			http.Error(w, "reloading", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(headerFederatedAPIKey) != "myapikey" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(getTestAPIKey())
+	}))
+	defer server.Close()
+	resetFederatedStore(server.URL)
+	viper.Set(flagPluginsAPIKeyKeyStoreOrder.GetLong(), keyStoreFederated)
+
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusServiceUnavailable, getStatusCode(err))
+	assert.Equal("apikey store unavailable. please retry later - retry after 3 seconds", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_store_unavailable", "true", true})
+
+	reloading = false
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")))
+
+	r := getTestRequest()
+	r.Header.Set("apikey", "notmyapikey")
+	m = &metrics.Metrics{}
+	err = Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err), "missing keys should still be denied")
+	assert.NotContains(*m, metrics.Metric{"api_key_store_unavailable", "true", true})
+}
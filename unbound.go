@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyUnboundKeyPolicy,
+	)
+}
+
+var (
+	flagPluginsAPIKeyUnboundKeyPolicy = config.Flag{
+		Long:  "plugins.apiKey.unbound_key_policy",
+		Short: "",
+		Value: unboundKeyForbidden,
+		Usage: "Response to a request made with an apikey that exists but is not bound to the APIProxy. Either forbidden, for a 403, or unauthorized, for a 401 as if the apikey were invalid.",
+	}
+)
+
+const (
+	unboundKeyForbidden    = "forbidden"
+	unboundKeyUnauthorized = "unauthorized"
+)
+
+// withUnboundKeyStatus returns the given error, which denies a request made
+// with an apikey that is not bound to the APIProxy, with the status code of
+// the configured policy. Its message, and so its reason code, is unchanged
+// so that such requests remain distinguishable from unknown apikeys.
+func withUnboundKeyStatus(err error) error {
+	switch policy := strings.ToLower(strings.TrimSpace(viper.GetString(flagPluginsAPIKeyUnboundKeyPolicy.GetLong()))); policy {
+	case "", unboundKeyForbidden:
+		return withForbiddenStatus(err)
+	case unboundKeyUnauthorized:
+		return withStatusCode(err, http.StatusUnauthorized)
+	default:
+		logrus.Warnf("unknown %s %s - unbound apikeys will be forbidden", flagPluginsAPIKeyUnboundKeyPolicy.GetLong(), policy)
+		return withForbiddenStatus(err)
+	}
+}
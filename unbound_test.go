@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithUnboundKeyStatus(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyUnboundKeyPolicy.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), false)
+
+	for policy, status := range map[string]int{
+		"":             http.StatusForbidden,
+		"forbidden":    http.StatusForbidden,
+		"Unauthorized": http.StatusUnauthorized,
+		"ignore":       http.StatusForbidden,
+	} {
+		viper.Set(flagPluginsAPIKeyUnboundKeyPolicy.GetLong(), policy)
+		err := withUnboundKeyStatus(errKeyNotBound)
+		assert.Equal(status, getStatusCode(err), policy)
+		assert.Equal(errKeyNotBound.Error(), err.Error(), policy)
+	}
+
+	viper.Set(flagPluginsAPIKeyUnboundKeyPolicy.GetLong(), unboundKeyForbidden)
+	viper.Set(flagPluginsAPIKeyForbiddenAsUnauthorized.GetLong(), true)
+	assert.Equal(http.StatusUnauthorized, getStatusCode(withUnboundKeyStatus(errKeyNotBound)), "the compatibility flag should still apply")
+}
+
+func TestOnRequestUnboundKey(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(flagPluginsAPIKeyUnboundKeyPolicy.GetLong(), "")
+
+	unbound := testutil.NamedKey("apikeyunbound", "myunboundkey")
+	defer testutil.Stores([]spec.APIKey{testutil.Key(), unbound}, []spec.APIKeyBinding{testutil.Binding()})()
+
+	request := func(apiKey string) (*metrics.Metrics, error) {
+		m := &metrics.Metrics{}
+		return m, Plugin.OnRequest(context.Background(), m, testutil.Proxy(), testutil.Request("GET", testutil.ProxyPath, apiKey), testutil.Span())
+	}
+
+	m, err := request("myunboundkey")
+	assert.Equal(http.StatusForbidden, getStatusCode(err))
+	assert.Equal("api_key_not_authorized_for_this_proxy", getDenyReasonCode(err))
+	assert.Contains(*m, metrics.Metric{"api_key_not_bound", "true", true})
+
+	viper.Set(flagPluginsAPIKeyUnboundKeyPolicy.GetLong(), unboundKeyUnauthorized)
+	m, err = request("myunboundkey")
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("api_key_not_authorized_for_this_proxy", getDenyReasonCode(err), "unbound keys should remain distinguishable from unknown keys")
+	assert.Contains(*m, metrics.Metric{"api_key_not_bound", "true", true})
+
+	m, err = request("notmyapikey")
+	assert.Equal(http.StatusUnauthorized, getStatusCode(err))
+	assert.Equal("apikey_not_found_in_k8s_cluster", getDenyReasonCode(err))
+	assert.NotContains(*m, metrics.Metric{"api_key_not_bound", "true", true})
+
+	_, err = request(testutil.KeyData)
+	assert.Nil(err)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+// annotationBindingUpstreamHeaders is the APIKeyBinding annotation holding
+// the headers, of the form name1=value1,name2=value2, added to every
+// request the binding authorizes before it is proxied upstream
+const annotationBindingUpstreamHeaders = "apikey.kanali.io/upstream-headers"
+
+// reservedUpstreamHeaders holds the headers a binding cannot set, because
+// they carry credentials, verdicts, or framing the upstream relies on
+var reservedUpstreamHeaders = map[string]bool{
+	"Authorization":     true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Transfer-Encoding": true,
+	headerVerdict:       true,
+	headerVerdictReason: true,
+}
+
+// isValidHeaderName will return true if the given header name is a
+// non-empty HTTP token
+func isValidHeaderName(name string) bool {
+	return name != "" && strings.IndexFunc(name, func(c rune) bool {
+		return c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c)
+	}) < 0
+}
+
+// getUpstreamHeaders returns the headers a binding adds to the requests it
+// authorizes. Reserved headers, the apikey header, and invalid header
+// names are ignored.
+func getUpstreamHeaders(binding spec.APIKeyBinding) http.Header {
+	value, ok := binding.ObjectMeta.Annotations[annotationBindingUpstreamHeaders]
+	if !ok {
+		return nil
+	}
+
+	keyHeader := http.CanonicalHeaderKey(viper.GetString(flagPluginsAPIKeyHeaderKey.GetLong()))
+	header := http.Header{}
+	for name, value := range parseStringMap(value) {
+		canonical := http.CanonicalHeaderKey(name)
+		if !isValidHeaderName(name) || reservedUpstreamHeaders[canonical] || canonical == keyHeader {
+			logrus.WithFields(logrus.Fields{
+				"binding":   binding.ObjectMeta.Name,
+				"namespace": binding.ObjectMeta.Namespace,
+			}).Warnf("header %s in the %s annotation cannot be set and will be ignored", name, annotationBindingUpstreamHeaders)
+			continue
+		}
+		header.Set(canonical, value)
+	}
+	return header
+}
+
+// setUpstreamHeaders adds the headers of the given binding to the given
+// request so that services downstream can route on the binding that
+// authorized it. Values sent by the client are overwritten so that they
+// cannot be spoofed.
+func setUpstreamHeaders(r *http.Request, binding spec.APIKeyBinding) {
+	header := getUpstreamHeaders(binding)
+	if len(header) < 1 {
+		return
+	}
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	for name := range header {
+		r.Header.Set(name, header.Get(name))
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali-plugin-apikey/internal/testutil"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestUpstreamHeadersBinding(headers string) spec.APIKeyBinding {
+	binding := testutil.Binding(testutil.GlobalKey(testutil.KeyName))
+	binding.ObjectMeta.Annotations = map[string]string{annotationBindingUpstreamHeaders: headers}
+	return binding
+}
+
+func TestIsValidHeaderName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isValidHeaderName("X-Backend-Pool"))
+	assert.True(isValidHeaderName("x_tier.1"))
+	assert.False(isValidHeaderName(""))
+	assert.False(isValidHeaderName("X Backend"))
+	assert.False(isValidHeaderName("X-Backend:Pool"))
+	assert.False(isValidHeaderName("X-Bäckend"))
+}
+
+func TestGetUpstreamHeaders(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	assert.Nil(getUpstreamHeaders(testutil.Binding()))
+
+	header := getUpstreamHeaders(getTestUpstreamHeadersBinding("x-backend-pool=blue, X-Tier = gold, malformed"))
+	assert.Equal(http.Header{
+		"X-Backend-Pool": []string{"blue"},
+		"X-Tier":         []string{"gold"},
+	}, header)
+
+	header = getUpstreamHeaders(getTestUpstreamHeadersBinding("Authorization=Bearer foo,host=evil.com,apikey=foo,X-Apikey-Verdict=allow,Bad Name=foo,X-Empty="))
+	assert.Equal(http.Header{"X-Empty": []string{""}}, header, "reserved and invalid headers should be ignored")
+}
+
+func TestSetUpstreamHeaders(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	r := testutil.Request("GET", testutil.ProxyPath, testutil.KeyData)
+	r.Header.Set("X-Backend-Pool", "spoofed")
+	r.Header.Set("X-Other", "kept")
+	setUpstreamHeaders(r, getTestUpstreamHeadersBinding("X-Backend-Pool=blue"))
+	assert.Equal([]string{"blue"}, r.Header["X-Backend-Pool"], "client values should be overwritten")
+	assert.Equal("kept", r.Header.Get("X-Other"))
+	assert.Equal(testutil.KeyData, r.Header.Get("apikey"))
+
+	r = &http.Request{}
+	setUpstreamHeaders(r, getTestUpstreamHeadersBinding("X-Backend-Pool=blue"))
+	assert.Equal("blue", r.Header.Get("X-Backend-Pool"))
+
+	r = &http.Request{}
+	setUpstreamHeaders(r, testutil.Binding())
+	assert.Nil(r.Header)
+}
+
+func TestOnRequestUpstreamHeaders(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+
+	binding := getTestUpstreamHeadersBinding("X-Backend-Pool=blue")
+	binding.Spec.Keys = append(binding.Spec.Keys, testutil.GranularKey("apikeytwo", "GET"))
+	defer testutil.Stores([]spec.APIKey{testutil.Key(), testutil.NamedKey("apikeytwo", "myotherapikey")}, []spec.APIKeyBinding{binding})()
+
+	r := testutil.Request("DELETE", testutil.ProxyPath, testutil.KeyData)
+	assert.Nil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	assert.Equal("blue", r.Header.Get("X-Backend-Pool"))
+
+	r = testutil.Request("DELETE", testutil.ProxyPath, "myotherapikey")
+	assert.NotNil(Plugin.OnRequest(context.Background(), &metrics.Metrics{}, testutil.Proxy(), r, testutil.Span()))
+	assert.Equal("", r.Header.Get("X-Backend-Pool"), "denied requests should not carry binding headers")
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyBlockedUserAgents,
+		flagPluginsAPIKeyBlockMissingUserAgent,
+	)
+}
+
+var (
+	flagPluginsAPIKeyBlockedUserAgents = config.Flag{
+		Long:  "plugins.apiKey.blocked_user_agents",
+		Short: "",
+		Value: "",
+		Usage: "Comma separated list of regular expressions. Requests whose User-Agent contains a match are rejected.",
+	}
+	flagPluginsAPIKeyBlockMissingUserAgent = config.Flag{
+		Long:  "plugins.apiKey.block_missing_user_agent",
+		Short: "",
+		Value: false,
+		Usage: "Reject requests that do not have a User-Agent header.",
+	}
+)
+
+// userAgentPatterns caches the compiled blocked user agent
+// patterns along with the configuration they were compiled from
+var userAgentPatterns = struct {
+	sync.Mutex
+	source   string
+	patterns []*regexp.Regexp
+}{}
+
+// getBlockedUserAgentPatterns returns the compiled blocked user agent
+// patterns. Patterns are only recompiled if the configuration changes.
+// Invalid regular expressions are matched as plain substrings.
+func getBlockedUserAgentPatterns() []*regexp.Regexp {
+	userAgentPatterns.Lock()
+	defer userAgentPatterns.Unlock()
+
+	source := viper.GetString(flagPluginsAPIKeyBlockedUserAgents.GetLong())
+	if source == userAgentPatterns.source && userAgentPatterns.patterns != nil {
+		return userAgentPatterns.patterns
+	}
+
+	patterns := []*regexp.Regexp{}
+	for _, expr := range getStringSlice(flagPluginsAPIKeyBlockedUserAgents.GetLong()) {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			logrus.Warnf("blocked user agent %s is not a valid regular expression and will be matched literally", expr)
+			pattern = regexp.MustCompile(regexp.QuoteMeta(expr))
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	userAgentPatterns.source = source
+	userAgentPatterns.patterns = patterns
+	return patterns
+}
+
+// isUserAgentBlocked will return true if requests
+// with the given User-Agent should be rejected
+func isUserAgentBlocked(userAgent string) bool {
+	if strings.TrimSpace(userAgent) == "" {
+		return viper.GetBool(flagPluginsAPIKeyBlockMissingUserAgent.GetLong())
+	}
+
+	for _, pattern := range getBlockedUserAgentPatterns() {
+		if pattern.MatchString(userAgent) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUserAgentBlocked(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyBlockedUserAgents.GetLong(), "")
+	defer viper.Set(flagPluginsAPIKeyBlockMissingUserAgent.GetLong(), false)
+
+	viper.Set(flagPluginsAPIKeyBlockedUserAgents.GetLong(), "")
+	assert.False(isUserAgentBlocked("BadBot/1.0"), "no user agents should be blocked when none are configured")
+
+	viper.Set(flagPluginsAPIKeyBlockedUserAgents.GetLong(), `BadBot, ^python-requests/2\.[0-9]+, scraper(`)
+	assert.True(isUserAgentBlocked("Mozilla/5.0 (compatible; BadBot/1.0)"), "substrings should match")
+	assert.True(isUserAgentBlocked("python-requests/2.18"))
+	assert.False(isUserAgentBlocked("my-python-requests/2.18"), "anchored expressions should be respected")
+	assert.True(isUserAgentBlocked("a scraper(v2)"), "invalid expressions should be matched literally")
+	assert.False(isUserAgentBlocked("a scraper"))
+	assert.False(isUserAgentBlocked("curl/7.54.0"))
+	assert.False(isUserAgentBlocked("badbot"), "matching should be case sensitive")
+
+	assert.False(isUserAgentBlocked(""), "missing user agents should be allowed by default")
+	viper.Set(flagPluginsAPIKeyBlockMissingUserAgent.GetLong(), true)
+	assert.True(isUserAgentBlocked(""))
+	assert.True(isUserAgentBlocked("  "))
+	assert.False(isUserAgentBlocked("curl/7.54.0"))
+
+	viper.Set(flagPluginsAPIKeyBlockedUserAgents.GetLong(), "curl")
+	assert.True(isUserAgentBlocked("curl/7.54.0"), "patterns should be recompiled when the configuration changes")
+}
+
+func TestOnRequestBlockedUserAgent(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(flagPluginsAPIKeyBlockedUserAgents.GetLong(), "")
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	viper.SetDefault(flagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	viper.Set(flagPluginsAPIKeyBlockedUserAgents.GetLong(), "BadBot")
+	spec.KeyStore.Set(getTestAPIKey())
+	spec.BindingStore.Set(getTestAPIKeyBinding())
+
+	r := getTestRequest()
+	r.Header.Set("User-Agent", "BadBot/1.0")
+	m := &metrics.Metrics{}
+	err := Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span"))
+	assert.Equal(http.StatusForbidden, getStatusCode(err), "blocked user agents should be rejected even with a valid apikey")
+	assert.Equal("user agent not allowed", err.Error())
+	assert.Contains(*m, metrics.Metric{"api_key_blocked_user_agent", "true", true})
+
+	r = getTestRequest()
+	r.Header.Set("User-Agent", "curl/7.54.0")
+	m = &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), r, opentracing.StartSpan("test span")))
+	assert.NotContains(*m, metrics.Metric{"api_key_blocked_user_agent", "true", true})
+
+	m = &metrics.Metrics{}
+	assert.Nil(Plugin.OnRequest(context.Background(), m, getTestAPIProxy(), getTestRequest(), opentracing.StartSpan("test span")), "requests without a user agent should be allowed")
+}
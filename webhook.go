@@ -0,0 +1,254 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	config.Flags.Add(
+		flagPluginsAPIKeyDenyWebhookURL,
+		flagPluginsAPIKeyDenyWebhookQueueSize,
+		flagPluginsAPIKeyDenyWebhookTimeout,
+	)
+}
+
+var (
+	flagPluginsAPIKeyDenyWebhookURL = config.Flag{
+		Long:  "plugins.apiKey.deny_webhook_url",
+		Short: "",
+		Value: "",
+		Usage: "URL that denied requests will be reported to. Reporting is disabled if empty.",
+	}
+	flagPluginsAPIKeyDenyWebhookQueueSize = config.Flag{
+		Long:  "plugins.apiKey.deny_webhook_queue_size",
+		Short: "",
+		Value: 100,
+		Usage: "Maximum number of deny events waiting to be reported before new events are dropped.",
+	}
+	flagPluginsAPIKeyDenyWebhookTimeout = config.Flag{
+		Long:  "plugins.apiKey.deny_webhook_timeout",
+		Short: "",
+		Value: "0h0m5s",
+		Usage: "Timeout of each request made to the deny webhook.",
+	}
+)
+
+// denyEventVersion is incremented whenever a breaking change
+// is made to the denyEvent schema
+const denyEventVersion = 1
+
+// denyEvent is the JSON document that is posted to the
+// deny webhook for every denied request
+type denyEvent struct {
+	Version        int    `json:"version"`
+	DecisionID     string `json:"decision_id"`
+	Time           string `json:"time"`
+	Status         int    `json:"status"`
+	Reason         string `json:"reason"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	RemoteAddr     string `json:"remote_addr"`
+	ProxyName      string `json:"proxy_name"`
+	ProxyNamespace string `json:"proxy_namespace"`
+	// Key is the name of the APIKey, masked if configured, if one was found
+	Key string `json:"key"`
+	// Binding is the namespace and name of the APIKeyBinding, if one was found
+	Binding string `json:"binding"`
+}
+
+// denyWebhook asynchronously reports deny events to an external
+// service. Events are buffered in a bounded queue so that a slow
+// or unavailable webhook never blocks the request path.
+type denyWebhook struct {
+	sync.RWMutex
+	url    string
+	client *http.Client
+	queue  chan denyEvent
+	closed bool
+}
+
+// denyWebhookSettings are the settings a deny webhook is started with
+type denyWebhookSettings struct {
+	url       string
+	queueSize int
+	timeout   time.Duration
+}
+
+// denyWebhooks holds the deny webhook started with the current settings
+var denyWebhooks = struct {
+	sync.RWMutex
+	loaded   bool
+	settings denyWebhookSettings
+	instance *denyWebhook
+}{}
+
+func newDenyWebhook(url string, queueSize int, timeout time.Duration) *denyWebhook {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	return &denyWebhook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan denyEvent, queueSize),
+	}
+}
+
+func getDenyWebhookSettings() denyWebhookSettings {
+	return denyWebhookSettings{
+		url:       viper.GetString(flagPluginsAPIKeyDenyWebhookURL.GetLong()),
+		queueSize: viper.GetInt(flagPluginsAPIKeyDenyWebhookQueueSize.GetLong()),
+		timeout:   viper.GetDuration(flagPluginsAPIKeyDenyWebhookTimeout.GetLong()),
+	}
+}
+
+// getDenyWebhook returns the configured deny webhook, starting it on first
+// use. If no webhook url has been configured, nil is returned.
+func getDenyWebhook() *denyWebhook {
+	denyWebhooks.RLock()
+	if denyWebhooks.loaded {
+		defer denyWebhooks.RUnlock()
+		return denyWebhooks.instance
+	}
+	denyWebhooks.RUnlock()
+
+	denyWebhooks.Lock()
+	defer denyWebhooks.Unlock()
+	if !denyWebhooks.loaded {
+		startDenyWebhook(getDenyWebhookSettings())
+	}
+	return denyWebhooks.instance
+}
+
+// reloadDenyWebhook replaces a started deny webhook if its settings have
+// changed. Events queued by the previous webhook are still reported.
+func reloadDenyWebhook() {
+	denyWebhooks.Lock()
+	defer denyWebhooks.Unlock()
+
+	settings := getDenyWebhookSettings()
+	if !denyWebhooks.loaded || settings == denyWebhooks.settings {
+		return
+	}
+	if denyWebhooks.instance != nil {
+		denyWebhooks.instance.close()
+	}
+	startDenyWebhook(settings)
+}
+
+// startDenyWebhook starts a deny webhook with the given settings, if a url
+// is set. It must be called with the lock held.
+func startDenyWebhook(settings denyWebhookSettings) {
+	denyWebhooks.loaded, denyWebhooks.settings, denyWebhooks.instance = true, settings, nil
+	if settings.url == "" {
+		return
+	}
+	denyWebhooks.instance = newDenyWebhook(settings.url, settings.queueSize, settings.timeout)
+	go denyWebhooks.instance.run()
+}
+
+// notify queues an event to be reported. It never blocks and will return
+// false if the event was dropped because the queue is full or closed.
+func (w *denyWebhook) notify(event denyEvent) bool {
+	w.RLock()
+	defer w.RUnlock()
+	if w.closed {
+		return false
+	}
+	select {
+	case w.queue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the webhook from accepting events. Events
+// that are already queued are still reported.
+func (w *denyWebhook) close() {
+	w.Lock()
+	defer w.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+}
+
+// run reports queued events until the queue is closed
+func (w *denyWebhook) run() {
+	for event := range w.queue {
+		if err := w.post(event); err != nil {
+			logrus.Warnf("could not report deny event to webhook: %s", err.Error())
+		}
+	}
+}
+
+func (w *denyWebhook) post(event denyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newDenyEvent creates a denyEvent describing why the given request was denied
+func newDenyEvent(p spec.APIProxy, r *http.Request, id string, err error, currTime time.Time) denyEvent {
+	event := denyEvent{
+		Version:        denyEventVersion,
+		DecisionID:     id,
+		Time:           currTime.UTC().Format(time.RFC3339),
+		Status:         getStatusCode(err),
+		Reason:         err.Error(),
+		Method:         r.Method,
+		RemoteAddr:     getClientAddr(r),
+		ProxyName:      p.ObjectMeta.Name,
+		ProxyNamespace: p.ObjectMeta.Namespace,
+	}
+	if r.URL != nil {
+		event.Path = r.URL.Path
+	}
+	if name := getAPIKeyName(r); name != "" {
+		event.Key = displayKeyName(name)
+	}
+	event.Binding = getBindingID(r)
+	return event
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDenyWebhook(t *testing.T) {
+	assert := assert.New(t)
+
+	received := make(chan denyEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event denyEvent
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		assert.Nil(json.NewDecoder(r.Body).Decode(&event))
+		received <- event
+	}))
+	defer server.Close()
+
+	webhook := newDenyWebhook(server.URL, 10, time.Second)
+	go webhook.run()
+	defer close(webhook.queue)
+
+	event := newDenyEvent(getTestAPIProxy(), getTestDenyRequest(), "abc123", &utils.StatusError{http.StatusUnauthorized, errors.New("apikey not found in request")}, time.Now())
+	assert.True(webhook.notify(event))
+
+	select {
+	case actual := <-received:
+		assert.Equal(event, actual)
+	case <-time.After(time.Second):
+		assert.Fail("deny event was not received by webhook")
+	}
+}
+
+func TestDenyWebhookOverflow(t *testing.T) {
+	assert := assert.New(t)
+
+	webhook := newDenyWebhook("http://localhost", 1, time.Second)
+	event := newDenyEvent(getTestAPIProxy(), getTestDenyRequest(), "abc123", errors.New("foo"), time.Now())
+
+	assert.True(webhook.notify(event))
+	assert.False(webhook.notify(event), "event should have been dropped")
+}
+
+func TestDenyWebhookPost(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	webhook := newDenyWebhook(server.URL, 1, time.Second)
+	assert.Equal("webhook responded with status 500", webhook.post(denyEvent{}).Error())
+}
+
+func TestNewDenyEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(denyEvent{
+		Version:        denyEventVersion,
+		DecisionID:     "abc123",
+		Time:           "2017-10-01T12:00:00Z",
+		Status:         http.StatusTooManyRequests,
+		Reason:         "quota limit reached",
+		Method:         "GET",
+		Path:           "/api/v1/accounts",
+		RemoteAddr:     "1.2.3.4:5678",
+		ProxyName:      "APIProxyone",
+		ProxyNamespace: "foo",
+	}, newDenyEvent(getTestAPIProxy(), getTestDenyRequest(), "abc123", &utils.StatusError{http.StatusTooManyRequests, errors.New("quota limit reached")}, now))
+
+	r := getTestDenyRequest()
+	setAPIKey(r, getTestAPIKey())
+	setBinding(r, getTestAPIKeyBinding())
+	event := newDenyEvent(getTestAPIProxy(), r, "abc123", errors.New("foo"), now)
+	assert.Equal("apikeyone", event.Key)
+	assert.Equal("foo/apikeybindingone", event.Binding)
+
+	event = newDenyEvent(getTestAPIProxy(), &http.Request{}, "abc123", errors.New("foo"), now)
+	assert.Equal(http.StatusInternalServerError, event.Status)
+	assert.Equal("", event.Path)
+
+	data, _ := json.Marshal(event)
+	assert.Equal(`{"version":1,"decision_id":"abc123","time":"2017-10-01T12:00:00Z","status":500,"reason":"foo","method":"","path":"","remote_addr":"","proxy_name":"APIProxyone","proxy_namespace":"foo","key":"","binding":""}`, string(data), "deny event schema should be stable")
+}
+
+func getTestDenyRequest() *http.Request {
+	u, _ := url.Parse("http://host.com/api/v1/accounts")
+	return &http.Request{
+		Method:     "GET",
+		URL:        u,
+		RemoteAddr: "1.2.3.4:5678",
+	}
+}